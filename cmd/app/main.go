@@ -8,8 +8,11 @@ import (
 	"os/signal"
 	"syscall"
 	"time"
+	_ "time/tzdata" // Tradebook timezones must resolve even without system zoneinfo
 
+	"tradebooklm-api/internal/authz"
 	"tradebooklm-api/internal/config"
+	"tradebooklm-api/internal/models"
 	"tradebooklm-api/internal/services"
 	"tradebooklm-api/pkg/middleware"
 
@@ -27,7 +30,7 @@ func main() {
 	if err != nil {
 		log.Fatalf("Failed to initialize clients: %v", err)
 	}
-	middleware.InitAuth()
+	authProvider := middleware.InitAuth()
 	defer config.CloseDB()
 
 	router := gin.New()
@@ -52,32 +55,81 @@ func main() {
 		})
 	})
 
-	webhookAPI := router.Group("/")
-	webhookAPI.Use(middleware.WebhookMiddleware())
+	router.POST("/stripe/webhook", middleware.StripeWebhookMiddleware(), func(c *gin.Context) {
+		services.StripeWebHookHandler(c, config.DB)
+	})
+
+	api := router.Group("/")
+	api.Use(middleware.AuthMiddleware(config.DB, authProvider))
+
+	// Profiles and workspaces come from WorkOS; with other providers users
+	// have no stored profile and no workspaces.
+	if authProvider.Name() == middleware.ProviderWorkOS {
+		webhookAPI := router.Group("/webhooks")
+		{
+			webhookAPI.POST("/workos", middleware.WorkOSWebhookMiddleware(), func(c *gin.Context) {
+				services.WorkOSWebhook(c, config.DB)
+			})
+		}
+
+		api.Use(
+			func(c *gin.Context) {
+				services.SyncUserProfile(c, config.DB)
+			},
+			func(c *gin.Context) {
+				services.SyncWorkspaceMembership(c, config.DB)
+			},
+		)
+	}
+
+	// Routes outside any one tradebook declare what they need here; routes on
+	// a tradebook check the caller's role and scopes with authz.Require.
+	sessionAPI := api.Group("/", authz.RequireSession())
+	readAPI := api.Group("/", authz.RequireScopes(models.ScopeRead))
+	writeAPI := api.Group("/", authz.RequireScopes(models.ScopeWrite))
+	workspaceMembersAPI := api.Group("/", authz.RequireScopes(models.ScopeReadWorkspaceMembers))
 	{
-		webhookAPI.POST("/user", func(c *gin.Context) {
-			services.UpsertUser(c, config.DB)
+		readAPI.GET("/me", func(c *gin.Context) {
+			services.GetMe(c, config.DB)
 		})
 
-		webhookAPI.DELETE("/user", func(c *gin.Context) {
-			services.DeleteUser(c, config.DB)
+		sessionAPI.PATCH("/me/preferences", func(c *gin.Context) {
+			services.UpdateMyPreferences(c, config.DB)
 		})
 
-		webhookAPI.PATCH("/user", func(c *gin.Context) {
-			services.UpsertUser(c, config.DB)
+		sessionAPI.POST("/api-keys", func(c *gin.Context) {
+			services.CreateAPIKey(c, config.DB)
 		})
-	}
 
-	api := router.Group("/")
-	api.Use(
-		middleware.AuthMiddleware(),
-	)
-	{
-		api.POST("/tradebook", func(c *gin.Context) {
+		sessionAPI.GET("/api-keys", func(c *gin.Context) {
+			services.ListAPIKeys(c, config.DB)
+		})
+
+		sessionAPI.DELETE("/api-keys/:keyId", func(c *gin.Context) {
+			services.RevokeAPIKey(c, config.DB)
+		})
+
+		sessionAPI.GET("/workspaces", func(c *gin.Context) {
+			services.ListWorkspaces(c, config.DB)
+		})
+
+		workspaceMembersAPI.GET("/workspace/members", func(c *gin.Context) {
+			services.ListWorkspaceMembers(c, config.DB)
+		})
+
+		sessionAPI.POST("/billing/checkout", func(c *gin.Context) {
+			services.StripeCreateCheckoutSessionHandler(c, config.DB, config.Stripe)
+		})
+
+		writeAPI.POST("/tradebook", func(c *gin.Context) {
 			services.CreateTradebook(c, config.DB)
 		})
 
-		api.DELETE("/tradebook/:tradebookId", func(c *gin.Context) {
+		sessionAPI.POST("/tradebook/import", func(c *gin.Context) {
+			services.ImportTradebook(c, config.DB)
+		})
+
+		api.DELETE("/tradebook/:tradebookId", authz.Require(config.DB, authz.DeleteTradebook), func(c *gin.Context) {
 			services.DeleteTradebook(c, config.DB)
 		})
 
@@ -85,11 +137,11 @@ func main() {
 		// 	services.DeleteTradebooks(c, config.DB)
 		// })
 
-		api.GET("/tradebook/:tradebookId", func(c *gin.Context) {
+		api.GET("/tradebook/:tradebookId", authz.Require(config.DB, authz.ViewTradebook), func(c *gin.Context) {
 			services.GetTradebook(c, config.DB)
 		})
 
-		api.GET("/tradebooks", func(c *gin.Context) {
+		readAPI.GET("/tradebooks", func(c *gin.Context) {
 			services.GetTradebooks(c, config.DB)
 			// c.JSON(200, []models.Tradebook{
 			// 	{
@@ -109,25 +161,169 @@ func main() {
 			// })
 		})
 
-		api.PATCH("/tradebook/:tradebookId", func(c *gin.Context) {
+		sessionAPI.GET("/trash", func(c *gin.Context) {
+			services.GetTrashedTradebooks(c, config.DB)
+		})
+
+		api.POST("/tradebook/:tradebookId/restore", authz.Require(config.DB, authz.RestoreTradebook), func(c *gin.Context) {
+			services.RestoreTradebook(c, config.DB)
+		})
+
+		api.PATCH("/tradebook/:tradebookId", authz.Require(config.DB, authz.UpdateTradebook), func(c *gin.Context) {
 			services.UpdateTradebook(c, config.DB)
 		})
 
-		api.POST("/trade/:tradebookId", func(c *gin.Context) {
+		api.PATCH("/tradebook/:tradebookId/preferences", authz.Require(config.DB, authz.SetPreferences), func(c *gin.Context) {
+			services.UpdateTradebookPreferences(c, config.DB)
+		})
+
+		api.GET("/tradebook/:tradebookId/members", authz.Require(config.DB, authz.ViewMembers), func(c *gin.Context) {
+			services.ListTradebookMembers(c, config.DB)
+		})
+
+		api.POST("/tradebook/:tradebookId/members", authz.Require(config.DB, authz.ManageMembers), func(c *gin.Context) {
+			services.AddTradebookMember(c, config.DB)
+		})
+
+		api.PATCH("/tradebook/:tradebookId/members/:userId", authz.Require(config.DB, authz.ManageMembers), func(c *gin.Context) {
+			services.UpdateTradebookMember(c, config.DB)
+		})
+
+		api.DELETE("/tradebook/:tradebookId/members/:userId", authz.Require(config.DB, authz.ManageMembers), func(c *gin.Context) {
+			services.RemoveTradebookMember(c, config.DB)
+		})
+
+		api.POST("/tradebook/:tradebookId/leave", authz.Require(config.DB, authz.LeaveTradebook), func(c *gin.Context) {
+			services.LeaveTradebook(c, config.DB)
+		})
+
+		api.POST("/tradebook/:tradebookId/clone", authz.Require(config.DB, authz.CloneTradebook), func(c *gin.Context) {
+			services.CloneTradebook(c, config.DB)
+		})
+
+		api.GET("/tradebook/:tradebookId/export", authz.Require(config.DB, authz.ExportTradebook), func(c *gin.Context) {
+			services.ExportTradebook(c, config.DB)
+		})
+
+		api.POST("/tradebook/:tradebookId/transfer", authz.Require(config.DB, authz.TransferTradebook), func(c *gin.Context) {
+			services.TransferTradebookOwnership(c, config.DB)
+		})
+
+		api.PUT("/tradebook/:tradebookId/workspace", authz.Require(config.DB, authz.TransferTradebook), func(c *gin.Context) {
+			services.SetTradebookWorkspace(c, config.DB)
+		})
+
+		api.POST("/tradebook/:tradebookId/invitations", authz.Require(config.DB, authz.ManageInvitations), func(c *gin.Context) {
+			services.CreateTradebookInvitation(c, config.DB, config.Mailer)
+		})
+
+		api.GET("/tradebook/:tradebookId/invitations", authz.Require(config.DB, authz.ManageInvitations), func(c *gin.Context) {
+			services.ListTradebookInvitations(c, config.DB)
+		})
+
+		api.DELETE("/tradebook/:tradebookId/invitations/:invitationId", authz.Require(config.DB, authz.ManageInvitations), func(c *gin.Context) {
+			services.RevokeTradebookInvitation(c, config.DB)
+		})
+
+		api.GET("/tradebook/:tradebookId/fields", authz.Require(config.DB, authz.ViewTradebook), func(c *gin.Context) {
+			services.GetCustomFields(c, config.DB)
+		})
+
+		api.POST("/tradebook/:tradebookId/fields", authz.Require(config.DB, authz.ManageFields), func(c *gin.Context) {
+			services.CreateCustomField(c, config.DB)
+		})
+
+		api.PATCH("/tradebook/:tradebookId/fields/:fieldId", authz.Require(config.DB, authz.ManageFields), func(c *gin.Context) {
+			services.UpdateCustomField(c, config.DB)
+		})
+
+		api.DELETE("/tradebook/:tradebookId/fields/:fieldId", authz.Require(config.DB, authz.ManageFields), func(c *gin.Context) {
+			services.DeleteCustomField(c, config.DB)
+		})
+
+		api.GET("/tradebook/:tradebookId/commissions", authz.Require(config.DB, authz.ViewTradebook), func(c *gin.Context) {
+			services.GetCommissionSchedules(c, config.DB)
+		})
+
+		api.PUT("/tradebook/:tradebookId/commissions/:assetClass", authz.Require(config.DB, authz.UpdateTradebook), func(c *gin.Context) {
+			services.PutCommissionSchedule(c, config.DB)
+		})
+
+		api.DELETE("/tradebook/:tradebookId/commissions/:assetClass", authz.Require(config.DB, authz.UpdateTradebook), func(c *gin.Context) {
+			services.DeleteCommissionSchedule(c, config.DB)
+		})
+
+		api.POST("/tradebook/:tradebookId/commissions/recompute", authz.Require(config.DB, authz.WriteTrades), func(c *gin.Context) {
+			services.RecomputeFees(c, config.DB)
+		})
+
+		api.GET("/tradebook/:tradebookId/activity", authz.Require(config.DB, authz.ViewActivity), func(c *gin.Context) {
+			services.GetTradebookActivity(c, config.DB)
+		})
+
+		sessionAPI.POST("/templates", func(c *gin.Context) {
+			services.CreateTemplate(c, config.DB)
+		})
+
+		sessionAPI.GET("/templates", func(c *gin.Context) {
+			services.GetTemplates(c, config.DB)
+		})
+
+		sessionAPI.GET("/templates/:templateId", func(c *gin.Context) {
+			services.GetTemplate(c, config.DB)
+		})
+
+		sessionAPI.PUT("/templates/:templateId", func(c *gin.Context) {
+			services.UpdateTemplate(c, config.DB)
+		})
+
+		sessionAPI.DELETE("/templates/:templateId", func(c *gin.Context) {
+			services.DeleteTemplate(c, config.DB)
+		})
+
+		sessionAPI.GET("/account/export", func(c *gin.Context) {
+			services.ExportAccount(c, config.DB)
+		})
+
+		sessionAPI.DELETE("/account", func(c *gin.Context) {
+			services.EraseAccount(c, config.DB)
+		})
+
+		sessionAPI.POST("/invitations/accept", func(c *gin.Context) {
+			services.AcceptTradebookInvitation(c, config.DB)
+		})
+
+		api.POST("/trade/:tradebookId", authz.Require(config.DB, authz.WriteTrades), func(c *gin.Context) {
 			services.CreateTrades(c, config.DB)
 		})
 
-		api.GET("/trade/:tradebookId", func(c *gin.Context) {
+		api.GET("/trade/:tradebookId", authz.Require(config.DB, authz.ViewTrades), func(c *gin.Context) {
 			services.GetTrades(c, config.DB)
 		})
 
-		api.PATCH("/trade/:tradebookId/:tradeId", func(c *gin.Context) {
+		api.PATCH("/trade/:tradebookId/:tradeId", authz.Require(config.DB, authz.WriteTrades), func(c *gin.Context) {
 			services.UpdateTrades(c, config.DB)
 		})
 
-		api.DELETE("/trade/:tradebookId", func(c *gin.Context) {
+		api.DELETE("/trade/:tradebookId/:tradeId", authz.Require(config.DB, authz.WriteTrades), func(c *gin.Context) {
 			services.DeleteTrades(c, config.DB)
 		})
+
+		api.GET("/trade/:tradebookId/breakdown", authz.Require(config.DB, authz.ViewTrades), func(c *gin.Context) {
+			services.GetTradeBreakdown(c, config.DB)
+		})
+
+		api.GET("/trade/:tradebookId/trash", authz.Require(config.DB, authz.ViewTrades), func(c *gin.Context) {
+			services.GetTrashedTrades(c, config.DB)
+		})
+
+		api.POST("/trade/:tradebookId/:tradeId/exits", authz.Require(config.DB, authz.WriteTrades), func(c *gin.Context) {
+			services.CreateExitLeg(c, config.DB)
+		})
+
+		api.POST("/trade/:tradebookId/:tradeId/restore", authz.Require(config.DB, authz.WriteTrades), func(c *gin.Context) {
+			services.RestoreTrade(c, config.DB)
+		})
 	}
 
 	port := os.Getenv("PORT")
@@ -141,6 +337,10 @@ func main() {
 		Handler: router,
 	}
 
+	// Purge expired trash in the background until shutdown.
+	purgeCtx, stopPurge := context.WithCancel(context.Background())
+	go services.RunTrashPurge(purgeCtx, config.DB)
+
 	// Run the server in a goroutine so it doesn't block the main thread.
 	go func() {
 		log.Printf("Server starting and listening on port %s...", port)
@@ -155,6 +355,7 @@ func main() {
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 	log.Println("Shutting down server... Awaiting request completion.")
+	stopPurge()
 
 	// Create a context with a 5-second timeout for graceful shutdown.
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -17,6 +17,7 @@ require (
 	github.com/google/uuid v1.6.0
 	github.com/jackc/pgx/v5 v5.7.6
 	github.com/shopspring/decimal v1.4.0
+	github.com/sqlc-dev/pqtype v0.3.0
 	github.com/workos/workos-go/v6 v6.0.0
 	google.golang.org/genai v1.32.0
 )
@@ -0,0 +1,76 @@
+package archive
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"tradebooklm-api/internal/models"
+)
+
+// A personal data export is a zip archive of everything stored about one
+// user (format version 1):
+//
+//	manifest.json       AccountManifest
+//	account.json        Account: profile, workspaces, memberships, templates, invitations,
+//	                    transfers, API keys
+//	activity.jsonl      One models.AuditEvent per line, for every action the user took
+//	token_usage.jsonl   One TokenUsage per line
+//	tradebooks/<id>.zip A tradebook archive per owned tradebook, importable as is
+//
+// Trades in tradebooks the user is only a member of belong to their owners
+// and are not included.
+const (
+	AccountFormatName = "tradebooklm.account"
+	AccountVersion    = 1
+
+	AccountFile    = "account.json"
+	ActivityFile   = "activity.jsonl"
+	TokenUsageFile = "token_usage.jsonl"
+	TradebooksDir  = "tradebooks/"
+)
+
+type AccountManifest struct {
+	Format     string    `json:"format"`
+	Version    int       `json:"version"`
+	ExportedAt time.Time `json:"exported_at"`
+	UserID     string    `json:"user_id"`
+}
+
+type Account struct {
+	UserID      string          `json:"user_id"`
+	Email       string          `json:"email"`
+	DisplayName string          `json:"display_name"`
+	AvatarURL   string          `json:"avatar_url"`
+	Preferences json.RawMessage `json:"preferences"`
+	CreatedAt   time.Time       `json:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+
+	Workspaces         []models.Workspace           `json:"workspaces"`
+	Memberships        []Membership                 `json:"memberships"`
+	Templates          []models.TradebookTemplate   `json:"templates"` // Own templates only
+	InvitationsSent    []models.TradebookInvitation `json:"invitations_sent"`
+	OwnershipTransfers []models.OwnershipTransfer   `json:"ownership_transfers"`
+	APIKeys            []models.APIKey              `json:"api_keys"`
+	Subscriptions      []models.Subscription        `json:"subscriptions"`
+}
+
+type Membership struct {
+	TradebookID string      `json:"tradebook_id"`
+	Title       string      `json:"title"`
+	Role        models.Role `json:"role"`
+	IsPinned    bool        `json:"is_pinned"`
+	IsArchived  bool        `json:"is_archived"`
+	JoinedAt    time.Time   `json:"joined_at"`
+}
+
+type TokenUsage struct {
+	EventID          string          `json:"event_id"`
+	Timestamp        time.Time       `json:"timestamp"`
+	ModelName        string          `json:"model_name"`
+	PromptTokens     int32           `json:"prompt_tokens"`
+	CompletionTokens int32           `json:"completion_tokens"`
+	TotalTokens      int32           `json:"total_tokens"`
+	Cost             decimal.Decimal `json:"cost"`
+}
@@ -0,0 +1,137 @@
+// Package archive defines the portable tradebook archive: a zip file that
+// holds everything needed to recreate a tradebook elsewhere.
+//
+// Layout (format version 1):
+//
+//	manifest.json     Manifest; always the first entry
+//	tradebook.json    Tradebook: title, settings, custom fields, commission schedules
+//	trades.jsonl      One Trade per line
+//	exit_legs.jsonl   One ExitLeg per line
+//	csv/trades.csv    The same trades as a spreadsheet, one column per custom field
+//	csv/exit_legs.csv The same exit legs as a spreadsheet
+//
+// IDs are the ones from the exporting tradebook and are only meaningful
+// inside the archive: exit legs point at trades by TradeID, and trade custom
+// field values are keyed by CustomField.ID. The CSV files are a convenience
+// view; importers read the JSON entries.
+//
+// The version is bumped for any change that an older importer would misread.
+// Adding optional fields does not bump it.
+//
+// The personal data export (see AccountManifest) wraps one such archive per
+// owned tradebook.
+package archive
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"tradebooklm-api/internal/models"
+)
+
+const (
+	FormatName = "tradebooklm.tradebook"
+	Version    = 1
+
+	ManifestFile    = "manifest.json"
+	TradebookFile   = "tradebook.json"
+	TradesFile      = "trades.jsonl"
+	ExitLegsFile    = "exit_legs.jsonl"
+	TradesCSVFile   = "csv/trades.csv"
+	ExitLegsCSVFile = "csv/exit_legs.csv"
+)
+
+type Manifest struct {
+	Format      string    `json:"format"`
+	Version     int       `json:"version"`
+	ExportedAt  time.Time `json:"exported_at"`
+	TradebookID string    `json:"tradebook_id"`
+}
+
+type Tradebook struct {
+	ID           string                      `json:"id"`
+	Title        string                      `json:"title"`
+	Settings     models.TradebookSettings    `json:"settings"` // Tag sets live here
+	CustomFields []CustomField               `json:"custom_fields"`
+	Commissions  []models.CommissionSchedule `json:"commission_schedules"`
+	CreatedAt    time.Time                   `json:"created_at"`
+}
+
+type CustomField struct {
+	ID       string                 `json:"id"`
+	Name     string                 `json:"name"`
+	Type     models.CustomFieldType `json:"type"`
+	Options  []string               `json:"options,omitempty"`
+	Position int32                  `json:"position"`
+}
+
+type Trade struct {
+	ID     string `json:"id"`
+	IsOpen bool   `json:"is_open"`
+
+	AssetClass   models.AssetClass   `json:"asset_class"`
+	PurchaseType models.PurchaseType `json:"purchase_type"`
+	OrderType    models.OrderType    `json:"order_type"`
+
+	EntryDate time.Time `json:"entry_date"`
+	Symbol    string    `json:"symbol"`
+	Currency  string    `json:"currency"`
+
+	EntryQuantity decimal.Decimal `json:"entry_quantity"`
+	EntryPrice    decimal.Decimal `json:"entry_price"`
+	EntryFees     decimal.Decimal `json:"entry_fees"`
+	EntryFeesAuto bool            `json:"entry_fees_auto"` // Computed from a commission schedule
+
+	CustomFields map[string]any `json:"custom_fields"` // Keyed by CustomField.ID
+
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type ExitLeg struct {
+	ID      string `json:"id"`
+	TradeID string `json:"trade_id"`
+
+	ExitDate     time.Time       `json:"exit_date"`
+	ExitQuantity decimal.Decimal `json:"exit_quantity"`
+	ExitPrice    decimal.Decimal `json:"exit_price"`
+	ExitFees     decimal.Decimal `json:"exit_fees"`
+	ExitFeesAuto bool            `json:"exit_fees_auto"`
+
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Writer streams an archive. Entries are written one at a time and in full,
+// so callers can page through large tradebooks without holding them in memory.
+type Writer struct {
+	zw *zip.Writer
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{zw: zip.NewWriter(w)}
+}
+
+// WriteJSON adds a single-document entry such as the manifest.
+func (w *Writer) WriteJSON(name string, v any) error {
+	entry, err := w.zw.Create(name)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(entry)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// Create starts a new entry; the previous one is finished implicitly.
+func (w *Writer) Create(name string) (io.Writer, error) {
+	return w.zw.Create(name)
+}
+
+// Close writes the zip directory. Without it the archive is unreadable.
+func (w *Writer) Close() error {
+	return w.zw.Close()
+}
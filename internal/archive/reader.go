@@ -0,0 +1,95 @@
+package archive
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var (
+	ErrNotArchive         = errors.New("not a tradebook archive")
+	ErrUnsupportedVersion = errors.New("unsupported archive version")
+	ErrInvalidEntry       = errors.New("invalid archive entry") // Missing or malformed
+)
+
+// Reader reads an archive written by Writer. NewReader checks the manifest,
+// so the remaining entries can be trusted to be in a format this build knows.
+type Reader struct {
+	zr       *zip.Reader
+	Manifest Manifest
+}
+
+func NewReader(r io.ReaderAt, size int64) (*Reader, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, ErrNotArchive
+	}
+
+	reader := &Reader{zr: zr}
+	if err := reader.readJSON(ManifestFile, &reader.Manifest); err != nil {
+		return nil, ErrNotArchive
+	}
+
+	if reader.Manifest.Format != FormatName {
+		return nil, ErrNotArchive
+	}
+	if reader.Manifest.Version < 1 || reader.Manifest.Version > Version {
+		return nil, fmt.Errorf("%w: %d (this server reads up to %d)", ErrUnsupportedVersion, reader.Manifest.Version, Version)
+	}
+
+	return reader, nil
+}
+
+func (r *Reader) ReadTradebook() (Tradebook, error) {
+	var tb Tradebook
+	err := r.readJSON(TradebookFile, &tb)
+	return tb, err
+}
+
+// EachTrade decodes trades.jsonl one line at a time; line is 1-based. An error
+// returned by fn stops the iteration and is returned as is.
+func (r *Reader) EachTrade(fn func(line int, trade Trade) error) error {
+	return eachLine(r, TradesFile, fn)
+}
+
+func (r *Reader) EachExitLeg(fn func(line int, leg ExitLeg) error) error {
+	return eachLine(r, ExitLegsFile, fn)
+}
+
+func (r *Reader) readJSON(name string, v any) error {
+	f, err := r.zr.Open(name)
+	if err != nil {
+		return fmt.Errorf("%w: %s is missing", ErrInvalidEntry, name)
+	}
+	defer f.Close()
+
+	if err := json.NewDecoder(f).Decode(v); err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrInvalidEntry, name, err)
+	}
+	return nil
+}
+
+func eachLine[T any](r *Reader, name string, fn func(line int, v T) error) error {
+	f, err := r.zr.Open(name)
+	if err != nil {
+		return fmt.Errorf("%w: %s is missing", ErrInvalidEntry, name)
+	}
+	defer f.Close()
+
+	dec := json.NewDecoder(f)
+	for line := 1; ; line++ {
+		var v T
+		if err := dec.Decode(&v); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return fmt.Errorf("%w: %s line %d: %v", ErrInvalidEntry, name, line, err)
+		}
+
+		if err := fn(line, v); err != nil {
+			return err
+		}
+	}
+}
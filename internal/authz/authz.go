@@ -0,0 +1,237 @@
+package authz
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"tradebooklm-api/internal/database"
+	"tradebooklm-api/internal/helpers"
+	"tradebooklm-api/internal/models"
+)
+
+type Action string
+
+const (
+	ViewTradebook     Action = "tradebook:view"
+	UpdateTradebook   Action = "tradebook:update"
+	DeleteTradebook   Action = "tradebook:delete"
+	RestoreTradebook  Action = "tradebook:restore"
+	TransferTradebook Action = "tradebook:transfer"
+	CloneTradebook    Action = "tradebook:clone"
+	ExportTradebook   Action = "tradebook:export"
+	LeaveTradebook    Action = "tradebook:leave"
+	SetPreferences    Action = "tradebook:preferences"
+
+	ViewMembers       Action = "members:view"
+	ManageMembers     Action = "members:manage"
+	ManageInvitations Action = "invitations:manage"
+	ViewActivity      Action = "activity:view"
+	ManageFields      Action = "fields:manage"
+
+	ViewTrades  Action = "trades:view"
+	WriteTrades Action = "trades:write"
+
+	// Checked against the caller's workspace role rather than a tradebook role
+	AddWorkspaceTradebook Action = "workspace:add_tradebook"
+)
+
+// permissions is the single source of truth for who may do what on a tradebook.
+var permissions = map[Action][]models.Role{
+	ViewTradebook:     {models.Owner, models.Editor, models.Reader},
+	UpdateTradebook:   {models.Owner},
+	DeleteTradebook:   {models.Owner},
+	RestoreTradebook:  {models.Owner},
+	TransferTradebook: {models.Owner},
+	CloneTradebook:    {models.Owner, models.Editor, models.Reader}, // The copy belongs to the caller
+	ExportTradebook:   {models.Owner, models.Editor, models.Reader},
+	LeaveTradebook:    {models.Editor, models.Reader}, // Owners must transfer first
+	SetPreferences:    {models.Owner, models.Editor, models.Reader},
+
+	ViewMembers:       {models.Owner, models.Editor, models.Reader},
+	ManageMembers:     {models.Owner},
+	ManageInvitations: {models.Owner},
+	ViewActivity:      {models.Owner},
+	ManageFields:      {models.Owner},
+
+	ViewTrades:  {models.Owner, models.Editor, models.Reader},
+	WriteTrades: {models.Owner, models.Editor},
+
+	AddWorkspaceTradebook: {models.Owner, models.Editor},
+}
+
+// trashActions are the only actions allowed on a tradebook in the trash.
+var trashActions = map[Action]bool{
+	RestoreTradebook: true,
+}
+
+// readActions are allowed to callers with the read scope; every other
+// action needs write. Leaving only gives up the caller's own access.
+var readActions = map[Action]bool{
+	ViewTradebook:   true,
+	ExportTradebook: true,
+	ViewMembers:     true,
+	ViewActivity:    true,
+	ViewTrades:      true,
+	LeaveTradebook:  true,
+}
+
+// sessionActions decide who has access to a tradebook at all, so no API key
+// may perform them, whatever its scopes.
+var sessionActions = map[Action]bool{
+	DeleteTradebook:   true,
+	TransferTradebook: true,
+	LeaveTradebook:    true,
+	ManageMembers:     true,
+	ManageInvitations: true,
+}
+
+const (
+	roleKey        = "tradebook_role"
+	tradebookIDKey = "tradebook_id"
+)
+
+// Can reports whether role is allowed to perform action. Unknown actions are denied.
+func Can(role models.Role, action Action) bool {
+	return slices.Contains(permissions[action], role)
+}
+
+// Require resolves the caller's role on the :tradebookId route param once,
+// checks it against the permission matrix and stores it on the context.
+func Require(conn *sql.DB, action Action) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		workosId, ok := helpers.GetWorkosID(c)
+		if !ok {
+			c.Abort()
+			return
+		}
+
+		tbUUID, err := helpers.ParseUUID(c.Param("tradebookId"))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Invalid tradebook ID"})
+			return
+		}
+
+		principal, _ := helpers.GetPrincipal(c)
+		key := principal.APIKey
+		if key != nil && !key.AllowsTradebook(tbUUID) {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "Tradebook not found or access denied"})
+			return
+		}
+
+		tx, ok := helpers.BeginUserTx(c, conn)
+		if !ok {
+			c.Abort()
+			return
+		}
+
+		q := database.New(tx)
+
+		access, err := q.GetTradebookRole(c.Request.Context(), database.GetTradebookRoleParams{
+			UserID:      workosId,
+			OrgID:       helpers.GetOrgID(c),
+			TradebookID: tbUUID,
+		})
+
+		// Release the connection before the handler opens its own transaction
+		tx.Rollback()
+
+		if err != nil {
+			if err == sql.ErrNoRows {
+				c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "Tradebook not found or access denied"})
+				return
+			}
+			log.Printf("Error resolving tradebook role: %v", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+
+		if access.IsDeleted != trashActions[action] {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "Tradebook not found or access denied"})
+			return
+		}
+
+		role := models.Role(access.Role)
+		if !Can(role, action) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Your role on this tradebook does not permit this action"})
+			return
+		}
+
+		if !scopesAllow(principal, action) {
+			if key != nil {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "This API key does not permit this action"})
+				return
+			}
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Your session does not permit this action"})
+			return
+		}
+
+		c.Set(roleKey, role)
+		c.Set(tradebookIDKey, tbUUID)
+		c.Next()
+	}
+}
+
+// scopesAllow checks the caller's scopes; the user's role on the tradebook
+// is checked as well.
+func scopesAllow(principal helpers.Principal, action Action) bool {
+	if principal.APIKey != nil && sessionActions[action] {
+		return false
+	}
+	if readActions[action] {
+		return principal.HasScope(models.ScopeRead)
+	}
+	return principal.HasScope(models.ScopeWrite)
+}
+
+// RequireSession rejects API keys, for routes outside any one tradebook that
+// manage the account itself, such as creating more keys.
+func RequireSession() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if principal, _ := helpers.GetPrincipal(c); principal.APIKey != nil {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "API keys cannot be used here; sign in instead"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireScopes guards a route group outside any one tradebook: the caller
+// must hold every scope. API keys limited to certain tradebooks are turned
+// away, since such routes reach beyond them.
+func RequireScopes(scopes ...models.Scope) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal, ok := helpers.GetPrincipal(c)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+			return
+		}
+
+		if principal.APIKey != nil && len(principal.APIKey.TradebookIDs) > 0 {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "This API key is limited to specific tradebooks"})
+			return
+		}
+
+		for _, scope := range scopes {
+			if !principal.HasScope(scope) {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Missing required scope: " + string(scope)})
+				return
+			}
+		}
+		c.Next()
+	}
+}
+
+// GetTradebookID returns the tradebook authorized by Require.
+func GetTradebookID(c *gin.Context) uuid.UUID {
+	return c.MustGet(tradebookIDKey).(uuid.UUID)
+}
+
+// GetRole returns the caller's role resolved by Require.
+func GetRole(c *gin.Context) models.Role {
+	return c.MustGet(roleKey).(models.Role)
+}
@@ -3,12 +3,15 @@ package config
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"net"
 	"os"
 	"time"
+	"tradebooklm-api/internal/database"
 	"tradebooklm-api/internal/helpers"
+	"tradebooklm-api/internal/mailer"
 
 	"cloud.google.com/go/cloudsqlconn"
 	"github.com/jackc/pgx/v5"
@@ -20,6 +23,7 @@ import (
 type Clients struct {
 	DB     *sql.DB
 	Gemini *genai.Client
+	Mailer mailer.Mailer
 	Stripe string
 }
 
@@ -27,8 +31,11 @@ func InitializeConfig() (*Clients, error) {
 	var (
 		geminiApiKey = helpers.MustGetenv("GEMINI_API_KEY")
 		stripeApiKey = helpers.MustGetenv("STRIPE_API_KEY")
+		cursorSecret = helpers.MustGetenv("CURSOR_SECRET")
 	)
 
+	helpers.SetCursorSecret(cursorSecret)
+
 	ctx := context.Background()
 
 	var db *sql.DB
@@ -44,6 +51,11 @@ func InitializeConfig() (*Clients, error) {
 		return nil, fmt.Errorf("error connecting to database: %w", err)
 	}
 
+	if err := checkRowLevelSecurity(ctx, db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
 	gemini, err := genai.NewClient(ctx, &genai.ClientConfig{
 		APIKey:  geminiApiKey,
 		Backend: genai.BackendGeminiAPI,
@@ -57,9 +69,30 @@ func InitializeConfig() (*Clients, error) {
 		DB:     db,
 		Stripe: stripeApiKey,
 		Gemini: gemini,
+		Mailer: mailer.NewFromEnv(),
 	}, nil
 }
 
+// checkRowLevelSecurity refuses a database role that the row-level security
+// policies in sql/schema.sql wouldn't apply to, since tenant isolation relies
+// on them when a query misses its own tenant predicate.
+func checkRowLevelSecurity(ctx context.Context, db *sql.DB) error {
+	bypass, err := database.New(db).GetRowSecurityBypass(ctx)
+	if err != nil {
+		return fmt.Errorf("error checking database role: %w", err)
+	}
+
+	switch {
+	case bypass.IsSuperuser:
+		return errors.New("database role is a superuser and would bypass row-level security")
+	case bypass.BypassesRls:
+		return errors.New("database role has BYPASSRLS and would bypass row-level security")
+	case bypass.OwnsTables:
+		return errors.New("database role owns tables with row-level security and could disable it")
+	}
+	return nil
+}
+
 func (c *Clients) CloseDB() {
 	if c.DB != nil {
 		c.DB.Close()
@@ -5,14 +5,60 @@
 package database
 
 import (
+	"database/sql"
 	"database/sql/driver"
+	"encoding/json"
 	"fmt"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/shopspring/decimal"
+	"github.com/sqlc-dev/pqtype"
 )
 
+type AccountingMethod string
+
+const (
+	AccountingMethodFifo        AccountingMethod = "fifo"
+	AccountingMethodLifo        AccountingMethod = "lifo"
+	AccountingMethodAverageCost AccountingMethod = "average_cost"
+)
+
+func (e *AccountingMethod) Scan(src interface{}) error {
+	switch s := src.(type) {
+	case []byte:
+		*e = AccountingMethod(s)
+	case string:
+		*e = AccountingMethod(s)
+	default:
+		return fmt.Errorf("unsupported scan type for AccountingMethod: %T", src)
+	}
+	return nil
+}
+
+type NullAccountingMethod struct {
+	AccountingMethod AccountingMethod
+	Valid            bool // Valid is true if AccountingMethod is not NULL
+}
+
+// Scan implements the Scanner interface.
+func (ns *NullAccountingMethod) Scan(value interface{}) error {
+	if value == nil {
+		ns.AccountingMethod, ns.Valid = "", false
+		return nil
+	}
+	ns.Valid = true
+	return ns.AccountingMethod.Scan(value)
+}
+
+// Value implements the driver Valuer interface.
+func (ns NullAccountingMethod) Value() (driver.Value, error) {
+	if !ns.Valid {
+		return nil, nil
+	}
+	return string(ns.AccountingMethod), nil
+}
+
 type AssetClass string
 
 const (
@@ -60,6 +106,94 @@ func (ns NullAssetClass) Value() (driver.Value, error) {
 	return string(ns.AssetClass), nil
 }
 
+type CommissionRateType string
+
+const (
+	CommissionRateTypePerShare          CommissionRateType = "per_share"
+	CommissionRateTypePerContract       CommissionRateType = "per_contract"
+	CommissionRateTypePercentOfNotional CommissionRateType = "percent_of_notional"
+)
+
+func (e *CommissionRateType) Scan(src interface{}) error {
+	switch s := src.(type) {
+	case []byte:
+		*e = CommissionRateType(s)
+	case string:
+		*e = CommissionRateType(s)
+	default:
+		return fmt.Errorf("unsupported scan type for CommissionRateType: %T", src)
+	}
+	return nil
+}
+
+type NullCommissionRateType struct {
+	CommissionRateType CommissionRateType
+	Valid              bool // Valid is true if CommissionRateType is not NULL
+}
+
+// Scan implements the Scanner interface.
+func (ns *NullCommissionRateType) Scan(value interface{}) error {
+	if value == nil {
+		ns.CommissionRateType, ns.Valid = "", false
+		return nil
+	}
+	ns.Valid = true
+	return ns.CommissionRateType.Scan(value)
+}
+
+// Value implements the driver Valuer interface.
+func (ns NullCommissionRateType) Value() (driver.Value, error) {
+	if !ns.Valid {
+		return nil, nil
+	}
+	return string(ns.CommissionRateType), nil
+}
+
+type CustomFieldType string
+
+const (
+	CustomFieldTypeText    CustomFieldType = "text"
+	CustomFieldTypeNumber  CustomFieldType = "number"
+	CustomFieldTypeSelect  CustomFieldType = "select"
+	CustomFieldTypeBoolean CustomFieldType = "boolean"
+	CustomFieldTypeDate    CustomFieldType = "date"
+)
+
+func (e *CustomFieldType) Scan(src interface{}) error {
+	switch s := src.(type) {
+	case []byte:
+		*e = CustomFieldType(s)
+	case string:
+		*e = CustomFieldType(s)
+	default:
+		return fmt.Errorf("unsupported scan type for CustomFieldType: %T", src)
+	}
+	return nil
+}
+
+type NullCustomFieldType struct {
+	CustomFieldType CustomFieldType
+	Valid           bool // Valid is true if CustomFieldType is not NULL
+}
+
+// Scan implements the Scanner interface.
+func (ns *NullCustomFieldType) Scan(value interface{}) error {
+	if value == nil {
+		ns.CustomFieldType, ns.Valid = "", false
+		return nil
+	}
+	ns.Valid = true
+	return ns.CustomFieldType.Scan(value)
+}
+
+// Value implements the driver Valuer interface.
+func (ns NullCustomFieldType) Value() (driver.Value, error) {
+	if !ns.Valid {
+		return nil, nil
+	}
+	return string(ns.CustomFieldType), nil
+}
+
 type TradeOrderType string
 
 const (
@@ -189,6 +323,71 @@ func (ns NullTradebookRole) Value() (driver.Value, error) {
 	return string(ns.TradebookRole), nil
 }
 
+type AccountErasure struct {
+	ID          uuid.UUID
+	SubjectHash string
+	Pseudonym   string
+	Report      json.RawMessage
+	CompletedAt time.Time
+}
+
+type ApiKey struct {
+	ID           uuid.UUID
+	UserID       string
+	OrgID        sql.NullString
+	Name         string
+	Prefix       string
+	KeyHash      string
+	Scopes       json.RawMessage
+	TradebookIds json.RawMessage
+	ExpiresAt    sql.NullTime
+	LastUsedAt   sql.NullTime
+	RevokedAt    sql.NullTime
+	CreatedAt    time.Time
+}
+
+type AuditLog struct {
+	ID          uuid.UUID
+	TradebookID uuid.UUID
+	ActorID     string
+	Action      string
+	EntityType  string
+	EntityID    string
+	Before      pqtype.NullRawMessage
+	After       pqtype.NullRawMessage
+	CreatedAt   time.Time
+}
+
+type BillingCustomer struct {
+	UserID           string
+	StripeCustomerID string
+	CreatedAt        time.Time
+}
+
+type CommissionSchedule struct {
+	TradebookID       uuid.UUID
+	AssetClass        AssetClass
+	RateType          CommissionRateType
+	Rate              decimal.Decimal
+	MinFee            decimal.Decimal
+	MaxFee            decimal.NullDecimal
+	RegulatoryRate    decimal.Decimal
+	RegulatoryPerUnit decimal.Decimal
+	CreatedAt         time.Time
+	UpdatedAt         time.Time
+}
+
+type CustomFieldDefinition struct {
+	ID          uuid.UUID
+	TradebookID uuid.UUID
+	Name        string
+	FieldType   CustomFieldType
+	Options     json.RawMessage
+	Position    int32
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
 type ExitLeg struct {
 	ID           uuid.UUID
 	TradeID      uuid.UUID
@@ -196,10 +395,27 @@ type ExitLeg struct {
 	ExitQuantity decimal.Decimal
 	ExitPrice    decimal.Decimal
 	ExitFees     decimal.NullDecimal
+	ExitFeesAuto bool
 	CreatedAt    time.Time
 	UpdatedAt    time.Time
 }
 
+type Subscription struct {
+	ID                  string
+	UserID              string
+	StripeCustomerID    string
+	PriceID             string
+	Plan                sql.NullString
+	Status              string
+	CurrentPeriodEnd    time.Time
+	CancelAtPeriodEnd   bool
+	SyncedAt            time.Time
+	LatestInvoiceStatus sql.NullString
+	InvoiceSyncedAt     sql.NullTime
+	CreatedAt           time.Time
+	UpdatedAt           time.Time
+}
+
 type TokenUsageLog struct {
 	EventID          uuid.UUID
 	UserID           string
@@ -214,6 +430,7 @@ type TokenUsageLog struct {
 type Trade struct {
 	ID            uuid.UUID
 	TradebookID   uuid.UUID
+	ClonedFromID  uuid.NullUUID
 	IsOpen        bool
 	AssetClass    AssetClass
 	PurchaseType  TradePurchaseType
@@ -224,16 +441,43 @@ type Trade struct {
 	EntryQuantity decimal.Decimal
 	EntryPrice    decimal.Decimal
 	EntryFees     decimal.NullDecimal
+	EntryFeesAuto bool
+	CustomFields  json.RawMessage
 	CreatedAt     time.Time
 	UpdatedAt     time.Time
+	DeletedAt     sql.NullTime
 }
 
 type Tradebook struct {
-	ID        uuid.UUID
-	OwnerID   string
-	Title     string
-	CreatedAt time.Time
-	UpdatedAt time.Time
+	ID                  uuid.UUID
+	OwnerID             string
+	WorkspaceID         sql.NullString
+	Title               string
+	TemplateID          uuid.NullUUID
+	BaseCurrency        string
+	AccountingMethod    AccountingMethod
+	DefaultAssetClasses json.RawMessage
+	TagSets             json.RawMessage
+	Timezone            string
+	DefaultFees         decimal.Decimal
+	FiscalYearStart     int32
+	CreatedAt           time.Time
+	UpdatedAt           time.Time
+	DeletedAt           sql.NullTime
+}
+
+type TradebookInvitation struct {
+	ID          uuid.UUID
+	TradebookID uuid.UUID
+	Email       string
+	Role        TradebookRole
+	TokenHash   string
+	InvitedBy   string
+	ExpiresAt   time.Time
+	AcceptedAt  sql.NullTime
+	AcceptedBy  sql.NullString
+	RevokedAt   sql.NullTime
+	CreatedAt   time.Time
 }
 
 type TradebookMember struct {
@@ -243,8 +487,65 @@ type TradebookMember struct {
 	JoinedAt    time.Time
 }
 
+type TradebookOwnershipTransfer struct {
+	ID            uuid.UUID
+	TradebookID   uuid.UUID
+	FromUserID    string
+	ToUserID      string
+	TransferredAt time.Time
+}
+
+type TradebookPreference struct {
+	TradebookID uuid.UUID
+	UserID      string
+	IsPinned    bool
+	IsArchived  bool
+}
+
+type TradebookTemplate struct {
+	ID                  uuid.UUID
+	OwnerID             string
+	WorkspaceID         sql.NullString
+	Name                string
+	IsShared            bool
+	BaseCurrency        string
+	AccountingMethod    AccountingMethod
+	DefaultAssetClasses json.RawMessage
+	TagSets             json.RawMessage
+	CustomFields        json.RawMessage
+	CreatedAt           time.Time
+	UpdatedAt           time.Time
+}
+
 type User struct {
+	ID               string
+	Email            sql.NullString
+	DisplayName      sql.NullString
+	AvatarUrl        sql.NullString
+	ProfileUpdatedAt sql.NullTime
+	Preferences      json.RawMessage
+	CreatedAt        time.Time
+	UpdatedAt        time.Time
+}
+
+type WebhookEvent struct {
+	Source     string
+	ID         string
+	EventType  string
+	ReceivedAt time.Time
+}
+
+type Workspace struct {
 	ID        string
+	Name      string
 	CreatedAt time.Time
 	UpdatedAt time.Time
 }
+
+type WorkspaceMember struct {
+	WorkspaceID string
+	UserID      string
+	Role        TradebookRole
+	SyncedAt    time.Time
+	JoinedAt    time.Time
+}
@@ -8,26 +8,45 @@ package database
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/shopspring/decimal"
+	"github.com/sqlc-dev/pqtype"
 )
 
+const acceptTradebookInvitation = `-- name: AcceptTradebookInvitation :exec
+UPDATE tradebook_invitations
+SET
+    accepted_at = NOW(),
+    accepted_by = $1::text
+WHERE id = $2
+`
+
+type AcceptTradebookInvitationParams struct {
+	UserID       string
+	InvitationID uuid.UUID
+}
+
+func (q *Queries) AcceptTradebookInvitation(ctx context.Context, arg AcceptTradebookInvitationParams) error {
+	_, err := q.db.ExecContext(ctx, acceptTradebookInvitation, arg.UserID, arg.InvitationID)
+	return err
+}
+
 const addExitLeg = `-- name: AddExitLeg :one
 INSERT INTO exit_legs (
-    trade_id, exit_date, exit_quantity, exit_price, exit_fees
+    trade_id, exit_date, exit_quantity, exit_price, exit_fees, exit_fees_auto
 )
 SELECT
-    $1, $2, $3, $4, $5
+    $1, $2, $3, $4, $5, $6
 FROM trades t
-JOIN tradebooks tb ON t.tradebook_id = tb.id
-LEFT JOIN tradebook_members tm ON tb.id = tm.tradebook_id
 WHERE t.id = $1
-    AND (tb.owner_id = $6 OR (tm.user_id = $6 AND tm.role IN ('owner', 'editor')))
+    AND t.tradebook_id = $7
+    AND t.deleted_at IS NULL
     -- LIMIT CHECK: Ensure we haven't hit 100 legs yet
     AND (SELECT COUNT(*) FROM exit_legs WHERE trade_id = $1) < 100
-RETURNING id, trade_id, exit_date, exit_quantity, exit_price, exit_fees, created_at, updated_at
+RETURNING id, trade_id, exit_date, exit_quantity, exit_price, exit_fees, exit_fees_auto, created_at, updated_at
 `
 
 type AddExitLegParams struct {
@@ -36,10 +55,11 @@ type AddExitLegParams struct {
 	ExitQuantity decimal.Decimal
 	ExitPrice    decimal.Decimal
 	ExitFees     decimal.NullDecimal
-	UserID       string
+	ExitFeesAuto bool
+	TradebookID  uuid.UUID
 }
 
-// Security: Verifies ownership AND enforces max 100 exit legs per trade (DB Level Safety Net)
+// Security: Scopes the trade to its tradebook AND enforces max 100 exit legs per trade (DB Level Safety Net)
 func (q *Queries) AddExitLeg(ctx context.Context, arg AddExitLegParams) (ExitLeg, error) {
 	row := q.db.QueryRowContext(ctx, addExitLeg,
 		arg.TradeID,
@@ -47,7 +67,8 @@ func (q *Queries) AddExitLeg(ctx context.Context, arg AddExitLegParams) (ExitLeg
 		arg.ExitQuantity,
 		arg.ExitPrice,
 		arg.ExitFees,
-		arg.UserID,
+		arg.ExitFeesAuto,
+		arg.TradebookID,
 	)
 	var i ExitLeg
 	err := row.Scan(
@@ -57,31 +78,329 @@ func (q *Queries) AddExitLeg(ctx context.Context, arg AddExitLegParams) (ExitLeg
 		&i.ExitQuantity,
 		&i.ExitPrice,
 		&i.ExitFees,
+		&i.ExitFeesAuto,
 		&i.CreatedAt,
 		&i.UpdatedAt,
 	)
 	return i, err
 }
 
-const createTrade = `-- name: CreateTrade :one
+const claimWebhookEvent = `-- name: ClaimWebhookEvent :execrows
+
+INSERT INTO webhook_events (source, id, event_type)
+VALUES ($1, $2, $3)
+ON CONFLICT (source, id) DO NOTHING
+`
+
+type ClaimWebhookEventParams struct {
+	Source    string
+	ID        string
+	EventType string
+}
+
+// ============================================================================
+// 18. WEBHOOKS
+// ============================================================================
+// Affects no rows if the event was already claimed (i.e. this is a redelivery)
+func (q *Queries) ClaimWebhookEvent(ctx context.Context, arg ClaimWebhookEventParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, claimWebhookEvent, arg.Source, arg.ID, arg.EventType)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const clearCustomFieldValues = `-- name: ClearCustomFieldValues :exec
+UPDATE trades
+SET custom_fields = custom_fields - $1::text
+WHERE tradebook_id = $2
+    AND custom_fields ? $1::text
+`
+
+type ClearCustomFieldValuesParams struct {
+	FieldID     string
+	TradebookID uuid.UUID
+}
+
+// Includes trashed trades so a restore doesn't bring back an orphaned value
+func (q *Queries) ClearCustomFieldValues(ctx context.Context, arg ClearCustomFieldValuesParams) error {
+	_, err := q.db.ExecContext(ctx, clearCustomFieldValues, arg.FieldID, arg.TradebookID)
+	return err
+}
+
+const clearRemovedOptionValues = `-- name: ClearRemovedOptionValues :exec
+UPDATE trades
+SET custom_fields = custom_fields - $1::text
+WHERE tradebook_id = $2
+    AND custom_fields ? $1::text
+    AND NOT $3::jsonb @> (custom_fields -> $1::text)
+`
+
+type ClearRemovedOptionValuesParams struct {
+	FieldID     string
+	TradebookID uuid.UUID
+	Options     json.RawMessage
+}
+
+// Removes a select field's value from trades where it is no longer one of
+// @options (a JSON array, which contains each of its strings); trashed
+// trades included, as above
+func (q *Queries) ClearRemovedOptionValues(ctx context.Context, arg ClearRemovedOptionValuesParams) error {
+	_, err := q.db.ExecContext(ctx, clearRemovedOptionValues, arg.FieldID, arg.TradebookID, arg.Options)
+	return err
+}
+
+const cloneCommissionSchedules = `-- name: CloneCommissionSchedules :execrows
+INSERT INTO commission_schedules (
+    tradebook_id, asset_class, rate_type, rate, min_fee, max_fee,
+    regulatory_rate, regulatory_per_unit
+)
+SELECT
+    $1::uuid, asset_class, rate_type, rate, min_fee, max_fee,
+    regulatory_rate, regulatory_per_unit
+FROM commission_schedules
+WHERE tradebook_id = $2
+`
+
+type CloneCommissionSchedulesParams struct {
+	TargetTradebookID uuid.UUID
+	SourceTradebookID uuid.UUID
+}
+
+func (q *Queries) CloneCommissionSchedules(ctx context.Context, arg CloneCommissionSchedulesParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, cloneCommissionSchedules, arg.TargetTradebookID, arg.SourceTradebookID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const cloneCustomFields = `-- name: CloneCustomFields :execrows
+INSERT INTO custom_field_definitions (tradebook_id, name, field_type, options, position)
+SELECT $1::uuid, name, field_type, options, position
+FROM custom_field_definitions
+WHERE tradebook_id = $2
+`
+
+type CloneCustomFieldsParams struct {
+	TargetTradebookID uuid.UUID
+	SourceTradebookID uuid.UUID
+}
+
+func (q *Queries) CloneCustomFields(ctx context.Context, arg CloneCustomFieldsParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, cloneCustomFields, arg.TargetTradebookID, arg.SourceTradebookID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const cloneExitLegs = `-- name: CloneExitLegs :execrows
+INSERT INTO exit_legs (trade_id, exit_date, exit_quantity, exit_price, exit_fees, exit_fees_auto)
+SELECT t.id, el.exit_date, el.exit_quantity, el.exit_price, el.exit_fees, el.exit_fees_auto
+FROM trades t
+JOIN exit_legs el ON el.trade_id = t.cloned_from_id
+WHERE t.tradebook_id = $1
+`
+
+// Copies exit legs onto trades created by CloneTrades, matched via cloned_from_id
+func (q *Queries) CloneExitLegs(ctx context.Context, tradebookID uuid.UUID) (int64, error) {
+	result, err := q.db.ExecContext(ctx, cloneExitLegs, tradebookID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
 
+const cloneTrades = `-- name: CloneTrades :execrows
 INSERT INTO trades (
-    tradebook_id, asset_class, purchase_type, order_type,
-    entry_date, symbol, currency, entry_quantity, entry_price, entry_fees
+    tradebook_id, cloned_from_id, is_open, asset_class, purchase_type, order_type,
+    entry_date, symbol, currency, entry_quantity, entry_price, entry_fees, entry_fees_auto, custom_fields
 )
 SELECT
-    $1, $2, $3, $4,
-    $5, $6, $7, $8, $9, $10
-WHERE EXISTS (
-    SELECT 1 FROM tradebooks tb
-    LEFT JOIN tradebook_members tm ON tb.id = tm.tradebook_id
-    WHERE tb.id = $1
-    AND (
-        tb.owner_id = $11
-        OR (tm.user_id = $11 AND tm.role IN ('owner', 'editor'))
+    $1::uuid, t.id, t.is_open, t.asset_class, t.purchase_type, t.order_type,
+    t.entry_date, t.symbol, t.currency, t.entry_quantity, t.entry_price, t.entry_fees, t.entry_fees_auto,
+    (
+        SELECT COALESCE(jsonb_object_agg(target_field.id::text, kv.value), '{}'::jsonb)
+        FROM jsonb_each(t.custom_fields) kv
+        JOIN custom_field_definitions source_field ON source_field.id::text = kv.key
+        JOIN custom_field_definitions target_field
+            ON target_field.tradebook_id = $1::uuid
+            AND target_field.name = source_field.name
     )
+FROM trades t
+WHERE t.tradebook_id = $2
+    AND t.deleted_at IS NULL
+`
+
+type CloneTradesParams struct {
+	TargetTradebookID uuid.UUID
+	SourceTradebookID uuid.UUID
+}
+
+// Copies the live trades of one tradebook into another. Run after CloneCustomFields:
+// custom field values are re-keyed to the target's definitions by field name
+func (q *Queries) CloneTrades(ctx context.Context, arg CloneTradesParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, cloneTrades, arg.TargetTradebookID, arg.SourceTradebookID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const countTradebookOwners = `-- name: CountTradebookOwners :one
+SELECT COUNT(*) FROM tradebook_members
+WHERE tradebook_id = $1 AND role = 'owner'
+`
+
+func (q *Queries) CountTradebookOwners(ctx context.Context, tradebookID uuid.UUID) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countTradebookOwners, tradebookID)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countUserRecords = `-- name: CountUserRecords :one
+SELECT count_user_records($1::text, $2::text)::bigint AS remaining
+`
+
+type CountUserRecordsParams struct {
+	UserID string
+	Email  sql.NullString
+}
+
+func (q *Queries) CountUserRecords(ctx context.Context, arg CountUserRecordsParams) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countUserRecords, arg.UserID, arg.Email)
+	var remaining int64
+	err := row.Scan(&remaining)
+	return remaining, err
+}
+
+const createAPIKey = `-- name: CreateAPIKey :one
+
+INSERT INTO api_keys (user_id, org_id, name, prefix, key_hash, scopes, tradebook_ids, expires_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+RETURNING id, user_id, org_id, name, prefix, key_hash, scopes, tradebook_ids, expires_at, last_used_at, revoked_at, created_at
+`
+
+type CreateAPIKeyParams struct {
+	UserID       string
+	OrgID        sql.NullString
+	Name         string
+	Prefix       string
+	KeyHash      string
+	Scopes       json.RawMessage
+	TradebookIds json.RawMessage
+	ExpiresAt    sql.NullTime
+}
+
+// ============================================================================
+// 20. API KEYS
+// ============================================================================
+func (q *Queries) CreateAPIKey(ctx context.Context, arg CreateAPIKeyParams) (ApiKey, error) {
+	row := q.db.QueryRowContext(ctx, createAPIKey,
+		arg.UserID,
+		arg.OrgID,
+		arg.Name,
+		arg.Prefix,
+		arg.KeyHash,
+		arg.Scopes,
+		arg.TradebookIds,
+		arg.ExpiresAt,
+	)
+	var i ApiKey
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.OrgID,
+		&i.Name,
+		&i.Prefix,
+		&i.KeyHash,
+		&i.Scopes,
+		&i.TradebookIds,
+		&i.ExpiresAt,
+		&i.LastUsedAt,
+		&i.RevokedAt,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const createCustomField = `-- name: CreateCustomField :one
+INSERT INTO custom_field_definitions (tradebook_id, name, field_type, options, position)
+VALUES (
+    $1, $2, $3, $4,
+    (SELECT COALESCE(MAX(position) + 1, 0) FROM custom_field_definitions WHERE tradebook_id = $1)
+)
+RETURNING id, tradebook_id, name, field_type, options, position, created_at, updated_at
+`
+
+type CreateCustomFieldParams struct {
+	TradebookID uuid.UUID
+	Name        string
+	FieldType   CustomFieldType
+	Options     json.RawMessage
+}
+
+// New fields go to the end of the list
+func (q *Queries) CreateCustomField(ctx context.Context, arg CreateCustomFieldParams) (CustomFieldDefinition, error) {
+	row := q.db.QueryRowContext(ctx, createCustomField,
+		arg.TradebookID,
+		arg.Name,
+		arg.FieldType,
+		arg.Options,
+	)
+	var i CustomFieldDefinition
+	err := row.Scan(
+		&i.ID,
+		&i.TradebookID,
+		&i.Name,
+		&i.FieldType,
+		&i.Options,
+		&i.Position,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const createCustomFieldsFromTemplate = `-- name: CreateCustomFieldsFromTemplate :execrows
+INSERT INTO custom_field_definitions (tradebook_id, name, field_type, options, position)
+SELECT
+    $1::uuid,
+    f.value->>'name',
+    (f.value->>'type')::custom_field_type,
+    COALESCE(f.value->'options', '[]'::jsonb),
+    f.ordinality::integer - 1
+FROM tradebook_templates t
+CROSS JOIN LATERAL jsonb_array_elements(t.custom_fields) WITH ORDINALITY AS f(value, ordinality)
+WHERE t.id = $2
+`
+
+type CreateCustomFieldsFromTemplateParams struct {
+	TradebookID uuid.UUID
+	TemplateID  uuid.UUID
+}
+
+// Expands the template's custom_fields JSON into definitions, keeping their order
+func (q *Queries) CreateCustomFieldsFromTemplate(ctx context.Context, arg CreateCustomFieldsFromTemplateParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, createCustomFieldsFromTemplate, arg.TradebookID, arg.TemplateID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const createTrade = `-- name: CreateTrade :one
+
+INSERT INTO trades (
+    tradebook_id, asset_class, purchase_type, order_type,
+    entry_date, symbol, currency, entry_quantity, entry_price, entry_fees, entry_fees_auto, custom_fields
+) VALUES (
+    $1, $2, $3, $4,
+    $5, $6, $7, $8, $9, $10, $11, $12
 )
-RETURNING id, tradebook_id, is_open, asset_class, purchase_type, order_type, entry_date, symbol, currency, entry_quantity, entry_price, entry_fees, created_at, updated_at
+RETURNING id, tradebook_id, cloned_from_id, is_open, asset_class, purchase_type, order_type, entry_date, symbol, currency, entry_quantity, entry_price, entry_fees, entry_fees_auto, custom_fields, created_at, updated_at, deleted_at
 `
 
 type CreateTradeParams struct {
@@ -95,7 +414,8 @@ type CreateTradeParams struct {
 	EntryQuantity decimal.Decimal
 	EntryPrice    decimal.Decimal
 	EntryFees     decimal.NullDecimal
-	UserID        string
+	EntryFeesAuto bool
+	CustomFields  json.RawMessage
 }
 
 // ============================================================================
@@ -113,12 +433,14 @@ func (q *Queries) CreateTrade(ctx context.Context, arg CreateTradeParams) (Trade
 		arg.EntryQuantity,
 		arg.EntryPrice,
 		arg.EntryFees,
-		arg.UserID,
+		arg.EntryFeesAuto,
+		arg.CustomFields,
 	)
 	var i Trade
 	err := row.Scan(
 		&i.ID,
 		&i.TradebookID,
+		&i.ClonedFromID,
 		&i.IsOpen,
 		&i.AssetClass,
 		&i.PurchaseType,
@@ -129,132 +451,654 @@ func (q *Queries) CreateTrade(ctx context.Context, arg CreateTradeParams) (Trade
 		&i.EntryQuantity,
 		&i.EntryPrice,
 		&i.EntryFees,
+		&i.EntryFeesAuto,
+		&i.CustomFields,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.DeletedAt,
 	)
 	return i, err
 }
 
 const createTradebook = `-- name: CreateTradebook :one
 
-INSERT INTO tradebooks (owner_id, title)
-VALUES ($1, $2)
-RETURNING id, owner_id, title, created_at, updated_at
+INSERT INTO tradebooks (owner_id, title, workspace_id)
+VALUES ($1, $2, $3)
+RETURNING id, owner_id, workspace_id, title, template_id, base_currency, accounting_method, default_asset_classes, tag_sets, timezone, default_fees, fiscal_year_start, created_at, updated_at, deleted_at
 `
 
 type CreateTradebookParams struct {
-	OwnerID string
-	Title   string
+	OwnerID     string
+	Title       string
+	WorkspaceID sql.NullString
 }
 
 // ============================================================================
 // 2. TRADEBOOKS
 // ============================================================================
 func (q *Queries) CreateTradebook(ctx context.Context, arg CreateTradebookParams) (Tradebook, error) {
-	row := q.db.QueryRowContext(ctx, createTradebook, arg.OwnerID, arg.Title)
+	row := q.db.QueryRowContext(ctx, createTradebook, arg.OwnerID, arg.Title, arg.WorkspaceID)
 	var i Tradebook
 	err := row.Scan(
 		&i.ID,
 		&i.OwnerID,
+		&i.WorkspaceID,
 		&i.Title,
+		&i.TemplateID,
+		&i.BaseCurrency,
+		&i.AccountingMethod,
+		&i.DefaultAssetClasses,
+		&i.TagSets,
+		&i.Timezone,
+		&i.DefaultFees,
+		&i.FiscalYearStart,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.DeletedAt,
 	)
 	return i, err
 }
 
-const deleteAllTradebooks = `-- name: DeleteAllTradebooks :exec
-DELETE FROM tradebooks
-WHERE owner_id = $1
+const createTradebookCopy = `-- name: CreateTradebookCopy :one
+INSERT INTO tradebooks (
+    owner_id, title, template_id,
+    base_currency, accounting_method, default_asset_classes, tag_sets,
+    timezone, default_fees, fiscal_year_start
+)
+SELECT
+    $1::text, $2::text, tb.template_id,
+    tb.base_currency, tb.accounting_method, tb.default_asset_classes, tb.tag_sets,
+    tb.timezone, tb.default_fees, tb.fiscal_year_start
+FROM tradebooks tb
+WHERE tb.id = $3
+RETURNING id, owner_id, workspace_id, title, template_id, base_currency, accounting_method, default_asset_classes, tag_sets, timezone, default_fees, fiscal_year_start, created_at, updated_at, deleted_at
 `
 
-func (q *Queries) DeleteAllTradebooks(ctx context.Context, userID string) error {
-	_, err := q.db.ExecContext(ctx, deleteAllTradebooks, userID)
-	return err
+type CreateTradebookCopyParams struct {
+	OwnerID           string
+	Title             string
+	SourceTradebookID uuid.UUID
+}
+
+// Copies the settings of an existing tradebook into a new one
+func (q *Queries) CreateTradebookCopy(ctx context.Context, arg CreateTradebookCopyParams) (Tradebook, error) {
+	row := q.db.QueryRowContext(ctx, createTradebookCopy, arg.OwnerID, arg.Title, arg.SourceTradebookID)
+	var i Tradebook
+	err := row.Scan(
+		&i.ID,
+		&i.OwnerID,
+		&i.WorkspaceID,
+		&i.Title,
+		&i.TemplateID,
+		&i.BaseCurrency,
+		&i.AccountingMethod,
+		&i.DefaultAssetClasses,
+		&i.TagSets,
+		&i.Timezone,
+		&i.DefaultFees,
+		&i.FiscalYearStart,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.DeletedAt,
+	)
+	return i, err
 }
 
-const deleteTrade = `-- name: DeleteTrade :exec
-DELETE FROM trades
-USING tradebooks tb, tradebook_members tm
-WHERE trades.id = $1
-    AND trades.tradebook_id = tb.id
-    AND tb.id = tm.tradebook_id
-    AND (tb.owner_id = $2 OR (tm.user_id = $2 AND tm.role IN ('owner', 'editor')))
+const createTradebookFromTemplate = `-- name: CreateTradebookFromTemplate :one
+INSERT INTO tradebooks (
+    owner_id, title, workspace_id, template_id,
+    base_currency, accounting_method, default_asset_classes, tag_sets
+)
+SELECT
+    $1::text, $2::text, $3::text, t.id,
+    t.base_currency, t.accounting_method, t.default_asset_classes, t.tag_sets
+FROM tradebook_templates t
+WHERE t.id = $4
+    AND (t.owner_id = $1 OR (t.is_shared AND t.workspace_id = $5))
+RETURNING id, owner_id, workspace_id, title, template_id, base_currency, accounting_method, default_asset_classes, tag_sets, timezone, default_fees, fiscal_year_start, created_at, updated_at, deleted_at
 `
 
-type DeleteTradeParams struct {
-	TradeID uuid.UUID
-	UserID  string
+type CreateTradebookFromTemplateParams struct {
+	OwnerID     string
+	Title       string
+	WorkspaceID sql.NullString
+	TemplateID  uuid.UUID
+	OrgID       sql.NullString
 }
 
-func (q *Queries) DeleteTrade(ctx context.Context, arg DeleteTradeParams) error {
-	_, err := q.db.ExecContext(ctx, deleteTrade, arg.TradeID, arg.UserID)
-	return err
+// Seeds the settings from a template the owner can see (their own or one
+// shared in the session's workspace)
+func (q *Queries) CreateTradebookFromTemplate(ctx context.Context, arg CreateTradebookFromTemplateParams) (Tradebook, error) {
+	row := q.db.QueryRowContext(ctx, createTradebookFromTemplate,
+		arg.OwnerID,
+		arg.Title,
+		arg.WorkspaceID,
+		arg.TemplateID,
+		arg.OrgID,
+	)
+	var i Tradebook
+	err := row.Scan(
+		&i.ID,
+		&i.OwnerID,
+		&i.WorkspaceID,
+		&i.Title,
+		&i.TemplateID,
+		&i.BaseCurrency,
+		&i.AccountingMethod,
+		&i.DefaultAssetClasses,
+		&i.TagSets,
+		&i.Timezone,
+		&i.DefaultFees,
+		&i.FiscalYearStart,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.DeletedAt,
+	)
+	return i, err
 }
 
-const deleteTradebook = `-- name: DeleteTradebook :exec
-DELETE FROM tradebooks
-WHERE id = $1 AND owner_id = $2
+const createTradebookInvitation = `-- name: CreateTradebookInvitation :one
+
+INSERT INTO tradebook_invitations (
+    tradebook_id, email, role, token_hash, invited_by, expires_at
+)
+VALUES (
+    $1, $2, $3, $4, $5, $6
+)
+RETURNING id, tradebook_id, email, role, token_hash, invited_by, expires_at, accepted_at, accepted_by, revoked_at, created_at
 `
 
-type DeleteTradebookParams struct {
+type CreateTradebookInvitationParams struct {
 	TradebookID uuid.UUID
-	UserID      string
+	Email       string
+	Role        TradebookRole
+	TokenHash   string
+	InvitedBy   string
+	ExpiresAt   time.Time
 }
 
-func (q *Queries) DeleteTradebook(ctx context.Context, arg DeleteTradebookParams) error {
-	_, err := q.db.ExecContext(ctx, deleteTradebook, arg.TradebookID, arg.UserID)
-	return err
+// ============================================================================
+// 8. INVITATIONS
+// ============================================================================
+func (q *Queries) CreateTradebookInvitation(ctx context.Context, arg CreateTradebookInvitationParams) (TradebookInvitation, error) {
+	row := q.db.QueryRowContext(ctx, createTradebookInvitation,
+		arg.TradebookID,
+		arg.Email,
+		arg.Role,
+		arg.TokenHash,
+		arg.InvitedBy,
+		arg.ExpiresAt,
+	)
+	var i TradebookInvitation
+	err := row.Scan(
+		&i.ID,
+		&i.TradebookID,
+		&i.Email,
+		&i.Role,
+		&i.TokenHash,
+		&i.InvitedBy,
+		&i.ExpiresAt,
+		&i.AcceptedAt,
+		&i.AcceptedBy,
+		&i.RevokedAt,
+		&i.CreatedAt,
+	)
+	return i, err
 }
 
-const getExitLegCount = `-- name: GetExitLegCount :one
+const createTradebookTemplate = `-- name: CreateTradebookTemplate :one
 
-SELECT COUNT(*) FROM exit_legs WHERE trade_id = $1
+INSERT INTO tradebook_templates (
+    owner_id, workspace_id, name, is_shared, base_currency, accounting_method,
+    default_asset_classes, tag_sets, custom_fields
+) VALUES (
+    $1, $2, $3, $4, $5, $6,
+    $7, $8, $9
+)
+RETURNING id, owner_id, workspace_id, name, is_shared, base_currency, accounting_method, default_asset_classes, tag_sets, custom_fields, created_at, updated_at
 `
 
+type CreateTradebookTemplateParams struct {
+	OwnerID             string
+	WorkspaceID         sql.NullString
+	Name                string
+	IsShared            bool
+	BaseCurrency        string
+	AccountingMethod    AccountingMethod
+	DefaultAssetClasses json.RawMessage
+	TagSets             json.RawMessage
+	CustomFields        json.RawMessage
+}
+
 // ============================================================================
-// 5. EXIT LEGS
+// 12. TEMPLATES
 // ============================================================================
-// Used for Code-Level check before insertion
-func (q *Queries) GetExitLegCount(ctx context.Context, tradeID uuid.UUID) (int64, error) {
-	row := q.db.QueryRowContext(ctx, getExitLegCount, tradeID)
-	var count int64
-	err := row.Scan(&count)
-	return count, err
+func (q *Queries) CreateTradebookTemplate(ctx context.Context, arg CreateTradebookTemplateParams) (TradebookTemplate, error) {
+	row := q.db.QueryRowContext(ctx, createTradebookTemplate,
+		arg.OwnerID,
+		arg.WorkspaceID,
+		arg.Name,
+		arg.IsShared,
+		arg.BaseCurrency,
+		arg.AccountingMethod,
+		arg.DefaultAssetClasses,
+		arg.TagSets,
+		arg.CustomFields,
+	)
+	var i TradebookTemplate
+	err := row.Scan(
+		&i.ID,
+		&i.OwnerID,
+		&i.WorkspaceID,
+		&i.Name,
+		&i.IsShared,
+		&i.BaseCurrency,
+		&i.AccountingMethod,
+		&i.DefaultAssetClasses,
+		&i.TagSets,
+		&i.CustomFields,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
 }
 
-const getOpenPositions = `-- name: GetOpenPositions :many
+const deleteCommissionSchedule = `-- name: DeleteCommissionSchedule :one
+DELETE FROM commission_schedules
+WHERE tradebook_id = $1 AND asset_class = $2
+RETURNING tradebook_id, asset_class, rate_type, rate, min_fee, max_fee, regulatory_rate, regulatory_per_unit, created_at, updated_at
+`
 
-SELECT t.id, t.tradebook_id, t.is_open, t.asset_class, t.purchase_type, t.order_type, t.entry_date, t.symbol, t.currency, t.entry_quantity, t.entry_price, t.entry_fees, t.created_at, t.updated_at
-FROM trades t
-JOIN tradebooks tb ON t.tradebook_id = tb.id
-LEFT JOIN tradebook_members tm ON tb.id = tm.tradebook_id
-WHERE t.tradebook_id = $1
-    AND t.is_open = TRUE
-    AND (tb.owner_id = $2 OR tm.user_id = $2)
-ORDER BY t.entry_date DESC
+type DeleteCommissionScheduleParams struct {
+	TradebookID uuid.UUID
+	AssetClass  AssetClass
+}
+
+func (q *Queries) DeleteCommissionSchedule(ctx context.Context, arg DeleteCommissionScheduleParams) (CommissionSchedule, error) {
+	row := q.db.QueryRowContext(ctx, deleteCommissionSchedule, arg.TradebookID, arg.AssetClass)
+	var i CommissionSchedule
+	err := row.Scan(
+		&i.TradebookID,
+		&i.AssetClass,
+		&i.RateType,
+		&i.Rate,
+		&i.MinFee,
+		&i.MaxFee,
+		&i.RegulatoryRate,
+		&i.RegulatoryPerUnit,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const deleteCustomField = `-- name: DeleteCustomField :one
+DELETE FROM custom_field_definitions
+WHERE id = $1
+    AND tradebook_id = $2
+RETURNING id, tradebook_id, name, field_type, options, position, created_at, updated_at
 `
 
-type GetOpenPositionsParams struct {
+type DeleteCustomFieldParams struct {
+	FieldID     uuid.UUID
 	TradebookID uuid.UUID
-	UserID      string
 }
 
-// ============================================================================
-// 6. DASHBOARD
-// ============================================================================
-func (q *Queries) GetOpenPositions(ctx context.Context, arg GetOpenPositionsParams) ([]Trade, error) {
-	rows, err := q.db.QueryContext(ctx, getOpenPositions, arg.TradebookID, arg.UserID)
+func (q *Queries) DeleteCustomField(ctx context.Context, arg DeleteCustomFieldParams) (CustomFieldDefinition, error) {
+	row := q.db.QueryRowContext(ctx, deleteCustomField, arg.FieldID, arg.TradebookID)
+	var i CustomFieldDefinition
+	err := row.Scan(
+		&i.ID,
+		&i.TradebookID,
+		&i.Name,
+		&i.FieldType,
+		&i.Options,
+		&i.Position,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const deleteInvitationsSentBy = `-- name: DeleteInvitationsSentBy :execrows
+DELETE FROM tradebook_invitations WHERE invited_by = $1
+`
+
+func (q *Queries) DeleteInvitationsSentBy(ctx context.Context, userID string) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteInvitationsSentBy, userID)
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
-	defer rows.Close()
+	return result.RowsAffected()
+}
+
+const deleteOwnedTradebooks = `-- name: DeleteOwnedTradebooks :execrows
+DELETE FROM tradebooks WHERE owner_id = $1
+`
+
+// Hard delete, trash included; trades and exit legs cascade
+func (q *Queries) DeleteOwnedTradebooks(ctx context.Context, ownerID string) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteOwnedTradebooks, ownerID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const deleteTemplatesOwnedBy = `-- name: DeleteTemplatesOwnedBy :execrows
+DELETE FROM tradebook_templates WHERE owner_id = $1
+`
+
+func (q *Queries) DeleteTemplatesOwnedBy(ctx context.Context, ownerID string) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteTemplatesOwnedBy, ownerID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const deleteTradebookTemplate = `-- name: DeleteTradebookTemplate :execrows
+DELETE FROM tradebook_templates
+WHERE id = $1
+    AND owner_id = $2
+`
+
+type DeleteTradebookTemplateParams struct {
+	TemplateID uuid.UUID
+	OwnerID    string
+}
+
+// Tradebooks created from the template keep their settings
+func (q *Queries) DeleteTradebookTemplate(ctx context.Context, arg DeleteTradebookTemplateParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteTradebookTemplate, arg.TemplateID, arg.OwnerID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const deleteUser = `-- name: DeleteUser :execrows
+DELETE FROM users WHERE id = $1
+`
+
+func (q *Queries) DeleteUser(ctx context.Context, id string) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteUser, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const deleteUserMemberships = `-- name: DeleteUserMemberships :execrows
+DELETE FROM tradebook_members WHERE user_id = $1
+`
+
+func (q *Queries) DeleteUserMemberships(ctx context.Context, userID string) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteUserMemberships, userID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const deleteWorkspace = `-- name: DeleteWorkspace :execrows
+DELETE FROM workspaces WHERE id = $1
+`
+
+// Its tradebooks fall back to being personal books of their owners
+func (q *Queries) DeleteWorkspace(ctx context.Context, id string) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteWorkspace, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const deleteWorkspaceMember = `-- name: DeleteWorkspaceMember :execrows
+DELETE FROM workspace_members
+WHERE workspace_id = $1
+    AND user_id = $2
+`
+
+type DeleteWorkspaceMemberParams struct {
+	WorkspaceID string
+	UserID      string
+}
+
+func (q *Queries) DeleteWorkspaceMember(ctx context.Context, arg DeleteWorkspaceMemberParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteWorkspaceMember, arg.WorkspaceID, arg.UserID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const ensureWorkspace = `-- name: EnsureWorkspace :exec
+INSERT INTO workspaces (id)
+VALUES ($1)
+ON CONFLICT (id) DO NOTHING
+`
+
+// For memberships that arrive before their organization
+func (q *Queries) EnsureWorkspace(ctx context.Context, id string) error {
+	_, err := q.db.ExecContext(ctx, ensureWorkspace, id)
+	return err
+}
+
+const eraseUserRecords = `-- name: EraseUserRecords :one
+SELECT
+    audit_entries::bigint AS audit_entries,
+    ownership_transfers::bigint AS ownership_transfers,
+    usage_logs::bigint AS usage_logs,
+    invitations_received::bigint AS invitations_received
+FROM erase_user_records($1::text, $2::text, $3::text)
+`
+
+type EraseUserRecordsParams struct {
+	UserID    string
+	Pseudonym string
+	Email     sql.NullString
+}
+
+type EraseUserRecordsRow struct {
+	AuditEntries        int64
+	OwnershipTransfers  int64
+	UsageLogs           int64
+	InvitationsReceived int64
+}
+
+// Anonymizes audit entries and ownership transfers, and purges usage logs
+// and invitations addressed to the user's email
+func (q *Queries) EraseUserRecords(ctx context.Context, arg EraseUserRecordsParams) (EraseUserRecordsRow, error) {
+	row := q.db.QueryRowContext(ctx, eraseUserRecords, arg.UserID, arg.Pseudonym, arg.Email)
+	var i EraseUserRecordsRow
+	err := row.Scan(
+		&i.AuditEntries,
+		&i.OwnershipTransfers,
+		&i.UsageLogs,
+		&i.InvitationsReceived,
+	)
+	return i, err
+}
+
+const getActiveAPIKeyByHash = `-- name: GetActiveAPIKeyByHash :one
+SELECT id, user_id, org_id, name, prefix, key_hash, scopes, tradebook_ids, expires_at, last_used_at, revoked_at, created_at FROM api_keys
+WHERE key_hash = $1
+    AND revoked_at IS NULL
+    AND (expires_at IS NULL OR expires_at > NOW())
+`
+
+func (q *Queries) GetActiveAPIKeyByHash(ctx context.Context, keyHash string) (ApiKey, error) {
+	row := q.db.QueryRowContext(ctx, getActiveAPIKeyByHash, keyHash)
+	var i ApiKey
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.OrgID,
+		&i.Name,
+		&i.Prefix,
+		&i.KeyHash,
+		&i.Scopes,
+		&i.TradebookIds,
+		&i.ExpiresAt,
+		&i.LastUsedAt,
+		&i.RevokedAt,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const getBillingCustomer = `-- name: GetBillingCustomer :one
+SELECT stripe_customer_id FROM billing_customers
+WHERE user_id = $1
+`
+
+func (q *Queries) GetBillingCustomer(ctx context.Context, userID string) (string, error) {
+	row := q.db.QueryRowContext(ctx, getBillingCustomer, userID)
+	var stripe_customer_id string
+	err := row.Scan(&stripe_customer_id)
+	return stripe_customer_id, err
+}
+
+const getBillingCustomerUser = `-- name: GetBillingCustomerUser :one
+SELECT user_id FROM billing_customers
+WHERE stripe_customer_id = $1
+`
+
+func (q *Queries) GetBillingCustomerUser(ctx context.Context, stripeCustomerID string) (string, error) {
+	row := q.db.QueryRowContext(ctx, getBillingCustomerUser, stripeCustomerID)
+	var user_id string
+	err := row.Scan(&user_id)
+	return user_id, err
+}
+
+const getCommissionSchedule = `-- name: GetCommissionSchedule :one
+SELECT tradebook_id, asset_class, rate_type, rate, min_fee, max_fee, regulatory_rate, regulatory_per_unit, created_at, updated_at FROM commission_schedules
+WHERE tradebook_id = $1 AND asset_class = $2
+`
+
+type GetCommissionScheduleParams struct {
+	TradebookID uuid.UUID
+	AssetClass  AssetClass
+}
+
+func (q *Queries) GetCommissionSchedule(ctx context.Context, arg GetCommissionScheduleParams) (CommissionSchedule, error) {
+	row := q.db.QueryRowContext(ctx, getCommissionSchedule, arg.TradebookID, arg.AssetClass)
+	var i CommissionSchedule
+	err := row.Scan(
+		&i.TradebookID,
+		&i.AssetClass,
+		&i.RateType,
+		&i.Rate,
+		&i.MinFee,
+		&i.MaxFee,
+		&i.RegulatoryRate,
+		&i.RegulatoryPerUnit,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const getCurrentSubscription = `-- name: GetCurrentSubscription :one
+SELECT id, user_id, stripe_customer_id, price_id, plan, status, current_period_end, cancel_at_period_end, synced_at, latest_invoice_status, invoice_synced_at, created_at, updated_at FROM subscriptions
+WHERE user_id = $1
+ORDER BY
+    (status IN ('active', 'trialing', 'past_due')) DESC,
+    current_period_end DESC,
+    id
+LIMIT 1
+`
+
+// Prefers a subscription that still grants its plan, then the latest period
+func (q *Queries) GetCurrentSubscription(ctx context.Context, userID string) (Subscription, error) {
+	row := q.db.QueryRowContext(ctx, getCurrentSubscription, userID)
+	var i Subscription
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.StripeCustomerID,
+		&i.PriceID,
+		&i.Plan,
+		&i.Status,
+		&i.CurrentPeriodEnd,
+		&i.CancelAtPeriodEnd,
+		&i.SyncedAt,
+		&i.LatestInvoiceStatus,
+		&i.InvoiceSyncedAt,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const getCustomField = `-- name: GetCustomField :one
+SELECT id, tradebook_id, name, field_type, options, position, created_at, updated_at FROM custom_field_definitions
+WHERE id = $1 AND tradebook_id = $2
+`
+
+type GetCustomFieldParams struct {
+	FieldID     uuid.UUID
+	TradebookID uuid.UUID
+}
+
+func (q *Queries) GetCustomField(ctx context.Context, arg GetCustomFieldParams) (CustomFieldDefinition, error) {
+	row := q.db.QueryRowContext(ctx, getCustomField, arg.FieldID, arg.TradebookID)
+	var i CustomFieldDefinition
+	err := row.Scan(
+		&i.ID,
+		&i.TradebookID,
+		&i.Name,
+		&i.FieldType,
+		&i.Options,
+		&i.Position,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const getExitLegCount = `-- name: GetExitLegCount :one
+
+SELECT COUNT(*) FROM exit_legs WHERE trade_id = $1
+`
+
+// ============================================================================
+// 5. EXIT LEGS
+// ============================================================================
+// Used for Code-Level check before insertion
+func (q *Queries) GetExitLegCount(ctx context.Context, tradeID uuid.UUID) (int64, error) {
+	row := q.db.QueryRowContext(ctx, getExitLegCount, tradeID)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const getOpenPositions = `-- name: GetOpenPositions :many
+
+SELECT id, tradebook_id, cloned_from_id, is_open, asset_class, purchase_type, order_type, entry_date, symbol, currency, entry_quantity, entry_price, entry_fees, entry_fees_auto, custom_fields, created_at, updated_at, deleted_at FROM trades
+WHERE tradebook_id = $1
+    AND is_open = TRUE
+    AND deleted_at IS NULL
+ORDER BY entry_date DESC
+`
+
+// ============================================================================
+// 6. DASHBOARD
+// ============================================================================
+func (q *Queries) GetOpenPositions(ctx context.Context, tradebookID uuid.UUID) ([]Trade, error) {
+	rows, err := q.db.QueryContext(ctx, getOpenPositions, tradebookID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 	var items []Trade
 	for rows.Next() {
 		var i Trade
 		if err := rows.Scan(
 			&i.ID,
 			&i.TradebookID,
+			&i.ClonedFromID,
 			&i.IsOpen,
 			&i.AssetClass,
 			&i.PurchaseType,
@@ -265,8 +1109,11 @@ func (q *Queries) GetOpenPositions(ctx context.Context, arg GetOpenPositionsPara
 			&i.EntryQuantity,
 			&i.EntryPrice,
 			&i.EntryFees,
+			&i.EntryFeesAuto,
+			&i.CustomFields,
 			&i.CreatedAt,
 			&i.UpdatedAt,
+			&i.DeletedAt,
 		); err != nil {
 			return nil, err
 		}
@@ -281,25 +1128,80 @@ func (q *Queries) GetOpenPositions(ctx context.Context, arg GetOpenPositionsPara
 	return items, nil
 }
 
+const getPendingInvitationByTokenHash = `-- name: GetPendingInvitationByTokenHash :one
+SELECT id, tradebook_id, email, role, token_hash, invited_by, expires_at, accepted_at, accepted_by, revoked_at, created_at FROM tradebook_invitations
+WHERE token_hash = $1
+    AND accepted_at IS NULL
+    AND revoked_at IS NULL
+    AND expires_at > NOW()
+FOR UPDATE
+`
+
+// Locks the invitation row so a token can only be accepted once
+func (q *Queries) GetPendingInvitationByTokenHash(ctx context.Context, tokenHash string) (TradebookInvitation, error) {
+	row := q.db.QueryRowContext(ctx, getPendingInvitationByTokenHash, tokenHash)
+	var i TradebookInvitation
+	err := row.Scan(
+		&i.ID,
+		&i.TradebookID,
+		&i.Email,
+		&i.Role,
+		&i.TokenHash,
+		&i.InvitedBy,
+		&i.ExpiresAt,
+		&i.AcceptedAt,
+		&i.AcceptedBy,
+		&i.RevokedAt,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const getRowSecurityBypass = `-- name: GetRowSecurityBypass :one
+SELECT
+    r.rolsuper::boolean AS is_superuser,
+    r.rolbypassrls::boolean AS bypasses_rls,
+    EXISTS (
+        SELECT 1 FROM pg_class c
+        WHERE c.relrowsecurity AND pg_has_role(r.oid, c.relowner, 'MEMBER')
+    )::boolean AS owns_tables
+FROM pg_roles r
+WHERE r.rolname = current_user
+`
+
+type GetRowSecurityBypassRow struct {
+	IsSuperuser bool
+	BypassesRls bool
+	OwnsTables  bool
+}
+
+// Ways the connected role could skip the policies; the API refuses to start
+// with any of them
+func (q *Queries) GetRowSecurityBypass(ctx context.Context) (GetRowSecurityBypassRow, error) {
+	row := q.db.QueryRowContext(ctx, getRowSecurityBypass)
+	var i GetRowSecurityBypassRow
+	err := row.Scan(&i.IsSuperuser, &i.BypassesRls, &i.OwnsTables)
+	return i, err
+}
+
 const getTrade = `-- name: GetTrade :one
-SELECT t.id, t.tradebook_id, t.is_open, t.asset_class, t.purchase_type, t.order_type, t.entry_date, t.symbol, t.currency, t.entry_quantity, t.entry_price, t.entry_fees, t.created_at, t.updated_at FROM trades t
-JOIN tradebooks tb ON t.tradebook_id = tb.id
-LEFT JOIN tradebook_members tm ON tb.id = tm.tradebook_id
-WHERE t.id = $1
-    AND (tb.owner_id = $2 OR tm.user_id = $2)
+SELECT id, tradebook_id, cloned_from_id, is_open, asset_class, purchase_type, order_type, entry_date, symbol, currency, entry_quantity, entry_price, entry_fees, entry_fees_auto, custom_fields, created_at, updated_at, deleted_at FROM trades
+WHERE id = $1 AND tradebook_id = $2
+    AND deleted_at IS NULL
 `
 
 type GetTradeParams struct {
-	TradeID uuid.UUID
-	UserID  string
+	TradeID     uuid.UUID
+	TradebookID uuid.UUID
 }
 
 func (q *Queries) GetTrade(ctx context.Context, arg GetTradeParams) (Trade, error) {
-	row := q.db.QueryRowContext(ctx, getTrade, arg.TradeID, arg.UserID)
+	row := q.db.QueryRowContext(ctx, getTrade, arg.TradeID, arg.TradebookID)
 	var i Trade
 	err := row.Scan(
 		&i.ID,
 		&i.TradebookID,
+		&i.ClonedFromID,
 		&i.IsOpen,
 		&i.AssetClass,
 		&i.PurchaseType,
@@ -310,96 +1212,82 @@ func (q *Queries) GetTrade(ctx context.Context, arg GetTradeParams) (Trade, erro
 		&i.EntryQuantity,
 		&i.EntryPrice,
 		&i.EntryFees,
+		&i.EntryFeesAuto,
+		&i.CustomFields,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.DeletedAt,
 	)
 	return i, err
 }
 
-const getTradebook = `-- name: GetTradebook :one
+const getTradeBreakdown = `-- name: GetTradeBreakdown :many
 SELECT
-    tb.id, tb.owner_id, tb.title, tb.created_at, tb.updated_at,
-    COALESCE(tm.role, 'owner')::tradebook_role AS user_role
-FROM tradebooks tb
-LEFT JOIN tradebook_members tm
-    ON tb.id = tm.tradebook_id AND tm.user_id = $1
-WHERE tb.id = $2
-    AND (tb.owner_id = $1 OR tm.user_id IS NOT NULL)
+    (t.custom_fields -> $1::text) AS value,
+    COUNT(*)::bigint AS trade_count,
+    COUNT(*) FILTER (WHERE t.is_open)::bigint AS open_count,
+    COALESCE(SUM(t.entry_quantity * t.entry_price), 0)::numeric AS entry_notional,
+    COALESCE(SUM(COALESCE(t.entry_fees, 0) + exits.fees), 0)::numeric AS total_fees,
+    COALESCE(SUM(($2::jsonb ->> t.id::text)::numeric), 0)::numeric AS realized_pnl
+FROM trades t
+CROSS JOIN LATERAL (
+    SELECT COALESCE(SUM(COALESCE(el.exit_fees, 0)), 0) AS fees
+    FROM exit_legs el
+    WHERE el.trade_id = t.id
+) exits
+WHERE t.tradebook_id = $3
+    AND t.deleted_at IS NULL
+    AND ($4::jsonb IS NULL OR t.custom_fields @> $4::jsonb)
+    AND ($5::timestamptz IS NULL OR t.entry_date >= $5::timestamptz)
+    AND ($6::timestamptz IS NULL OR t.entry_date < $6::timestamptz)
+GROUP BY 1
+ORDER BY trade_count DESC
 `
 
-type GetTradebookParams struct {
-	UserID      string
-	TradebookID uuid.UUID
+type GetTradeBreakdownParams struct {
+	FieldID      string
+	RealizedPnl  json.RawMessage
+	TradebookID  uuid.UUID
+	CustomFields pqtype.NullRawMessage
+	EntryFrom    sql.NullTime
+	EntryTo      sql.NullTime
 }
 
-type GetTradebookRow struct {
-	ID        uuid.UUID
-	OwnerID   string
-	Title     string
-	CreatedAt time.Time
-	UpdatedAt time.Time
-	UserRole  TradebookRole
+type GetTradeBreakdownRow struct {
+	Value         pqtype.NullRawMessage
+	TradeCount    int64
+	OpenCount     int64
+	EntryNotional decimal.Decimal
+	TotalFees     decimal.Decimal
+	RealizedPnl   decimal.Decimal
 }
 
-func (q *Queries) GetTradebook(ctx context.Context, arg GetTradebookParams) (GetTradebookRow, error) {
-	row := q.db.QueryRowContext(ctx, getTradebook, arg.UserID, arg.TradebookID)
-	var i GetTradebookRow
-	err := row.Scan(
-		&i.ID,
-		&i.OwnerID,
-		&i.Title,
-		&i.CreatedAt,
-		&i.UpdatedAt,
-		&i.UserRole,
+// Groups live trades by one custom field's value; trades without it fall in the NULL group.
+// Realized P&L is matched to lots by the caller, per the tradebook's accounting
+// method, and passed in as {"<trade_id>": "<pnl>"}
+func (q *Queries) GetTradeBreakdown(ctx context.Context, arg GetTradeBreakdownParams) ([]GetTradeBreakdownRow, error) {
+	rows, err := q.db.QueryContext(ctx, getTradeBreakdown,
+		arg.FieldID,
+		arg.RealizedPnl,
+		arg.TradebookID,
+		arg.CustomFields,
+		arg.EntryFrom,
+		arg.EntryTo,
 	)
-	return i, err
-}
-
-const getUser = `-- name: GetUser :one
-SELECT id, created_at, updated_at FROM users
-WHERE id = $1
-`
-
-func (q *Queries) GetUser(ctx context.Context, id string) (User, error) {
-	row := q.db.QueryRowContext(ctx, getUser, id)
-	var i User
-	err := row.Scan(&i.ID, &i.CreatedAt, &i.UpdatedAt)
-	return i, err
-}
-
-const listExitLegs = `-- name: ListExitLegs :many
-SELECT el.id, el.trade_id, el.exit_date, el.exit_quantity, el.exit_price, el.exit_fees, el.created_at, el.updated_at FROM exit_legs el
-JOIN trades t ON el.trade_id = t.id
-JOIN tradebooks tb ON t.tradebook_id = tb.id
-LEFT JOIN tradebook_members tm ON tb.id = tm.tradebook_id
-WHERE t.id = $1
-    AND (tb.owner_id = $2 OR tm.user_id = $2)
-ORDER BY el.exit_date ASC
-`
-
-type ListExitLegsParams struct {
-	TradeID uuid.UUID
-	UserID  string
-}
-
-func (q *Queries) ListExitLegs(ctx context.Context, arg ListExitLegsParams) ([]ExitLeg, error) {
-	rows, err := q.db.QueryContext(ctx, listExitLegs, arg.TradeID, arg.UserID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var items []ExitLeg
+	var items []GetTradeBreakdownRow
 	for rows.Next() {
-		var i ExitLeg
+		var i GetTradeBreakdownRow
 		if err := rows.Scan(
-			&i.ID,
-			&i.TradeID,
-			&i.ExitDate,
-			&i.ExitQuantity,
-			&i.ExitPrice,
-			&i.ExitFees,
-			&i.CreatedAt,
-			&i.UpdatedAt,
+			&i.Value,
+			&i.TradeCount,
+			&i.OpenCount,
+			&i.EntryNotional,
+			&i.TotalFees,
+			&i.RealizedPnl,
 		); err != nil {
 			return nil, err
 		}
@@ -414,50 +1302,366 @@ func (q *Queries) ListExitLegs(ctx context.Context, arg ListExitLegsParams) ([]E
 	return items, nil
 }
 
-const listTradebooks = `-- name: ListTradebooks :many
+const getTradebook = `-- name: GetTradebook :one
 SELECT
-    tb.id, tb.owner_id, tb.title, tb.created_at, tb.updated_at,
-    COALESCE(tm.role, 'owner')::tradebook_role AS user_role
+    tb.id, tb.owner_id, tb.workspace_id, tb.title, tb.template_id, tb.base_currency, tb.accounting_method, tb.default_asset_classes, tb.tag_sets, tb.timezone, tb.default_fees, tb.fiscal_year_start, tb.created_at, tb.updated_at, tb.deleted_at,
+    (CASE WHEN tb.owner_id = $1 THEN 'owner' ELSE LEAST(tm.role, wm.role) END)::tradebook_role AS user_role,
+    COALESCE(tp.is_pinned, FALSE)::boolean AS is_pinned,
+    COALESCE(tp.is_archived, FALSE)::boolean AS is_archived
 FROM tradebooks tb
 LEFT JOIN tradebook_members tm
     ON tb.id = tm.tradebook_id AND tm.user_id = $1
-WHERE tb.owner_id = $1
-    OR tm.user_id = $1
-ORDER BY tb.updated_at DESC
-LIMIT $3 OFFSET $2
+LEFT JOIN tradebook_preferences tp
+    ON tb.id = tp.tradebook_id AND tp.user_id = $1
+LEFT JOIN workspace_members wm
+    ON wm.workspace_id = tb.workspace_id AND wm.workspace_id = $2 AND wm.user_id = $1
+WHERE tb.id = $3
+    AND (tb.owner_id = $1 OR tm.user_id IS NOT NULL OR wm.user_id IS NOT NULL)
+    AND tb.deleted_at IS NULL
 `
 
-type ListTradebooksParams struct {
-	UserID    string
-	OffsetVal int32
-	LimitVal  int32
+type GetTradebookParams struct {
+	UserID      string
+	OrgID       sql.NullString
+	TradebookID uuid.UUID
 }
 
-type ListTradebooksRow struct {
-	ID        uuid.UUID
-	OwnerID   string
-	Title     string
-	CreatedAt time.Time
-	UpdatedAt time.Time
-	UserRole  TradebookRole
+type GetTradebookRow struct {
+	ID                  uuid.UUID
+	OwnerID             string
+	WorkspaceID         sql.NullString
+	Title               string
+	TemplateID          uuid.NullUUID
+	BaseCurrency        string
+	AccountingMethod    AccountingMethod
+	DefaultAssetClasses json.RawMessage
+	TagSets             json.RawMessage
+	Timezone            string
+	DefaultFees         decimal.Decimal
+	FiscalYearStart     int32
+	CreatedAt           time.Time
+	UpdatedAt           time.Time
+	DeletedAt           sql.NullTime
+	UserRole            TradebookRole
+	IsPinned            bool
+	IsArchived          bool
 }
 
-func (q *Queries) ListTradebooks(ctx context.Context, arg ListTradebooksParams) ([]ListTradebooksRow, error) {
-	rows, err := q.db.QueryContext(ctx, listTradebooks, arg.UserID, arg.OffsetVal, arg.LimitVal)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-	var items []ListTradebooksRow
-	for rows.Next() {
-		var i ListTradebooksRow
-		if err := rows.Scan(
-			&i.ID,
-			&i.OwnerID,
-			&i.Title,
-			&i.CreatedAt,
-			&i.UpdatedAt,
-			&i.UserRole,
+func (q *Queries) GetTradebook(ctx context.Context, arg GetTradebookParams) (GetTradebookRow, error) {
+	row := q.db.QueryRowContext(ctx, getTradebook, arg.UserID, arg.OrgID, arg.TradebookID)
+	var i GetTradebookRow
+	err := row.Scan(
+		&i.ID,
+		&i.OwnerID,
+		&i.WorkspaceID,
+		&i.Title,
+		&i.TemplateID,
+		&i.BaseCurrency,
+		&i.AccountingMethod,
+		&i.DefaultAssetClasses,
+		&i.TagSets,
+		&i.Timezone,
+		&i.DefaultFees,
+		&i.FiscalYearStart,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.DeletedAt,
+		&i.UserRole,
+		&i.IsPinned,
+		&i.IsArchived,
+	)
+	return i, err
+}
+
+const getTradebookMember = `-- name: GetTradebookMember :one
+SELECT tradebook_id, user_id, role, joined_at FROM tradebook_members
+WHERE tradebook_id = $1 AND user_id = $2
+`
+
+type GetTradebookMemberParams struct {
+	TradebookID uuid.UUID
+	UserID      string
+}
+
+func (q *Queries) GetTradebookMember(ctx context.Context, arg GetTradebookMemberParams) (TradebookMember, error) {
+	row := q.db.QueryRowContext(ctx, getTradebookMember, arg.TradebookID, arg.UserID)
+	var i TradebookMember
+	err := row.Scan(
+		&i.TradebookID,
+		&i.UserID,
+		&i.Role,
+		&i.JoinedAt,
+	)
+	return i, err
+}
+
+const getTradebookRole = `-- name: GetTradebookRole :one
+SELECT
+    (CASE WHEN tb.owner_id = $1 THEN 'owner' ELSE LEAST(tm.role, wm.role) END)::tradebook_role AS role,
+    (tb.deleted_at IS NOT NULL)::boolean AS is_deleted
+FROM tradebooks tb
+LEFT JOIN tradebook_members tm
+    ON tb.id = tm.tradebook_id AND tm.user_id = $1
+LEFT JOIN workspace_members wm
+    ON wm.workspace_id = tb.workspace_id AND wm.workspace_id = $2 AND wm.user_id = $1
+WHERE tb.id = $3
+    AND (tb.owner_id = $1 OR tm.user_id IS NOT NULL OR wm.user_id IS NOT NULL)
+`
+
+type GetTradebookRoleParams struct {
+	UserID      string
+	OrgID       sql.NullString
+	TradebookID uuid.UUID
+}
+
+type GetTradebookRoleRow struct {
+	Role      TradebookRole
+	IsDeleted bool
+}
+
+// Resolves the caller's effective role, the stronger of their role on the
+// book and, in a session of the book's workspace, their workspace role; no
+// row means no access
+func (q *Queries) GetTradebookRole(ctx context.Context, arg GetTradebookRoleParams) (GetTradebookRoleRow, error) {
+	row := q.db.QueryRowContext(ctx, getTradebookRole, arg.UserID, arg.OrgID, arg.TradebookID)
+	var i GetTradebookRoleRow
+	err := row.Scan(&i.Role, &i.IsDeleted)
+	return i, err
+}
+
+const getTradebookSettings = `-- name: GetTradebookSettings :one
+SELECT timezone, base_currency, accounting_method, default_fees, fiscal_year_start
+FROM tradebooks
+WHERE id = $1
+`
+
+type GetTradebookSettingsRow struct {
+	Timezone         string
+	BaseCurrency     string
+	AccountingMethod AccountingMethod
+	DefaultFees      decimal.Decimal
+	FiscalYearStart  int32
+}
+
+// Everything trade and report calculations need; access is checked by the caller
+func (q *Queries) GetTradebookSettings(ctx context.Context, tradebookID uuid.UUID) (GetTradebookSettingsRow, error) {
+	row := q.db.QueryRowContext(ctx, getTradebookSettings, tradebookID)
+	var i GetTradebookSettingsRow
+	err := row.Scan(
+		&i.Timezone,
+		&i.BaseCurrency,
+		&i.AccountingMethod,
+		&i.DefaultFees,
+		&i.FiscalYearStart,
+	)
+	return i, err
+}
+
+const getTradebookTemplate = `-- name: GetTradebookTemplate :one
+SELECT id, owner_id, workspace_id, name, is_shared, base_currency, accounting_method, default_asset_classes, tag_sets, custom_fields, created_at, updated_at FROM tradebook_templates
+WHERE id = $1
+    AND (owner_id = $2 OR (is_shared AND workspace_id = $3))
+`
+
+type GetTradebookTemplateParams struct {
+	TemplateID uuid.UUID
+	UserID     string
+	OrgID      sql.NullString
+}
+
+func (q *Queries) GetTradebookTemplate(ctx context.Context, arg GetTradebookTemplateParams) (TradebookTemplate, error) {
+	row := q.db.QueryRowContext(ctx, getTradebookTemplate, arg.TemplateID, arg.UserID, arg.OrgID)
+	var i TradebookTemplate
+	err := row.Scan(
+		&i.ID,
+		&i.OwnerID,
+		&i.WorkspaceID,
+		&i.Name,
+		&i.IsShared,
+		&i.BaseCurrency,
+		&i.AccountingMethod,
+		&i.DefaultAssetClasses,
+		&i.TagSets,
+		&i.CustomFields,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const getUser = `-- name: GetUser :one
+SELECT id, email, display_name, avatar_url, profile_updated_at, preferences, created_at, updated_at FROM users
+WHERE id = $1
+`
+
+func (q *Queries) GetUser(ctx context.Context, id string) (User, error) {
+	row := q.db.QueryRowContext(ctx, getUser, id)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Email,
+		&i.DisplayName,
+		&i.AvatarUrl,
+		&i.ProfileUpdatedAt,
+		&i.Preferences,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const getWorkspace = `-- name: GetWorkspace :one
+SELECT
+    w.id, w.name, w.created_at,
+    wm.role, wm.joined_at
+FROM workspaces w
+JOIN workspace_members wm ON wm.workspace_id = w.id
+WHERE w.id = $1
+    AND wm.user_id = $2
+`
+
+type GetWorkspaceParams struct {
+	WorkspaceID string
+	UserID      string
+}
+
+type GetWorkspaceRow struct {
+	ID        string
+	Name      string
+	CreatedAt time.Time
+	Role      TradebookRole
+	JoinedAt  time.Time
+}
+
+func (q *Queries) GetWorkspace(ctx context.Context, arg GetWorkspaceParams) (GetWorkspaceRow, error) {
+	row := q.db.QueryRowContext(ctx, getWorkspace, arg.WorkspaceID, arg.UserID)
+	var i GetWorkspaceRow
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.CreatedAt,
+		&i.Role,
+		&i.JoinedAt,
+	)
+	return i, err
+}
+
+const importExitLeg = `-- name: ImportExitLeg :exec
+INSERT INTO exit_legs (
+    trade_id, exit_date, exit_quantity, exit_price, exit_fees, exit_fees_auto, created_at
+) VALUES (
+    $1, $2, $3, $4, $5, $6, $7
+)
+`
+
+type ImportExitLegParams struct {
+	TradeID      uuid.UUID
+	ExitDate     time.Time
+	ExitQuantity decimal.Decimal
+	ExitPrice    decimal.Decimal
+	ExitFees     decimal.NullDecimal
+	ExitFeesAuto bool
+	CreatedAt    time.Time
+}
+
+func (q *Queries) ImportExitLeg(ctx context.Context, arg ImportExitLegParams) error {
+	_, err := q.db.ExecContext(ctx, importExitLeg,
+		arg.TradeID,
+		arg.ExitDate,
+		arg.ExitQuantity,
+		arg.ExitPrice,
+		arg.ExitFees,
+		arg.ExitFeesAuto,
+		arg.CreatedAt,
+	)
+	return err
+}
+
+const importTrade = `-- name: ImportTrade :one
+
+INSERT INTO trades (
+    tradebook_id, is_open, asset_class, purchase_type, order_type,
+    entry_date, symbol, currency, entry_quantity, entry_price, entry_fees, entry_fees_auto,
+    custom_fields, created_at
+) VALUES (
+    $1, $2, $3, $4, $5,
+    $6, $7, $8, $9, $10, $11, $12,
+    $13, $14
+)
+RETURNING id
+`
+
+type ImportTradeParams struct {
+	TradebookID   uuid.UUID
+	IsOpen        bool
+	AssetClass    AssetClass
+	PurchaseType  TradePurchaseType
+	OrderType     TradeOrderType
+	EntryDate     time.Time
+	Symbol        string
+	Currency      string
+	EntryQuantity decimal.Decimal
+	EntryPrice    decimal.Decimal
+	EntryFees     decimal.NullDecimal
+	EntryFeesAuto bool
+	CustomFields  json.RawMessage
+	CreatedAt     time.Time
+}
+
+// ============================================================================
+// 16. IMPORT
+// ============================================================================
+// Like CreateTrade, but keeps the archived status, fee origin and creation time
+func (q *Queries) ImportTrade(ctx context.Context, arg ImportTradeParams) (uuid.UUID, error) {
+	row := q.db.QueryRowContext(ctx, importTrade,
+		arg.TradebookID,
+		arg.IsOpen,
+		arg.AssetClass,
+		arg.PurchaseType,
+		arg.OrderType,
+		arg.EntryDate,
+		arg.Symbol,
+		arg.Currency,
+		arg.EntryQuantity,
+		arg.EntryPrice,
+		arg.EntryFees,
+		arg.EntryFeesAuto,
+		arg.CustomFields,
+		arg.CreatedAt,
+	)
+	var id uuid.UUID
+	err := row.Scan(&id)
+	return id, err
+}
+
+const listAPIKeys = `-- name: ListAPIKeys :many
+SELECT id, user_id, org_id, name, prefix, key_hash, scopes, tradebook_ids, expires_at, last_used_at, revoked_at, created_at FROM api_keys
+WHERE user_id = $1
+ORDER BY created_at DESC, id DESC
+`
+
+func (q *Queries) ListAPIKeys(ctx context.Context, userID string) ([]ApiKey, error) {
+	rows, err := q.db.QueryContext(ctx, listAPIKeys, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ApiKey
+	for rows.Next() {
+		var i ApiKey
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.OrgID,
+			&i.Name,
+			&i.Prefix,
+			&i.KeyHash,
+			&i.Scopes,
+			&i.TradebookIds,
+			&i.ExpiresAt,
+			&i.LastUsedAt,
+			&i.RevokedAt,
+			&i.CreatedAt,
 		); err != nil {
 			return nil, err
 		}
@@ -472,145 +1676,2112 @@ func (q *Queries) ListTradebooks(ctx context.Context, arg ListTradebooksParams)
 	return items, nil
 }
 
-const listTrades = `-- name: ListTrades :many
-SELECT t.id, t.tradebook_id, t.is_open, t.asset_class, t.purchase_type, t.order_type, t.entry_date, t.symbol, t.currency, t.entry_quantity, t.entry_price, t.entry_fees, t.created_at, t.updated_at FROM trades t
-JOIN tradebooks tb ON t.tradebook_id = tb.id
-LEFT JOIN tradebook_members tm ON tb.id = tm.tradebook_id
-WHERE t.tradebook_id = $1
-    AND (tb.owner_id = $2 OR tm.user_id = $2)
-ORDER BY t.entry_date DESC
-LIMIT $4 OFFSET $3
+const listAuditEvents = `-- name: ListAuditEvents :many
+SELECT id, tradebook_id, actor_id, action, entity_type, entity_id, before, after, created_at FROM audit_log
+WHERE tradebook_id = $1
+    AND ($2::text IS NULL OR actor_id = $2::text)
+    AND ($3::text IS NULL OR action = $3::text)
+    AND ($4::text IS NULL OR entity_type = $4::text)
+    AND (
+        $5::timestamptz IS NULL
+        OR (created_at, id) < ($5::timestamptz, $6::uuid)
+    )
+ORDER BY created_at DESC, id DESC
+LIMIT $7
+`
+
+type ListAuditEventsParams struct {
+	TradebookID     uuid.UUID
+	ActorID         sql.NullString
+	Action          sql.NullString
+	EntityType      sql.NullString
+	CursorCreatedAt sql.NullTime
+	CursorID        uuid.NullUUID
+	LimitVal        int32
+}
+
+// Keyset pagination on (created_at, id); NULL filters match everything
+func (q *Queries) ListAuditEvents(ctx context.Context, arg ListAuditEventsParams) ([]AuditLog, error) {
+	rows, err := q.db.QueryContext(ctx, listAuditEvents,
+		arg.TradebookID,
+		arg.ActorID,
+		arg.Action,
+		arg.EntityType,
+		arg.CursorCreatedAt,
+		arg.CursorID,
+		arg.LimitVal,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []AuditLog
+	for rows.Next() {
+		var i AuditLog
+		if err := rows.Scan(
+			&i.ID,
+			&i.TradebookID,
+			&i.ActorID,
+			&i.Action,
+			&i.EntityType,
+			&i.EntityID,
+			&i.Before,
+			&i.After,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listAuditEventsByActor = `-- name: ListAuditEventsByActor :many
+SELECT id, tradebook_id, actor_id, action, entity_type, entity_id, before, after, created_at FROM audit_log
+WHERE actor_id = $1
+    AND ($2::uuid IS NULL OR id > $2::uuid)
+ORDER BY id
+LIMIT $3
+`
+
+type ListAuditEventsByActorParams struct {
+	ActorID  string
+	AfterID  uuid.NullUUID
+	LimitVal int32
+}
+
+// Keyset pagination on id, for exports
+func (q *Queries) ListAuditEventsByActor(ctx context.Context, arg ListAuditEventsByActorParams) ([]AuditLog, error) {
+	rows, err := q.db.QueryContext(ctx, listAuditEventsByActor, arg.ActorID, arg.AfterID, arg.LimitVal)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []AuditLog
+	for rows.Next() {
+		var i AuditLog
+		if err := rows.Scan(
+			&i.ID,
+			&i.TradebookID,
+			&i.ActorID,
+			&i.Action,
+			&i.EntityType,
+			&i.EntityID,
+			&i.Before,
+			&i.After,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listCommissionSchedules = `-- name: ListCommissionSchedules :many
+
+SELECT tradebook_id, asset_class, rate_type, rate, min_fee, max_fee, regulatory_rate, regulatory_per_unit, created_at, updated_at FROM commission_schedules
+WHERE tradebook_id = $1
+ORDER BY asset_class
+`
+
+// ============================================================================
+// 14. COMMISSIONS
+// ============================================================================
+func (q *Queries) ListCommissionSchedules(ctx context.Context, tradebookID uuid.UUID) ([]CommissionSchedule, error) {
+	rows, err := q.db.QueryContext(ctx, listCommissionSchedules, tradebookID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []CommissionSchedule
+	for rows.Next() {
+		var i CommissionSchedule
+		if err := rows.Scan(
+			&i.TradebookID,
+			&i.AssetClass,
+			&i.RateType,
+			&i.Rate,
+			&i.MinFee,
+			&i.MaxFee,
+			&i.RegulatoryRate,
+			&i.RegulatoryPerUnit,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listCustomFields = `-- name: ListCustomFields :many
+
+SELECT id, tradebook_id, name, field_type, options, position, created_at, updated_at FROM custom_field_definitions
+WHERE tradebook_id = $1
+ORDER BY position ASC, created_at ASC
+`
+
+// ============================================================================
+// 13. CUSTOM FIELDS
+// ============================================================================
+func (q *Queries) ListCustomFields(ctx context.Context, tradebookID uuid.UUID) ([]CustomFieldDefinition, error) {
+	rows, err := q.db.QueryContext(ctx, listCustomFields, tradebookID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []CustomFieldDefinition
+	for rows.Next() {
+		var i CustomFieldDefinition
+		if err := rows.Scan(
+			&i.ID,
+			&i.TradebookID,
+			&i.Name,
+			&i.FieldType,
+			&i.Options,
+			&i.Position,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listEntryLots = `-- name: ListEntryLots :many
+SELECT id, symbol, entry_date, entry_quantity, entry_price FROM trades
+WHERE tradebook_id = $1
+    AND deleted_at IS NULL
+ORDER BY entry_date ASC, id ASC
+`
+
+type ListEntryLotsRow struct {
+	ID            uuid.UUID
+	Symbol        string
+	EntryDate     time.Time
+	EntryQuantity decimal.Decimal
+	EntryPrice    decimal.Decimal
+}
+
+// Each live trade's entry is one lot for exits to be matched against
+func (q *Queries) ListEntryLots(ctx context.Context, tradebookID uuid.UUID) ([]ListEntryLotsRow, error) {
+	rows, err := q.db.QueryContext(ctx, listEntryLots, tradebookID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListEntryLotsRow
+	for rows.Next() {
+		var i ListEntryLotsRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Symbol,
+			&i.EntryDate,
+			&i.EntryQuantity,
+			&i.EntryPrice,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listExitLegs = `-- name: ListExitLegs :many
+SELECT el.id, el.trade_id, el.exit_date, el.exit_quantity, el.exit_price, el.exit_fees, el.exit_fees_auto, el.created_at, el.updated_at FROM exit_legs el
+JOIN trades t ON el.trade_id = t.id
+WHERE t.id = $1
+    AND t.tradebook_id = $2
+    AND t.deleted_at IS NULL
+ORDER BY el.exit_date ASC
+`
+
+type ListExitLegsParams struct {
+	TradeID     uuid.UUID
+	TradebookID uuid.UUID
+}
+
+func (q *Queries) ListExitLegs(ctx context.Context, arg ListExitLegsParams) ([]ExitLeg, error) {
+	rows, err := q.db.QueryContext(ctx, listExitLegs, arg.TradeID, arg.TradebookID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ExitLeg
+	for rows.Next() {
+		var i ExitLeg
+		if err := rows.Scan(
+			&i.ID,
+			&i.TradeID,
+			&i.ExitDate,
+			&i.ExitQuantity,
+			&i.ExitPrice,
+			&i.ExitFees,
+			&i.ExitFeesAuto,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listExitLegsForExport = `-- name: ListExitLegsForExport :many
+SELECT el.id, el.trade_id, el.exit_date, el.exit_quantity, el.exit_price, el.exit_fees, el.exit_fees_auto, el.created_at, el.updated_at FROM exit_legs el
+JOIN trades t ON el.trade_id = t.id
+WHERE t.tradebook_id = $1
+    AND t.deleted_at IS NULL
+    AND ($2::uuid IS NULL OR el.id > $2::uuid)
+ORDER BY el.id
+LIMIT $3
+`
+
+type ListExitLegsForExportParams struct {
+	TradebookID uuid.UUID
+	AfterID     uuid.NullUUID
+	LimitVal    int32
+}
+
+func (q *Queries) ListExitLegsForExport(ctx context.Context, arg ListExitLegsForExportParams) ([]ExitLeg, error) {
+	rows, err := q.db.QueryContext(ctx, listExitLegsForExport, arg.TradebookID, arg.AfterID, arg.LimitVal)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ExitLeg
+	for rows.Next() {
+		var i ExitLeg
+		if err := rows.Scan(
+			&i.ID,
+			&i.TradeID,
+			&i.ExitDate,
+			&i.ExitQuantity,
+			&i.ExitPrice,
+			&i.ExitFees,
+			&i.ExitFeesAuto,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listExitLegsForFeeRecompute = `-- name: ListExitLegsForFeeRecompute :many
+SELECT el.id, el.trade_id, el.exit_date, el.exit_quantity, el.exit_price, el.exit_fees, el.exit_fees_auto, el.created_at, el.updated_at, t.asset_class
+FROM exit_legs el
+JOIN trades t ON el.trade_id = t.id
+WHERE t.tradebook_id = $1
+    AND t.deleted_at IS NULL
+    AND (el.exit_fees_auto OR $2::boolean)
+    AND ($3::asset_class IS NULL OR t.asset_class = $3::asset_class)
+`
+
+type ListExitLegsForFeeRecomputeParams struct {
+	TradebookID   uuid.UUID
+	IncludeManual bool
+	AssetClass    NullAssetClass
+}
+
+type ListExitLegsForFeeRecomputeRow struct {
+	ID           uuid.UUID
+	TradeID      uuid.UUID
+	ExitDate     time.Time
+	ExitQuantity decimal.Decimal
+	ExitPrice    decimal.Decimal
+	ExitFees     decimal.NullDecimal
+	ExitFeesAuto bool
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	AssetClass   AssetClass
+}
+
+func (q *Queries) ListExitLegsForFeeRecompute(ctx context.Context, arg ListExitLegsForFeeRecomputeParams) ([]ListExitLegsForFeeRecomputeRow, error) {
+	rows, err := q.db.QueryContext(ctx, listExitLegsForFeeRecompute, arg.TradebookID, arg.IncludeManual, arg.AssetClass)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListExitLegsForFeeRecomputeRow
+	for rows.Next() {
+		var i ListExitLegsForFeeRecomputeRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.TradeID,
+			&i.ExitDate,
+			&i.ExitQuantity,
+			&i.ExitPrice,
+			&i.ExitFees,
+			&i.ExitFeesAuto,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.AssetClass,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listExitsForLotMatching = `-- name: ListExitsForLotMatching :many
+SELECT el.trade_id, t.symbol, t.entry_price, el.exit_date, el.exit_quantity, el.exit_price, el.exit_fees
+FROM exit_legs el
+JOIN trades t ON el.trade_id = t.id
+WHERE t.tradebook_id = $1
+    AND t.deleted_at IS NULL
+ORDER BY el.exit_date ASC, el.id ASC
+`
+
+type ListExitsForLotMatchingRow struct {
+	TradeID      uuid.UUID
+	Symbol       string
+	EntryPrice   decimal.Decimal
+	ExitDate     time.Time
+	ExitQuantity decimal.Decimal
+	ExitPrice    decimal.Decimal
+	ExitFees     decimal.NullDecimal
+}
+
+func (q *Queries) ListExitsForLotMatching(ctx context.Context, tradebookID uuid.UUID) ([]ListExitsForLotMatchingRow, error) {
+	rows, err := q.db.QueryContext(ctx, listExitsForLotMatching, tradebookID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListExitsForLotMatchingRow
+	for rows.Next() {
+		var i ListExitsForLotMatchingRow
+		if err := rows.Scan(
+			&i.TradeID,
+			&i.Symbol,
+			&i.EntryPrice,
+			&i.ExitDate,
+			&i.ExitQuantity,
+			&i.ExitPrice,
+			&i.ExitFees,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listInvitationsSentBy = `-- name: ListInvitationsSentBy :many
+SELECT id, tradebook_id, email, role, token_hash, invited_by, expires_at, accepted_at, accepted_by, revoked_at, created_at FROM tradebook_invitations
+WHERE invited_by = $1
+ORDER BY created_at, id
+`
+
+func (q *Queries) ListInvitationsSentBy(ctx context.Context, userID string) ([]TradebookInvitation, error) {
+	rows, err := q.db.QueryContext(ctx, listInvitationsSentBy, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []TradebookInvitation
+	for rows.Next() {
+		var i TradebookInvitation
+		if err := rows.Scan(
+			&i.ID,
+			&i.TradebookID,
+			&i.Email,
+			&i.Role,
+			&i.TokenHash,
+			&i.InvitedBy,
+			&i.ExpiresAt,
+			&i.AcceptedAt,
+			&i.AcceptedBy,
+			&i.RevokedAt,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listOwnedTradebooks = `-- name: ListOwnedTradebooks :many
+SELECT
+    t.id,
+    t.workspace_id,
+    t.deleted_at,
+    COALESCE(
+        (
+            SELECT wm.user_id FROM workspace_members wm
+            WHERE wm.workspace_id = t.workspace_id AND wm.user_id <> t.owner_id
+            ORDER BY wm.role, wm.joined_at, wm.user_id
+            LIMIT 1
+        ),
+        (
+            SELECT m.user_id FROM tradebook_members m
+            WHERE m.tradebook_id = t.id AND m.user_id <> t.owner_id
+            ORDER BY (m.role = 'editor') DESC, m.joined_at, m.user_id
+            LIMIT 1
+        )
+    )::text AS successor_id
+FROM tradebooks t
+WHERE t.owner_id = $1
+ORDER BY t.created_at, t.id
+`
+
+type ListOwnedTradebooksRow struct {
+	ID          uuid.UUID
+	WorkspaceID sql.NullString
+	DeletedAt   sql.NullTime
+	SuccessorID sql.NullString
+}
+
+// Trashed books included. The successor, who takes the book over when the
+// owner's account is erased, is the longest-standing editor or else reader;
+// workspace books go to the strongest, longest-standing workspace member first.
+func (q *Queries) ListOwnedTradebooks(ctx context.Context, ownerID string) ([]ListOwnedTradebooksRow, error) {
+	rows, err := q.db.QueryContext(ctx, listOwnedTradebooks, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListOwnedTradebooksRow
+	for rows.Next() {
+		var i ListOwnedTradebooksRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.WorkspaceID,
+			&i.DeletedAt,
+			&i.SuccessorID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listOwnershipTransfersForUser = `-- name: ListOwnershipTransfersForUser :many
+SELECT id, tradebook_id, from_user_id, to_user_id, transferred_at FROM tradebook_ownership_transfers
+WHERE from_user_id = $1 OR to_user_id = $1
+ORDER BY transferred_at, id
+`
+
+func (q *Queries) ListOwnershipTransfersForUser(ctx context.Context, userID string) ([]TradebookOwnershipTransfer, error) {
+	rows, err := q.db.QueryContext(ctx, listOwnershipTransfersForUser, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []TradebookOwnershipTransfer
+	for rows.Next() {
+		var i TradebookOwnershipTransfer
+		if err := rows.Scan(
+			&i.ID,
+			&i.TradebookID,
+			&i.FromUserID,
+			&i.ToUserID,
+			&i.TransferredAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listTokenUsage = `-- name: ListTokenUsage :many
+SELECT event_id, user_id, timestamp, model_name, prompt_tokens, completion_tokens, total_tokens, cost FROM token_usage_log
+WHERE user_id = $1
+    AND ($2::uuid IS NULL OR event_id > $2::uuid)
+ORDER BY event_id
+LIMIT $3
+`
+
+type ListTokenUsageParams struct {
+	UserID   string
+	AfterID  uuid.NullUUID
+	LimitVal int32
+}
+
+func (q *Queries) ListTokenUsage(ctx context.Context, arg ListTokenUsageParams) ([]TokenUsageLog, error) {
+	rows, err := q.db.QueryContext(ctx, listTokenUsage, arg.UserID, arg.AfterID, arg.LimitVal)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []TokenUsageLog
+	for rows.Next() {
+		var i TokenUsageLog
+		if err := rows.Scan(
+			&i.EventID,
+			&i.UserID,
+			&i.Timestamp,
+			&i.ModelName,
+			&i.PromptTokens,
+			&i.CompletionTokens,
+			&i.TotalTokens,
+			&i.Cost,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listTradebookInvitations = `-- name: ListTradebookInvitations :many
+SELECT id, tradebook_id, email, role, token_hash, invited_by, expires_at, accepted_at, accepted_by, revoked_at, created_at FROM tradebook_invitations
+WHERE tradebook_id = $1
+    AND accepted_at IS NULL
+    AND revoked_at IS NULL
+ORDER BY created_at DESC
+`
+
+func (q *Queries) ListTradebookInvitations(ctx context.Context, tradebookID uuid.UUID) ([]TradebookInvitation, error) {
+	rows, err := q.db.QueryContext(ctx, listTradebookInvitations, tradebookID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []TradebookInvitation
+	for rows.Next() {
+		var i TradebookInvitation
+		if err := rows.Scan(
+			&i.ID,
+			&i.TradebookID,
+			&i.Email,
+			&i.Role,
+			&i.TokenHash,
+			&i.InvitedBy,
+			&i.ExpiresAt,
+			&i.AcceptedAt,
+			&i.AcceptedBy,
+			&i.RevokedAt,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listTradebookMembers = `-- name: ListTradebookMembers :many
+SELECT
+    m.user_id, m.role, m.joined_at,
+    u.email, u.display_name, u.avatar_url
+FROM tradebook_members m
+JOIN users u ON u.id = m.user_id
+WHERE m.tradebook_id = $1
+ORDER BY m.joined_at ASC
+`
+
+type ListTradebookMembersRow struct {
+	UserID      string
+	Role        TradebookRole
+	JoinedAt    time.Time
+	Email       sql.NullString
+	DisplayName sql.NullString
+	AvatarUrl   sql.NullString
+}
+
+func (q *Queries) ListTradebookMembers(ctx context.Context, tradebookID uuid.UUID) ([]ListTradebookMembersRow, error) {
+	rows, err := q.db.QueryContext(ctx, listTradebookMembers, tradebookID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListTradebookMembersRow
+	for rows.Next() {
+		var i ListTradebookMembersRow
+		if err := rows.Scan(
+			&i.UserID,
+			&i.Role,
+			&i.JoinedAt,
+			&i.Email,
+			&i.DisplayName,
+			&i.AvatarUrl,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listTradebookTemplates = `-- name: ListTradebookTemplates :many
+SELECT id, owner_id, workspace_id, name, is_shared, base_currency, accounting_method, default_asset_classes, tag_sets, custom_fields, created_at, updated_at FROM tradebook_templates
+WHERE owner_id = $1 OR (is_shared AND workspace_id = $2)
+ORDER BY (owner_id = $1) DESC, name ASC
+`
+
+type ListTradebookTemplatesParams struct {
+	UserID string
+	OrgID  sql.NullString
+}
+
+// The caller's own templates first, then ones shared in the session's workspace
+func (q *Queries) ListTradebookTemplates(ctx context.Context, arg ListTradebookTemplatesParams) ([]TradebookTemplate, error) {
+	rows, err := q.db.QueryContext(ctx, listTradebookTemplates, arg.UserID, arg.OrgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []TradebookTemplate
+	for rows.Next() {
+		var i TradebookTemplate
+		if err := rows.Scan(
+			&i.ID,
+			&i.OwnerID,
+			&i.WorkspaceID,
+			&i.Name,
+			&i.IsShared,
+			&i.BaseCurrency,
+			&i.AccountingMethod,
+			&i.DefaultAssetClasses,
+			&i.TagSets,
+			&i.CustomFields,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listTradebooks = `-- name: ListTradebooks :many
+SELECT
+    tb.id, tb.owner_id, tb.workspace_id, tb.title, tb.created_at, tb.updated_at,
+    (CASE WHEN tb.owner_id = $1 THEN 'owner' ELSE LEAST(tm.role, wm.role) END)::tradebook_role AS user_role,
+    COALESCE(tp.is_pinned, FALSE)::boolean AS is_pinned,
+    COALESCE(tp.is_archived, FALSE)::boolean AS is_archived
+FROM tradebooks tb
+LEFT JOIN tradebook_members tm
+    ON tb.id = tm.tradebook_id AND tm.user_id = $1
+LEFT JOIN tradebook_preferences tp
+    ON tb.id = tp.tradebook_id AND tp.user_id = $1
+LEFT JOIN workspace_members wm
+    ON wm.workspace_id = tb.workspace_id AND wm.workspace_id = $2 AND wm.user_id = $1
+WHERE (tb.owner_id = $1 OR tm.user_id IS NOT NULL OR wm.user_id IS NOT NULL)
+    AND tb.deleted_at IS NULL
+    AND ($3::boolean IS NULL OR COALESCE(tp.is_archived, FALSE) = $3::boolean)
+    AND ($4::boolean IS NULL OR COALESCE(tp.is_pinned, FALSE) = $4::boolean)
+ORDER BY is_pinned DESC, tb.updated_at DESC
+LIMIT $6 OFFSET $5
+`
+
+type ListTradebooksParams struct {
+	UserID    string
+	OrgID     sql.NullString
+	Archived  sql.NullBool
+	Pinned    sql.NullBool
+	OffsetVal int32
+	LimitVal  int32
+}
+
+type ListTradebooksRow struct {
+	ID          uuid.UUID
+	OwnerID     string
+	WorkspaceID sql.NullString
+	Title       string
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	UserRole    TradebookRole
+	IsPinned    bool
+	IsArchived  bool
+}
+
+func (q *Queries) ListTradebooks(ctx context.Context, arg ListTradebooksParams) ([]ListTradebooksRow, error) {
+	rows, err := q.db.QueryContext(ctx, listTradebooks,
+		arg.UserID,
+		arg.OrgID,
+		arg.Archived,
+		arg.Pinned,
+		arg.OffsetVal,
+		arg.LimitVal,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListTradebooksRow
+	for rows.Next() {
+		var i ListTradebooksRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.OwnerID,
+			&i.WorkspaceID,
+			&i.Title,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.UserRole,
+			&i.IsPinned,
+			&i.IsArchived,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listTradebooksByCursor = `-- name: ListTradebooksByCursor :many
+SELECT
+    tb.id, tb.owner_id, tb.workspace_id, tb.title, tb.created_at, tb.updated_at,
+    (CASE WHEN tb.owner_id = $1 THEN 'owner' ELSE LEAST(tm.role, wm.role) END)::tradebook_role AS user_role,
+    COALESCE(tp.is_pinned, FALSE)::boolean AS is_pinned,
+    COALESCE(tp.is_archived, FALSE)::boolean AS is_archived
+FROM tradebooks tb
+LEFT JOIN tradebook_members tm
+    ON tb.id = tm.tradebook_id AND tm.user_id = $1
+LEFT JOIN tradebook_preferences tp
+    ON tb.id = tp.tradebook_id AND tp.user_id = $1
+LEFT JOIN workspace_members wm
+    ON wm.workspace_id = tb.workspace_id AND wm.workspace_id = $2 AND wm.user_id = $1
+WHERE (tb.owner_id = $1 OR tm.user_id IS NOT NULL OR wm.user_id IS NOT NULL)
+    AND tb.deleted_at IS NULL
+    AND ($3::boolean IS NULL OR COALESCE(tp.is_archived, FALSE) = $3::boolean)
+    AND ($4::boolean IS NULL OR COALESCE(tp.is_pinned, FALSE) = $4::boolean)
+    AND (
+        $5::timestamptz IS NULL
+        OR (COALESCE(tp.is_pinned, FALSE), tb.updated_at, tb.id)
+            < ($6::boolean, $5::timestamptz, $7::uuid)
+    )
+ORDER BY is_pinned DESC, tb.updated_at DESC, tb.id DESC
+LIMIT $8
+`
+
+type ListTradebooksByCursorParams struct {
+	UserID          string
+	OrgID           sql.NullString
+	Archived        sql.NullBool
+	Pinned          sql.NullBool
+	CursorUpdatedAt sql.NullTime
+	CursorPinned    sql.NullBool
+	CursorID        uuid.NullUUID
+	LimitVal        int32
+}
+
+type ListTradebooksByCursorRow struct {
+	ID          uuid.UUID
+	OwnerID     string
+	WorkspaceID sql.NullString
+	Title       string
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	UserRole    TradebookRole
+	IsPinned    bool
+	IsArchived  bool
+}
+
+// Keyset pagination on (is_pinned, updated_at, id) so pinned books sort first;
+// a NULL cursor starts from the top and NULL flag filters match everything
+func (q *Queries) ListTradebooksByCursor(ctx context.Context, arg ListTradebooksByCursorParams) ([]ListTradebooksByCursorRow, error) {
+	rows, err := q.db.QueryContext(ctx, listTradebooksByCursor,
+		arg.UserID,
+		arg.OrgID,
+		arg.Archived,
+		arg.Pinned,
+		arg.CursorUpdatedAt,
+		arg.CursorPinned,
+		arg.CursorID,
+		arg.LimitVal,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListTradebooksByCursorRow
+	for rows.Next() {
+		var i ListTradebooksByCursorRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.OwnerID,
+			&i.WorkspaceID,
+			&i.Title,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.UserRole,
+			&i.IsPinned,
+			&i.IsArchived,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listTrades = `-- name: ListTrades :many
+SELECT id, tradebook_id, cloned_from_id, is_open, asset_class, purchase_type, order_type, entry_date, symbol, currency, entry_quantity, entry_price, entry_fees, entry_fees_auto, custom_fields, created_at, updated_at, deleted_at FROM trades
+WHERE tradebook_id = $1
+    AND deleted_at IS NULL
+    AND ($2::jsonb IS NULL OR custom_fields @> $2::jsonb)
+ORDER BY entry_date DESC
+LIMIT $4 OFFSET $3
+`
+
+type ListTradesParams struct {
+	TradebookID  uuid.UUID
+	CustomFields pqtype.NullRawMessage
+	OffsetVal    int32
+	LimitVal     int32
+}
+
+func (q *Queries) ListTrades(ctx context.Context, arg ListTradesParams) ([]Trade, error) {
+	rows, err := q.db.QueryContext(ctx, listTrades,
+		arg.TradebookID,
+		arg.CustomFields,
+		arg.OffsetVal,
+		arg.LimitVal,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Trade
+	for rows.Next() {
+		var i Trade
+		if err := rows.Scan(
+			&i.ID,
+			&i.TradebookID,
+			&i.ClonedFromID,
+			&i.IsOpen,
+			&i.AssetClass,
+			&i.PurchaseType,
+			&i.OrderType,
+			&i.EntryDate,
+			&i.Symbol,
+			&i.Currency,
+			&i.EntryQuantity,
+			&i.EntryPrice,
+			&i.EntryFees,
+			&i.EntryFeesAuto,
+			&i.CustomFields,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.DeletedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listTradesByCursor = `-- name: ListTradesByCursor :many
+SELECT id, tradebook_id, cloned_from_id, is_open, asset_class, purchase_type, order_type, entry_date, symbol, currency, entry_quantity, entry_price, entry_fees, entry_fees_auto, custom_fields, created_at, updated_at, deleted_at FROM trades
+WHERE tradebook_id = $1
+    AND deleted_at IS NULL
+    AND ($2::jsonb IS NULL OR custom_fields @> $2::jsonb)
+    AND (
+        $3::timestamptz IS NULL
+        OR (entry_date, id) < ($3::timestamptz, $4::uuid)
+    )
+ORDER BY entry_date DESC, id DESC
+LIMIT $5
+`
+
+type ListTradesByCursorParams struct {
+	TradebookID     uuid.UUID
+	CustomFields    pqtype.NullRawMessage
+	CursorEntryDate sql.NullTime
+	CursorID        uuid.NullUUID
+	LimitVal        int32
+}
+
+// Keyset pagination on (entry_date, id); a NULL cursor starts from the newest.
+// custom_fields filters by containment, e.g. {"<field id>": "breakout"}
+func (q *Queries) ListTradesByCursor(ctx context.Context, arg ListTradesByCursorParams) ([]Trade, error) {
+	rows, err := q.db.QueryContext(ctx, listTradesByCursor,
+		arg.TradebookID,
+		arg.CustomFields,
+		arg.CursorEntryDate,
+		arg.CursorID,
+		arg.LimitVal,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Trade
+	for rows.Next() {
+		var i Trade
+		if err := rows.Scan(
+			&i.ID,
+			&i.TradebookID,
+			&i.ClonedFromID,
+			&i.IsOpen,
+			&i.AssetClass,
+			&i.PurchaseType,
+			&i.OrderType,
+			&i.EntryDate,
+			&i.Symbol,
+			&i.Currency,
+			&i.EntryQuantity,
+			&i.EntryPrice,
+			&i.EntryFees,
+			&i.EntryFeesAuto,
+			&i.CustomFields,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.DeletedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listTradesForExport = `-- name: ListTradesForExport :many
+
+SELECT id, tradebook_id, cloned_from_id, is_open, asset_class, purchase_type, order_type, entry_date, symbol, currency, entry_quantity, entry_price, entry_fees, entry_fees_auto, custom_fields, created_at, updated_at, deleted_at FROM trades
+WHERE tradebook_id = $1
+    AND deleted_at IS NULL
+    AND ($2::uuid IS NULL OR id > $2::uuid)
+ORDER BY id
+LIMIT $3
+`
+
+type ListTradesForExportParams struct {
+	TradebookID uuid.UUID
+	AfterID     uuid.NullUUID
+	LimitVal    int32
+}
+
+// ============================================================================
+// 15. EXPORT
+// ============================================================================
+// Keyset pagination on id; the order only has to be stable
+func (q *Queries) ListTradesForExport(ctx context.Context, arg ListTradesForExportParams) ([]Trade, error) {
+	rows, err := q.db.QueryContext(ctx, listTradesForExport, arg.TradebookID, arg.AfterID, arg.LimitVal)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Trade
+	for rows.Next() {
+		var i Trade
+		if err := rows.Scan(
+			&i.ID,
+			&i.TradebookID,
+			&i.ClonedFromID,
+			&i.IsOpen,
+			&i.AssetClass,
+			&i.PurchaseType,
+			&i.OrderType,
+			&i.EntryDate,
+			&i.Symbol,
+			&i.Currency,
+			&i.EntryQuantity,
+			&i.EntryPrice,
+			&i.EntryFees,
+			&i.EntryFeesAuto,
+			&i.CustomFields,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.DeletedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listTradesForFeeRecompute = `-- name: ListTradesForFeeRecompute :many
+SELECT id, tradebook_id, cloned_from_id, is_open, asset_class, purchase_type, order_type, entry_date, symbol, currency, entry_quantity, entry_price, entry_fees, entry_fees_auto, custom_fields, created_at, updated_at, deleted_at FROM trades
+WHERE tradebook_id = $1
+    AND deleted_at IS NULL
+    AND (entry_fees_auto OR $2::boolean)
+    AND ($3::asset_class IS NULL OR asset_class = $3::asset_class)
+`
+
+type ListTradesForFeeRecomputeParams struct {
+	TradebookID   uuid.UUID
+	IncludeManual bool
+	AssetClass    NullAssetClass
+}
+
+// Live trades whose entry fees were computed, or all of them with include_manual
+func (q *Queries) ListTradesForFeeRecompute(ctx context.Context, arg ListTradesForFeeRecomputeParams) ([]Trade, error) {
+	rows, err := q.db.QueryContext(ctx, listTradesForFeeRecompute, arg.TradebookID, arg.IncludeManual, arg.AssetClass)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Trade
+	for rows.Next() {
+		var i Trade
+		if err := rows.Scan(
+			&i.ID,
+			&i.TradebookID,
+			&i.ClonedFromID,
+			&i.IsOpen,
+			&i.AssetClass,
+			&i.PurchaseType,
+			&i.OrderType,
+			&i.EntryDate,
+			&i.Symbol,
+			&i.Currency,
+			&i.EntryQuantity,
+			&i.EntryPrice,
+			&i.EntryFees,
+			&i.EntryFeesAuto,
+			&i.CustomFields,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.DeletedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listTrashedTradebooks = `-- name: ListTrashedTradebooks :many
+SELECT id, owner_id, workspace_id, title, template_id, base_currency, accounting_method, default_asset_classes, tag_sets, timezone, default_fees, fiscal_year_start, created_at, updated_at, deleted_at FROM tradebooks
+WHERE owner_id = $1
+    AND deleted_at > $2::timestamptz
+ORDER BY deleted_at DESC
+`
+
+type ListTrashedTradebooksParams struct {
+	UserID string
+	Cutoff time.Time
+}
+
+func (q *Queries) ListTrashedTradebooks(ctx context.Context, arg ListTrashedTradebooksParams) ([]Tradebook, error) {
+	rows, err := q.db.QueryContext(ctx, listTrashedTradebooks, arg.UserID, arg.Cutoff)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Tradebook
+	for rows.Next() {
+		var i Tradebook
+		if err := rows.Scan(
+			&i.ID,
+			&i.OwnerID,
+			&i.WorkspaceID,
+			&i.Title,
+			&i.TemplateID,
+			&i.BaseCurrency,
+			&i.AccountingMethod,
+			&i.DefaultAssetClasses,
+			&i.TagSets,
+			&i.Timezone,
+			&i.DefaultFees,
+			&i.FiscalYearStart,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.DeletedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listTrashedTrades = `-- name: ListTrashedTrades :many
+SELECT id, tradebook_id, cloned_from_id, is_open, asset_class, purchase_type, order_type, entry_date, symbol, currency, entry_quantity, entry_price, entry_fees, entry_fees_auto, custom_fields, created_at, updated_at, deleted_at FROM trades
+WHERE tradebook_id = $1
+    AND deleted_at > $2::timestamptz
+ORDER BY deleted_at DESC
+`
+
+type ListTrashedTradesParams struct {
+	TradebookID uuid.UUID
+	Cutoff      time.Time
+}
+
+func (q *Queries) ListTrashedTrades(ctx context.Context, arg ListTrashedTradesParams) ([]Trade, error) {
+	rows, err := q.db.QueryContext(ctx, listTrashedTrades, arg.TradebookID, arg.Cutoff)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Trade
+	for rows.Next() {
+		var i Trade
+		if err := rows.Scan(
+			&i.ID,
+			&i.TradebookID,
+			&i.ClonedFromID,
+			&i.IsOpen,
+			&i.AssetClass,
+			&i.PurchaseType,
+			&i.OrderType,
+			&i.EntryDate,
+			&i.Symbol,
+			&i.Currency,
+			&i.EntryQuantity,
+			&i.EntryPrice,
+			&i.EntryFees,
+			&i.EntryFeesAuto,
+			&i.CustomFields,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.DeletedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listUserMemberships = `-- name: ListUserMemberships :many
+
+SELECT
+    m.tradebook_id, t.title, m.role,
+    COALESCE(p.is_pinned, FALSE)::boolean AS is_pinned,
+    COALESCE(p.is_archived, FALSE)::boolean AS is_archived,
+    m.joined_at
+FROM tradebook_members m
+JOIN tradebooks t ON t.id = m.tradebook_id
+LEFT JOIN tradebook_preferences p ON p.tradebook_id = m.tradebook_id AND p.user_id = m.user_id
+WHERE m.user_id = $1
+ORDER BY m.joined_at, m.tradebook_id
+`
+
+type ListUserMembershipsRow struct {
+	TradebookID uuid.UUID
+	Title       string
+	Role        TradebookRole
+	IsPinned    bool
+	IsArchived  bool
+	JoinedAt    time.Time
+}
+
+// ============================================================================
+// 17. ACCOUNT (Personal Data Export & Erasure)
+// ============================================================================
+func (q *Queries) ListUserMemberships(ctx context.Context, userID string) ([]ListUserMembershipsRow, error) {
+	rows, err := q.db.QueryContext(ctx, listUserMemberships, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListUserMembershipsRow
+	for rows.Next() {
+		var i ListUserMembershipsRow
+		if err := rows.Scan(
+			&i.TradebookID,
+			&i.Title,
+			&i.Role,
+			&i.IsPinned,
+			&i.IsArchived,
+			&i.JoinedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listUserSubscriptions = `-- name: ListUserSubscriptions :many
+SELECT id, user_id, stripe_customer_id, price_id, plan, status, current_period_end, cancel_at_period_end, synced_at, latest_invoice_status, invoice_synced_at, created_at, updated_at FROM subscriptions
+WHERE user_id = $1
+ORDER BY created_at DESC, id
+`
+
+func (q *Queries) ListUserSubscriptions(ctx context.Context, userID string) ([]Subscription, error) {
+	rows, err := q.db.QueryContext(ctx, listUserSubscriptions, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Subscription
+	for rows.Next() {
+		var i Subscription
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.StripeCustomerID,
+			&i.PriceID,
+			&i.Plan,
+			&i.Status,
+			&i.CurrentPeriodEnd,
+			&i.CancelAtPeriodEnd,
+			&i.SyncedAt,
+			&i.LatestInvoiceStatus,
+			&i.InvoiceSyncedAt,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listUserWorkspaces = `-- name: ListUserWorkspaces :many
+SELECT
+    w.id, w.name, w.created_at,
+    wm.role, wm.joined_at
+FROM workspace_members wm
+JOIN workspaces w ON w.id = wm.workspace_id
+WHERE wm.user_id = $1
+ORDER BY wm.joined_at, w.id
+`
+
+type ListUserWorkspacesRow struct {
+	ID        string
+	Name      string
+	CreatedAt time.Time
+	Role      TradebookRole
+	JoinedAt  time.Time
+}
+
+func (q *Queries) ListUserWorkspaces(ctx context.Context, userID string) ([]ListUserWorkspacesRow, error) {
+	rows, err := q.db.QueryContext(ctx, listUserWorkspaces, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListUserWorkspacesRow
+	for rows.Next() {
+		var i ListUserWorkspacesRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.CreatedAt,
+			&i.Role,
+			&i.JoinedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listWorkspaceMembers = `-- name: ListWorkspaceMembers :many
+SELECT
+    wm.user_id, wm.role, wm.joined_at,
+    u.email, u.display_name, u.avatar_url
+FROM workspace_members wm
+JOIN users u ON u.id = wm.user_id
+WHERE wm.workspace_id = $1
+ORDER BY wm.joined_at ASC, wm.user_id ASC
+`
+
+type ListWorkspaceMembersRow struct {
+	UserID      string
+	Role        TradebookRole
+	JoinedAt    time.Time
+	Email       sql.NullString
+	DisplayName sql.NullString
+	AvatarUrl   sql.NullString
+}
+
+func (q *Queries) ListWorkspaceMembers(ctx context.Context, workspaceID string) ([]ListWorkspaceMembersRow, error) {
+	rows, err := q.db.QueryContext(ctx, listWorkspaceMembers, workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListWorkspaceMembersRow
+	for rows.Next() {
+		var i ListWorkspaceMembersRow
+		if err := rows.Scan(
+			&i.UserID,
+			&i.Role,
+			&i.JoinedAt,
+			&i.Email,
+			&i.DisplayName,
+			&i.AvatarUrl,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const logTokenUsage = `-- name: LogTokenUsage :exec
+
+INSERT INTO token_usage_log (
+    user_id, model_name, prompt_tokens, completion_tokens, total_tokens, cost
+) VALUES (
+    $1, $2, $3, $4, $5, $6
+)
+`
+
+type LogTokenUsageParams struct {
+	UserID           string
+	ModelName        string
+	PromptTokens     int32
+	CompletionTokens int32
+	TotalTokens      int32
+	Cost             decimal.Decimal
+}
+
+// ============================================================================
+// 7. METERING
+// ============================================================================
+func (q *Queries) LogTokenUsage(ctx context.Context, arg LogTokenUsageParams) error {
+	_, err := q.db.ExecContext(ctx, logTokenUsage,
+		arg.UserID,
+		arg.ModelName,
+		arg.PromptTokens,
+		arg.CompletionTokens,
+		arg.TotalTokens,
+		arg.Cost,
+	)
+	return err
+}
+
+const purgeExpiredTrash = `-- name: PurgeExpiredTrash :one
+
+SELECT purge_expired_trash($1::timestamptz)::bigint AS purged
+`
+
+// ============================================================================
+// 11. TRASH
+// ============================================================================
+// Hard-deletes tradebooks and trades trashed before the cutoff
+func (q *Queries) PurgeExpiredTrash(ctx context.Context, cutoff time.Time) (int64, error) {
+	row := q.db.QueryRowContext(ctx, purgeExpiredTrash, cutoff)
+	var purged int64
+	err := row.Scan(&purged)
+	return purged, err
+}
+
+const recordAccountErasure = `-- name: RecordAccountErasure :one
+INSERT INTO account_erasures (subject_hash, pseudonym, report)
+VALUES ($1, $2, $3)
+RETURNING id, completed_at
+`
+
+type RecordAccountErasureParams struct {
+	SubjectHash string
+	Pseudonym   string
+	Report      json.RawMessage
+}
+
+type RecordAccountErasureRow struct {
+	ID          uuid.UUID
+	CompletedAt time.Time
+}
+
+func (q *Queries) RecordAccountErasure(ctx context.Context, arg RecordAccountErasureParams) (RecordAccountErasureRow, error) {
+	row := q.db.QueryRowContext(ctx, recordAccountErasure, arg.SubjectHash, arg.Pseudonym, arg.Report)
+	var i RecordAccountErasureRow
+	err := row.Scan(&i.ID, &i.CompletedAt)
+	return i, err
+}
+
+const recordAuditEvent = `-- name: RecordAuditEvent :exec
+
+INSERT INTO audit_log (
+    tradebook_id, actor_id, action, entity_type, entity_id, before, after
+) VALUES (
+    $1, $2, $3, $4, $5, $6, $7
+)
+`
+
+type RecordAuditEventParams struct {
+	TradebookID uuid.UUID
+	ActorID     string
+	Action      string
+	EntityType  string
+	EntityID    string
+	Before      pqtype.NullRawMessage
+	After       pqtype.NullRawMessage
+}
+
+// ============================================================================
+// 10. AUDIT LOG
+// ============================================================================
+func (q *Queries) RecordAuditEvent(ctx context.Context, arg RecordAuditEventParams) error {
+	_, err := q.db.ExecContext(ctx, recordAuditEvent,
+		arg.TradebookID,
+		arg.ActorID,
+		arg.Action,
+		arg.EntityType,
+		arg.EntityID,
+		arg.Before,
+		arg.After,
+	)
+	return err
+}
+
+const recordOwnershipTransfer = `-- name: RecordOwnershipTransfer :one
+INSERT INTO tradebook_ownership_transfers (tradebook_id, from_user_id, to_user_id)
+VALUES ($1, $2, $3)
+RETURNING id, tradebook_id, from_user_id, to_user_id, transferred_at
+`
+
+type RecordOwnershipTransferParams struct {
+	TradebookID uuid.UUID
+	FromUserID  string
+	ToUserID    string
+}
+
+func (q *Queries) RecordOwnershipTransfer(ctx context.Context, arg RecordOwnershipTransferParams) (TradebookOwnershipTransfer, error) {
+	row := q.db.QueryRowContext(ctx, recordOwnershipTransfer, arg.TradebookID, arg.FromUserID, arg.ToUserID)
+	var i TradebookOwnershipTransfer
+	err := row.Scan(
+		&i.ID,
+		&i.TradebookID,
+		&i.FromUserID,
+		&i.ToUserID,
+		&i.TransferredAt,
+	)
+	return i, err
+}
+
+const removeTradebookMember = `-- name: RemoveTradebookMember :execrows
+DELETE FROM tradebook_members
+WHERE tradebook_id = $1
+    AND user_id = $2
+`
+
+type RemoveTradebookMemberParams struct {
+	TradebookID uuid.UUID
+	UserID      string
+}
+
+func (q *Queries) RemoveTradebookMember(ctx context.Context, arg RemoveTradebookMemberParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, removeTradebookMember, arg.TradebookID, arg.UserID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const restoreTrade = `-- name: RestoreTrade :one
+UPDATE trades
+SET deleted_at = NULL
+WHERE id = $1
+    AND tradebook_id = $2
+    AND deleted_at > $3::timestamptz
+RETURNING id, tradebook_id, cloned_from_id, is_open, asset_class, purchase_type, order_type, entry_date, symbol, currency, entry_quantity, entry_price, entry_fees, entry_fees_auto, custom_fields, created_at, updated_at, deleted_at
+`
+
+type RestoreTradeParams struct {
+	TradeID     uuid.UUID
+	TradebookID uuid.UUID
+	Cutoff      time.Time
+}
+
+func (q *Queries) RestoreTrade(ctx context.Context, arg RestoreTradeParams) (Trade, error) {
+	row := q.db.QueryRowContext(ctx, restoreTrade, arg.TradeID, arg.TradebookID, arg.Cutoff)
+	var i Trade
+	err := row.Scan(
+		&i.ID,
+		&i.TradebookID,
+		&i.ClonedFromID,
+		&i.IsOpen,
+		&i.AssetClass,
+		&i.PurchaseType,
+		&i.OrderType,
+		&i.EntryDate,
+		&i.Symbol,
+		&i.Currency,
+		&i.EntryQuantity,
+		&i.EntryPrice,
+		&i.EntryFees,
+		&i.EntryFeesAuto,
+		&i.CustomFields,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.DeletedAt,
+	)
+	return i, err
+}
+
+const restoreTradebook = `-- name: RestoreTradebook :one
+UPDATE tradebooks
+SET deleted_at = NULL
+WHERE id = $1
+    AND deleted_at > $2::timestamptz
+RETURNING id, owner_id, workspace_id, title, template_id, base_currency, accounting_method, default_asset_classes, tag_sets, timezone, default_fees, fiscal_year_start, created_at, updated_at, deleted_at
+`
+
+type RestoreTradebookParams struct {
+	TradebookID uuid.UUID
+	Cutoff      time.Time
+}
+
+func (q *Queries) RestoreTradebook(ctx context.Context, arg RestoreTradebookParams) (Tradebook, error) {
+	row := q.db.QueryRowContext(ctx, restoreTradebook, arg.TradebookID, arg.Cutoff)
+	var i Tradebook
+	err := row.Scan(
+		&i.ID,
+		&i.OwnerID,
+		&i.WorkspaceID,
+		&i.Title,
+		&i.TemplateID,
+		&i.BaseCurrency,
+		&i.AccountingMethod,
+		&i.DefaultAssetClasses,
+		&i.TagSets,
+		&i.Timezone,
+		&i.DefaultFees,
+		&i.FiscalYearStart,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.DeletedAt,
+	)
+	return i, err
+}
+
+const revokeAPIKey = `-- name: RevokeAPIKey :one
+UPDATE api_keys
+SET revoked_at = NOW()
+WHERE id = $1
+    AND user_id = $2
+    AND revoked_at IS NULL
+RETURNING id, user_id, org_id, name, prefix, key_hash, scopes, tradebook_ids, expires_at, last_used_at, revoked_at, created_at
+`
+
+type RevokeAPIKeyParams struct {
+	ID     uuid.UUID
+	UserID string
+}
+
+func (q *Queries) RevokeAPIKey(ctx context.Context, arg RevokeAPIKeyParams) (ApiKey, error) {
+	row := q.db.QueryRowContext(ctx, revokeAPIKey, arg.ID, arg.UserID)
+	var i ApiKey
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.OrgID,
+		&i.Name,
+		&i.Prefix,
+		&i.KeyHash,
+		&i.Scopes,
+		&i.TradebookIds,
+		&i.ExpiresAt,
+		&i.LastUsedAt,
+		&i.RevokedAt,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const revokeTradebookInvitation = `-- name: RevokeTradebookInvitation :one
+UPDATE tradebook_invitations
+SET revoked_at = NOW()
+WHERE id = $1
+    AND tradebook_id = $2
+    AND accepted_at IS NULL
+    AND revoked_at IS NULL
+RETURNING id, tradebook_id, email, role, token_hash, invited_by, expires_at, accepted_at, accepted_by, revoked_at, created_at
+`
+
+type RevokeTradebookInvitationParams struct {
+	InvitationID uuid.UUID
+	TradebookID  uuid.UUID
+}
+
+func (q *Queries) RevokeTradebookInvitation(ctx context.Context, arg RevokeTradebookInvitationParams) (TradebookInvitation, error) {
+	row := q.db.QueryRowContext(ctx, revokeTradebookInvitation, arg.InvitationID, arg.TradebookID)
+	var i TradebookInvitation
+	err := row.Scan(
+		&i.ID,
+		&i.TradebookID,
+		&i.Email,
+		&i.Role,
+		&i.TokenHash,
+		&i.InvitedBy,
+		&i.ExpiresAt,
+		&i.AcceptedAt,
+		&i.AcceptedBy,
+		&i.RevokedAt,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const setCurrentOrg = `-- name: SetCurrentOrg :exec
+SELECT set_config('app.current_org_id', $1::text, true)
+`
+
+func (q *Queries) SetCurrentOrg(ctx context.Context, orgID string) error {
+	_, err := q.db.ExecContext(ctx, setCurrentOrg, orgID)
+	return err
+}
+
+const setCurrentUser = `-- name: SetCurrentUser :exec
+
+SELECT set_config('app.current_user_id', $1::text, true)
+`
+
+// ============================================================================
+// 9. ROW-LEVEL SECURITY
+// ============================================================================
+// Transaction-local (is_local = true) so pooled connections never leak it
+func (q *Queries) SetCurrentUser(ctx context.Context, userID string) error {
+	_, err := q.db.ExecContext(ctx, setCurrentUser, userID)
+	return err
+}
+
+const setExitLegFees = `-- name: SetExitLegFees :exec
+UPDATE exit_legs
+SET
+    exit_fees = $1,
+    exit_fees_auto = TRUE,
+    updated_at = NOW()
+WHERE id = $2
+`
+
+type SetExitLegFeesParams struct {
+	ExitFees  decimal.NullDecimal
+	ExitLegID uuid.UUID
+}
+
+func (q *Queries) SetExitLegFees(ctx context.Context, arg SetExitLegFeesParams) error {
+	_, err := q.db.ExecContext(ctx, setExitLegFees, arg.ExitFees, arg.ExitLegID)
+	return err
+}
+
+const setSubscriptionInvoiceStatus = `-- name: SetSubscriptionInvoiceStatus :execrows
+UPDATE subscriptions
+SET
+    latest_invoice_status = $1,
+    invoice_synced_at = $2
+WHERE id = $3
+    AND (invoice_synced_at IS NULL OR invoice_synced_at <= $2)
+`
+
+type SetSubscriptionInvoiceStatusParams struct {
+	LatestInvoiceStatus sql.NullString
+	InvoiceSyncedAt     sql.NullTime
+	ID                  string
+}
+
+// Affects no rows for unknown subscriptions or older invoice events
+func (q *Queries) SetSubscriptionInvoiceStatus(ctx context.Context, arg SetSubscriptionInvoiceStatusParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, setSubscriptionInvoiceStatus, arg.LatestInvoiceStatus, arg.InvoiceSyncedAt, arg.ID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const setTradeEntryFees = `-- name: SetTradeEntryFees :exec
+UPDATE trades
+SET
+    entry_fees = $1,
+    entry_fees_auto = TRUE,
+    updated_at = NOW()
+WHERE id = $2
+    AND tradebook_id = $3
+`
+
+type SetTradeEntryFeesParams struct {
+	EntryFees   decimal.NullDecimal
+	TradeID     uuid.UUID
+	TradebookID uuid.UUID
+}
+
+func (q *Queries) SetTradeEntryFees(ctx context.Context, arg SetTradeEntryFeesParams) error {
+	_, err := q.db.ExecContext(ctx, setTradeEntryFees, arg.EntryFees, arg.TradeID, arg.TradebookID)
+	return err
+}
+
+const setTradebookWorkspace = `-- name: SetTradebookWorkspace :one
+UPDATE tradebooks
+SET
+    workspace_id = $1,
+    updated_at = NOW()
+WHERE id = $2
+    AND owner_id = $3
+RETURNING id, owner_id, workspace_id, title, template_id, base_currency, accounting_method, default_asset_classes, tag_sets, timezone, default_fees, fiscal_year_start, created_at, updated_at, deleted_at
+`
+
+type SetTradebookWorkspaceParams struct {
+	WorkspaceID sql.NullString
+	TradebookID uuid.UUID
+	OwnerID     string
+}
+
+// Only the recorded owner may move a book in or out of a workspace
+func (q *Queries) SetTradebookWorkspace(ctx context.Context, arg SetTradebookWorkspaceParams) (Tradebook, error) {
+	row := q.db.QueryRowContext(ctx, setTradebookWorkspace, arg.WorkspaceID, arg.TradebookID, arg.OwnerID)
+	var i Tradebook
+	err := row.Scan(
+		&i.ID,
+		&i.OwnerID,
+		&i.WorkspaceID,
+		&i.Title,
+		&i.TemplateID,
+		&i.BaseCurrency,
+		&i.AccountingMethod,
+		&i.DefaultAssetClasses,
+		&i.TagSets,
+		&i.Timezone,
+		&i.DefaultFees,
+		&i.FiscalYearStart,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.DeletedAt,
+	)
+	return i, err
+}
+
+const softDeleteAllTradebooks = `-- name: SoftDeleteAllTradebooks :exec
+UPDATE tradebooks
+SET deleted_at = NOW()
+WHERE owner_id = $1
+    AND deleted_at IS NULL
+`
+
+func (q *Queries) SoftDeleteAllTradebooks(ctx context.Context, userID string) error {
+	_, err := q.db.ExecContext(ctx, softDeleteAllTradebooks, userID)
+	return err
+}
+
+const softDeleteTrade = `-- name: SoftDeleteTrade :one
+UPDATE trades
+SET deleted_at = NOW()
+WHERE id = $1
+    AND tradebook_id = $2
+    AND deleted_at IS NULL
+RETURNING id, tradebook_id, cloned_from_id, is_open, asset_class, purchase_type, order_type, entry_date, symbol, currency, entry_quantity, entry_price, entry_fees, entry_fees_auto, custom_fields, created_at, updated_at, deleted_at
+`
+
+type SoftDeleteTradeParams struct {
+	TradeID     uuid.UUID
+	TradebookID uuid.UUID
+}
+
+func (q *Queries) SoftDeleteTrade(ctx context.Context, arg SoftDeleteTradeParams) (Trade, error) {
+	row := q.db.QueryRowContext(ctx, softDeleteTrade, arg.TradeID, arg.TradebookID)
+	var i Trade
+	err := row.Scan(
+		&i.ID,
+		&i.TradebookID,
+		&i.ClonedFromID,
+		&i.IsOpen,
+		&i.AssetClass,
+		&i.PurchaseType,
+		&i.OrderType,
+		&i.EntryDate,
+		&i.Symbol,
+		&i.Currency,
+		&i.EntryQuantity,
+		&i.EntryPrice,
+		&i.EntryFees,
+		&i.EntryFeesAuto,
+		&i.CustomFields,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.DeletedAt,
+	)
+	return i, err
+}
+
+const softDeleteTradebook = `-- name: SoftDeleteTradebook :exec
+UPDATE tradebooks
+SET deleted_at = NOW()
+WHERE id = $1
+    AND deleted_at IS NULL
 `
 
-type ListTradesParams struct {
-	TradebookID uuid.UUID
-	UserID      string
-	OffsetVal   int32
-	LimitVal    int32
+// Moves the tradebook to the trash; its trades stay untouched until the purge
+func (q *Queries) SoftDeleteTradebook(ctx context.Context, tradebookID uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, softDeleteTradebook, tradebookID)
+	return err
 }
 
-func (q *Queries) ListTrades(ctx context.Context, arg ListTradesParams) ([]Trade, error) {
-	rows, err := q.db.QueryContext(ctx, listTrades,
-		arg.TradebookID,
-		arg.UserID,
-		arg.OffsetVal,
-		arg.LimitVal,
-	)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-	var items []Trade
-	for rows.Next() {
-		var i Trade
-		if err := rows.Scan(
-			&i.ID,
-			&i.TradebookID,
-			&i.IsOpen,
-			&i.AssetClass,
-			&i.PurchaseType,
-			&i.OrderType,
-			&i.EntryDate,
-			&i.Symbol,
-			&i.Currency,
-			&i.EntryQuantity,
-			&i.EntryPrice,
-			&i.EntryFees,
-			&i.CreatedAt,
-			&i.UpdatedAt,
-		); err != nil {
-			return nil, err
-		}
-		items = append(items, i)
-	}
-	if err := rows.Close(); err != nil {
-		return nil, err
-	}
-	if err := rows.Err(); err != nil {
-		return nil, err
-	}
-	return items, nil
-}
+const touchAPIKey = `-- name: TouchAPIKey :exec
+UPDATE api_keys
+SET last_used_at = NOW()
+WHERE id = $1
+    AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute')
+`
 
-const logTokenUsage = `-- name: LogTokenUsage :exec
+// At most one write a minute per key, however busy the key is
+func (q *Queries) TouchAPIKey(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, touchAPIKey, id)
+	return err
+}
 
-INSERT INTO token_usage_log (
-    user_id, model_name, prompt_tokens, completion_tokens, total_tokens, cost
-) VALUES (
-    $1, $2, $3, $4, $5, $6
-)
+const transferTradebookOwnership = `-- name: TransferTradebookOwnership :execrows
+UPDATE tradebooks
+SET
+    owner_id = $1,
+    updated_at = NOW()
+WHERE id = $2
+    AND owner_id = $3
 `
 
-type LogTokenUsageParams struct {
-	UserID           string
-	ModelName        string
-	PromptTokens     int32
-	CompletionTokens int32
-	TotalTokens      int32
-	Cost             decimal.Decimal
+type TransferTradebookOwnershipParams struct {
+	NewOwnerID     string
+	TradebookID    uuid.UUID
+	CurrentOwnerID string
 }
 
-// ============================================================================
-// 7. METERING
-// ============================================================================
-func (q *Queries) LogTokenUsage(ctx context.Context, arg LogTokenUsageParams) error {
-	_, err := q.db.ExecContext(ctx, logTokenUsage,
-		arg.UserID,
-		arg.ModelName,
-		arg.PromptTokens,
-		arg.CompletionTokens,
-		arg.TotalTokens,
-		arg.Cost,
-	)
-	return err
+func (q *Queries) TransferTradebookOwnership(ctx context.Context, arg TransferTradebookOwnershipParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, transferTradebookOwnership, arg.NewOwnerID, arg.TradebookID, arg.CurrentOwnerID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
 }
 
-const removeTradebookMember = `-- name: RemoveTradebookMember :exec
-DELETE FROM tradebook_members
-WHERE tradebook_id = $1
-    AND user_id = $2
-    AND EXISTS (
-        SELECT 1 FROM tradebooks
-        WHERE id = $1 AND owner_id = $3
-    )
+const updateCustomField = `-- name: UpdateCustomField :one
+UPDATE custom_field_definitions
+SET
+    name = COALESCE($1, name),
+    options = COALESCE($2::jsonb, options),
+    position = COALESCE($3, position),
+    updated_at = NOW()
+WHERE id = $4
+    AND tradebook_id = $5
+RETURNING id, tradebook_id, name, field_type, options, position, created_at, updated_at
 `
 
-type RemoveTradebookMemberParams struct {
-	TradebookID  uuid.UUID
-	TargetUserID string
-	OwnerID      string
+type UpdateCustomFieldParams struct {
+	Name        sql.NullString
+	Options     pqtype.NullRawMessage
+	Position    sql.NullInt32
+	FieldID     uuid.UUID
+	TradebookID uuid.UUID
 }
 
-func (q *Queries) RemoveTradebookMember(ctx context.Context, arg RemoveTradebookMemberParams) error {
-	_, err := q.db.ExecContext(ctx, removeTradebookMember, arg.TradebookID, arg.TargetUserID, arg.OwnerID)
-	return err
+// The type is fixed once created so stored values stay valid
+func (q *Queries) UpdateCustomField(ctx context.Context, arg UpdateCustomFieldParams) (CustomFieldDefinition, error) {
+	row := q.db.QueryRowContext(ctx, updateCustomField,
+		arg.Name,
+		arg.Options,
+		arg.Position,
+		arg.FieldID,
+		arg.TradebookID,
+	)
+	var i CustomFieldDefinition
+	err := row.Scan(
+		&i.ID,
+		&i.TradebookID,
+		&i.Name,
+		&i.FieldType,
+		&i.Options,
+		&i.Position,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
 }
 
 const updateTrade = `-- name: UpdateTrade :one
 UPDATE trades
 SET
     is_open = COALESCE($1, is_open),
+    custom_fields = jsonb_strip_nulls(custom_fields || COALESCE($2::jsonb, '{}'::jsonb)),
     updated_at = NOW()
-FROM tradebooks tb
-LEFT JOIN tradebook_members tm ON tb.id = tm.tradebook_id
-WHERE trades.id = $2
-    AND trades.tradebook_id = tb.id
-    AND (tb.owner_id = $3 OR (tm.user_id = $3 AND tm.role IN ('owner', 'editor')))
-RETURNING trades.id, trades.tradebook_id, trades.is_open, trades.asset_class, trades.purchase_type, trades.order_type, trades.entry_date, trades.symbol, trades.currency, trades.entry_quantity, trades.entry_price, trades.entry_fees, trades.created_at, trades.updated_at
+WHERE id = $3
+    AND tradebook_id = $4
+    AND deleted_at IS NULL
+RETURNING id, tradebook_id, cloned_from_id, is_open, asset_class, purchase_type, order_type, entry_date, symbol, currency, entry_quantity, entry_price, entry_fees, entry_fees_auto, custom_fields, created_at, updated_at, deleted_at
 `
 
 type UpdateTradeParams struct {
-	IsOpen  sql.NullBool
-	TradeID uuid.UUID
-	UserID  string
+	IsOpen       sql.NullBool
+	CustomFields pqtype.NullRawMessage
+	TradeID      uuid.UUID
+	TradebookID  uuid.UUID
 }
 
+// custom_fields is merged into the existing values; a null value removes that field
 func (q *Queries) UpdateTrade(ctx context.Context, arg UpdateTradeParams) (Trade, error) {
-	row := q.db.QueryRowContext(ctx, updateTrade, arg.IsOpen, arg.TradeID, arg.UserID)
+	row := q.db.QueryRowContext(ctx, updateTrade,
+		arg.IsOpen,
+		arg.CustomFields,
+		arg.TradeID,
+		arg.TradebookID,
+	)
 	var i Trade
 	err := row.Scan(
 		&i.ID,
 		&i.TradebookID,
+		&i.ClonedFromID,
 		&i.IsOpen,
 		&i.AssetClass,
 		&i.PurchaseType,
@@ -621,8 +3792,11 @@ func (q *Queries) UpdateTrade(ctx context.Context, arg UpdateTradeParams) (Trade
 		&i.EntryQuantity,
 		&i.EntryPrice,
 		&i.EntryFees,
+		&i.EntryFeesAuto,
+		&i.CustomFields,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.DeletedAt,
 	)
 	return i, err
 }
@@ -631,42 +3805,278 @@ const updateTradebook = `-- name: UpdateTradebook :one
 UPDATE tradebooks
 SET
     title = COALESCE($1, title),
+    timezone = COALESCE($2, timezone),
+    base_currency = COALESCE($3, base_currency),
+    accounting_method = COALESCE($4, accounting_method),
+    default_fees = COALESCE($5, default_fees),
+    fiscal_year_start = COALESCE($6, fiscal_year_start),
+    default_asset_classes = COALESCE($7::jsonb, default_asset_classes),
+    tag_sets = COALESCE($8::jsonb, tag_sets),
     updated_at = NOW()
-WHERE id = $2
-    AND owner_id = $3
-RETURNING id, owner_id, title, created_at, updated_at
+WHERE id = $9
+    AND deleted_at IS NULL
+RETURNING id, owner_id, workspace_id, title, template_id, base_currency, accounting_method, default_asset_classes, tag_sets, timezone, default_fees, fiscal_year_start, created_at, updated_at, deleted_at
 `
 
 type UpdateTradebookParams struct {
-	Title       sql.NullString
-	TradebookID uuid.UUID
-	UserID      string
+	Title               sql.NullString
+	Timezone            sql.NullString
+	BaseCurrency        sql.NullString
+	AccountingMethod    NullAccountingMethod
+	DefaultFees         decimal.NullDecimal
+	FiscalYearStart     sql.NullInt32
+	DefaultAssetClasses pqtype.NullRawMessage
+	TagSets             pqtype.NullRawMessage
+	TradebookID         uuid.UUID
 }
 
 func (q *Queries) UpdateTradebook(ctx context.Context, arg UpdateTradebookParams) (Tradebook, error) {
-	row := q.db.QueryRowContext(ctx, updateTradebook, arg.Title, arg.TradebookID, arg.UserID)
+	row := q.db.QueryRowContext(ctx, updateTradebook,
+		arg.Title,
+		arg.Timezone,
+		arg.BaseCurrency,
+		arg.AccountingMethod,
+		arg.DefaultFees,
+		arg.FiscalYearStart,
+		arg.DefaultAssetClasses,
+		arg.TagSets,
+		arg.TradebookID,
+	)
 	var i Tradebook
 	err := row.Scan(
 		&i.ID,
 		&i.OwnerID,
+		&i.WorkspaceID,
 		&i.Title,
+		&i.TemplateID,
+		&i.BaseCurrency,
+		&i.AccountingMethod,
+		&i.DefaultAssetClasses,
+		&i.TagSets,
+		&i.Timezone,
+		&i.DefaultFees,
+		&i.FiscalYearStart,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.DeletedAt,
 	)
 	return i, err
 }
 
-const upsertTradebookMember = `-- name: UpsertTradebookMember :one
+const updateTradebookTemplate = `-- name: UpdateTradebookTemplate :one
+UPDATE tradebook_templates
+SET
+    name = $1,
+    is_shared = $2,
+    base_currency = $3,
+    accounting_method = $4,
+    default_asset_classes = $5,
+    tag_sets = $6,
+    custom_fields = $7,
+    updated_at = NOW()
+WHERE id = $8
+    AND owner_id = $9
+RETURNING id, owner_id, workspace_id, name, is_shared, base_currency, accounting_method, default_asset_classes, tag_sets, custom_fields, created_at, updated_at
+`
+
+type UpdateTradebookTemplateParams struct {
+	Name                string
+	IsShared            bool
+	BaseCurrency        string
+	AccountingMethod    AccountingMethod
+	DefaultAssetClasses json.RawMessage
+	TagSets             json.RawMessage
+	CustomFields        json.RawMessage
+	TemplateID          uuid.UUID
+	OwnerID             string
+}
+
+func (q *Queries) UpdateTradebookTemplate(ctx context.Context, arg UpdateTradebookTemplateParams) (TradebookTemplate, error) {
+	row := q.db.QueryRowContext(ctx, updateTradebookTemplate,
+		arg.Name,
+		arg.IsShared,
+		arg.BaseCurrency,
+		arg.AccountingMethod,
+		arg.DefaultAssetClasses,
+		arg.TagSets,
+		arg.CustomFields,
+		arg.TemplateID,
+		arg.OwnerID,
+	)
+	var i TradebookTemplate
+	err := row.Scan(
+		&i.ID,
+		&i.OwnerID,
+		&i.WorkspaceID,
+		&i.Name,
+		&i.IsShared,
+		&i.BaseCurrency,
+		&i.AccountingMethod,
+		&i.DefaultAssetClasses,
+		&i.TagSets,
+		&i.CustomFields,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const updateUserPreferences = `-- name: UpdateUserPreferences :one
+UPDATE users
+SET
+    preferences = jsonb_strip_nulls(preferences || $1::jsonb),
+    updated_at = NOW()
+WHERE id = $2
+RETURNING id, email, display_name, avatar_url, profile_updated_at, preferences, created_at, updated_at
+`
+
+type UpdateUserPreferencesParams struct {
+	Preferences json.RawMessage
+	ID          string
+}
+
+// Merges into the stored preferences; a null value removes the key
+func (q *Queries) UpdateUserPreferences(ctx context.Context, arg UpdateUserPreferencesParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, updateUserPreferences, arg.Preferences, arg.ID)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Email,
+		&i.DisplayName,
+		&i.AvatarUrl,
+		&i.ProfileUpdatedAt,
+		&i.Preferences,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const upsertBillingCustomer = `-- name: UpsertBillingCustomer :exec
+
+INSERT INTO billing_customers (user_id, stripe_customer_id)
+VALUES ($1, $2)
+ON CONFLICT (user_id) DO UPDATE
+SET stripe_customer_id = EXCLUDED.stripe_customer_id
+`
+
+type UpsertBillingCustomerParams struct {
+	UserID           string
+	StripeCustomerID string
+}
+
+// ============================================================================
+// 21. BILLING
+// ============================================================================
+func (q *Queries) UpsertBillingCustomer(ctx context.Context, arg UpsertBillingCustomerParams) error {
+	_, err := q.db.ExecContext(ctx, upsertBillingCustomer, arg.UserID, arg.StripeCustomerID)
+	return err
+}
 
-WITH authorized_check AS (
-    -- Only proceed if the @owner_id is the actual owner of the tradebook
-    SELECT 1
-    FROM tradebooks
-    WHERE id = $1 AND owner_id = $4
+const upsertCommissionSchedule = `-- name: UpsertCommissionSchedule :one
+INSERT INTO commission_schedules (
+    tradebook_id, asset_class, rate_type, rate, min_fee, max_fee,
+    regulatory_rate, regulatory_per_unit
+) VALUES (
+    $1, $2, $3, $4, $5, $6,
+    $7, $8
 )
+ON CONFLICT (tradebook_id, asset_class) DO UPDATE SET
+    rate_type = EXCLUDED.rate_type,
+    rate = EXCLUDED.rate,
+    min_fee = EXCLUDED.min_fee,
+    max_fee = EXCLUDED.max_fee,
+    regulatory_rate = EXCLUDED.regulatory_rate,
+    regulatory_per_unit = EXCLUDED.regulatory_per_unit,
+    updated_at = NOW()
+RETURNING tradebook_id, asset_class, rate_type, rate, min_fee, max_fee, regulatory_rate, regulatory_per_unit, created_at, updated_at
+`
+
+type UpsertCommissionScheduleParams struct {
+	TradebookID       uuid.UUID
+	AssetClass        AssetClass
+	RateType          CommissionRateType
+	Rate              decimal.Decimal
+	MinFee            decimal.Decimal
+	MaxFee            decimal.NullDecimal
+	RegulatoryRate    decimal.Decimal
+	RegulatoryPerUnit decimal.Decimal
+}
+
+func (q *Queries) UpsertCommissionSchedule(ctx context.Context, arg UpsertCommissionScheduleParams) (CommissionSchedule, error) {
+	row := q.db.QueryRowContext(ctx, upsertCommissionSchedule,
+		arg.TradebookID,
+		arg.AssetClass,
+		arg.RateType,
+		arg.Rate,
+		arg.MinFee,
+		arg.MaxFee,
+		arg.RegulatoryRate,
+		arg.RegulatoryPerUnit,
+	)
+	var i CommissionSchedule
+	err := row.Scan(
+		&i.TradebookID,
+		&i.AssetClass,
+		&i.RateType,
+		&i.Rate,
+		&i.MinFee,
+		&i.MaxFee,
+		&i.RegulatoryRate,
+		&i.RegulatoryPerUnit,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const upsertSubscription = `-- name: UpsertSubscription :exec
+INSERT INTO subscriptions (id, user_id, stripe_customer_id, price_id, plan, status, current_period_end, cancel_at_period_end, synced_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+ON CONFLICT (id) DO UPDATE
+SET
+    price_id = EXCLUDED.price_id,
+    plan = EXCLUDED.plan,
+    status = EXCLUDED.status,
+    current_period_end = EXCLUDED.current_period_end,
+    cancel_at_period_end = EXCLUDED.cancel_at_period_end,
+    synced_at = EXCLUDED.synced_at
+WHERE subscriptions.synced_at <= EXCLUDED.synced_at
+`
+
+type UpsertSubscriptionParams struct {
+	ID                string
+	UserID            string
+	StripeCustomerID  string
+	PriceID           string
+	Plan              sql.NullString
+	Status            string
+	CurrentPeriodEnd  time.Time
+	CancelAtPeriodEnd bool
+	SyncedAt          time.Time
+}
+
+// Stripe doesn't deliver events in order, so an older event never overwrites
+// a newer one
+func (q *Queries) UpsertSubscription(ctx context.Context, arg UpsertSubscriptionParams) error {
+	_, err := q.db.ExecContext(ctx, upsertSubscription,
+		arg.ID,
+		arg.UserID,
+		arg.StripeCustomerID,
+		arg.PriceID,
+		arg.Plan,
+		arg.Status,
+		arg.CurrentPeriodEnd,
+		arg.CancelAtPeriodEnd,
+		arg.SyncedAt,
+	)
+	return err
+}
+
+const upsertTradebookMember = `-- name: UpsertTradebookMember :one
+
 INSERT INTO tradebook_members (tradebook_id, user_id, role)
-SELECT $1, $2, $3
-FROM authorized_check
+VALUES ($1, $2, $3)
 ON CONFLICT (tradebook_id, user_id) DO UPDATE
 SET
     role = EXCLUDED.role
@@ -675,21 +4085,16 @@ RETURNING tradebook_id, user_id, role, joined_at
 
 type UpsertTradebookMemberParams struct {
 	TradebookID uuid.UUID
-	NewMemberID string
+	UserID      string
 	Role        TradebookRole
-	OwnerID     string
 }
 
 // ============================================================================
 // 3. MEMBERS (Refactored to use UPSERT)
 // ============================================================================
+// Permission checks live in internal/authz; queries below trust @tradebook_id
 func (q *Queries) UpsertTradebookMember(ctx context.Context, arg UpsertTradebookMemberParams) (TradebookMember, error) {
-	row := q.db.QueryRowContext(ctx, upsertTradebookMember,
-		arg.TradebookID,
-		arg.NewMemberID,
-		arg.Role,
-		arg.OwnerID,
-	)
+	row := q.db.QueryRowContext(ctx, upsertTradebookMember, arg.TradebookID, arg.UserID, arg.Role)
 	var i TradebookMember
 	err := row.Scan(
 		&i.TradebookID,
@@ -700,13 +4105,44 @@ func (q *Queries) UpsertTradebookMember(ctx context.Context, arg UpsertTradebook
 	return i, err
 }
 
+const upsertTradebookPreferences = `-- name: UpsertTradebookPreferences :exec
+INSERT INTO tradebook_preferences (tradebook_id, user_id, is_pinned, is_archived)
+VALUES (
+    $1, $2,
+    COALESCE($3::boolean, FALSE),
+    COALESCE($4::boolean, FALSE)
+)
+ON CONFLICT (tradebook_id, user_id) DO UPDATE
+SET
+    is_pinned = COALESCE($3::boolean, tradebook_preferences.is_pinned),
+    is_archived = COALESCE($4::boolean, tradebook_preferences.is_archived)
+`
+
+type UpsertTradebookPreferencesParams struct {
+	TradebookID uuid.UUID
+	UserID      string
+	IsPinned    sql.NullBool
+	IsArchived  sql.NullBool
+}
+
+// Only touches the caller's own row; NULL leaves a flag unchanged
+func (q *Queries) UpsertTradebookPreferences(ctx context.Context, arg UpsertTradebookPreferencesParams) error {
+	_, err := q.db.ExecContext(ctx, upsertTradebookPreferences,
+		arg.TradebookID,
+		arg.UserID,
+		arg.IsPinned,
+		arg.IsArchived,
+	)
+	return err
+}
+
 const upsertUser = `-- name: UpsertUser :one
 
 INSERT INTO users (id)
 VALUES ($1)
 ON CONFLICT (id) DO UPDATE
 SET updated_at = NOW()
-RETURNING id, created_at, updated_at
+RETURNING id, email, display_name, avatar_url, profile_updated_at, preferences, created_at, updated_at
 `
 
 // ============================================================================
@@ -715,6 +4151,98 @@ RETURNING id, created_at, updated_at
 func (q *Queries) UpsertUser(ctx context.Context, id string) (User, error) {
 	row := q.db.QueryRowContext(ctx, upsertUser, id)
 	var i User
-	err := row.Scan(&i.ID, &i.CreatedAt, &i.UpdatedAt)
+	err := row.Scan(
+		&i.ID,
+		&i.Email,
+		&i.DisplayName,
+		&i.AvatarUrl,
+		&i.ProfileUpdatedAt,
+		&i.Preferences,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
 	return i, err
 }
+
+const upsertUserProfile = `-- name: UpsertUserProfile :exec
+INSERT INTO users (id, email, display_name, avatar_url, profile_updated_at)
+VALUES ($1, $2, $3, $4, $5)
+ON CONFLICT (id) DO UPDATE
+SET
+    email = EXCLUDED.email,
+    display_name = EXCLUDED.display_name,
+    avatar_url = EXCLUDED.avatar_url,
+    profile_updated_at = EXCLUDED.profile_updated_at,
+    updated_at = NOW()
+WHERE users.profile_updated_at IS NULL
+    OR users.profile_updated_at <= EXCLUDED.profile_updated_at
+`
+
+type UpsertUserProfileParams struct {
+	ID               string
+	Email            sql.NullString
+	DisplayName      sql.NullString
+	AvatarUrl        sql.NullString
+	ProfileUpdatedAt sql.NullTime
+}
+
+// Skips profiles older than the stored one, since webhooks can arrive out of order
+func (q *Queries) UpsertUserProfile(ctx context.Context, arg UpsertUserProfileParams) error {
+	_, err := q.db.ExecContext(ctx, upsertUserProfile,
+		arg.ID,
+		arg.Email,
+		arg.DisplayName,
+		arg.AvatarUrl,
+		arg.ProfileUpdatedAt,
+	)
+	return err
+}
+
+const upsertWorkspace = `-- name: UpsertWorkspace :exec
+
+INSERT INTO workspaces (id, name)
+VALUES ($1, $2)
+ON CONFLICT (id) DO UPDATE
+SET name = EXCLUDED.name
+`
+
+type UpsertWorkspaceParams struct {
+	ID   string
+	Name string
+}
+
+// ============================================================================
+// 19. WORKSPACES
+// ============================================================================
+func (q *Queries) UpsertWorkspace(ctx context.Context, arg UpsertWorkspaceParams) error {
+	_, err := q.db.ExecContext(ctx, upsertWorkspace, arg.ID, arg.Name)
+	return err
+}
+
+const upsertWorkspaceMember = `-- name: UpsertWorkspaceMember :exec
+INSERT INTO workspace_members (workspace_id, user_id, role, synced_at)
+VALUES ($1, $2, $3, $4)
+ON CONFLICT (workspace_id, user_id) DO UPDATE
+SET
+    role = EXCLUDED.role,
+    synced_at = EXCLUDED.synced_at
+WHERE workspace_members.synced_at <= EXCLUDED.synced_at
+`
+
+type UpsertWorkspaceMemberParams struct {
+	WorkspaceID string
+	UserID      string
+	Role        TradebookRole
+	SyncedAt    time.Time
+}
+
+// Skips memberships older than the stored one, as with UpsertUserProfile
+func (q *Queries) UpsertWorkspaceMember(ctx context.Context, arg UpsertWorkspaceMemberParams) error {
+	_, err := q.db.ExecContext(ctx, upsertWorkspaceMember,
+		arg.WorkspaceID,
+		arg.UserID,
+		arg.Role,
+		arg.SyncedAt,
+	)
+	return err
+}
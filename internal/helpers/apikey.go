@@ -0,0 +1,33 @@
+package helpers
+
+import (
+	"slices"
+
+	"github.com/google/uuid"
+
+	"tradebooklm-api/internal/models"
+)
+
+// APIKeyPrefix starts every API key, so the auth middleware can tell keys
+// from JWTs and leaked keys are easy to search for.
+const APIKeyPrefix = "tlm_"
+
+// APIKeyAccess is what a request authenticated with an API key may reach.
+type APIKeyAccess struct {
+	ID           uuid.UUID
+	Scopes       []models.Scope
+	TradebookIDs []uuid.UUID // Empty means every tradebook
+}
+
+// HasScope reports whether the key grants scope; write implies read.
+func (k APIKeyAccess) HasScope(scope models.Scope) bool {
+	if scope == models.ScopeRead && slices.Contains(k.Scopes, models.ScopeWrite) {
+		return true
+	}
+	return slices.Contains(k.Scopes, scope)
+}
+
+// AllowsTradebook reports whether the key reaches the tradebook.
+func (k APIKeyAccess) AllowsTradebook(id uuid.UUID) bool {
+	return len(k.TradebookIDs) == 0 || slices.Contains(k.TradebookIDs, id)
+}
@@ -0,0 +1,63 @@
+package helpers
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	SetCursorSecret("test-secret")
+
+	want := Cursor{
+		Time:   time.Date(2025, 3, 1, 12, 30, 0, 0, time.UTC),
+		ID:     uuid.New(),
+		Pinned: true,
+	}
+
+	got, err := DecodeCursor("tradebooks?archived=false&pinned=", EncodeCursor("tradebooks?archived=false&pinned=", want))
+	if err != nil {
+		t.Fatalf("DecodeCursor: %v", err)
+	}
+	if !got.Time.Equal(want.Time) || got.ID != want.ID || got.Pinned != want.Pinned {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestDecodeCursorRejects(t *testing.T) {
+	const scope = "tradebooks?archived=false&pinned="
+	SetCursorSecret("test-secret")
+	token := EncodeCursor(scope, Cursor{Time: time.Now(), ID: uuid.New()})
+	payload, sig, _ := strings.Cut(token, ".")
+
+	forged := base64.RawURLEncoding.EncodeToString([]byte(`{"t":"2020-01-01T00:00:00Z","id":"` + uuid.NewString() + `"}`))
+
+	SetCursorSecret("other-secret")
+	otherSecret := EncodeCursor(scope, Cursor{Time: time.Now(), ID: uuid.New()})
+	SetCursorSecret("test-secret")
+
+	tests := []struct {
+		name  string
+		scope string
+		token string
+	}{
+		{"another list", "trades/" + uuid.NewString() + "?", token},
+		{"other filters", "tradebooks?archived=true&pinned=", token},
+		{"another secret", scope, otherSecret},
+		{"forged payload", scope, forged + "." + sig},
+		{"missing signature", scope, payload},
+		{"invalid base64", scope, "!!!." + sig},
+		{"empty", scope, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := DecodeCursor(tt.scope, tt.token); err != ErrInvalidCursor {
+				t.Errorf("DecodeCursor() error = %v, want ErrInvalidCursor", err)
+			}
+		})
+	}
+}
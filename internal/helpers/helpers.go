@@ -1,6 +1,7 @@
 package helpers
 
 import (
+	"database/sql"
 	"log"
 	"net/http"
 	"os"
@@ -10,33 +11,69 @@ import (
 	"github.com/google/uuid"
 )
 
+// GetWorkosID returns the caller's user ID. On failure a response has
+// already been written.
 func GetWorkosID(c *gin.Context) (string, bool) {
-	workosIdInterface, exists := c.Get("workos_id")
-	if !exists {
-		log.Println("workos_id not found in context")
+	p, ok := GetPrincipal(c)
+	if !ok || p.UserID == "" {
+		log.Println("principal not found in context")
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "WorkOS ID not found in context"})
 		return "", false
 	}
 
-	workosId, ok := workosIdInterface.(string)
-	if !ok {
-		log.Println("workos_id in context is not a string")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid WorkOS ID format in context"})
-		return "", false
+	return p.UserID, true
+}
+
+// GetOrgID returns the WorkOS organization the session or API key is scoped
+// to. It is NULL for personal sessions.
+func GetOrgID(c *gin.Context) sql.NullString {
+	p, _ := GetPrincipal(c)
+	return sql.NullString{String: p.OrgID, Valid: p.OrgID != ""}
+}
+
+// GetOptionalQuery returns the query parameter as a NULL when it is absent or
+// empty, for use with sqlc.narg filters.
+func GetOptionalQuery(c *gin.Context, key string) sql.NullString {
+	value := c.Query(key)
+	return sql.NullString{String: value, Valid: value != ""}
+}
+
+// GetOptionalBoolQuery parses an optional true/false query parameter. On an
+// invalid value it writes a 400 response and returns false.
+func GetOptionalBoolQuery(c *gin.Context, key string) (sql.NullBool, bool) {
+	value := c.Query(key)
+	if value == "" {
+		return sql.NullBool{}, true
 	}
 
-	return workosId, true
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + key + " filter"})
+		return sql.NullBool{}, false
+	}
+
+	return sql.NullBool{Bool: parsed, Valid: true}, true
 }
 
 func GetPaginationParams(c *gin.Context) (int32, int32) {
 	pageStr := c.DefaultQuery("page", "1")
-	limitStr := c.DefaultQuery("limit", "20")
 
 	page, err := strconv.Atoi(pageStr)
 	if err != nil || page < 1 {
 		page = 1
 	}
 
+	limit := getLimitParam(c)
+
+	// Calculate offset
+	offset := (page - 1) * int(limit)
+
+	return limit, int32(offset)
+}
+
+func getLimitParam(c *gin.Context) int32 {
+	limitStr := c.DefaultQuery("limit", "20")
+
 	limit, err := strconv.Atoi(limitStr)
 	if err != nil || limit < 1 {
 		limit = 20
@@ -47,10 +84,7 @@ func GetPaginationParams(c *gin.Context) (int32, int32) {
 		limit = 100
 	}
 
-	// Calculate offset
-	offset := (page - 1) * limit
-
-	return int32(limit), int32(offset)
+	return int32(limit)
 }
 
 func ParseUUID(id string) (uuid.UUID, error) {
@@ -0,0 +1,98 @@
+package helpers
+
+import (
+	"slices"
+
+	"github.com/gin-gonic/gin"
+
+	"tradebooklm-api/internal/models"
+)
+
+const principalKey = "principal"
+
+// Principal is the authenticated caller, set by the auth middleware for
+// sessions and API keys alike.
+type Principal struct {
+	UserID string // WorkOS user ID
+	OrgID  string // WorkOS organization of the session or key; empty if personal
+
+	// From the WorkOS access token; empty for API keys
+	Role        string   // Organization role slug
+	Roles       []string // Every organization role slug
+	Permissions []string
+
+	APIKey *APIKeyAccess // Set when authenticated with an API key
+}
+
+// roleScopes are the scopes of a session in an organization whose token has
+// no known permissions. As with workspace roles, unlisted roles can only read.
+var roleScopes = map[string][]models.Scope{
+	"admin":  {models.ScopeRead, models.ScopeWrite, models.ScopeReadWorkspaceMembers},
+	"member": {models.ScopeRead, models.ScopeWrite},
+}
+
+// permissionScopes are the WorkOS permissions a session's token can grant
+// scopes with; any other permission is ignored.
+var permissionScopes = map[string]models.Scope{
+	string(models.ScopeRead):                 models.ScopeRead,
+	string(models.ScopeWrite):                models.ScopeWrite,
+	string(models.ScopeReadWorkspaceMembers): models.ScopeReadWorkspaceMembers,
+}
+
+// HasScope reports whether the principal holds scope (see models.Scope).
+// Write implies read.
+func (p Principal) HasScope(scope models.Scope) bool {
+	if p.APIKey != nil {
+		return p.APIKey.HasScope(scope)
+	}
+
+	scopes := p.sessionScopes()
+	if scope == models.ScopeRead && slices.Contains(scopes, models.ScopeWrite) {
+		return true
+	}
+	return slices.Contains(scopes, scope)
+}
+
+// sessionScopes come from the token's known permissions if it has any, else
+// from its organization roles. Personal sessions read and write.
+func (p Principal) sessionScopes() []models.Scope {
+	var scopes []models.Scope
+	for _, permission := range p.Permissions {
+		if scope, ok := permissionScopes[permission]; ok {
+			scopes = append(scopes, scope)
+		}
+	}
+	if len(scopes) > 0 {
+		return scopes
+	}
+
+	if len(p.Roles) == 0 {
+		return []models.Scope{models.ScopeRead, models.ScopeWrite}
+	}
+
+	for _, role := range p.Roles {
+		granted, ok := roleScopes[role]
+		if !ok {
+			granted = []models.Scope{models.ScopeRead}
+		}
+		scopes = append(scopes, granted...)
+	}
+	return scopes
+}
+
+func (p Principal) HasPermission(permission string) bool {
+	return slices.Contains(p.Permissions, permission)
+}
+
+func SetPrincipal(c *gin.Context, p Principal) {
+	c.Set(principalKey, p)
+}
+
+// GetPrincipal returns the caller; ok is false outside authenticated routes.
+func GetPrincipal(c *gin.Context) (Principal, bool) {
+	p, ok := c.Get(principalKey)
+	if !ok {
+		return Principal{}, false
+	}
+	return p.(Principal), true
+}
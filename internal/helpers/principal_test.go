@@ -0,0 +1,87 @@
+package helpers
+
+import (
+	"slices"
+	"testing"
+
+	"tradebooklm-api/internal/models"
+)
+
+func TestSessionScopes(t *testing.T) {
+	var (
+		read    = models.ScopeRead
+		write   = models.ScopeWrite
+		members = models.ScopeReadWorkspaceMembers
+	)
+
+	tests := []struct {
+		name      string
+		principal Principal
+		want      []models.Scope // Every scope not listed must be denied
+	}{
+		{
+			name:      "personal session",
+			principal: Principal{UserID: "user_1"},
+			want:      []models.Scope{read, write},
+		},
+		{
+			name:      "admin role",
+			principal: Principal{OrgID: "org_1", Role: "admin", Roles: []string{"admin"}},
+			want:      []models.Scope{read, write, members},
+		},
+		{
+			name:      "member role",
+			principal: Principal{OrgID: "org_1", Role: "member", Roles: []string{"member"}},
+			want:      []models.Scope{read, write},
+		},
+		{
+			name:      "unknown role reads",
+			principal: Principal{OrgID: "org_1", Role: "auditor", Roles: []string{"auditor"}},
+			want:      []models.Scope{read},
+		},
+		{
+			name:      "roles combine",
+			principal: Principal{OrgID: "org_1", Roles: []string{"auditor", "admin"}},
+			want:      []models.Scope{read, write, members},
+		},
+		{
+			name:      "permissions override roles",
+			principal: Principal{OrgID: "org_1", Roles: []string{"admin"}, Permissions: []string{"read"}},
+			want:      []models.Scope{read},
+		},
+		{
+			name:      "write permission implies read",
+			principal: Principal{OrgID: "org_1", Permissions: []string{"write"}},
+			want:      []models.Scope{read, write},
+		},
+		{
+			name:      "unknown permissions fall back to roles",
+			principal: Principal{OrgID: "org_1", Roles: []string{"member"}, Permissions: []string{"billing:manage"}},
+			want:      []models.Scope{read, write},
+		},
+		{
+			name:      "unknown permissions grant nothing themselves",
+			principal: Principal{OrgID: "org_1", Roles: []string{"auditor"}, Permissions: []string{"write:all", "members"}},
+			want:      []models.Scope{read},
+		},
+		{
+			name: "api keys use their own scopes",
+			principal: Principal{
+				Roles:  []string{"admin"},
+				APIKey: &APIKeyAccess{Scopes: []models.Scope{read}},
+			},
+			want: []models.Scope{read},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, scope := range []models.Scope{read, write, members} {
+				want := slices.Contains(tt.want, scope)
+				if got := tt.principal.HasScope(scope); got != want {
+					t.Errorf("HasScope(%q) = %v, want %v", scope, got, want)
+				}
+			}
+		})
+	}
+}
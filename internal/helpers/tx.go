@@ -0,0 +1,58 @@
+package helpers
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"tradebooklm-api/internal/database"
+)
+
+// SnapshotTxOptions give a read-only transaction one consistent view of the
+// database, for reads spread over many queries such as exports.
+var SnapshotTxOptions = &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true}
+
+// BeginUserTx starts a transaction scoped to the authenticated caller.
+// Row-level security policies read app.current_user_id, so tenant tables
+// (tradebooks, members, trades, exit legs) must be queried through it.
+// On failure a response has already been written.
+func BeginUserTx(c *gin.Context, conn *sql.DB) (*sql.Tx, bool) {
+	return BeginUserTxWithOptions(c, conn, nil)
+}
+
+// BeginUserTxWithOptions is BeginUserTx with the given isolation level and
+// access mode.
+func BeginUserTxWithOptions(c *gin.Context, conn *sql.DB, opts *sql.TxOptions) (*sql.Tx, bool) {
+	workosId, ok := GetWorkosID(c)
+	if !ok {
+		return nil, false
+	}
+
+	ctx := c.Request.Context()
+
+	tx, err := conn.BeginTx(ctx, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Transaction start failed"})
+		return nil, false
+	}
+
+	if err := database.New(tx).SetCurrentUser(ctx, workosId); err != nil {
+		tx.Rollback()
+		log.Printf("Error scoping transaction to user: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Transaction start failed"})
+		return nil, false
+	}
+
+	if orgID := GetOrgID(c); orgID.Valid {
+		if err := database.New(tx).SetCurrentOrg(ctx, orgID.String); err != nil {
+			tx.Rollback()
+			log.Printf("Error scoping transaction to organization: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Transaction start failed"})
+			return nil, false
+		}
+	}
+
+	return tx, true
+}
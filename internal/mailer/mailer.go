@@ -0,0 +1,91 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"tradebooklm-api/internal/helpers"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string // Plain text
+}
+
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// NewFromEnv returns an SMTP mailer when SMTP_HOST is set, otherwise a
+// mailer that only logs (local development).
+func NewFromEnv() Mailer {
+	host := os.Getenv("SMTP_HOST")
+	if host == "" {
+		log.Println("SMTP_HOST not set. Emails will be logged instead of sent")
+		return LogMailer{}
+	}
+
+	port := os.Getenv("SMTP_PORT")
+	if port == "" {
+		port = "587"
+	}
+
+	return &SMTPMailer{
+		Addr:     net.JoinHostPort(host, port),
+		Host:     host,
+		Username: os.Getenv("SMTP_USERNAME"),
+		Password: os.Getenv("SMTP_PASSWORD"),
+		From:     helpers.MustGetenv("MAIL_FROM"),
+	}
+}
+
+type SMTPMailer struct {
+	Addr     string
+	Host     string
+	Username string
+	Password string
+	From     string
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, msg Message) error {
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+
+	// Reject header injection through user-supplied addresses
+	if strings.ContainsAny(msg.To, "\r\n") || strings.ContainsAny(msg.Subject, "\r\n") {
+		return fmt.Errorf("invalid header value")
+	}
+
+	body := "From: " + m.From + "\r\n" +
+		"To: " + msg.To + "\r\n" +
+		"Subject: " + msg.Subject + "\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n" +
+		"\r\n" +
+		msg.Body
+
+	// net/smtp has no context support, so honour cancellation up front
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if err := smtp.SendMail(m.Addr, auth, m.From, []string{msg.To}, []byte(body)); err != nil {
+		return fmt.Errorf("smtp send: %w", err)
+	}
+
+	return nil
+}
+
+type LogMailer struct{}
+
+func (LogMailer) Send(ctx context.Context, msg Message) error {
+	log.Printf("MAIL to=%s subject=%q\n%s", msg.To, msg.Subject, msg.Body)
+	return nil
+}
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"encoding/json"
 	"time"
 
 	"github.com/shopspring/decimal"
@@ -42,29 +43,494 @@ const (
 	Reader Role = "reader"
 )
 
+type AccountingMethod string
+
+const (
+	FIFO        AccountingMethod = "fifo"
+	LIFO        AccountingMethod = "lifo"
+	AverageCost AccountingMethod = "average_cost"
+)
+
+type CustomFieldType string
+
+const (
+	FieldText    CustomFieldType = "text"
+	FieldNumber  CustomFieldType = "number"
+	FieldSelect  CustomFieldType = "select"
+	FieldBoolean CustomFieldType = "boolean"
+	FieldDate    CustomFieldType = "date"
+)
+
+// CreateTradebookRequest is optional; an empty body creates an "Untitled Tradebook".
+type CreateTradebookRequest struct {
+	Title       string `json:"title"`
+	TemplateID  string `json:"template_id"`  // Seeds settings and custom fields
+	WorkspaceID string `json:"workspace_id"` // Must be the session's workspace; empty for a personal book
+}
+
+type TagSet struct {
+	Name string   `json:"name" binding:"required"`
+	Tags []string `json:"tags"`
+}
+
+// TemplateCustomField is a custom field a template adds to new tradebooks.
+// Options lists the allowed values and is only used by select fields.
+type TemplateCustomField struct {
+	Name    string          `json:"name" binding:"required"`
+	Type    CustomFieldType `json:"type" binding:"required,oneof=text number select boolean date"`
+	Options []string        `json:"options,omitempty"`
+}
+
+type CommissionRateType string
+
+const (
+	PerShare          CommissionRateType = "per_share"
+	PerContract       CommissionRateType = "per_contract"
+	PercentOfNotional CommissionRateType = "percent_of_notional"
+)
+
+// CommissionSchedule computes fees for trades and exit legs of one asset
+// class that are entered without fees. Regulatory fees apply to exits only.
+type CommissionSchedule struct {
+	AssetClass        AssetClass         `json:"asset_class"`
+	RateType          CommissionRateType `json:"rate_type"`
+	Rate              decimal.Decimal    `json:"rate"` // Per unit, or a fraction of notional (0.001 = 0.1%)
+	MinFee            decimal.Decimal    `json:"min_fee"`
+	MaxFee            *decimal.Decimal   `json:"max_fee"` // null means uncapped
+	RegulatoryRate    decimal.Decimal    `json:"regulatory_rate"`
+	RegulatoryPerUnit decimal.Decimal    `json:"regulatory_per_unit"`
+	UpdatedAt         time.Time          `json:"updated_at"`
+}
+
+type CommissionScheduleRequest struct {
+	RateType          CommissionRateType `json:"rate_type" binding:"required,oneof=per_share per_contract percent_of_notional"`
+	Rate              decimal.Decimal    `json:"rate"`
+	MinFee            decimal.Decimal    `json:"min_fee"`
+	MaxFee            *decimal.Decimal   `json:"max_fee"`
+	RegulatoryRate    decimal.Decimal    `json:"regulatory_rate"`
+	RegulatoryPerUnit decimal.Decimal    `json:"regulatory_per_unit"`
+}
+
+// RecomputeFeesRequest recomputes fees that were filled in automatically.
+// IncludeManual also overwrites fees that were entered by hand.
+type RecomputeFeesRequest struct {
+	AssetClass    AssetClass `json:"asset_class" binding:"omitempty,oneof=equities fixed_income commodities etfs forex derivatives crypto"`
+	IncludeManual bool       `json:"include_manual"`
+}
+
+type RecomputeFeesResult struct {
+	TradesUpdated   int `json:"trades_updated"`
+	ExitLegsUpdated int `json:"exit_legs_updated"`
+}
+
+type CustomField struct {
+	ID        string          `json:"id"`
+	Name      string          `json:"name"`
+	Type      CustomFieldType `json:"type"`
+	Options   []string        `json:"options,omitempty"`
+	Position  int32           `json:"position"`
+	CreatedAt time.Time       `json:"created_at"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+type CreateCustomFieldRequest struct {
+	Name    string          `json:"name" binding:"required"`
+	Type    CustomFieldType `json:"type" binding:"required,oneof=text number select boolean date"`
+	Options []string        `json:"options"` // Required for select fields
+}
+
+// UpdateCustomFieldRequest leaves omitted fields unchanged. The type can't be changed.
+type UpdateCustomFieldRequest struct {
+	Name     *string  `json:"name"`
+	Options  []string `json:"options"`
+	Position *int32   `json:"position"`
+}
+
+type TradebookTemplate struct {
+	ID          string `json:"id"`
+	OwnerID     string `json:"owner_id"`
+	WorkspaceID string `json:"workspace_id,omitempty"` // The session's workspace when it was created
+	Name        string `json:"name"`
+	IsShared    bool   `json:"is_shared"` // Usable by members of the workspace; without one, only by the owner
+
+	BaseCurrency        string                `json:"base_currency"`
+	AccountingMethod    AccountingMethod      `json:"accounting_method"`
+	DefaultAssetClasses []AssetClass          `json:"default_asset_classes"`
+	TagSets             []TagSet              `json:"tag_sets"`
+	CustomFields        []TemplateCustomField `json:"custom_fields"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// TradebookTemplateRequest is used for both creating and replacing a template.
+type TradebookTemplateRequest struct {
+	Name     string `json:"name" binding:"required"`
+	IsShared bool   `json:"is_shared"`
+
+	BaseCurrency        string                `json:"base_currency" binding:"omitempty,len=3"`                            // Defaults to USD
+	AccountingMethod    AccountingMethod      `json:"accounting_method" binding:"omitempty,oneof=fifo lifo average_cost"` // Defaults to fifo
+	DefaultAssetClasses []AssetClass          `json:"default_asset_classes" binding:"dive,oneof=equities fixed_income commodities etfs forex derivatives crypto"`
+	TagSets             []TagSet              `json:"tag_sets" binding:"dive"`
+	CustomFields        []TemplateCustomField `json:"custom_fields" binding:"dive"`
+}
+
+type CloneTradebookRequest struct {
+	Title         string `json:"title"`          // Defaults to "<source title> (Copy)"
+	IncludeTrades bool   `json:"include_trades"` // Also copy trades and their exit legs
+}
+
+// ImportConflict is an archive entry that could not be imported as is.
+type ImportConflict struct {
+	Entry  string `json:"entry"`          // Archive file, e.g. trades.jsonl
+	Line   int    `json:"line,omitempty"` // 1-based, for JSON Lines entries
+	ID     string `json:"id,omitempty"`   // The entry's ID in the archive
+	Reason string `json:"reason"`
+}
+
+// ImportTradebookResult lists the conflicts that were skipped; without
+// skip_conflicts any conflict fails the import instead.
+type ImportTradebookResult struct {
+	Tradebook        Tradebook        `json:"tradebook"`
+	TradesImported   int              `json:"trades_imported"`
+	ExitLegsImported int              `json:"exit_legs_imported"`
+	Conflicts        []ImportConflict `json:"conflicts"`
+}
+
+// UpdateTradebookRequest leaves omitted fields (and settings) unchanged.
 type UpdateTradebookRequest struct {
-	Title string `json:"title" binding:"required"`
+	Title    string                          `json:"title"`
+	Settings *UpdateTradebookSettingsRequest `json:"settings"`
 }
 
-type CreateWorkosUserRequest struct {
-	ID        string    `json:"id" binding:"required"`
-	CreatedAt time.Time `json:"created_at" binding:"required"`
-	UpdatedAt time.Time `json:"updated_at" binding:"required"`
+// TradebookSettings drive the calculations on a tradebook's trades:
+// fees and currency defaults for new trades, lot matching for realized P&L,
+// and period boundaries in reports.
+type TradebookSettings struct {
+	Timezone            string           `json:"timezone"`
+	BaseCurrency        string           `json:"base_currency"`
+	AccountingMethod    AccountingMethod `json:"accounting_method"` // Lot matching for closing trades
+	DefaultFees         decimal.Decimal  `json:"default_fees"`
+	FiscalYearStart     int32            `json:"fiscal_year_start"` // Month, 1-12
+	DefaultAssetClasses []AssetClass     `json:"default_asset_classes"`
+	TagSets             []TagSet         `json:"tag_sets"`
 }
 
-type User struct {
+type UpdateTradebookSettingsRequest struct {
+	Timezone            *string           `json:"timezone"`
+	BaseCurrency        *string           `json:"base_currency" binding:"omitempty,len=3"`
+	AccountingMethod    *AccountingMethod `json:"accounting_method" binding:"omitempty,oneof=fifo lifo average_cost"`
+	DefaultFees         *decimal.Decimal  `json:"default_fees"`
+	FiscalYearStart     *int32            `json:"fiscal_year_start" binding:"omitempty,min=1,max=12"`
+	DefaultAssetClasses []AssetClass      `json:"default_asset_classes" binding:"omitempty,dive,oneof=equities fixed_income commodities etfs forex derivatives crypto"`
+	TagSets             []TagSet          `json:"tag_sets" binding:"omitempty,dive"`
+}
+
+// UpdateTradebookPreferencesRequest changes how a tradebook appears in the
+// caller's own list; omitted fields are left unchanged.
+type UpdateTradebookPreferencesRequest struct {
+	IsPinned   *bool `json:"is_pinned"`
+	IsArchived *bool `json:"is_archived"`
+}
+
+type TradebookMember struct {
+	UserID   string    `json:"user_id"`
+	Role     Role      `json:"role"`
+	JoinedAt time.Time `json:"joined_at"`
+
+	// Profile, on member lists only
+	Email       string `json:"email,omitempty"`
+	DisplayName string `json:"display_name,omitempty"`
+	AvatarURL   string `json:"avatar_url,omitempty"`
+}
+
+type AddTradebookMemberRequest struct {
+	UserID string `json:"user_id" binding:"required"`
+	Role   Role   `json:"role" binding:"required"`
+}
+
+type UpdateTradebookMemberRequest struct {
+	Role Role `json:"role" binding:"required"`
+}
+
+type TradebookInvitation struct {
+	ID          string    `json:"id"`
+	TradebookID string    `json:"tradebook_id"`
+	Email       string    `json:"email"`
+	Role        Role      `json:"role"`
+	InvitedBy   string    `json:"invited_by"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type CreateInvitationRequest struct {
+	Email string `json:"email" binding:"required,email"`
+	Role  Role   `json:"role" binding:"required"`
+}
+
+type AcceptInvitationRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+type TransferOwnershipRequest struct {
+	NewOwnerID        string `json:"new_owner_id" binding:"required"`
+	PreviousOwnerRole Role   `json:"previous_owner_role"` // Defaults to editor
+}
+
+type OwnershipTransfer struct {
+	ID            string    `json:"id"`
+	TradebookID   string    `json:"tradebook_id"`
+	FromUserID    string    `json:"from_user_id"`
+	ToUserID      string    `json:"to_user_id"`
+	TransferredAt time.Time `json:"transferred_at"`
+}
+
+// ErasurePolicy decides what happens to the tradebooks a user owns when their
+// account is erased.
+type ErasurePolicy string
+
+const (
+	// ErasureTransfer hands each book to its longest-standing editor (or
+	// reader); books without other members, and trashed books, are deleted.
+	ErasureTransfer ErasurePolicy = "transfer"
+	ErasureDelete   ErasurePolicy = "delete"
+)
+
+type EraseAccountRequest struct {
+	OwnedTradebooks ErasurePolicy `json:"owned_tradebooks" binding:"required,oneof=transfer delete"`
+}
+
+// ErasureReport is returned once an erasure has committed. It is also kept,
+// without the user ID, so the erasure can be shown to have happened.
+type ErasureReport struct {
+	ID          string        `json:"id"`
+	SubjectHash string        `json:"subject_hash"` // SHA-256 hex of the erased user ID
+	Pseudonym   string        `json:"pseudonym"`    // Replaces the user ID in retained audit entries
+	Policy      ErasurePolicy `json:"policy"`
+
+	TradebooksTransferred int64 `json:"tradebooks_transferred"`
+	TradebooksDeleted     int64 `json:"tradebooks_deleted"`
+	MembershipsRemoved    int64 `json:"memberships_removed"`
+	TemplatesDeleted      int64 `json:"templates_deleted"`
+	InvitationsDeleted    int64 `json:"invitations_deleted"`
+
+	AuditEntriesAnonymized       int64 `json:"audit_entries_anonymized"`
+	OwnershipTransfersAnonymized int64 `json:"ownership_transfers_anonymized"`
+	UsageLogsPurged              int64 `json:"usage_logs_purged"`
+	InvitationsReceivedDeleted   int64 `json:"invitations_received_deleted"` // Addressed to the user's email
+
+	// Rows still referencing the user after the erasure; always 0, since the
+	// erasure is rolled back otherwise
+	RemainingReferences int64 `json:"remaining_references"`
+
+	CompletedAt time.Time `json:"completed_at"`
+}
+
+type AuditAction string
+
+// The prefix before the dot is recorded as the entity type.
+const (
+	AuditTradebookCreate   AuditAction = "tradebook.create"
+	AuditTradebookUpdate   AuditAction = "tradebook.update"
+	AuditTradebookDelete   AuditAction = "tradebook.delete"
+	AuditTradebookRestore  AuditAction = "tradebook.restore"
+	AuditTradebookClone    AuditAction = "tradebook.clone"
+	AuditTradebookTransfer AuditAction = "tradebook.transfer"
+	AuditTradebookImport   AuditAction = "tradebook.import"
+	AuditTradebookMove     AuditAction = "tradebook.move" // Into or out of a workspace
+
+	AuditMemberAdd    AuditAction = "member.add"
+	AuditMemberUpdate AuditAction = "member.update"
+	AuditMemberRemove AuditAction = "member.remove"
+
+	AuditInvitationCreate AuditAction = "invitation.create"
+	AuditInvitationRevoke AuditAction = "invitation.revoke"
+
+	AuditTradeCreate  AuditAction = "trade.create"
+	AuditTradeUpdate  AuditAction = "trade.update"
+	AuditTradeDelete  AuditAction = "trade.delete"
+	AuditTradeRestore AuditAction = "trade.restore"
+
+	AuditExitLegCreate AuditAction = "exit_leg.create"
+
+	AuditCommissionUpdate    AuditAction = "commission.update"
+	AuditCommissionDelete    AuditAction = "commission.delete"
+	AuditCommissionRecompute AuditAction = "commission.recompute"
+
+	AuditFieldCreate AuditAction = "field.create"
+	AuditFieldUpdate AuditAction = "field.update"
+	AuditFieldDelete AuditAction = "field.delete"
+)
+
+type AuditEvent struct {
+	ID         string          `json:"id"`
+	ActorID    string          `json:"actor_id"`
+	Action     AuditAction     `json:"action"`
+	EntityType string          `json:"entity_type"`
+	EntityID   string          `json:"entity_id"`
+	Before     json.RawMessage `json:"before"` // null for creations
+	After      json.RawMessage `json:"after"`  // null for deletions
+	CreatedAt  time.Time       `json:"created_at"`
+}
+
+// WorkOSEvent is the envelope of every WorkOS webhook; Data depends on Event.
+type WorkOSEvent struct {
+	ID        string          `json:"id" binding:"required"` // Stable across redeliveries
+	Event     string          `json:"event" binding:"required"`
+	Data      json.RawMessage `json:"data"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// WorkOSUser is the data of user.* events.
+type WorkOSUser struct {
+	ID                string    `json:"id" binding:"required"`
+	Email             string    `json:"email"`
+	FirstName         string    `json:"first_name"`
+	LastName          string    `json:"last_name"`
+	ProfilePictureURL string    `json:"profile_picture_url"`
+	CreatedAt         time.Time `json:"created_at"`
+	UpdatedAt         time.Time `json:"updated_at"`
+}
+
+// WorkOSOrganization is the data of organization.* events.
+type WorkOSOrganization struct {
 	ID        string    `json:"id"`
-	WorkosID  string    `json:"workos_id"`
+	Name      string    `json:"name"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 }
 
-type Tradebook struct {
-	ID        string    `json:"id"`
-	Title     string    `json:"title"`
+// WorkOSOrganizationMembership is the data of organization_membership.* events.
+type WorkOSOrganizationMembership struct {
+	ID             string `json:"id"`
+	UserID         string `json:"user_id"`
+	OrganizationID string `json:"organization_id"`
+	Role           struct {
+		Slug string `json:"slug"`
+	} `json:"role"`
+	Status    string    `json:"status"` // active, inactive or pending; only active memberships grant access
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
-	Role      Role      `json:"role"` // This is usually injected during retrieval
+}
+
+// Workspace is a WorkOS organization as seen by one of its members. Role
+// applies to every tradebook the workspace owns.
+type Workspace struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Role      Role      `json:"role"`
+	IsCurrent bool      `json:"is_current"` // The session's organization
+	CreatedAt time.Time `json:"created_at"`
+	JoinedAt  time.Time `json:"joined_at"`
+}
+
+// SetTradebookWorkspaceRequest moves a tradebook into the session's workspace,
+// or back to its owner with an empty WorkspaceID.
+type SetTradebookWorkspaceRequest struct {
+	WorkspaceID string `json:"workspace_id"`
+}
+
+// Scope is what a route group requires of the caller. A session holds each
+// WorkOS permission of its token named after a scope; tokens without any get
+// scopes by organization role, and personal sessions read and write. API keys
+// hold only the scopes they were created with.
+type Scope string
+
+const (
+	ScopeRead  Scope = "read"  // View tradebooks and trades
+	ScopeWrite Scope = "write" // Also add and edit trades and settings; implies read
+
+	ScopeReadWorkspaceMembers Scope = "workspace-members:read" // List the session's workspace members
+)
+
+// APIKey never includes the key itself, which is only returned on creation.
+type APIKey struct {
+	ID           string     `json:"id"`
+	Name         string     `json:"name"`
+	Prefix       string     `json:"prefix"`
+	Scopes       []Scope    `json:"scopes"`
+	TradebookIDs []string   `json:"tradebook_ids"` // Empty means every tradebook
+	WorkspaceID  string     `json:"workspace_id,omitempty"`
+	ExpiresAt    *time.Time `json:"expires_at"`
+	LastUsedAt   *time.Time `json:"last_used_at"`
+	RevokedAt    *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+type CreateAPIKeyRequest struct {
+	Name         string     `json:"name" binding:"required,max=100"`
+	Scopes       []Scope    `json:"scopes" binding:"required,min=1,dive,oneof=read write"`
+	TradebookIDs []string   `json:"tradebook_ids" binding:"dive,uuid"`
+	ExpiresAt    *time.Time `json:"expires_at"` // Omit for a key that never expires
+}
+
+// CreatedAPIKey is the only response that carries the key.
+type CreatedAPIKey struct {
+	APIKey
+	Key string `json:"key"`
+}
+
+type SubscriptionTier string
+
+const (
+	TierFree  SubscriptionTier = "free"
+	TierPro   SubscriptionTier = "pro"
+	TierUltra SubscriptionTier = "ultra"
+)
+
+// User is the GET /me response. Profile fields are empty until the profile
+// has been synced from WorkOS.
+type User struct {
+	ID          string           `json:"id"` // WorkOS user ID
+	Email       string           `json:"email"`
+	DisplayName string           `json:"display_name"`
+	AvatarURL   string           `json:"avatar_url"`
+	Tier        SubscriptionTier `json:"tier"`
+	Preferences json.RawMessage  `json:"preferences"`
+	CreatedAt   time.Time        `json:"created_at"`
+	UpdatedAt   time.Time        `json:"updated_at"`
+
+	Subscription *Subscription `json:"subscription"` // Null if the user never subscribed
+}
+
+// Subscription is a Stripe subscription as last reported by its webhooks.
+type Subscription struct {
+	ID                  string           `json:"id"` // Stripe subscription ID
+	Plan                SubscriptionTier `json:"plan,omitempty"`
+	Status              string           `json:"status"` // Stripe's status, e.g. "active" or "past_due"
+	CurrentPeriodEnd    time.Time        `json:"current_period_end"`
+	CancelAtPeriodEnd   bool             `json:"cancel_at_period_end"`
+	LatestInvoiceStatus string           `json:"latest_invoice_status,omitempty"`
+	CreatedAt           time.Time        `json:"created_at"`
+}
+
+type Tradebook struct {
+	ID          string    `json:"id"`
+	Title       string    `json:"title"`
+	WorkspaceID string    `json:"workspace_id,omitempty"` // Set for workspace tradebooks
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	Role        Role      `json:"role"` // This is usually injected during retrieval
+
+	// Per-user view preferences
+	IsPinned   bool `json:"is_pinned"`
+	IsArchived bool `json:"is_archived"`
+
+	Settings *TradebookSettings `json:"settings,omitempty"` // Only on single-tradebook responses
+}
+
+type TrashedTradebook struct {
+	Tradebook
+	DeletedAt time.Time `json:"deleted_at"`
+	PurgeAt   time.Time `json:"purge_at"` // Restorable until then
+}
+
+// Page is the envelope returned by cursor-paginated list endpoints.
+// NextCursor is empty when HasMore is false.
+type Page[T any] struct {
+	Data       []T    `json:"data"`
+	NextCursor string `json:"next_cursor,omitempty"`
+	HasMore    bool   `json:"has_more"`
 }
 
 type Trade struct {
@@ -89,26 +555,56 @@ type Trade struct {
 
 	ExitLegs []*ExitLeg `json:"exit_legs"`
 
+	// Custom field values keyed by field ID: strings for text, select and
+	// date (YYYY-MM-DD) fields, numbers and booleans otherwise
+	CustomFields map[string]any `json:"custom_fields"`
+
 	Notes string `json:"notes,omitempty"` // omitempty if blank
 
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 }
 
+type TrashedTrade struct {
+	Trade
+	DeletedAt time.Time `json:"deleted_at"`
+	PurgeAt   time.Time `json:"purge_at"` // Restorable until then
+}
+
 type AddTradeRequest struct {
 	Title        string       `json:"title"`
-	AssetClass   AssetClass   `json:"asset_class"`
-	PurchaseType PurchaseType `json:"purchase_type"`
-	OrderType    OrderType    `json:"order_type"`
+	AssetClass   AssetClass   `json:"asset_class" binding:"required,oneof=equities fixed_income commodities etfs forex derivatives crypto"`
+	PurchaseType PurchaseType `json:"purchase_type" binding:"required,oneof=cash margin"`
+	OrderType    OrderType    `json:"order_type" binding:"required,oneof=market limit stop stop_limit"`
 
-	EntryDate time.Time `json:"entry_date"`
-	Symbol    string    `json:"symbol"`
-	Currency  string    `json:"currency"` // Added: e.g. "USD", "BTC"
+	EntryDate time.Time `json:"entry_date" binding:"required"`
+	Symbol    string    `json:"symbol" binding:"required"`
+	Currency  string    `json:"currency"` // Defaults to the tradebook's base currency
 
 	// FINANCIAL FIELDS
-	EntryQuantity decimal.Decimal `json:"entry_quantity"`
-	EntryPrice    decimal.Decimal `json:"entry_price"`
-	EntryFees     decimal.Decimal `json:"entry_fees"`
+	EntryQuantity decimal.Decimal  `json:"entry_quantity"`
+	EntryPrice    decimal.Decimal  `json:"entry_price"`
+	EntryFees     *decimal.Decimal `json:"entry_fees"` // Computed from the commission schedule when omitted
+
+	CustomFields map[string]any `json:"custom_fields"`
+}
+
+// UpdateTradeRequest leaves omitted fields unchanged. CustomFields is merged
+// into the trade's values; set a field to null to clear it.
+type UpdateTradeRequest struct {
+	IsOpen       *bool          `json:"is_open"`
+	CustomFields map[string]any `json:"custom_fields"`
+}
+
+// TradeBreakdown aggregates the trades sharing one value of a custom field.
+// Value is null for trades that don't have the field set.
+type TradeBreakdown struct {
+	Value         any             `json:"value"`
+	TradeCount    int64           `json:"trade_count"`
+	OpenCount     int64           `json:"open_count"`
+	EntryNotional decimal.Decimal `json:"entry_notional"`
+	TotalFees     decimal.Decimal `json:"total_fees"`
+	RealizedPnL   decimal.Decimal `json:"realized_pnl"`
 }
 
 type ExitLeg struct {
@@ -128,11 +624,11 @@ type ExitLeg struct {
 type AddExitLegRequest struct {
 	TradeID string `json:"trade_id"`
 
-	ExitDate time.Time `json:"exit_date"`
+	ExitDate time.Time `json:"exit_date" binding:"required"`
 
-	ExitQuantity decimal.Decimal `json:"exit_quantity"`
-	ExitPrice    decimal.Decimal `json:"exit_price"`
-	ExitFees     decimal.Decimal `json:"exit_fees"`
+	ExitQuantity decimal.Decimal  `json:"exit_quantity"`
+	ExitPrice    decimal.Decimal  `json:"exit_price"`
+	ExitFees     *decimal.Decimal `json:"exit_fees"` // Computed from the commission schedule when omitted
 
 	Notes string `json:"notes,omitempty"`
 }
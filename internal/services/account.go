@@ -0,0 +1,526 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"tradebooklm-api/internal/archive"
+	"tradebooklm-api/internal/database"
+	"tradebooklm-api/internal/helpers"
+	"tradebooklm-api/internal/models"
+)
+
+var errUserNotFound = errors.New("user not found")
+
+// ExportAccount streams everything stored about the caller as a zip archive;
+// see archive.AccountManifest for the layout. Each owned tradebook is
+// included as a tradebook archive that ImportTradebook accepts.
+func ExportAccount(c *gin.Context, conn *sql.DB) {
+	ctx := c.Request.Context()
+
+	workosId, ok := helpers.GetWorkosID(c)
+	if !ok {
+		return
+	}
+
+	// The archive is read over many queries; a snapshot keeps them consistent
+	tx, ok := helpers.BeginUserTxWithOptions(c, conn, helpers.SnapshotTxOptions)
+	if !ok {
+		return
+	}
+	defer tx.Rollback()
+
+	q := database.New(tx)
+
+	// 1. Load the Account (before streaming, while errors can still be reported)
+	user, err := q.GetUser(ctx, workosId)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		log.Printf("Error fetching user: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	account, owned, err := loadAccount(ctx, q, user)
+	if err != nil {
+		log.Printf("Error loading account for export: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	// 2. Stream. As with ExportTradebook, a failure from here on can only
+	// truncate the archive.
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", `attachment; filename="account-export.zip"`)
+	c.Status(http.StatusOK)
+
+	w := archive.NewWriter(c.Writer)
+	if err := writeAccountArchive(ctx, q, w, account, owned); err != nil {
+		log.Printf("Error streaming account export: %v", err)
+		return
+	}
+	if err := w.Close(); err != nil {
+		log.Printf("Error finishing account export: %v", err)
+	}
+}
+
+// loadAccount reads account.json and the IDs of the live tradebooks the user
+// owns. Trashed tradebooks are left out, as ExportTradebook leaves out
+// trashed trades.
+func loadAccount(ctx context.Context, q *database.Queries, user database.User) (archive.Account, []uuid.UUID, error) {
+	account := archive.Account{
+		UserID:             user.ID,
+		Email:              user.Email.String,
+		DisplayName:        user.DisplayName.String,
+		AvatarURL:          user.AvatarUrl.String,
+		Preferences:        user.Preferences,
+		CreatedAt:          user.CreatedAt,
+		UpdatedAt:          user.UpdatedAt,
+		Workspaces:         []models.Workspace{},
+		Memberships:        []archive.Membership{},
+		Templates:          []models.TradebookTemplate{},
+		InvitationsSent:    []models.TradebookInvitation{},
+		OwnershipTransfers: []models.OwnershipTransfer{},
+		APIKeys:            []models.APIKey{},
+		Subscriptions:      []models.Subscription{},
+	}
+
+	workspaces, err := q.ListUserWorkspaces(ctx, user.ID)
+	if err != nil {
+		return account, nil, err
+	}
+	for _, w := range workspaces {
+		account.Workspaces = append(account.Workspaces, models.Workspace{
+			ID:        w.ID,
+			Name:      w.Name,
+			Role:      models.Role(w.Role),
+			CreatedAt: w.CreatedAt,
+			JoinedAt:  w.JoinedAt,
+		})
+	}
+
+	memberships, err := q.ListUserMemberships(ctx, user.ID)
+	if err != nil {
+		return account, nil, err
+	}
+	for _, m := range memberships {
+		account.Memberships = append(account.Memberships, archive.Membership{
+			TradebookID: m.TradebookID.String(),
+			Title:       m.Title,
+			Role:        models.Role(m.Role),
+			IsPinned:    m.IsPinned,
+			IsArchived:  m.IsArchived,
+			JoinedAt:    m.JoinedAt,
+		})
+	}
+
+	// Shared templates by other users are theirs
+	templates, err := q.ListTradebookTemplates(ctx, database.ListTradebookTemplatesParams{UserID: user.ID})
+	if err != nil {
+		return account, nil, err
+	}
+	for _, t := range templates {
+		if t.OwnerID == user.ID {
+			account.Templates = append(account.Templates, toTemplateResponse(t))
+		}
+	}
+
+	invitations, err := q.ListInvitationsSentBy(ctx, user.ID)
+	if err != nil {
+		return account, nil, err
+	}
+	for _, inv := range invitations {
+		account.InvitationsSent = append(account.InvitationsSent, toInvitationResponse(inv))
+	}
+
+	transfers, err := q.ListOwnershipTransfersForUser(ctx, user.ID)
+	if err != nil {
+		return account, nil, err
+	}
+	for _, t := range transfers {
+		account.OwnershipTransfers = append(account.OwnershipTransfers, models.OwnershipTransfer{
+			ID:            t.ID.String(),
+			TradebookID:   t.TradebookID.String(),
+			FromUserID:    t.FromUserID,
+			ToUserID:      t.ToUserID,
+			TransferredAt: t.TransferredAt,
+		})
+	}
+
+	keys, err := q.ListAPIKeys(ctx, user.ID)
+	if err != nil {
+		return account, nil, err
+	}
+	for _, k := range keys {
+		account.APIKeys = append(account.APIKeys, toAPIKeyResponse(k))
+	}
+
+	subscriptions, err := q.ListUserSubscriptions(ctx, user.ID)
+	if err != nil {
+		return account, nil, err
+	}
+	for _, sub := range subscriptions {
+		account.Subscriptions = append(account.Subscriptions, *toSubscriptionResponse(sub))
+	}
+
+	ownedRows, err := q.ListOwnedTradebooks(ctx, user.ID)
+	if err != nil {
+		return account, nil, err
+	}
+	owned := make([]uuid.UUID, 0, len(ownedRows))
+	for _, row := range ownedRows {
+		if !row.DeletedAt.Valid {
+			owned = append(owned, row.ID)
+		}
+	}
+
+	return account, owned, nil
+}
+
+func writeAccountArchive(ctx context.Context, q *database.Queries, w *archive.Writer, account archive.Account, owned []uuid.UUID) error {
+	err := w.WriteJSON(archive.ManifestFile, archive.AccountManifest{
+		Format:     archive.AccountFormatName,
+		Version:    archive.AccountVersion,
+		ExportedAt: time.Now().UTC(),
+		UserID:     account.UserID,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := w.WriteJSON(archive.AccountFile, account); err != nil {
+		return err
+	}
+
+	// Activity, including tradebooks the user has since left
+	entry, err := w.Create(archive.ActivityFile)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(entry)
+	var after uuid.NullUUID
+	for {
+		rows, err := q.ListAuditEventsByActor(ctx, database.ListAuditEventsByActorParams{
+			ActorID:  account.UserID,
+			AfterID:  after,
+			LimitVal: exportBatchSize,
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, row := range rows {
+			err := enc.Encode(models.AuditEvent{
+				ID:         row.ID.String(),
+				ActorID:    row.ActorID,
+				Action:     models.AuditAction(row.Action),
+				EntityType: row.EntityType,
+				EntityID:   row.EntityID,
+				Before:     row.Before.RawMessage,
+				After:      row.After.RawMessage,
+				CreatedAt:  row.CreatedAt,
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		if len(rows) < exportBatchSize {
+			break
+		}
+		after = uuid.NullUUID{UUID: rows[len(rows)-1].ID, Valid: true}
+	}
+
+	// Token Usage
+	if entry, err = w.Create(archive.TokenUsageFile); err != nil {
+		return err
+	}
+	enc = json.NewEncoder(entry)
+	after = uuid.NullUUID{}
+	for {
+		rows, err := q.ListTokenUsage(ctx, database.ListTokenUsageParams{
+			UserID:   account.UserID,
+			AfterID:  after,
+			LimitVal: exportBatchSize,
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, row := range rows {
+			err := enc.Encode(archive.TokenUsage{
+				EventID:          row.EventID.String(),
+				Timestamp:        row.Timestamp,
+				ModelName:        row.ModelName,
+				PromptTokens:     row.PromptTokens,
+				CompletionTokens: row.CompletionTokens,
+				TotalTokens:      row.TotalTokens,
+				Cost:             row.Cost,
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		if len(rows) < exportBatchSize {
+			break
+		}
+		after = uuid.NullUUID{UUID: rows[len(rows)-1].EventID, Valid: true}
+	}
+
+	// One nested tradebook archive per owned book
+	for _, tbUUID := range owned {
+		meta, fields, err := loadArchiveTradebook(ctx, q, tbUUID, account.UserID, sql.NullString{})
+		if err != nil {
+			return err
+		}
+
+		if entry, err = w.Create(fmt.Sprintf("%s%s.zip", archive.TradebooksDir, tbUUID)); err != nil {
+			return err
+		}
+		tbw := archive.NewWriter(entry)
+		if err := writeArchive(ctx, q, tbw, tbUUID, meta, fields); err != nil {
+			return err
+		}
+		if err := tbw.Close(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// EraseAccount deletes the caller's account and everything stored about
+// them; see eraseUser.
+func EraseAccount(c *gin.Context, conn *sql.DB) {
+	ctx := c.Request.Context()
+
+	workosId, ok := helpers.GetWorkosID(c)
+	if !ok {
+		return
+	}
+
+	var req models.EraseAccountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Body"})
+		return
+	}
+
+	tx, ok := helpers.BeginUserTx(c, conn)
+	if !ok {
+		return
+	}
+	defer tx.Rollback()
+
+	report, err := eraseUser(ctx, database.New(tx), workosId, req.OwnedTradebooks)
+	if err != nil {
+		if err == errUserNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		log.Printf("Error erasing account: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to erase account"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Transaction commit failed"})
+		return
+	}
+	syncedProfiles.Delete(workosId)
+
+	c.JSON(http.StatusOK, report)
+}
+
+// eraseUser removes a user within q's transaction, which the caller commits:
+//
+//  1. Owned tradebooks are transferred or deleted according to policy.
+//  2. Memberships in other tradebooks are removed, each with an audit entry.
+//  3. Their templates and the invitations they sent are deleted.
+//  4. Retained audit entries and ownership transfers get a pseudonym in place
+//     of the user ID and email, token usage logs are purged, and invitations
+//     addressed to the email are deleted.
+//  5. The user row is deleted, and the erasure is only committed if no row
+//     anywhere still references the user or their email.
+//
+// The transaction is scoped to the user being erased, so the tradebook
+// changes go through the same row-level security as the user's own requests.
+func eraseUser(ctx context.Context, q *database.Queries, userID string, policy models.ErasurePolicy) (models.ErasureReport, error) {
+	report := models.ErasureReport{
+		SubjectHash: helpers.HashToken(userID),
+		Pseudonym:   "deleted-" + uuid.NewString(),
+		Policy:      policy,
+	}
+
+	if err := q.SetCurrentUser(ctx, userID); err != nil {
+		return report, err
+	}
+
+	user, err := q.GetUser(ctx, userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return report, errUserNotFound
+		}
+		return report, err
+	}
+
+	// 1. Owned Tradebooks
+	owned, err := q.ListOwnedTradebooks(ctx, userID)
+	if err != nil {
+		return report, err
+	}
+	for _, tb := range owned {
+		// Workspace books belong to the workspace, so they are handed on
+		// whatever the policy
+		if (policy != models.ErasureTransfer && !tb.WorkspaceID.Valid) || !tb.SuccessorID.Valid || tb.DeletedAt.Valid {
+			continue
+		}
+		if err := transferForErasure(ctx, q, tb.ID, userID, tb.SuccessorID.String); err != nil {
+			return report, fmt.Errorf("transferring tradebook %s: %w", tb.ID, err)
+		}
+		report.TradebooksTransferred++
+	}
+
+	if report.TradebooksDeleted, err = q.DeleteOwnedTradebooks(ctx, userID); err != nil {
+		return report, err
+	}
+
+	// 2. Memberships (including the books just transferred)
+	memberships, err := q.ListUserMemberships(ctx, userID)
+	if err != nil {
+		return report, err
+	}
+	for _, m := range memberships {
+		err := recordAudit(ctx, q, auditEvent{
+			TradebookID: m.TradebookID,
+			ActorID:     userID,
+			Action:      models.AuditMemberRemove,
+			EntityID:    userID,
+			Before: models.TradebookMember{
+				UserID:   userID,
+				Role:     models.Role(m.Role),
+				JoinedAt: m.JoinedAt,
+			},
+		})
+		if err != nil {
+			return report, err
+		}
+	}
+
+	if report.MembershipsRemoved, err = q.DeleteUserMemberships(ctx, userID); err != nil {
+		return report, err
+	}
+
+	// 3. Templates and Invitations
+	if report.TemplatesDeleted, err = q.DeleteTemplatesOwnedBy(ctx, userID); err != nil {
+		return report, err
+	}
+	if report.InvitationsDeleted, err = q.DeleteInvitationsSentBy(ctx, userID); err != nil {
+		return report, err
+	}
+
+	// 4. Anonymize what is retained, purge usage logs and invitations
+	// addressed to the user's email (accepted ones included)
+	erased, err := q.EraseUserRecords(ctx, database.EraseUserRecordsParams{
+		UserID:    userID,
+		Pseudonym: report.Pseudonym,
+		Email:     user.Email,
+	})
+	if err != nil {
+		return report, err
+	}
+	report.AuditEntriesAnonymized = erased.AuditEntries
+	report.OwnershipTransfersAnonymized = erased.OwnershipTransfers
+	report.UsageLogsPurged = erased.UsageLogs
+	report.InvitationsReceivedDeleted = erased.InvitationsReceived
+
+	// 5. Delete the User and verify
+	if _, err := q.DeleteUser(ctx, userID); err != nil {
+		return report, err
+	}
+
+	report.RemainingReferences, err = q.CountUserRecords(ctx, database.CountUserRecordsParams{
+		UserID: userID,
+		Email:  user.Email,
+	})
+	if err != nil {
+		return report, err
+	}
+	if report.RemainingReferences != 0 {
+		return report, fmt.Errorf("%d rows still reference the user after erasure", report.RemainingReferences)
+	}
+
+	stored, err := json.Marshal(report)
+	if err != nil {
+		return report, err
+	}
+
+	row, err := q.RecordAccountErasure(ctx, database.RecordAccountErasureParams{
+		SubjectHash: report.SubjectHash,
+		Pseudonym:   report.Pseudonym,
+		Report:      stored,
+	})
+	if err != nil {
+		return report, err
+	}
+	report.ID = row.ID.String()
+	report.CompletedAt = row.CompletedAt
+
+	return report, nil
+}
+
+// transferForErasure hands a tradebook to its successor the way
+// TransferTradebookOwnership does, leaving the old owner's member row for
+// eraseUser to remove.
+func transferForErasure(ctx context.Context, q *database.Queries, tbUUID uuid.UUID, ownerID, successorID string) error {
+	swapped, err := q.TransferTradebookOwnership(ctx, database.TransferTradebookOwnershipParams{
+		NewOwnerID:     successorID,
+		TradebookID:    tbUUID,
+		CurrentOwnerID: ownerID,
+	})
+	if err != nil {
+		return err
+	}
+	if swapped == 0 {
+		return fmt.Errorf("ownership changed concurrently")
+	}
+
+	_, err = q.UpsertTradebookMember(ctx, database.UpsertTradebookMemberParams{
+		TradebookID: tbUUID,
+		UserID:      successorID,
+		Role:        database.TradebookRoleOwner,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = q.RecordOwnershipTransfer(ctx, database.RecordOwnershipTransferParams{
+		TradebookID: tbUUID,
+		FromUserID:  ownerID,
+		ToUserID:    successorID,
+	})
+	if err != nil {
+		return err
+	}
+
+	return recordAudit(ctx, q, auditEvent{
+		TradebookID: tbUUID,
+		ActorID:     ownerID,
+		Action:      models.AuditTradebookTransfer,
+		EntityID:    tbUUID.String(),
+		Before:      gin.H{"owner_id": ownerID},
+		After:       gin.H{"owner_id": successorID, "reason": "account_erasure"},
+	})
+}
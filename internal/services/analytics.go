@@ -0,0 +1,220 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+
+	"tradebooklm-api/internal/authz"
+	"tradebooklm-api/internal/database"
+	"tradebooklm-api/internal/helpers"
+	"tradebooklm-api/internal/models"
+)
+
+// GetTradeBreakdown groups live trades by the value of the custom field in
+// group_by. It takes the same field[<id>]=<value> filters as GetTrades, and
+// fiscal_year limits it to trades entered in that year of the tradebook's
+// fiscal calendar. Realized P&L follows the tradebook's accounting method.
+func GetTradeBreakdown(c *gin.Context, conn *sql.DB) {
+	ctx := c.Request.Context()
+
+	tbUUID := authz.GetTradebookID(c)
+
+	tx, ok := helpers.BeginUserTx(c, conn)
+	if !ok {
+		return
+	}
+	defer tx.Rollback()
+
+	q := database.New(tx)
+
+	// 1. Resolve the grouping field and filters
+	fields, err := loadCustomFields(ctx, q, tbUUID)
+	if err != nil {
+		log.Printf("Error fetching custom fields: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	groupBy := c.Query("group_by")
+	if _, ok := fields[groupBy]; !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "group_by must be a custom field ID"})
+		return
+	}
+
+	fieldFilter, ok := fields.filter(c)
+	if !ok {
+		return
+	}
+
+	settings, err := q.GetTradebookSettings(ctx, tbUUID)
+	if err != nil {
+		log.Printf("Error fetching tradebook settings: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	var entryFrom, entryTo sql.NullTime
+	if fiscalYear := c.Query("fiscal_year"); fiscalYear != "" {
+		year, err := strconv.Atoi(fiscalYear)
+		if err != nil || year < 1900 || year > 9999 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid fiscal_year"})
+			return
+		}
+
+		start, end := fiscalYearRange(settings, year)
+		entryFrom = sql.NullTime{Time: start, Valid: true}
+		entryTo = sql.NullTime{Time: end, Valid: true}
+	}
+
+	// 2. Match exits to lots across the whole book, whatever the filters
+	realized, err := realizedPnL(ctx, q, tbUUID, settings.AccountingMethod)
+	if err != nil {
+		log.Printf("Error matching lots: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	realizedJSON, err := json.Marshal(realized)
+	if err != nil {
+		log.Printf("Error encoding realized P&L: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch data"})
+		return
+	}
+
+	// 3. Aggregate
+	rows, err := q.GetTradeBreakdown(ctx, database.GetTradeBreakdownParams{
+		FieldID:      groupBy,
+		RealizedPnl:  realizedJSON,
+		TradebookID:  tbUUID,
+		CustomFields: fieldFilter,
+		EntryFrom:    entryFrom,
+		EntryTo:      entryTo,
+	})
+	if err != nil {
+		log.Printf("Error fetching trade breakdown: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch data"})
+		return
+	}
+
+	responseList := make([]models.TradeBreakdown, 0, len(rows))
+	for _, row := range rows {
+		var value any
+		if row.Value.Valid {
+			_ = json.Unmarshal(row.Value.RawMessage, &value)
+		}
+
+		responseList = append(responseList, models.TradeBreakdown{
+			Value:         value,
+			TradeCount:    row.TradeCount,
+			OpenCount:     row.OpenCount,
+			EntryNotional: row.EntryNotional,
+			TotalFees:     row.TotalFees,
+			RealizedPnL:   row.RealizedPnl,
+		})
+	}
+
+	c.JSON(http.StatusOK, responseList)
+}
+
+// entryLot is the quantity of a trade's entry not yet matched to an exit.
+type entryLot struct {
+	entryDate time.Time
+	price     decimal.Decimal
+	remaining decimal.Decimal
+}
+
+// realizedPnL loads the tradebook's lots and exits and matches them with
+// matchLots.
+func realizedPnL(ctx context.Context, q *database.Queries, tradebookID uuid.UUID, method database.AccountingMethod) (map[uuid.UUID]decimal.Decimal, error) {
+	lotRows, err := q.ListEntryLots(ctx, tradebookID)
+	if err != nil {
+		return nil, err
+	}
+
+	exits, err := q.ListExitsForLotMatching(ctx, tradebookID)
+	if err != nil {
+		return nil, err
+	}
+
+	return matchLots(lotRows, exits, method), nil
+}
+
+// matchLots matches every exit, in exit order, to the open lots of its
+// symbol entered by then: the oldest first for FIFO, the newest first for
+// LIFO, or all of them pro rata at their average cost. Each exit's P&L, net
+// of its fees, is credited to the trade it was recorded on. Exits beyond the
+// recorded lots are matched against their own trade's entry price. Both
+// slices must be in date order, as the queries return them.
+func matchLots(lotRows []database.ListEntryLotsRow, exits []database.ListExitsForLotMatchingRow, method database.AccountingMethod) map[uuid.UUID]decimal.Decimal {
+	// Lots per symbol, oldest first
+	lots := make(map[string][]*entryLot)
+	for _, row := range lotRows {
+		lots[row.Symbol] = append(lots[row.Symbol], &entryLot{
+			entryDate: row.EntryDate,
+			price:     row.EntryPrice,
+			remaining: row.EntryQuantity,
+		})
+	}
+
+	realized := make(map[uuid.UUID]decimal.Decimal)
+	for _, exit := range exits {
+		var open []*entryLot
+		for _, lot := range lots[exit.Symbol] {
+			if !lot.entryDate.After(exit.ExitDate) && lot.remaining.IsPositive() {
+				open = append(open, lot)
+			}
+		}
+
+		unmatched := exit.ExitQuantity
+		basis := decimal.Zero
+
+		switch method {
+		case database.AccountingMethodAverageCost:
+			total := decimal.Zero
+			for _, lot := range open {
+				total = total.Add(lot.remaining)
+			}
+			if total.IsPositive() {
+				take := decimal.Min(unmatched, total)
+				for _, lot := range open {
+					share := lot.remaining.Mul(take).Div(total)
+					basis = basis.Add(share.Mul(lot.price))
+					lot.remaining = lot.remaining.Sub(share)
+				}
+				unmatched = unmatched.Sub(take)
+			}
+		default:
+			if method == database.AccountingMethodLifo {
+				slices.Reverse(open)
+			}
+			for _, lot := range open {
+				if !unmatched.IsPositive() {
+					break
+				}
+				take := decimal.Min(unmatched, lot.remaining)
+				basis = basis.Add(take.Mul(lot.price))
+				lot.remaining = lot.remaining.Sub(take)
+				unmatched = unmatched.Sub(take)
+			}
+		}
+
+		if unmatched.IsPositive() {
+			basis = basis.Add(unmatched.Mul(exit.EntryPrice))
+		}
+
+		pnl := exit.ExitQuantity.Mul(exit.ExitPrice).Sub(basis).Sub(exit.ExitFees.Decimal)
+		realized[exit.TradeID] = realized[exit.TradeID].Add(pnl)
+	}
+
+	return realized
+}
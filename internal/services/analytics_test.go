@@ -0,0 +1,117 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+
+	"tradebooklm-api/internal/database"
+)
+
+func TestMatchLots(t *testing.T) {
+	var (
+		day1 = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+		day2 = day1.AddDate(0, 0, 1)
+		day3 = day1.AddDate(0, 0, 2)
+
+		tradeA = uuid.New() // AAPL, 10 @ 100 on day 1
+		tradeB = uuid.New() // AAPL, 10 @ 110 on day 2
+		tradeC = uuid.New() // MSFT, 10 @ 300 on day 1
+	)
+
+	d := decimal.RequireFromString
+	lots := []database.ListEntryLotsRow{
+		{ID: tradeA, Symbol: "AAPL", EntryDate: day1, EntryQuantity: d("10"), EntryPrice: d("100")},
+		{ID: tradeC, Symbol: "MSFT", EntryDate: day1, EntryQuantity: d("10"), EntryPrice: d("300")},
+		{ID: tradeB, Symbol: "AAPL", EntryDate: day2, EntryQuantity: d("10"), EntryPrice: d("110")},
+	}
+	exit := func(trade uuid.UUID, symbol, entryPrice string, date time.Time, quantity, price, fees string) database.ListExitsForLotMatchingRow {
+		return database.ListExitsForLotMatchingRow{
+			TradeID:      trade,
+			Symbol:       symbol,
+			EntryPrice:   d(entryPrice),
+			ExitDate:     date,
+			ExitQuantity: d(quantity),
+			ExitPrice:    d(price),
+			ExitFees:     decimal.NewNullDecimal(d(fees)),
+		}
+	}
+
+	tests := []struct {
+		name   string
+		method database.AccountingMethod
+		exits  []database.ListExitsForLotMatchingRow
+		want   map[uuid.UUID]string
+	}{
+		{
+			// 1800 - (10 * 100 + 5 * 110) - 5
+			name:   "fifo takes the oldest lot first",
+			method: database.AccountingMethodFifo,
+			exits:  []database.ListExitsForLotMatchingRow{exit(tradeB, "AAPL", "110", day3, "15", "120", "5")},
+			want:   map[uuid.UUID]string{tradeB: "245"},
+		},
+		{
+			// 1800 - (10 * 110 + 5 * 100) - 5
+			name:   "lifo takes the newest lot first",
+			method: database.AccountingMethodLifo,
+			exits:  []database.ListExitsForLotMatchingRow{exit(tradeB, "AAPL", "110", day3, "15", "120", "5")},
+			want:   map[uuid.UUID]string{tradeB: "195"},
+		},
+		{
+			// 1800 - (7.5 * 100 + 7.5 * 110) - 5
+			name:   "average cost takes from every lot pro rata",
+			method: database.AccountingMethodAverageCost,
+			exits:  []database.ListExitsForLotMatchingRow{exit(tradeB, "AAPL", "110", day3, "15", "120", "5")},
+			want:   map[uuid.UUID]string{tradeB: "220"},
+		},
+		{
+			// Only trade A's lot is open on day 1, even for LIFO
+			name:   "lots entered after the exit are skipped",
+			method: database.AccountingMethodLifo,
+			exits:  []database.ListExitsForLotMatchingRow{exit(tradeA, "AAPL", "100", day1, "5", "120", "0")},
+			want:   map[uuid.UUID]string{tradeA: "100"},
+		},
+		{
+			// The second exit finds trade A's lot used up
+			name:   "exits use up lots in order",
+			method: database.AccountingMethodFifo,
+			exits: []database.ListExitsForLotMatchingRow{
+				exit(tradeA, "AAPL", "100", day2, "10", "120", "0"),
+				exit(tradeA, "AAPL", "100", day3, "10", "120", "0"),
+			},
+			want: map[uuid.UUID]string{tradeA: "300"},
+		},
+		{
+			// 1800 - (10 * 100 + 5 * 100), the excess at trade A's own entry price
+			name:   "excess beyond the lots is matched at the trade's entry price",
+			method: database.AccountingMethodFifo,
+			exits:  []database.ListExitsForLotMatchingRow{exit(tradeA, "AAPL", "100", day1, "15", "120", "0")},
+			want:   map[uuid.UUID]string{tradeA: "300"},
+		},
+		{
+			name:   "symbols are matched separately",
+			method: database.AccountingMethodFifo,
+			exits: []database.ListExitsForLotMatchingRow{
+				exit(tradeC, "MSFT", "300", day3, "10", "310", "0"),
+				exit(tradeA, "AAPL", "100", day3, "10", "90", "0"),
+			},
+			want: map[uuid.UUID]string{tradeC: "100", tradeA: "-100"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := matchLots(lots, tt.exits, tt.method)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got P&L for %d trades, want %d: %v", len(got), len(tt.want), got)
+			}
+			for trade, want := range tt.want {
+				if !got[trade].Equal(d(want)) {
+					t.Errorf("trade %s: got %s, want %s", trade, got[trade], want)
+				}
+			}
+		})
+	}
+}
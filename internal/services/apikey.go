@@ -0,0 +1,239 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"tradebooklm-api/internal/database"
+	"tradebooklm-api/internal/helpers"
+	"tradebooklm-api/internal/models"
+)
+
+// apiKeyPrefixLength is how much of a key is kept to tell keys apart.
+const apiKeyPrefixLength = 12
+
+// CreateAPIKey issues a key acting as the caller. Keys created in a
+// workspace session reach that workspace's tradebooks too. The key is in
+// this response only.
+func CreateAPIKey(c *gin.Context, conn *sql.DB) {
+	ctx := c.Request.Context()
+
+	workosId, ok := helpers.GetWorkosID(c)
+	if !ok {
+		return
+	}
+
+	var req models.CreateAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Body"})
+		return
+	}
+
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Name is required"})
+		return
+	}
+
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Expiry must be in the future"})
+		return
+	}
+
+	slices.Sort(req.Scopes)
+	req.Scopes = slices.Compact(req.Scopes)
+
+	// A key can't hold a scope its creator's session lacks
+	principal, _ := helpers.GetPrincipal(c)
+	for _, scope := range req.Scopes {
+		if !principal.HasScope(scope) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Your session does not hold the scope: " + string(scope)})
+			return
+		}
+	}
+	writes := slices.Contains(req.Scopes, models.ScopeWrite)
+
+	tx, ok := helpers.BeginUserTx(c, conn)
+	if !ok {
+		return
+	}
+	defer tx.Rollback()
+
+	q := database.New(tx)
+
+	// 1. Check the Tradebooks; a key can only be limited to books the caller
+	// can reach, and can only write to those the caller can write to
+	tradebookIDs := make([]string, 0, len(req.TradebookIDs))
+	for _, id := range req.TradebookIDs {
+		tbUUID, err := helpers.ParseUUID(id)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid tradebook ID"})
+			return
+		}
+
+		access, err := q.GetTradebookRole(ctx, database.GetTradebookRoleParams{
+			UserID:      workosId,
+			OrgID:       helpers.GetOrgID(c),
+			TradebookID: tbUUID,
+		})
+		if err != nil {
+			if err == sql.ErrNoRows {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Tradebook not found or access denied: " + id})
+				return
+			}
+			log.Printf("Error resolving tradebook role: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		if writes && access.Role == database.TradebookRoleReader {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Your role on this tradebook is read-only: " + id})
+			return
+		}
+
+		tradebookIDs = append(tradebookIDs, tbUUID.String())
+	}
+
+	scopes, err := json.Marshal(req.Scopes)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid scopes"})
+		return
+	}
+	tradebooks, err := json.Marshal(tradebookIDs)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid tradebook IDs"})
+		return
+	}
+
+	// 2. Generate and Store
+	token, _, err := helpers.NewToken()
+	if err != nil {
+		log.Printf("Error generating API key: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create API key"})
+		return
+	}
+	key := helpers.APIKeyPrefix + token
+
+	var expiresAt sql.NullTime
+	if req.ExpiresAt != nil {
+		expiresAt = sql.NullTime{Time: *req.ExpiresAt, Valid: true}
+	}
+
+	row, err := q.CreateAPIKey(ctx, database.CreateAPIKeyParams{
+		UserID:       workosId,
+		OrgID:        helpers.GetOrgID(c),
+		Name:         name,
+		Prefix:       key[:apiKeyPrefixLength],
+		KeyHash:      helpers.HashToken(key),
+		Scopes:       scopes,
+		TradebookIds: tradebooks,
+		ExpiresAt:    expiresAt,
+	})
+	if err != nil {
+		log.Printf("Error creating API key: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create API key"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Transaction commit failed"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, models.CreatedAPIKey{
+		APIKey: toAPIKeyResponse(row),
+		Key:    key,
+	})
+}
+
+// ListAPIKeys returns the caller's keys, revoked and expired ones included.
+func ListAPIKeys(c *gin.Context, conn *sql.DB) {
+	ctx := c.Request.Context()
+
+	workosId, ok := helpers.GetWorkosID(c)
+	if !ok {
+		return
+	}
+
+	q := database.New(conn)
+
+	rows, err := q.ListAPIKeys(ctx, workosId)
+	if err != nil {
+		log.Printf("Error fetching API keys: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch data"})
+		return
+	}
+
+	responseList := make([]models.APIKey, 0, len(rows))
+	for _, row := range rows {
+		responseList = append(responseList, toAPIKeyResponse(row))
+	}
+
+	c.JSON(http.StatusOK, responseList)
+}
+
+func RevokeAPIKey(c *gin.Context, conn *sql.DB) {
+	ctx := c.Request.Context()
+
+	workosId, ok := helpers.GetWorkosID(c)
+	if !ok {
+		return
+	}
+
+	keyUUID, err := helpers.ParseUUID(c.Param("keyId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid API key ID"})
+		return
+	}
+
+	q := database.New(conn)
+
+	row, err := q.RevokeAPIKey(ctx, database.RevokeAPIKeyParams{
+		ID:     keyUUID,
+		UserID: workosId,
+	})
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "API key not found or already revoked"})
+			return
+		}
+		log.Printf("Error revoking API key: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke API key"})
+		return
+	}
+
+	c.JSON(http.StatusOK, toAPIKeyResponse(row))
+}
+
+func toAPIKeyResponse(row database.ApiKey) models.APIKey {
+	key := models.APIKey{
+		ID:           row.ID.String(),
+		Name:         row.Name,
+		Prefix:       row.Prefix,
+		Scopes:       []models.Scope{},
+		TradebookIDs: []string{},
+		WorkspaceID:  row.OrgID.String,
+		ExpiresAt:    nullTimePtr(row.ExpiresAt),
+		LastUsedAt:   nullTimePtr(row.LastUsedAt),
+		RevokedAt:    nullTimePtr(row.RevokedAt),
+		CreatedAt:    row.CreatedAt,
+	}
+
+	_ = json.Unmarshal(row.Scopes, &key.Scopes)
+	_ = json.Unmarshal(row.TradebookIds, &key.TradebookIDs)
+
+	return key
+}
+
+func nullTimePtr(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
@@ -0,0 +1,139 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/sqlc-dev/pqtype"
+
+	"tradebooklm-api/internal/authz"
+	"tradebooklm-api/internal/database"
+	"tradebooklm-api/internal/helpers"
+	"tradebooklm-api/internal/models"
+)
+
+// auditEvent describes a single mutation. Before and After are stored as JSON;
+// leave Before nil for creations and After nil for deletions.
+type auditEvent struct {
+	TradebookID uuid.UUID
+	ActorID     string
+	Action      models.AuditAction
+	EntityID    string
+	Before      any
+	After       any
+}
+
+// recordAudit appends ev to the audit log. q must be bound to the transaction
+// performing the mutation so the entry commits or rolls back with it.
+func recordAudit(ctx context.Context, q *database.Queries, ev auditEvent) error {
+	before, err := toAuditState(ev.Before)
+	if err != nil {
+		return err
+	}
+
+	after, err := toAuditState(ev.After)
+	if err != nil {
+		return err
+	}
+
+	entityType, _, _ := strings.Cut(string(ev.Action), ".")
+
+	return q.RecordAuditEvent(ctx, database.RecordAuditEventParams{
+		TradebookID: ev.TradebookID,
+		ActorID:     ev.ActorID,
+		Action:      string(ev.Action),
+		EntityType:  entityType,
+		EntityID:    ev.EntityID,
+		Before:      before,
+		After:       after,
+	})
+}
+
+func toAuditState(v any) (pqtype.NullRawMessage, error) {
+	if v == nil {
+		return pqtype.NullRawMessage{}, nil
+	}
+
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return pqtype.NullRawMessage{}, err
+	}
+
+	return pqtype.NullRawMessage{RawMessage: raw, Valid: true}, nil
+}
+
+// GetTradebookActivity lists audit entries newest first. Optional filters:
+// actor_id, action and entity_type.
+func GetTradebookActivity(c *gin.Context, conn *sql.DB) {
+	ctx := c.Request.Context()
+
+	tbUUID := authz.GetTradebookID(c)
+	cursorScope := "activity/" + tbUUID.String()
+
+	// 1. Decode Cursor
+	limit, cursor, ok := helpers.GetCursorParams(c, cursorScope)
+	if !ok {
+		return
+	}
+
+	tx, ok := helpers.BeginUserTx(c, conn)
+	if !ok {
+		return
+	}
+	defer tx.Rollback()
+
+	q := database.New(tx)
+
+	// 2. Fetch one extra row to know whether another page exists
+	rows, err := q.ListAuditEvents(ctx, database.ListAuditEventsParams{
+		TradebookID:     tbUUID,
+		ActorID:         helpers.GetOptionalQuery(c, "actor_id"),
+		Action:          helpers.GetOptionalQuery(c, "action"),
+		EntityType:      helpers.GetOptionalQuery(c, "entity_type"),
+		CursorCreatedAt: cursor.NullTime(),
+		CursorID:        cursor.NullID(),
+		LimitVal:        limit + 1,
+	})
+	if err != nil {
+		log.Printf("Error fetching activity: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch data"})
+		return
+	}
+
+	hasMore := len(rows) > int(limit)
+	if hasMore {
+		rows = rows[:limit]
+	}
+
+	// 3. Map to Response
+	page := models.Page[models.AuditEvent]{
+		Data:    make([]models.AuditEvent, 0, len(rows)),
+		HasMore: hasMore,
+	}
+
+	for _, row := range rows {
+		page.Data = append(page.Data, models.AuditEvent{
+			ID:         row.ID.String(),
+			ActorID:    row.ActorID,
+			Action:     models.AuditAction(row.Action),
+			EntityType: row.EntityType,
+			EntityID:   row.EntityID,
+			Before:     row.Before.RawMessage,
+			After:      row.After.RawMessage,
+			CreatedAt:  row.CreatedAt,
+		})
+	}
+
+	if hasMore {
+		last := rows[len(rows)-1]
+		page.NextCursor = helpers.EncodeCursor(cursorScope, helpers.Cursor{Time: last.CreatedAt, ID: last.ID})
+	}
+
+	c.JSON(http.StatusOK, page)
+}
@@ -0,0 +1,148 @@
+package services
+
+import (
+	"database/sql"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"tradebooklm-api/internal/authz"
+	"tradebooklm-api/internal/database"
+	"tradebooklm-api/internal/helpers"
+	"tradebooklm-api/internal/models"
+)
+
+// CloneTradebook copies a tradebook and its settings into a new one owned by
+// the caller, optionally with all of its live trades and exit legs. Members
+// and invitations are not copied.
+func CloneTradebook(c *gin.Context, conn *sql.DB) {
+	ctx := c.Request.Context()
+
+	workosId, ok := helpers.GetWorkosID(c)
+	if !ok {
+		return
+	}
+
+	sourceID := authz.GetTradebookID(c)
+
+	// The body is optional; an empty one copies the tradebook without trades
+	var req models.CloneTradebookRequest
+	if err := c.ShouldBindJSON(&req); err != nil && err != io.EOF {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Body"})
+		return
+	}
+
+	// 1. Start Transaction
+	tx, ok := helpers.BeginUserTx(c, conn)
+	if !ok {
+		return
+	}
+	defer tx.Rollback()
+
+	qTx := database.New(tx)
+
+	// 2. Load Source
+	source, err := qTx.GetTradebook(ctx, database.GetTradebookParams{
+		TradebookID: sourceID,
+		UserID:      workosId,
+		OrgID:       helpers.GetOrgID(c),
+	})
+	if err != nil {
+		log.Printf("Error fetching tradebook: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	title := req.Title
+	if title == "" {
+		title = source.Title + " (Copy)"
+	}
+
+	// 3. Create Tradebook with the source's settings, custom fields and commission schedules
+	tb, err := qTx.CreateTradebookCopy(ctx, database.CreateTradebookCopyParams{
+		OwnerID:           workosId,
+		Title:             title,
+		SourceTradebookID: sourceID,
+	})
+	if err == nil {
+		_, err = qTx.CloneCustomFields(ctx, database.CloneCustomFieldsParams{
+			TargetTradebookID: tb.ID,
+			SourceTradebookID: sourceID,
+		})
+	}
+	if err == nil {
+		_, err = qTx.CloneCommissionSchedules(ctx, database.CloneCommissionSchedulesParams{
+			TargetTradebookID: tb.ID,
+			SourceTradebookID: sourceID,
+		})
+	}
+	if err != nil {
+		log.Printf("Error creating tradebook: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to clone tradebook"})
+		return
+	}
+
+	// 4. Add Member (Owner)
+	_, err = qTx.UpsertTradebookMember(ctx, database.UpsertTradebookMemberParams{
+		TradebookID: tb.ID,
+		UserID:      workosId,
+		Role:        database.TradebookRoleOwner,
+	})
+	if err != nil {
+		log.Printf("Error adding member: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to assign ownership"})
+		return
+	}
+
+	// 5. Copy Trades, then their Exit Legs
+	var tradesCopied int64
+	if req.IncludeTrades {
+		tradesCopied, err = qTx.CloneTrades(ctx, database.CloneTradesParams{
+			TargetTradebookID: tb.ID,
+			SourceTradebookID: sourceID,
+		})
+		if err == nil {
+			_, err = qTx.CloneExitLegs(ctx, tb.ID)
+		}
+		if err != nil {
+			log.Printf("Error copying trades: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to clone tradebook"})
+			return
+		}
+	}
+
+	response := models.Tradebook{
+		ID:        tb.ID.String(),
+		Title:     tb.Title,
+		CreatedAt: tb.CreatedAt,
+		UpdatedAt: tb.UpdatedAt,
+		Role:      models.Owner,
+	}
+
+	// 6. Audit (on the new tradebook, which is the one that changed)
+	err = recordAudit(ctx, qTx, auditEvent{
+		TradebookID: tb.ID,
+		ActorID:     workosId,
+		Action:      models.AuditTradebookClone,
+		EntityID:    tb.ID.String(),
+		After: gin.H{
+			"tradebook":           response,
+			"source_tradebook_id": sourceID.String(),
+			"trades_copied":       tradesCopied,
+		},
+	})
+	if err != nil {
+		log.Printf("Error recording audit event: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to clone tradebook"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Transaction commit failed"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, response)
+}
@@ -0,0 +1,436 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+
+	"tradebooklm-api/internal/authz"
+	"tradebooklm-api/internal/database"
+	"tradebooklm-api/internal/helpers"
+	"tradebooklm-api/internal/models"
+)
+
+func GetCommissionSchedules(c *gin.Context, conn *sql.DB) {
+	ctx := c.Request.Context()
+
+	tx, ok := helpers.BeginUserTx(c, conn)
+	if !ok {
+		return
+	}
+	defer tx.Rollback()
+
+	rows, err := database.New(tx).ListCommissionSchedules(ctx, authz.GetTradebookID(c))
+	if err != nil {
+		log.Printf("Error fetching commission schedules: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch data"})
+		return
+	}
+
+	responseList := make([]models.CommissionSchedule, 0, len(rows))
+	for _, row := range rows {
+		responseList = append(responseList, toCommissionResponse(row))
+	}
+
+	c.JSON(http.StatusOK, responseList)
+}
+
+// PutCommissionSchedule creates or replaces the schedule for the :assetClass
+// route param. Existing fees are unchanged until RecomputeFees runs.
+func PutCommissionSchedule(c *gin.Context, conn *sql.DB) {
+	ctx := c.Request.Context()
+
+	workosId, ok := helpers.GetWorkosID(c)
+	if !ok {
+		return
+	}
+
+	tbUUID := authz.GetTradebookID(c)
+
+	assetClass, ok := getAssetClassParam(c)
+	if !ok {
+		return
+	}
+
+	var req models.CommissionScheduleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Body"})
+		return
+	}
+
+	params, err := toCommissionScheduleParams(tbUUID, assetClass, req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tx, ok := helpers.BeginUserTx(c, conn)
+	if !ok {
+		return
+	}
+	defer tx.Rollback()
+
+	q := database.New(tx)
+
+	// 1. Snapshot for the audit log (none if this creates the schedule)
+	var before any
+	existing, err := q.GetCommissionSchedule(ctx, database.GetCommissionScheduleParams{
+		TradebookID: tbUUID,
+		AssetClass:  assetClass,
+	})
+	switch {
+	case err == nil:
+		before = toCommissionResponse(existing)
+	case err != sql.ErrNoRows:
+		log.Printf("Error fetching commission schedule: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	// 2. Upsert
+	schedule, err := q.UpsertCommissionSchedule(ctx, params)
+	if err != nil {
+		log.Printf("Error saving commission schedule: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save commission schedule"})
+		return
+	}
+
+	response := toCommissionResponse(schedule)
+
+	// 3. Audit
+	err = recordAudit(ctx, q, auditEvent{
+		TradebookID: tbUUID,
+		ActorID:     workosId,
+		Action:      models.AuditCommissionUpdate,
+		EntityID:    string(assetClass),
+		Before:      before,
+		After:       response,
+	})
+	if err != nil {
+		log.Printf("Error recording audit event: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save commission schedule"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Transaction commit failed"})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+func DeleteCommissionSchedule(c *gin.Context, conn *sql.DB) {
+	ctx := c.Request.Context()
+
+	workosId, ok := helpers.GetWorkosID(c)
+	if !ok {
+		return
+	}
+
+	tbUUID := authz.GetTradebookID(c)
+
+	assetClass, ok := getAssetClassParam(c)
+	if !ok {
+		return
+	}
+
+	tx, ok := helpers.BeginUserTx(c, conn)
+	if !ok {
+		return
+	}
+	defer tx.Rollback()
+
+	q := database.New(tx)
+
+	// 1. Delete
+	schedule, err := q.DeleteCommissionSchedule(ctx, database.DeleteCommissionScheduleParams{
+		TradebookID: tbUUID,
+		AssetClass:  assetClass,
+	})
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Commission schedule not found"})
+			return
+		}
+		log.Printf("Error deleting commission schedule: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete commission schedule"})
+		return
+	}
+
+	// 2. Audit
+	err = recordAudit(ctx, q, auditEvent{
+		TradebookID: tbUUID,
+		ActorID:     workosId,
+		Action:      models.AuditCommissionDelete,
+		EntityID:    string(assetClass),
+		Before:      toCommissionResponse(schedule),
+	})
+	if err != nil {
+		log.Printf("Error recording audit event: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete commission schedule"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Transaction commit failed"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// RecomputeFees re-applies the current commission schedules (or the default
+// fees, for asset classes without one) to live trades and their exit legs.
+// By default only fees that were filled in automatically are touched.
+func RecomputeFees(c *gin.Context, conn *sql.DB) {
+	ctx := c.Request.Context()
+
+	workosId, ok := helpers.GetWorkosID(c)
+	if !ok {
+		return
+	}
+
+	tbUUID := authz.GetTradebookID(c)
+
+	// The body is optional; an empty one recomputes every asset class
+	var req models.RecomputeFeesRequest
+	if err := c.ShouldBindJSON(&req); err != nil && err != io.EOF {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Body"})
+		return
+	}
+
+	assetClass := database.NullAssetClass{
+		AssetClass: database.AssetClass(req.AssetClass),
+		Valid:      req.AssetClass != "",
+	}
+
+	tx, ok := helpers.BeginUserTx(c, conn)
+	if !ok {
+		return
+	}
+	defer tx.Rollback()
+
+	q := database.New(tx)
+
+	// 1. Load Schedules
+	calc, err := loadFeeCalculator(ctx, q, tbUUID)
+	if err != nil {
+		log.Printf("Error fetching commission schedules: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	var result models.RecomputeFeesResult
+
+	// 2. Entry Fees
+	trades, err := q.ListTradesForFeeRecompute(ctx, database.ListTradesForFeeRecomputeParams{
+		TradebookID:   tbUUID,
+		IncludeManual: req.IncludeManual,
+		AssetClass:    assetClass,
+	})
+	if err != nil {
+		log.Printf("Error fetching trades: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to recompute fees"})
+		return
+	}
+
+	for _, trade := range trades {
+		fees := calc.fees(trade.AssetClass, trade.EntryQuantity, trade.EntryPrice, false)
+		if trade.EntryFeesAuto && trade.EntryFees.Valid && trade.EntryFees.Decimal.Equal(fees) {
+			continue
+		}
+
+		err := q.SetTradeEntryFees(ctx, database.SetTradeEntryFeesParams{
+			EntryFees:   decimal.NewNullDecimal(fees),
+			TradeID:     trade.ID,
+			TradebookID: tbUUID,
+		})
+		if err != nil {
+			log.Printf("Error updating trade fees: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to recompute fees"})
+			return
+		}
+		result.TradesUpdated++
+	}
+
+	// 3. Exit Fees
+	legs, err := q.ListExitLegsForFeeRecompute(ctx, database.ListExitLegsForFeeRecomputeParams{
+		TradebookID:   tbUUID,
+		IncludeManual: req.IncludeManual,
+		AssetClass:    assetClass,
+	})
+	if err != nil {
+		log.Printf("Error fetching exit legs: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to recompute fees"})
+		return
+	}
+
+	for _, leg := range legs {
+		fees := calc.fees(leg.AssetClass, leg.ExitQuantity, leg.ExitPrice, true)
+		if leg.ExitFeesAuto && leg.ExitFees.Valid && leg.ExitFees.Decimal.Equal(fees) {
+			continue
+		}
+
+		err := q.SetExitLegFees(ctx, database.SetExitLegFeesParams{
+			ExitFees:  decimal.NewNullDecimal(fees),
+			ExitLegID: leg.ID,
+		})
+		if err != nil {
+			log.Printf("Error updating exit leg fees: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to recompute fees"})
+			return
+		}
+		result.ExitLegsUpdated++
+	}
+
+	// 4. Audit
+	err = recordAudit(ctx, q, auditEvent{
+		TradebookID: tbUUID,
+		ActorID:     workosId,
+		Action:      models.AuditCommissionRecompute,
+		EntityID:    tbUUID.String(),
+		After:       gin.H{"request": req, "result": result},
+	})
+	if err != nil {
+		log.Printf("Error recording audit event: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to recompute fees"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Transaction commit failed"})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// feeCalculator fills in fees that weren't entered: from the asset class's
+// commission schedule if there is one, otherwise the tradebook's default fees.
+type feeCalculator struct {
+	schedules   map[database.AssetClass]database.CommissionSchedule
+	defaultFees decimal.Decimal
+}
+
+func loadFeeCalculator(ctx context.Context, q *database.Queries, tradebookID uuid.UUID) (feeCalculator, error) {
+	calc := feeCalculator{schedules: map[database.AssetClass]database.CommissionSchedule{}}
+
+	settings, err := q.GetTradebookSettings(ctx, tradebookID)
+	if err != nil {
+		return calc, err
+	}
+	calc.defaultFees = settings.DefaultFees
+
+	rows, err := q.ListCommissionSchedules(ctx, tradebookID)
+	if err != nil {
+		return calc, err
+	}
+	for _, row := range rows {
+		calc.schedules[row.AssetClass] = row
+	}
+
+	return calc, nil
+}
+
+// fees returns the fees for one fill. The commission is clamped to the
+// schedule's min and max; regulatory fees are added on exits only.
+func (calc feeCalculator) fees(assetClass database.AssetClass, quantity, price decimal.Decimal, isExit bool) decimal.Decimal {
+	schedule, ok := calc.schedules[assetClass]
+	if !ok {
+		return calc.defaultFees
+	}
+
+	notional := quantity.Mul(price)
+
+	var fees decimal.Decimal
+	switch schedule.RateType {
+	case database.CommissionRateTypePercentOfNotional:
+		fees = notional.Mul(schedule.Rate)
+	default: // per_share, per_contract
+		fees = quantity.Mul(schedule.Rate)
+	}
+
+	if fees.LessThan(schedule.MinFee) {
+		fees = schedule.MinFee
+	}
+	if schedule.MaxFee.Valid && fees.GreaterThan(schedule.MaxFee.Decimal) {
+		fees = schedule.MaxFee.Decimal
+	}
+
+	if isExit {
+		fees = fees.Add(notional.Mul(schedule.RegulatoryRate)).Add(quantity.Mul(schedule.RegulatoryPerUnit))
+	}
+
+	return fees.Round(8)
+}
+
+var assetClasses = []database.AssetClass{
+	database.AssetClassEquities, database.AssetClassFixedIncome, database.AssetClassCommodities,
+	database.AssetClassEtfs, database.AssetClassForex, database.AssetClassDerivatives, database.AssetClassCrypto,
+}
+
+// toCommissionScheduleParams checks the amounts that the binding tags can't.
+func toCommissionScheduleParams(tradebookID uuid.UUID, assetClass database.AssetClass, req models.CommissionScheduleRequest) (database.UpsertCommissionScheduleParams, error) {
+	params := database.UpsertCommissionScheduleParams{
+		TradebookID:       tradebookID,
+		AssetClass:        assetClass,
+		RateType:          database.CommissionRateType(req.RateType),
+		Rate:              req.Rate,
+		MinFee:            req.MinFee,
+		RegulatoryRate:    req.RegulatoryRate,
+		RegulatoryPerUnit: req.RegulatoryPerUnit,
+	}
+
+	if req.Rate.IsNegative() || req.MinFee.IsNegative() || req.RegulatoryRate.IsNegative() || req.RegulatoryPerUnit.IsNegative() {
+		return params, errors.New("rates and fees can't be negative")
+	}
+
+	if req.MaxFee != nil {
+		if req.MaxFee.LessThan(req.MinFee) {
+			return params, errors.New("max_fee must be at least min_fee")
+		}
+		params.MaxFee = decimal.NewNullDecimal(*req.MaxFee)
+	}
+
+	return params, nil
+}
+
+// getAssetClassParam reads the :assetClass route param. On an unknown asset
+// class it writes a 400 response and returns false.
+func getAssetClassParam(c *gin.Context) (database.AssetClass, bool) {
+	assetClass := database.AssetClass(c.Param("assetClass"))
+
+	if !slices.Contains(assetClasses, assetClass) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid asset class"})
+		return "", false
+	}
+
+	return assetClass, true
+}
+
+func toCommissionResponse(row database.CommissionSchedule) models.CommissionSchedule {
+	response := models.CommissionSchedule{
+		AssetClass:        models.AssetClass(row.AssetClass),
+		RateType:          models.CommissionRateType(row.RateType),
+		Rate:              row.Rate,
+		MinFee:            row.MinFee,
+		RegulatoryRate:    row.RegulatoryRate,
+		RegulatoryPerUnit: row.RegulatoryPerUnit,
+		UpdatedAt:         row.UpdatedAt,
+	}
+
+	if row.MaxFee.Valid {
+		response.MaxFee = &row.MaxFee.Decimal
+	}
+
+	return response
+}
@@ -0,0 +1,481 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/sqlc-dev/pqtype"
+
+	"tradebooklm-api/internal/authz"
+	"tradebooklm-api/internal/database"
+	"tradebooklm-api/internal/helpers"
+	"tradebooklm-api/internal/models"
+)
+
+func GetCustomFields(c *gin.Context, conn *sql.DB) {
+	ctx := c.Request.Context()
+
+	tx, ok := helpers.BeginUserTx(c, conn)
+	if !ok {
+		return
+	}
+	defer tx.Rollback()
+
+	rows, err := database.New(tx).ListCustomFields(ctx, authz.GetTradebookID(c))
+	if err != nil {
+		log.Printf("Error fetching custom fields: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch data"})
+		return
+	}
+
+	responseList := make([]models.CustomField, 0, len(rows))
+	for _, row := range rows {
+		responseList = append(responseList, toCustomFieldResponse(row))
+	}
+
+	c.JSON(http.StatusOK, responseList)
+}
+
+func CreateCustomField(c *gin.Context, conn *sql.DB) {
+	ctx := c.Request.Context()
+
+	workosId, ok := helpers.GetWorkosID(c)
+	if !ok {
+		return
+	}
+
+	tbUUID := authz.GetTradebookID(c)
+
+	var req models.CreateCustomFieldRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Body"})
+		return
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if err := validateCustomField(req.Name, req.Type, req.Options); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	options, err := toJSONList(req.Options)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid options"})
+		return
+	}
+
+	tx, ok := helpers.BeginUserTx(c, conn)
+	if !ok {
+		return
+	}
+	defer tx.Rollback()
+
+	q := database.New(tx)
+
+	// 1. Create Definition
+	field, err := q.CreateCustomField(ctx, database.CreateCustomFieldParams{
+		TradebookID: tbUUID,
+		Name:        req.Name,
+		FieldType:   database.CustomFieldType(req.Type),
+		Options:     options,
+	})
+	if err != nil {
+		if helpers.IsUniqueViolation(err) {
+			c.JSON(http.StatusConflict, gin.H{"error": "A field with this name already exists"})
+			return
+		}
+		log.Printf("Error creating custom field: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create field"})
+		return
+	}
+
+	response := toCustomFieldResponse(field)
+
+	// 2. Audit
+	err = recordAudit(ctx, q, auditEvent{
+		TradebookID: tbUUID,
+		ActorID:     workosId,
+		Action:      models.AuditFieldCreate,
+		EntityID:    field.ID.String(),
+		After:       response,
+	})
+	if err != nil {
+		log.Printf("Error recording audit event: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create field"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Transaction commit failed"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, response)
+}
+
+// UpdateCustomField renames, reorders or changes the options of a field.
+// Values of select options that are removed are cleared from every trade.
+func UpdateCustomField(c *gin.Context, conn *sql.DB) {
+	ctx := c.Request.Context()
+
+	workosId, ok := helpers.GetWorkosID(c)
+	if !ok {
+		return
+	}
+
+	tbUUID := authz.GetTradebookID(c)
+
+	fieldUUID, err := helpers.ParseUUID(c.Param("fieldId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid field ID"})
+		return
+	}
+
+	var req models.UpdateCustomFieldRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Body"})
+		return
+	}
+
+	tx, ok := helpers.BeginUserTx(c, conn)
+	if !ok {
+		return
+	}
+	defer tx.Rollback()
+
+	q := database.New(tx)
+
+	// 1. Load Current Definition
+	before, err := q.GetCustomField(ctx, database.GetCustomFieldParams{
+		FieldID:     fieldUUID,
+		TradebookID: tbUUID,
+	})
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Field not found"})
+			return
+		}
+		log.Printf("Error fetching custom field: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	// 2. Validate the result of the change, not just the change itself
+	name := before.Name
+	if req.Name != nil {
+		name = strings.TrimSpace(*req.Name)
+	}
+
+	options := decodeOptions(before.Options)
+	if req.Options != nil {
+		options = req.Options
+	}
+
+	if err := validateCustomField(name, models.CustomFieldType(before.FieldType), options); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	params := database.UpdateCustomFieldParams{
+		FieldID:     fieldUUID,
+		TradebookID: tbUUID,
+	}
+	if req.Name != nil {
+		params.Name = sql.NullString{String: name, Valid: true}
+	}
+	if req.Options != nil {
+		raw, err := json.Marshal(req.Options)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid options"})
+			return
+		}
+		params.Options = pqtype.NullRawMessage{RawMessage: raw, Valid: true}
+	}
+	if req.Position != nil {
+		params.Position = sql.NullInt32{Int32: *req.Position, Valid: true}
+	}
+
+	// 3. Update Definition
+	field, err := q.UpdateCustomField(ctx, params)
+	if err != nil {
+		if helpers.IsUniqueViolation(err) {
+			c.JSON(http.StatusConflict, gin.H{"error": "A field with this name already exists"})
+			return
+		}
+		log.Printf("Error updating custom field: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update field"})
+		return
+	}
+
+	// 4. Clear Values of Removed Options
+	if params.Options.Valid && field.FieldType == database.CustomFieldTypeSelect {
+		err = q.ClearRemovedOptionValues(ctx, database.ClearRemovedOptionValuesParams{
+			FieldID:     fieldUUID.String(),
+			TradebookID: tbUUID,
+			Options:     params.Options.RawMessage,
+		})
+		if err != nil {
+			log.Printf("Error clearing removed option values: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update field"})
+			return
+		}
+	}
+
+	response := toCustomFieldResponse(field)
+
+	// 5. Audit
+	err = recordAudit(ctx, q, auditEvent{
+		TradebookID: tbUUID,
+		ActorID:     workosId,
+		Action:      models.AuditFieldUpdate,
+		EntityID:    field.ID.String(),
+		Before:      toCustomFieldResponse(before),
+		After:       response,
+	})
+	if err != nil {
+		log.Printf("Error recording audit event: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update field"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Transaction commit failed"})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// DeleteCustomField removes a definition along with its value on every trade.
+func DeleteCustomField(c *gin.Context, conn *sql.DB) {
+	ctx := c.Request.Context()
+
+	workosId, ok := helpers.GetWorkosID(c)
+	if !ok {
+		return
+	}
+
+	tbUUID := authz.GetTradebookID(c)
+
+	fieldUUID, err := helpers.ParseUUID(c.Param("fieldId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid field ID"})
+		return
+	}
+
+	tx, ok := helpers.BeginUserTx(c, conn)
+	if !ok {
+		return
+	}
+	defer tx.Rollback()
+
+	q := database.New(tx)
+
+	// 1. Delete Definition
+	field, err := q.DeleteCustomField(ctx, database.DeleteCustomFieldParams{
+		FieldID:     fieldUUID,
+		TradebookID: tbUUID,
+	})
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Field not found"})
+			return
+		}
+		log.Printf("Error deleting custom field: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete field"})
+		return
+	}
+
+	// 2. Clear Values
+	err = q.ClearCustomFieldValues(ctx, database.ClearCustomFieldValuesParams{
+		FieldID:     fieldUUID.String(),
+		TradebookID: tbUUID,
+	})
+	if err != nil {
+		log.Printf("Error clearing custom field values: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete field"})
+		return
+	}
+
+	// 3. Audit
+	err = recordAudit(ctx, q, auditEvent{
+		TradebookID: tbUUID,
+		ActorID:     workosId,
+		Action:      models.AuditFieldDelete,
+		EntityID:    field.ID.String(),
+		Before:      toCustomFieldResponse(field),
+	})
+	if err != nil {
+		log.Printf("Error recording audit event: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete field"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Transaction commit failed"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// validateCustomField checks a field definition. Only select fields take
+// options, and they need at least one.
+func validateCustomField(name string, fieldType models.CustomFieldType, options []string) error {
+	if name == "" {
+		return errors.New("field name is required")
+	}
+
+	if fieldType == models.FieldSelect && len(options) == 0 {
+		return fmt.Errorf("select field %q needs at least one option", name)
+	}
+	if fieldType != models.FieldSelect && len(options) > 0 {
+		return errors.New("only select fields take options")
+	}
+
+	return nil
+}
+
+// customFields is a tradebook's field definitions keyed by ID.
+type customFields map[string]database.CustomFieldDefinition
+
+func loadCustomFields(ctx context.Context, q *database.Queries, tradebookID uuid.UUID) (customFields, error) {
+	rows, err := q.ListCustomFields(ctx, tradebookID)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make(customFields, len(rows))
+	for _, row := range rows {
+		fields[row.ID.String()] = row
+	}
+
+	return fields, nil
+}
+
+// encodeValues validates trade values against the definitions and encodes
+// them for the custom_fields column. With allowNull, a null value is kept
+// so that UpdateTrade removes the field.
+func (fields customFields) encodeValues(values map[string]any, allowNull bool) (json.RawMessage, error) {
+	if values == nil {
+		values = map[string]any{}
+	}
+
+	for id, value := range values {
+		field, ok := fields[id]
+		if !ok {
+			return nil, fmt.Errorf("unknown custom field %q", id)
+		}
+
+		if value == nil && allowNull {
+			continue
+		}
+
+		if !isValidFieldValue(field, value) {
+			return nil, fmt.Errorf("invalid value for custom field %q", field.Name)
+		}
+	}
+
+	return json.Marshal(values)
+}
+
+// filter reads field[<id>]=<value> query parameters into a containment filter
+// for the trade queries. On an invalid filter it writes a 400 response and
+// returns false.
+func (fields customFields) filter(c *gin.Context) (pqtype.NullRawMessage, bool) {
+	params := c.QueryMap("field")
+	if len(params) == 0 {
+		return pqtype.NullRawMessage{}, true
+	}
+
+	filter := make(map[string]any, len(params))
+	for id, raw := range params {
+		field, ok := fields[id]
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown custom field filter"})
+			return pqtype.NullRawMessage{}, false
+		}
+
+		value, err := parseFieldValue(field, raw)
+		if err != nil || !isValidFieldValue(field, value) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid value for custom field filter " + field.Name})
+			return pqtype.NullRawMessage{}, false
+		}
+
+		filter[id] = value
+	}
+
+	encoded, err := json.Marshal(filter)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid custom field filter"})
+		return pqtype.NullRawMessage{}, false
+	}
+
+	return pqtype.NullRawMessage{RawMessage: encoded, Valid: true}, true
+}
+
+// parseFieldValue converts a query string value to the JSON type stored for the field.
+func parseFieldValue(field database.CustomFieldDefinition, raw string) (any, error) {
+	switch models.CustomFieldType(field.FieldType) {
+	case models.FieldNumber:
+		return strconv.ParseFloat(raw, 64)
+	case models.FieldBoolean:
+		return strconv.ParseBool(raw)
+	default:
+		return raw, nil
+	}
+}
+
+func isValidFieldValue(field database.CustomFieldDefinition, value any) bool {
+	switch models.CustomFieldType(field.FieldType) {
+	case models.FieldText:
+		_, ok := value.(string)
+		return ok
+	case models.FieldNumber:
+		_, ok := value.(float64)
+		return ok
+	case models.FieldBoolean:
+		_, ok := value.(bool)
+		return ok
+	case models.FieldDate:
+		s, ok := value.(string)
+		if !ok {
+			return false
+		}
+		_, err := time.Parse(time.DateOnly, s)
+		return err == nil
+	case models.FieldSelect:
+		s, ok := value.(string)
+		return ok && slices.Contains(decodeOptions(field.Options), s)
+	}
+	return false
+}
+
+func decodeOptions(raw json.RawMessage) []string {
+	var options []string
+	_ = json.Unmarshal(raw, &options)
+	return options
+}
+
+func toCustomFieldResponse(row database.CustomFieldDefinition) models.CustomField {
+	return models.CustomField{
+		ID:        row.ID.String(),
+		Name:      row.Name,
+		Type:      models.CustomFieldType(row.FieldType),
+		Options:   decodeOptions(row.Options),
+		Position:  row.Position,
+		CreatedAt: row.CreatedAt,
+		UpdatedAt: row.UpdatedAt,
+	}
+}
@@ -0,0 +1,139 @@
+package services
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+
+	"tradebooklm-api/internal/authz"
+	"tradebooklm-api/internal/database"
+	"tradebooklm-api/internal/helpers"
+	"tradebooklm-api/internal/models"
+)
+
+// CreateExitLeg records a (partial) exit on a trade. Omitted fees are
+// computed from the tradebook's commission schedule.
+func CreateExitLeg(c *gin.Context, conn *sql.DB) {
+	ctx := c.Request.Context()
+
+	workosId, ok := helpers.GetWorkosID(c)
+	if !ok {
+		return
+	}
+
+	tbUUID := authz.GetTradebookID(c)
+
+	tradeUUID, err := helpers.ParseUUID(c.Param("tradeId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid trade ID"})
+		return
+	}
+
+	var req models.AddExitLegRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Body"})
+		return
+	}
+
+	if !validAmounts(req.ExitQuantity, req.ExitPrice, req.ExitFees) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid quantity, price or fees"})
+		return
+	}
+
+	tx, ok := helpers.BeginUserTx(c, conn)
+	if !ok {
+		return
+	}
+	defer tx.Rollback()
+
+	q := database.New(tx)
+
+	// 1. Load Trade (for its asset class)
+	trade, err := q.GetTrade(ctx, database.GetTradeParams{
+		TradeID:     tradeUUID,
+		TradebookID: tbUUID,
+	})
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Trade not found"})
+			return
+		}
+		log.Printf("Error fetching trade: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	// 2. Fees left out are computed from the commission schedule
+	var exitFees decimal.Decimal
+	feesAuto := req.ExitFees == nil
+	if feesAuto {
+		calc, err := loadFeeCalculator(ctx, q, tbUUID)
+		if err != nil {
+			log.Printf("Error fetching commission schedules: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		exitFees = calc.fees(trade.AssetClass, req.ExitQuantity, req.ExitPrice, true)
+	} else {
+		exitFees = *req.ExitFees
+	}
+
+	// 3. Add Leg (the query enforces the per-trade limit)
+	leg, err := q.AddExitLeg(ctx, database.AddExitLegParams{
+		TradeID:      tradeUUID,
+		ExitDate:     req.ExitDate,
+		ExitQuantity: req.ExitQuantity,
+		ExitPrice:    req.ExitPrice,
+		ExitFees:     decimal.NewNullDecimal(exitFees),
+		ExitFeesAuto: feesAuto,
+		TradebookID:  tbUUID,
+	})
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusConflict, gin.H{"error": "Trade has reached the exit leg limit"})
+			return
+		}
+		log.Printf("Error adding exit leg: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add exit leg"})
+		return
+	}
+
+	response := toExitLegResponse(leg)
+
+	// 4. Audit
+	err = recordAudit(ctx, q, auditEvent{
+		TradebookID: tbUUID,
+		ActorID:     workosId,
+		Action:      models.AuditExitLegCreate,
+		EntityID:    leg.ID.String(),
+		After:       response,
+	})
+	if err != nil {
+		log.Printf("Error recording audit event: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add exit leg"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Transaction commit failed"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, response)
+}
+
+func toExitLegResponse(row database.ExitLeg) models.ExitLeg {
+	return models.ExitLeg{
+		ID:           row.ID.String(),
+		TradeID:      row.TradeID.String(),
+		ExitDate:     row.ExitDate,
+		ExitQuantity: row.ExitQuantity,
+		ExitPrice:    row.ExitPrice,
+		ExitFees:     row.ExitFees.Decimal,
+		CreatedAt:    row.CreatedAt,
+		UpdatedAt:    row.UpdatedAt,
+	}
+}
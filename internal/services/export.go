@@ -0,0 +1,324 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"tradebooklm-api/internal/archive"
+	"tradebooklm-api/internal/authz"
+	"tradebooklm-api/internal/database"
+	"tradebooklm-api/internal/helpers"
+	"tradebooklm-api/internal/models"
+)
+
+// exportBatchSize is how many trades or exit legs are held in memory at once.
+const exportBatchSize = 500
+
+// ExportTradebook streams the tradebook as a zip archive; see package archive
+// for the format. Trashed trades are left out.
+func ExportTradebook(c *gin.Context, conn *sql.DB) {
+	ctx := c.Request.Context()
+
+	workosId, ok := helpers.GetWorkosID(c)
+	if !ok {
+		return
+	}
+
+	tbUUID := authz.GetTradebookID(c)
+
+	// The archive is read over many queries; a snapshot keeps them consistent
+	tx, ok := helpers.BeginUserTxWithOptions(c, conn, helpers.SnapshotTxOptions)
+	if !ok {
+		return
+	}
+	defer tx.Rollback()
+
+	q := database.New(tx)
+
+	// 1. Load Metadata (before streaming, while errors can still be reported)
+	meta, fields, err := loadArchiveTradebook(ctx, q, tbUUID, workosId, helpers.GetOrgID(c))
+	if err != nil {
+		log.Printf("Error loading tradebook for export: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	// 2. Stream. From here on the status is sent, so a failure can only
+	// truncate the archive, which clients detect as a corrupt zip.
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="tradebook-%s.zip"`, tbUUID))
+	c.Status(http.StatusOK)
+
+	w := archive.NewWriter(c.Writer)
+	if err := writeArchive(ctx, q, w, tbUUID, meta, fields); err != nil {
+		log.Printf("Error streaming export of tradebook %s: %v", tbUUID, err)
+		return
+	}
+	if err := w.Close(); err != nil {
+		log.Printf("Error finishing export of tradebook %s: %v", tbUUID, err)
+	}
+}
+
+// loadArchiveTradebook reads what goes into tradebook.json. The field
+// definitions are returned as well for the CSV columns.
+func loadArchiveTradebook(ctx context.Context, q *database.Queries, tbUUID uuid.UUID, userID string, orgID sql.NullString) (archive.Tradebook, []database.CustomFieldDefinition, error) {
+	tb, err := q.GetTradebook(ctx, database.GetTradebookParams{
+		TradebookID: tbUUID,
+		UserID:      userID,
+		OrgID:       orgID,
+	})
+	if err != nil {
+		return archive.Tradebook{}, nil, err
+	}
+
+	fields, err := q.ListCustomFields(ctx, tbUUID)
+	if err != nil {
+		return archive.Tradebook{}, nil, err
+	}
+
+	commissions, err := q.ListCommissionSchedules(ctx, tbUUID)
+	if err != nil {
+		return archive.Tradebook{}, nil, err
+	}
+
+	meta := archive.Tradebook{
+		ID:           tb.ID.String(),
+		Title:        tb.Title,
+		Settings:     *toSettingsResponse(tb),
+		CustomFields: make([]archive.CustomField, 0, len(fields)),
+		Commissions:  make([]models.CommissionSchedule, 0, len(commissions)),
+		CreatedAt:    tb.CreatedAt,
+	}
+	for _, field := range fields {
+		meta.CustomFields = append(meta.CustomFields, archive.CustomField{
+			ID:       field.ID.String(),
+			Name:     field.Name,
+			Type:     models.CustomFieldType(field.FieldType),
+			Options:  decodeOptions(field.Options),
+			Position: field.Position,
+		})
+	}
+	for _, commission := range commissions {
+		meta.Commissions = append(meta.Commissions, toCommissionResponse(commission))
+	}
+
+	return meta, fields, nil
+}
+
+func writeArchive(ctx context.Context, q *database.Queries, w *archive.Writer, tbUUID uuid.UUID, meta archive.Tradebook, fields []database.CustomFieldDefinition) error {
+	err := w.WriteJSON(archive.ManifestFile, archive.Manifest{
+		Format:      archive.FormatName,
+		Version:     archive.Version,
+		ExportedAt:  time.Now().UTC(),
+		TradebookID: meta.ID,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := w.WriteJSON(archive.TradebookFile, meta); err != nil {
+		return err
+	}
+
+	// JSON Lines
+	entry, err := w.Create(archive.TradesFile)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(entry)
+	err = eachTradeForExport(ctx, q, tbUUID, func(row database.Trade) error {
+		return enc.Encode(toArchiveTrade(row))
+	})
+	if err != nil {
+		return err
+	}
+
+	if entry, err = w.Create(archive.ExitLegsFile); err != nil {
+		return err
+	}
+	enc = json.NewEncoder(entry)
+	err = eachExitLegForExport(ctx, q, tbUUID, func(row database.ExitLeg) error {
+		return enc.Encode(toArchiveExitLeg(row))
+	})
+	if err != nil {
+		return err
+	}
+
+	// CSV views, one column per custom field
+	if entry, err = w.Create(archive.TradesCSVFile); err != nil {
+		return err
+	}
+	cw := csv.NewWriter(entry)
+	header := []string{
+		"id", "symbol", "asset_class", "purchase_type", "order_type", "is_open", "entry_date",
+		"currency", "entry_quantity", "entry_price", "entry_fees", "created_at",
+	}
+	for _, field := range fields {
+		header = append(header, csvText(field.Name))
+	}
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	err = eachTradeForExport(ctx, q, tbUUID, func(row database.Trade) error {
+		trade := toArchiveTrade(row)
+		record := []string{
+			trade.ID, csvText(trade.Symbol), string(trade.AssetClass), string(trade.PurchaseType), string(trade.OrderType),
+			strconv.FormatBool(trade.IsOpen), trade.EntryDate.Format(time.RFC3339), trade.Currency,
+			trade.EntryQuantity.String(), trade.EntryPrice.String(), trade.EntryFees.String(),
+			trade.CreatedAt.Format(time.RFC3339),
+		}
+		for _, field := range fields {
+			record = append(record, csvValue(trade.CustomFields[field.ID.String()]))
+		}
+		return cw.Write(record)
+	})
+	if err != nil {
+		return err
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return err
+	}
+
+	if entry, err = w.Create(archive.ExitLegsCSVFile); err != nil {
+		return err
+	}
+	cw = csv.NewWriter(entry)
+	if err := cw.Write([]string{"id", "trade_id", "exit_date", "exit_quantity", "exit_price", "exit_fees", "created_at"}); err != nil {
+		return err
+	}
+	err = eachExitLegForExport(ctx, q, tbUUID, func(row database.ExitLeg) error {
+		return cw.Write([]string{
+			row.ID.String(), row.TradeID.String(), row.ExitDate.Format(time.RFC3339),
+			row.ExitQuantity.String(), row.ExitPrice.String(), row.ExitFees.Decimal.String(),
+			row.CreatedAt.Format(time.RFC3339),
+		})
+	})
+	if err != nil {
+		return err
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// eachTradeForExport calls fn for every live trade, exportBatchSize rows at a time.
+func eachTradeForExport(ctx context.Context, q *database.Queries, tradebookID uuid.UUID, fn func(database.Trade) error) error {
+	var after uuid.NullUUID
+	for {
+		rows, err := q.ListTradesForExport(ctx, database.ListTradesForExportParams{
+			TradebookID: tradebookID,
+			AfterID:     after,
+			LimitVal:    exportBatchSize,
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, row := range rows {
+			if err := fn(row); err != nil {
+				return err
+			}
+		}
+
+		if len(rows) < exportBatchSize {
+			return nil
+		}
+		after = uuid.NullUUID{UUID: rows[len(rows)-1].ID, Valid: true}
+	}
+}
+
+func eachExitLegForExport(ctx context.Context, q *database.Queries, tradebookID uuid.UUID, fn func(database.ExitLeg) error) error {
+	var after uuid.NullUUID
+	for {
+		rows, err := q.ListExitLegsForExport(ctx, database.ListExitLegsForExportParams{
+			TradebookID: tradebookID,
+			AfterID:     after,
+			LimitVal:    exportBatchSize,
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, row := range rows {
+			if err := fn(row); err != nil {
+				return err
+			}
+		}
+
+		if len(rows) < exportBatchSize {
+			return nil
+		}
+		after = uuid.NullUUID{UUID: rows[len(rows)-1].ID, Valid: true}
+	}
+}
+
+func toArchiveTrade(row database.Trade) archive.Trade {
+	customValues := map[string]any{}
+	_ = json.Unmarshal(row.CustomFields, &customValues)
+
+	return archive.Trade{
+		ID:            row.ID.String(),
+		IsOpen:        row.IsOpen,
+		AssetClass:    models.AssetClass(row.AssetClass),
+		PurchaseType:  models.PurchaseType(row.PurchaseType),
+		OrderType:     models.OrderType(row.OrderType),
+		EntryDate:     row.EntryDate,
+		Symbol:        row.Symbol,
+		Currency:      row.Currency,
+		EntryQuantity: row.EntryQuantity,
+		EntryPrice:    row.EntryPrice,
+		EntryFees:     row.EntryFees.Decimal,
+		EntryFeesAuto: row.EntryFeesAuto,
+		CustomFields:  customValues,
+		CreatedAt:     row.CreatedAt,
+	}
+}
+
+func toArchiveExitLeg(row database.ExitLeg) archive.ExitLeg {
+	return archive.ExitLeg{
+		ID:           row.ID.String(),
+		TradeID:      row.TradeID.String(),
+		ExitDate:     row.ExitDate,
+		ExitQuantity: row.ExitQuantity,
+		ExitPrice:    row.ExitPrice,
+		ExitFees:     row.ExitFees.Decimal,
+		ExitFeesAuto: row.ExitFeesAuto,
+		CreatedAt:    row.CreatedAt,
+	}
+}
+
+// csvValue formats a custom field value; unset fields are empty cells.
+func csvValue(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case string:
+		return csvText(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// csvText quotes user-entered text that a spreadsheet would otherwise run as
+// a formula. Numbers are written by the API itself and left alone.
+func csvText(s string) string {
+	if s != "" && strings.ContainsRune("=+-@", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
@@ -0,0 +1,493 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+
+	"tradebooklm-api/internal/archive"
+	"tradebooklm-api/internal/database"
+	"tradebooklm-api/internal/helpers"
+	"tradebooklm-api/internal/models"
+)
+
+// maxImportSize caps the uploaded archive.
+const maxImportSize = 100 << 20
+
+// maxExitLegs is the per-trade limit AddExitLeg enforces, which ImportExitLeg
+// doesn't check itself.
+const maxExitLegs = 100
+
+// ImportTradebook recreates an exported tradebook (see package archive) as a
+// new tradebook owned by the caller. Everything gets a new ID, and exit legs
+// and custom field values are remapped to them.
+//
+// The archive is a multipart "archive" file. Entries that can't be imported
+// as is are conflicts: by default any conflict fails the import with a 422
+// listing them all, and with skip_conflicts=true they are left out instead.
+func ImportTradebook(c *gin.Context, conn *sql.DB) {
+	ctx := c.Request.Context()
+
+	workosId, ok := helpers.GetWorkosID(c)
+	if !ok {
+		return
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxImportSize)
+
+	file, err := c.FormFile("archive")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "An archive file is required"})
+		return
+	}
+
+	skipConflicts := false
+	if raw := c.PostForm("skip_conflicts"); raw != "" {
+		if skipConflicts, err = strconv.ParseBool(raw); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid skip_conflicts"})
+			return
+		}
+	}
+
+	f, err := file.Open()
+	if err != nil {
+		log.Printf("Error opening uploaded archive: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read archive"})
+		return
+	}
+	defer f.Close()
+
+	// 1. Check the Format and Version before touching the database
+	reader, err := archive.NewReader(f, file.Size)
+	if err != nil {
+		if errors.Is(err, archive.ErrUnsupportedVersion) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Not a tradebook archive"})
+		return
+	}
+
+	meta, err := reader.ReadTradebook()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	title := strings.TrimSpace(c.PostForm("title"))
+	if title == "" {
+		title = strings.TrimSpace(meta.Title)
+	}
+	if title == "" {
+		title = "Untitled Tradebook"
+	}
+
+	q := database.New(conn)
+
+	// 2. Ensure User Exists
+	if _, err := q.UpsertUser(ctx, workosId); err != nil {
+		log.Printf("Error ensuring user exists: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	tx, ok := helpers.BeginUserTx(c, conn)
+	if !ok {
+		return
+	}
+	defer tx.Rollback()
+
+	qTx := q.WithTx(tx)
+
+	// 3. Create Tradebook
+	tb, err := qTx.CreateTradebook(ctx, database.CreateTradebookParams{
+		OwnerID: workosId,
+		Title:   title,
+	})
+	if err != nil {
+		log.Printf("Error creating tradebook: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to import tradebook"})
+		return
+	}
+
+	_, err = qTx.UpsertTradebookMember(ctx, database.UpsertTradebookMemberParams{
+		TradebookID: tb.ID,
+		UserID:      workosId,
+		Role:        database.TradebookRoleOwner,
+	})
+	if err != nil {
+		log.Printf("Error adding member: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to assign ownership"})
+		return
+	}
+
+	// 4. Recreate Settings, Custom Fields and Commissions, then Trades and
+	// their Exit Legs, remapping IDs along the way
+	imp := &tradebookImport{
+		q:           qTx,
+		tradebookID: tb.ID,
+		fields:      customFields{},
+		trades:      map[string]uuid.UUID{},
+		legs:        map[uuid.UUID]int{},
+		conflicts:   []models.ImportConflict{},
+	}
+
+	err = imp.importSettings(ctx, meta.Settings)
+	if err == nil {
+		err = imp.importCustomFields(ctx, meta.CustomFields)
+	}
+	if err == nil {
+		err = imp.importCommissions(ctx, meta.Commissions)
+	}
+	if err == nil {
+		err = reader.EachTrade(func(line int, trade archive.Trade) error {
+			return imp.importTrade(ctx, line, trade)
+		})
+	}
+	if err == nil {
+		err = reader.EachExitLeg(func(line int, leg archive.ExitLeg) error {
+			return imp.importExitLeg(ctx, line, leg)
+		})
+	}
+	if err != nil {
+		if errors.Is(err, archive.ErrInvalidEntry) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		log.Printf("Error importing tradebook: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to import tradebook"})
+		return
+	}
+
+	if len(imp.conflicts) > 0 && !skipConflicts {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error":     "The archive has conflicts; fix them or retry with skip_conflicts",
+			"conflicts": imp.conflicts,
+		})
+		return
+	}
+
+	// 5. Load the Result
+	row, err := qTx.GetTradebook(ctx, database.GetTradebookParams{
+		TradebookID: tb.ID,
+		UserID:      workosId,
+		OrgID:       helpers.GetOrgID(c),
+	})
+	if err != nil {
+		log.Printf("Error fetching tradebook: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to import tradebook"})
+		return
+	}
+
+	result := models.ImportTradebookResult{
+		Tradebook:        toTradebookResponse(row),
+		TradesImported:   imp.tradesImported,
+		ExitLegsImported: imp.exitLegsImported,
+		Conflicts:        imp.conflicts,
+	}
+
+	// 6. Audit
+	err = recordAudit(ctx, qTx, auditEvent{
+		TradebookID: tb.ID,
+		ActorID:     workosId,
+		Action:      models.AuditTradebookImport,
+		EntityID:    tb.ID.String(),
+		After: gin.H{
+			"tradebook":           result.Tradebook,
+			"source_tradebook_id": meta.ID,
+			"archive_version":     reader.Manifest.Version,
+			"trades_imported":     result.TradesImported,
+			"exit_legs_imported":  result.ExitLegsImported,
+			"conflicts_skipped":   len(result.Conflicts),
+		},
+	})
+	if err != nil {
+		log.Printf("Error recording audit event: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to import tradebook"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Transaction commit failed"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, result)
+}
+
+// tradebookImport holds the ID mappings while an archive is imported.
+// Entries are validated before they're written, since a failed statement
+// would abort the whole transaction; the import methods only return
+// database errors.
+type tradebookImport struct {
+	q           *database.Queries
+	tradebookID uuid.UUID
+
+	fields customFields         // New definitions, keyed by archive field ID
+	trades map[string]uuid.UUID // Archive trade ID to new trade ID
+	legs   map[uuid.UUID]int    // Exit legs imported so far, by new trade ID
+
+	conflicts        []models.ImportConflict
+	tradesImported   int
+	exitLegsImported int
+}
+
+func (imp *tradebookImport) conflict(entry string, line int, id string, reason string, args ...any) {
+	imp.conflicts = append(imp.conflicts, models.ImportConflict{
+		Entry:  entry,
+		Line:   line,
+		ID:     id,
+		Reason: fmt.Sprintf(reason, args...),
+	})
+}
+
+// importSettings applies the archived settings with the same checks as
+// UpdateTradebook. Invalid settings are a conflict and leave the defaults.
+func (imp *tradebookImport) importSettings(ctx context.Context, settings models.TradebookSettings) error {
+	req := models.UpdateTradebookRequest{
+		Settings: &models.UpdateTradebookSettingsRequest{
+			Timezone:            &settings.Timezone,
+			BaseCurrency:        &settings.BaseCurrency,
+			AccountingMethod:    &settings.AccountingMethod,
+			DefaultFees:         &settings.DefaultFees,
+			FiscalYearStart:     &settings.FiscalYearStart,
+			DefaultAssetClasses: settings.DefaultAssetClasses,
+			TagSets:             settings.TagSets,
+		},
+	}
+
+	if err := binding.Validator.ValidateStruct(req); err != nil {
+		imp.conflict(archive.TradebookFile, 0, "", "Invalid settings: %v", err)
+		return nil
+	}
+
+	params, err := toUpdateTradebookParams(req)
+	if err != nil {
+		imp.conflict(archive.TradebookFile, 0, "", "Invalid settings: %v", err)
+		return nil
+	}
+	params.TradebookID = imp.tradebookID
+
+	_, err = imp.q.UpdateTradebook(ctx, params)
+	return err
+}
+
+func (imp *tradebookImport) importCustomFields(ctx context.Context, fields []archive.CustomField) error {
+	// CreateCustomField appends, so create them in their archived order
+	slices.SortStableFunc(fields, func(a, b archive.CustomField) int {
+		return int(a.Position) - int(b.Position)
+	})
+
+	validTypes := []models.CustomFieldType{
+		models.FieldText, models.FieldNumber, models.FieldSelect, models.FieldBoolean, models.FieldDate,
+	}
+
+	names := make(map[string]bool, len(fields))
+	for _, field := range fields {
+		name := strings.TrimSpace(field.Name)
+
+		switch {
+		case imp.fields[field.ID].ID != uuid.Nil:
+			imp.conflict(archive.TradebookFile, 0, field.ID, "Duplicate custom field ID")
+			continue
+		case names[name]:
+			imp.conflict(archive.TradebookFile, 0, field.ID, "Duplicate custom field %q", name)
+			continue
+		case !slices.Contains(validTypes, field.Type):
+			imp.conflict(archive.TradebookFile, 0, field.ID, "Unknown custom field type %q", field.Type)
+			continue
+		}
+		if err := validateCustomField(name, field.Type, field.Options); err != nil {
+			imp.conflict(archive.TradebookFile, 0, field.ID, "%v", err)
+			continue
+		}
+
+		options, err := toJSONList(field.Options)
+		if err != nil {
+			return err
+		}
+
+		created, err := imp.q.CreateCustomField(ctx, database.CreateCustomFieldParams{
+			TradebookID: imp.tradebookID,
+			Name:        name,
+			FieldType:   database.CustomFieldType(field.Type),
+			Options:     options,
+		})
+		if err != nil {
+			return err
+		}
+
+		names[name] = true
+		imp.fields[field.ID] = created
+	}
+
+	return nil
+}
+
+func (imp *tradebookImport) importCommissions(ctx context.Context, schedules []models.CommissionSchedule) error {
+	seen := make(map[database.AssetClass]bool, len(schedules))
+	for _, schedule := range schedules {
+		assetClass := database.AssetClass(schedule.AssetClass)
+		id := string(assetClass)
+
+		if !slices.Contains(assetClasses, assetClass) {
+			imp.conflict(archive.TradebookFile, 0, id, "Unknown asset class for commission schedule")
+			continue
+		}
+		if seen[assetClass] {
+			imp.conflict(archive.TradebookFile, 0, id, "Duplicate commission schedule")
+			continue
+		}
+
+		req := models.CommissionScheduleRequest{
+			RateType:          schedule.RateType,
+			Rate:              schedule.Rate,
+			MinFee:            schedule.MinFee,
+			MaxFee:            schedule.MaxFee,
+			RegulatoryRate:    schedule.RegulatoryRate,
+			RegulatoryPerUnit: schedule.RegulatoryPerUnit,
+		}
+		if err := binding.Validator.ValidateStruct(req); err != nil {
+			imp.conflict(archive.TradebookFile, 0, id, "Invalid commission schedule: %v", err)
+			continue
+		}
+
+		params, err := toCommissionScheduleParams(imp.tradebookID, assetClass, req)
+		if err != nil {
+			imp.conflict(archive.TradebookFile, 0, id, "Invalid commission schedule: %v", err)
+			continue
+		}
+
+		if _, err := imp.q.UpsertCommissionSchedule(ctx, params); err != nil {
+			return err
+		}
+		seen[assetClass] = true
+	}
+
+	return nil
+}
+
+// importTrade skips invalid trades. Custom field values that don't fit the
+// imported definitions are dropped from an otherwise valid trade.
+func (imp *tradebookImport) importTrade(ctx context.Context, line int, trade archive.Trade) error {
+	if _, ok := imp.trades[trade.ID]; ok {
+		imp.conflict(archive.TradesFile, line, trade.ID, "Duplicate trade ID")
+		return nil
+	}
+
+	// Same rules as AddTrade
+	req := models.AddTradeRequest{
+		AssetClass:    trade.AssetClass,
+		PurchaseType:  trade.PurchaseType,
+		OrderType:     trade.OrderType,
+		EntryDate:     trade.EntryDate,
+		Symbol:        trade.Symbol,
+		Currency:      trade.Currency,
+		EntryQuantity: trade.EntryQuantity,
+		EntryPrice:    trade.EntryPrice,
+	}
+	if err := binding.Validator.ValidateStruct(req); err != nil {
+		imp.conflict(archive.TradesFile, line, trade.ID, "Invalid trade: %v", err)
+		return nil
+	}
+	if len(trade.Currency) != 3 {
+		imp.conflict(archive.TradesFile, line, trade.ID, "Invalid currency %q", trade.Currency)
+		return nil
+	}
+	if !validAmounts(trade.EntryQuantity, trade.EntryPrice, &trade.EntryFees) {
+		imp.conflict(archive.TradesFile, line, trade.ID, "Invalid quantity, price or fees")
+		return nil
+	}
+
+	values := make(map[string]any, len(trade.CustomFields))
+	for fieldID, value := range trade.CustomFields {
+		field, ok := imp.fields[fieldID]
+		switch {
+		case !ok:
+			imp.conflict(archive.TradesFile, line, trade.ID, "Unknown custom field %q", fieldID)
+		case !isValidFieldValue(field, value):
+			imp.conflict(archive.TradesFile, line, trade.ID, "Invalid value for custom field %q", field.Name)
+		default:
+			values[field.ID.String()] = value
+		}
+	}
+
+	encoded, err := json.Marshal(values)
+	if err != nil {
+		return err
+	}
+
+	newID, err := imp.q.ImportTrade(ctx, database.ImportTradeParams{
+		TradebookID:   imp.tradebookID,
+		IsOpen:        trade.IsOpen,
+		AssetClass:    database.AssetClass(trade.AssetClass),
+		PurchaseType:  database.TradePurchaseType(trade.PurchaseType),
+		OrderType:     database.TradeOrderType(trade.OrderType),
+		EntryDate:     trade.EntryDate,
+		Symbol:        trade.Symbol,
+		Currency:      strings.ToUpper(trade.Currency),
+		EntryQuantity: trade.EntryQuantity,
+		EntryPrice:    trade.EntryPrice,
+		EntryFees:     decimal.NewNullDecimal(trade.EntryFees),
+		EntryFeesAuto: trade.EntryFeesAuto,
+		CustomFields:  encoded,
+		CreatedAt:     trade.CreatedAt,
+	})
+	if err != nil {
+		return err
+	}
+
+	imp.trades[trade.ID] = newID
+	imp.tradesImported++
+	return nil
+}
+
+func (imp *tradebookImport) importExitLeg(ctx context.Context, line int, leg archive.ExitLeg) error {
+	tradeID, ok := imp.trades[leg.TradeID]
+	if !ok {
+		imp.conflict(archive.ExitLegsFile, line, leg.ID, "Unknown trade %q", leg.TradeID)
+		return nil
+	}
+
+	if leg.ExitDate.IsZero() {
+		imp.conflict(archive.ExitLegsFile, line, leg.ID, "Missing exit date")
+		return nil
+	}
+	if !validAmounts(leg.ExitQuantity, leg.ExitPrice, &leg.ExitFees) {
+		imp.conflict(archive.ExitLegsFile, line, leg.ID, "Invalid quantity, price or fees")
+		return nil
+	}
+	if imp.legs[tradeID] >= maxExitLegs {
+		imp.conflict(archive.ExitLegsFile, line, leg.ID, "Trade %q has more than %d exit legs", leg.TradeID, maxExitLegs)
+		return nil
+	}
+
+	err := imp.q.ImportExitLeg(ctx, database.ImportExitLegParams{
+		TradeID:      tradeID,
+		ExitDate:     leg.ExitDate,
+		ExitQuantity: leg.ExitQuantity,
+		ExitPrice:    leg.ExitPrice,
+		ExitFees:     decimal.NewNullDecimal(leg.ExitFees),
+		ExitFeesAuto: leg.ExitFeesAuto,
+		CreatedAt:    leg.CreatedAt,
+	})
+	if err != nil {
+		return err
+	}
+
+	imp.legs[tradeID]++
+	imp.exitLegsImported++
+	return nil
+}
@@ -0,0 +1,363 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"tradebooklm-api/internal/authz"
+	"tradebooklm-api/internal/database"
+	"tradebooklm-api/internal/helpers"
+	"tradebooklm-api/internal/mailer"
+	"tradebooklm-api/internal/models"
+)
+
+const invitationTTL = 7 * 24 * time.Hour
+
+func CreateTradebookInvitation(c *gin.Context, conn *sql.DB, m mailer.Mailer) {
+	ctx := c.Request.Context()
+
+	workosId, ok := helpers.GetWorkosID(c)
+	if !ok {
+		return
+	}
+
+	var req models.CreateInvitationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Body"})
+		return
+	}
+
+	// Ownership is only granted by transfer, never by invitation
+	if req.Role != models.Editor && req.Role != models.Reader {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Role must be editor or reader"})
+		return
+	}
+
+	token, tokenHash, err := helpers.NewToken()
+	if err != nil {
+		log.Printf("Error generating invitation token: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create invitation"})
+		return
+	}
+
+	// 1. Start Transaction
+	tx, ok := helpers.BeginUserTx(c, conn)
+	if !ok {
+		return
+	}
+	defer tx.Rollback()
+
+	qTx := database.New(tx)
+
+	tbUUID := authz.GetTradebookID(c)
+
+	// 2. Create Invitation
+	inv, err := qTx.CreateTradebookInvitation(ctx, database.CreateTradebookInvitationParams{
+		TradebookID: tbUUID,
+		Email:       req.Email,
+		Role:        database.TradebookRole(req.Role),
+		TokenHash:   tokenHash,
+		InvitedBy:   workosId,
+		ExpiresAt:   time.Now().Add(invitationTTL),
+	})
+	if err != nil {
+		log.Printf("Error creating invitation: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create invitation"})
+		return
+	}
+
+	// 3. Audit
+	err = recordAudit(ctx, qTx, auditEvent{
+		TradebookID: tbUUID,
+		ActorID:     workosId,
+		Action:      models.AuditInvitationCreate,
+		EntityID:    inv.ID.String(),
+		After:       toInvitationResponse(inv),
+	})
+	if err != nil {
+		log.Printf("Error recording audit event: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create invitation"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Transaction commit failed"})
+		return
+	}
+
+	// 4. Send Email. Only after the commit, so the mail server doesn't hold
+	// the transaction open and no email goes out for a rolled-back invitation.
+	acceptURL := fmt.Sprintf("%s/invitations/accept?token=%s", os.Getenv("TRADEBOOKLM_WEB_URL"), url.QueryEscape(token))
+
+	err = m.Send(ctx, mailer.Message{
+		To:      req.Email,
+		Subject: "You've been invited to a tradebook on TradeBookLM",
+		Body: fmt.Sprintf("You've been invited to collaborate on a tradebook as %s.\n\n"+
+			"Accept the invitation here:\n%s\n\n"+
+			"This link expires on %s.\n",
+			req.Role, acceptURL, inv.ExpiresAt.UTC().Format(time.RFC1123)),
+	})
+	if err != nil {
+		log.Printf("Error sending invitation email: %v", err)
+		if revokeUnsentInvitation(c, conn, workosId, inv) {
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to send invitation email"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusCreated, toInvitationResponse(inv))
+}
+
+// revokeUnsentInvitation revokes an invitation whose email failed, so the
+// list doesn't show one nobody received. On failure a response has already
+// been written.
+func revokeUnsentInvitation(c *gin.Context, conn *sql.DB, workosId string, inv database.TradebookInvitation) bool {
+	ctx := c.Request.Context()
+
+	tx, ok := helpers.BeginUserTx(c, conn)
+	if !ok {
+		return false
+	}
+	defer tx.Rollback()
+
+	q := database.New(tx)
+
+	revoked, err := q.RevokeTradebookInvitation(ctx, database.RevokeTradebookInvitationParams{
+		InvitationID: inv.ID,
+		TradebookID:  inv.TradebookID,
+	})
+	if err != nil {
+		log.Printf("Error revoking unsent invitation %s: %v", inv.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send invitation email"})
+		return false
+	}
+
+	err = recordAudit(ctx, q, auditEvent{
+		TradebookID: inv.TradebookID,
+		ActorID:     workosId,
+		Action:      models.AuditInvitationRevoke,
+		EntityID:    revoked.ID.String(),
+		Before:      toInvitationResponse(revoked),
+	})
+	if err != nil {
+		log.Printf("Error recording audit event: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send invitation email"})
+		return false
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Transaction commit failed"})
+		return false
+	}
+
+	return true
+}
+
+func ListTradebookInvitations(c *gin.Context, conn *sql.DB) {
+	ctx := c.Request.Context()
+
+	tx, ok := helpers.BeginUserTx(c, conn)
+	if !ok {
+		return
+	}
+	defer tx.Rollback()
+
+	q := database.New(tx)
+
+	rows, err := q.ListTradebookInvitations(ctx, authz.GetTradebookID(c))
+	if err != nil {
+		log.Printf("Error fetching invitations: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch data"})
+		return
+	}
+
+	responseList := make([]models.TradebookInvitation, 0, len(rows))
+	for _, row := range rows {
+		responseList = append(responseList, toInvitationResponse(row))
+	}
+
+	c.JSON(http.StatusOK, responseList)
+}
+
+func RevokeTradebookInvitation(c *gin.Context, conn *sql.DB) {
+	ctx := c.Request.Context()
+
+	workosId, ok := helpers.GetWorkosID(c)
+	if !ok {
+		return
+	}
+
+	tbUUID := authz.GetTradebookID(c)
+
+	invUUID, err := helpers.ParseUUID(c.Param("invitationId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid invitation ID"})
+		return
+	}
+
+	tx, ok := helpers.BeginUserTx(c, conn)
+	if !ok {
+		return
+	}
+	defer tx.Rollback()
+
+	q := database.New(tx)
+
+	revoked, err := q.RevokeTradebookInvitation(ctx, database.RevokeTradebookInvitationParams{
+		InvitationID: invUUID,
+		TradebookID:  tbUUID,
+	})
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Invitation not found"})
+			return
+		}
+		log.Printf("Error revoking invitation: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke invitation"})
+		return
+	}
+
+	err = recordAudit(ctx, q, auditEvent{
+		TradebookID: tbUUID,
+		ActorID:     workosId,
+		Action:      models.AuditInvitationRevoke,
+		EntityID:    revoked.ID.String(),
+		Before:      toInvitationResponse(revoked),
+	})
+	if err != nil {
+		log.Printf("Error recording audit event: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke invitation"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Transaction commit failed"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// AcceptTradebookInvitation redeems an emailed token for the authenticated
+// caller. The token is single-use and must not be expired or revoked.
+func AcceptTradebookInvitation(c *gin.Context, conn *sql.DB) {
+	ctx := c.Request.Context()
+
+	workosId, ok := helpers.GetWorkosID(c)
+	if !ok {
+		return
+	}
+
+	var req models.AcceptInvitationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Body"})
+		return
+	}
+
+	q := database.New(conn)
+
+	// 1. Ensure User Exists (invitees may not have hit the webhook yet)
+	_, err := q.UpsertUser(ctx, workosId)
+	if err != nil {
+		log.Printf("Error ensuring user exists: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	// 2. Start Transaction
+	tx, ok := helpers.BeginUserTx(c, conn)
+	if !ok {
+		return
+	}
+	defer tx.Rollback()
+
+	qTx := q.WithTx(tx)
+
+	// 3. Lock Invitation
+	inv, err := qTx.GetPendingInvitationByTokenHash(ctx, helpers.HashToken(req.Token))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Invitation is invalid, expired or already used"})
+			return
+		}
+		log.Printf("Error fetching invitation: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	// 4. Burn Token (row-level security only admits the new member afterwards)
+	err = qTx.AcceptTradebookInvitation(ctx, database.AcceptTradebookInvitationParams{
+		UserID:       workosId,
+		InvitationID: inv.ID,
+	})
+	if err != nil {
+		log.Printf("Error accepting invitation: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to accept invitation"})
+		return
+	}
+
+	// 5. Add Member, unless the caller already has a role on this tradebook
+	existing, err := qTx.GetTradebookMember(ctx, database.GetTradebookMemberParams{
+		TradebookID: inv.TradebookID,
+		UserID:      workosId,
+	})
+	switch {
+	case err == sql.ErrNoRows:
+		existing, err = qTx.UpsertTradebookMember(ctx, database.UpsertTradebookMemberParams{
+			TradebookID: inv.TradebookID,
+			UserID:      workosId,
+			Role:        inv.Role,
+		})
+		if err != nil {
+			log.Printf("Error adding member: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add member"})
+			return
+		}
+
+		err = recordAudit(ctx, qTx, auditEvent{
+			TradebookID: inv.TradebookID,
+			ActorID:     workosId,
+			Action:      models.AuditMemberAdd,
+			EntityID:    workosId,
+			After:       toMemberResponse(existing),
+		})
+		if err != nil {
+			log.Printf("Error recording audit event: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add member"})
+			return
+		}
+	case err != nil:
+		log.Printf("Error fetching member: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Transaction commit failed"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"tradebook_id": inv.TradebookID.String(),
+		"role":         models.Role(existing.Role),
+	})
+}
+
+func toInvitationResponse(row database.TradebookInvitation) models.TradebookInvitation {
+	return models.TradebookInvitation{
+		ID:          row.ID.String(),
+		TradebookID: row.TradebookID.String(),
+		Email:       row.Email,
+		Role:        models.Role(row.Role),
+		InvitedBy:   row.InvitedBy,
+		ExpiresAt:   row.ExpiresAt,
+		CreatedAt:   row.CreatedAt,
+	}
+}
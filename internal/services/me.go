@@ -0,0 +1,200 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/workos/workos-go/v6/pkg/usermanagement"
+
+	"tradebooklm-api/internal/database"
+	"tradebooklm-api/internal/helpers"
+	"tradebooklm-api/internal/models"
+)
+
+// syncedProfiles holds the IDs of users whose profile is known to be stored,
+// so SyncUserProfile only reads the users table once per user per instance.
+var syncedProfiles sync.Map
+
+// SyncUserProfile runs after authentication and fetches the caller's profile
+// from WorkOS if it has never been stored, e.g. for users who signed up
+// before the webhook was set up. Failures are logged and the request goes
+// on; the next request retries.
+func SyncUserProfile(c *gin.Context, conn *sql.DB) {
+	workosId, ok := helpers.GetWorkosID(c)
+	if !ok {
+		c.Abort()
+		return
+	}
+
+	if _, ok := syncedProfiles.Load(workosId); ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	q := database.New(conn)
+
+	// 1. Check the Stored Profile
+	user, err := q.GetUser(ctx, workosId)
+	if err != nil && err != sql.ErrNoRows {
+		log.Printf("Error fetching user for profile sync: %v", err)
+		return
+	}
+	if err == nil && user.ProfileUpdatedAt.Valid {
+		syncedProfiles.Store(workosId, struct{}{})
+		return
+	}
+
+	// 2. Fetch from WorkOS
+	profile, err := fetchWorkOSUser(ctx, workosId)
+	if err != nil {
+		log.Printf("Error fetching WorkOS profile for %s: %v", workosId, err)
+		return
+	}
+
+	// 3. Store. A newer profile from a webhook wins.
+	if err := q.UpsertUserProfile(ctx, toUserProfileParams(profile)); err != nil {
+		log.Printf("Error storing profile for %s: %v", workosId, err)
+		return
+	}
+
+	syncedProfiles.Store(workosId, struct{}{})
+}
+
+func fetchWorkOSUser(ctx context.Context, id string) (models.WorkOSUser, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	u, err := usermanagement.GetUser(ctx, usermanagement.GetUserOpts{User: id})
+	if err != nil {
+		return models.WorkOSUser{}, err
+	}
+
+	// WorkOS returns RFC 3339 timestamps as strings here
+	createdAt, _ := time.Parse(time.RFC3339, u.CreatedAt)
+	updatedAt, err := time.Parse(time.RFC3339, u.UpdatedAt)
+	if err != nil {
+		updatedAt = time.Now()
+	}
+
+	return models.WorkOSUser{
+		ID:                u.ID,
+		Email:             u.Email,
+		FirstName:         u.FirstName,
+		LastName:          u.LastName,
+		ProfilePictureURL: u.ProfilePictureURL,
+		CreatedAt:         createdAt,
+		UpdatedAt:         updatedAt,
+	}, nil
+}
+
+func toUserProfileParams(u models.WorkOSUser) database.UpsertUserProfileParams {
+	displayName := strings.TrimSpace(u.FirstName + " " + u.LastName)
+
+	return database.UpsertUserProfileParams{
+		ID:               u.ID,
+		Email:            sql.NullString{String: u.Email, Valid: u.Email != ""},
+		DisplayName:      sql.NullString{String: displayName, Valid: displayName != ""},
+		AvatarUrl:        sql.NullString{String: u.ProfilePictureURL, Valid: u.ProfilePictureURL != ""},
+		ProfileUpdatedAt: sql.NullTime{Time: u.UpdatedAt, Valid: !u.UpdatedAt.IsZero()},
+	}
+}
+
+func GetMe(c *gin.Context, conn *sql.DB) {
+	ctx := c.Request.Context()
+
+	workosId, ok := helpers.GetWorkosID(c)
+	if !ok {
+		return
+	}
+
+	q := database.New(conn)
+
+	user, err := q.GetUser(ctx, workosId)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		log.Printf("Error fetching user: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	sub, err := loadSubscription(ctx, q, workosId)
+	if err != nil {
+		log.Printf("Error fetching subscription: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, toUserResponse(user, sub))
+}
+
+// UpdateMyPreferences merges the body into the stored preferences. Keys set
+// to null are removed; the API doesn't interpret the values.
+func UpdateMyPreferences(c *gin.Context, conn *sql.DB) {
+	ctx := c.Request.Context()
+
+	workosId, ok := helpers.GetWorkosID(c)
+	if !ok {
+		return
+	}
+
+	var prefs map[string]json.RawMessage
+	if err := c.ShouldBindJSON(&prefs); err != nil || prefs == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Preferences must be a JSON object"})
+		return
+	}
+
+	encoded, err := json.Marshal(prefs)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Body"})
+		return
+	}
+
+	q := database.New(conn)
+
+	user, err := q.UpdateUserPreferences(ctx, database.UpdateUserPreferencesParams{
+		Preferences: encoded,
+		ID:          workosId,
+	})
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		log.Printf("Error updating preferences: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update preferences"})
+		return
+	}
+
+	sub, err := loadSubscription(ctx, q, workosId)
+	if err != nil {
+		log.Printf("Error fetching subscription: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, toUserResponse(user, sub))
+}
+
+func toUserResponse(user database.User, sub *models.Subscription) models.User {
+	return models.User{
+		ID:           user.ID,
+		Email:        user.Email.String,
+		DisplayName:  user.DisplayName.String,
+		AvatarURL:    user.AvatarUrl.String,
+		Tier:         subscriptionTier(sub),
+		Preferences:  user.Preferences,
+		CreatedAt:    user.CreatedAt,
+		UpdatedAt:    user.UpdatedAt,
+		Subscription: sub,
+	}
+}
@@ -0,0 +1,332 @@
+package services
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"tradebooklm-api/internal/authz"
+	"tradebooklm-api/internal/database"
+	"tradebooklm-api/internal/helpers"
+	"tradebooklm-api/internal/models"
+)
+
+func ListTradebookMembers(c *gin.Context, conn *sql.DB) {
+	ctx := c.Request.Context()
+
+	tx, ok := helpers.BeginUserTx(c, conn)
+	if !ok {
+		return
+	}
+	defer tx.Rollback()
+
+	q := database.New(tx)
+
+	rows, err := q.ListTradebookMembers(ctx, authz.GetTradebookID(c))
+	if err != nil {
+		log.Printf("Error fetching members: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch data"})
+		return
+	}
+
+	responseList := make([]models.TradebookMember, 0, len(rows))
+	for _, row := range rows {
+		responseList = append(responseList, models.TradebookMember{
+			UserID:      row.UserID,
+			Role:        models.Role(row.Role),
+			JoinedAt:    row.JoinedAt,
+			Email:       row.Email.String,
+			DisplayName: row.DisplayName.String,
+			AvatarURL:   row.AvatarUrl.String,
+		})
+	}
+
+	c.JSON(http.StatusOK, responseList)
+}
+
+func AddTradebookMember(c *gin.Context, conn *sql.DB) {
+	var req models.AddTradebookMemberRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Body"})
+		return
+	}
+
+	setTradebookMemberRole(c, conn, authz.GetTradebookID(c), req.UserID, req.Role, false)
+}
+
+func UpdateTradebookMember(c *gin.Context, conn *sql.DB) {
+	var req models.UpdateTradebookMemberRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Body"})
+		return
+	}
+
+	setTradebookMemberRole(c, conn, authz.GetTradebookID(c), c.Param("userId"), req.Role, true)
+}
+
+func RemoveTradebookMember(c *gin.Context, conn *sql.DB) {
+	ctx := c.Request.Context()
+
+	workosId, ok := helpers.GetWorkosID(c)
+	if !ok {
+		return
+	}
+
+	tbUUID := authz.GetTradebookID(c)
+	targetId := c.Param("userId")
+
+	tx, ok := helpers.BeginUserTx(c, conn)
+	if !ok {
+		return
+	}
+	defer tx.Rollback()
+
+	qTx := database.New(tx)
+
+	target, err := qTx.GetTradebookMember(ctx, database.GetTradebookMemberParams{
+		TradebookID: tbUUID,
+		UserID:      targetId,
+	})
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Member not found"})
+			return
+		}
+		log.Printf("Error fetching member: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	if !guardLastOwner(c, qTx, target) {
+		return
+	}
+
+	_, err = qTx.RemoveTradebookMember(ctx, database.RemoveTradebookMemberParams{
+		TradebookID: tbUUID,
+		UserID:      targetId,
+	})
+	if err != nil {
+		log.Printf("Error removing member: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove member"})
+		return
+	}
+
+	err = recordAudit(ctx, qTx, auditEvent{
+		TradebookID: tbUUID,
+		ActorID:     workosId,
+		Action:      models.AuditMemberRemove,
+		EntityID:    targetId,
+		Before:      toMemberResponse(target),
+	})
+	if err != nil {
+		log.Printf("Error recording audit event: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove member"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Transaction commit failed"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// LeaveTradebook removes the caller from a tradebook. The permission matrix
+// keeps owners out; they have to transfer ownership first.
+func LeaveTradebook(c *gin.Context, conn *sql.DB) {
+	ctx := c.Request.Context()
+
+	workosId, ok := helpers.GetWorkosID(c)
+	if !ok {
+		return
+	}
+
+	tx, ok := helpers.BeginUserTx(c, conn)
+	if !ok {
+		return
+	}
+	defer tx.Rollback()
+
+	q := database.New(tx)
+
+	tbUUID := authz.GetTradebookID(c)
+
+	// 1. Snapshot for the audit log
+	member, err := q.GetTradebookMember(ctx, database.GetTradebookMemberParams{
+		TradebookID: tbUUID,
+		UserID:      workosId,
+	})
+	if err != nil {
+		log.Printf("Error fetching member: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to leave tradebook"})
+		return
+	}
+
+	// 2. Audit (written first; row-level security hides the tradebook once we leave)
+	err = recordAudit(ctx, q, auditEvent{
+		TradebookID: tbUUID,
+		ActorID:     workosId,
+		Action:      models.AuditMemberRemove,
+		EntityID:    workosId,
+		Before:      toMemberResponse(member),
+	})
+	if err != nil {
+		log.Printf("Error recording audit event: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to leave tradebook"})
+		return
+	}
+
+	// 3. Remove Member
+	_, err = q.RemoveTradebookMember(ctx, database.RemoveTradebookMemberParams{
+		TradebookID: tbUUID,
+		UserID:      workosId,
+	})
+	if err != nil {
+		log.Printf("Error leaving tradebook: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to leave tradebook"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Transaction commit failed"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// setTradebookMemberRole adds a member or changes an existing member's role.
+// When mustExist is set the target has to be a member already (PATCH semantics).
+func setTradebookMemberRole(c *gin.Context, conn *sql.DB, tbUUID uuid.UUID, targetId string, role models.Role, mustExist bool) {
+	ctx := c.Request.Context()
+
+	workosId, ok := helpers.GetWorkosID(c)
+	if !ok {
+		return
+	}
+
+	// Ownership is only granted by transfer, never through the members API
+	if role != models.Editor && role != models.Reader {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Role must be editor or reader"})
+		return
+	}
+
+	q := database.New(conn)
+
+	// 1. Target must have an account
+	_, err := q.GetUser(ctx, targetId)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		log.Printf("Error fetching user: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	// 2. Start Transaction
+	tx, ok := helpers.BeginUserTx(c, conn)
+	if !ok {
+		return
+	}
+	defer tx.Rollback()
+
+	qTx := q.WithTx(tx)
+
+	// 3. Guard existing owners against demotion
+	status := http.StatusOK
+	action := models.AuditMemberUpdate
+	var before any
+
+	existing, err := qTx.GetTradebookMember(ctx, database.GetTradebookMemberParams{
+		TradebookID: tbUUID,
+		UserID:      targetId,
+	})
+	switch {
+	case err == sql.ErrNoRows:
+		if mustExist {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Member not found"})
+			return
+		}
+		status = http.StatusCreated
+		action = models.AuditMemberAdd
+	case err != nil:
+		log.Printf("Error fetching member: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	default:
+		if !guardLastOwner(c, qTx, existing) {
+			return
+		}
+		before = toMemberResponse(existing)
+	}
+
+	// 4. Upsert Member
+	member, err := qTx.UpsertTradebookMember(ctx, database.UpsertTradebookMemberParams{
+		TradebookID: tbUUID,
+		UserID:      targetId,
+		Role:        database.TradebookRole(role),
+	})
+	if err != nil {
+		log.Printf("Error upserting member: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update member"})
+		return
+	}
+
+	// 5. Audit
+	err = recordAudit(ctx, qTx, auditEvent{
+		TradebookID: tbUUID,
+		ActorID:     workosId,
+		Action:      action,
+		EntityID:    targetId,
+		Before:      before,
+		After:       toMemberResponse(member),
+	})
+	if err != nil {
+		log.Printf("Error recording audit event: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update member"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Transaction commit failed"})
+		return
+	}
+
+	c.JSON(status, toMemberResponse(member))
+}
+
+// guardLastOwner writes a 409 response and returns false if target is the
+// only remaining owner of its tradebook.
+func guardLastOwner(c *gin.Context, q *database.Queries, target database.TradebookMember) bool {
+	if target.Role != database.TradebookRoleOwner {
+		return true
+	}
+
+	owners, err := q.CountTradebookOwners(c.Request.Context(), target.TradebookID)
+	if err != nil {
+		log.Printf("Error counting owners: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return false
+	}
+
+	if owners <= 1 {
+		c.JSON(http.StatusConflict, gin.H{"error": "A tradebook must keep at least one owner; transfer ownership first"})
+		return false
+	}
+
+	return true
+}
+
+func toMemberResponse(row database.TradebookMember) models.TradebookMember {
+	return models.TradebookMember{
+		UserID:   row.UserID,
+		Role:     models.Role(row.Role),
+		JoinedAt: row.JoinedAt,
+	}
+}
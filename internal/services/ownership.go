@@ -0,0 +1,144 @@
+package services
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"tradebooklm-api/internal/authz"
+	"tradebooklm-api/internal/database"
+	"tradebooklm-api/internal/helpers"
+	"tradebooklm-api/internal/models"
+)
+
+// TransferTradebookOwnership hands a tradebook to an existing member. The
+// owner_id swap, both member rows and the audit record commit together.
+func TransferTradebookOwnership(c *gin.Context, conn *sql.DB) {
+	ctx := c.Request.Context()
+
+	workosId, ok := helpers.GetWorkosID(c)
+	if !ok {
+		return
+	}
+
+	tbUUID := authz.GetTradebookID(c)
+
+	var req models.TransferOwnershipRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Body"})
+		return
+	}
+
+	if req.PreviousOwnerRole == "" {
+		req.PreviousOwnerRole = models.Editor
+	}
+	if req.PreviousOwnerRole != models.Editor && req.PreviousOwnerRole != models.Reader {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Previous owner role must be editor or reader"})
+		return
+	}
+
+	if req.NewOwnerID == workosId {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "You already own this tradebook"})
+		return
+	}
+
+	// 1. Start Transaction
+	tx, ok := helpers.BeginUserTx(c, conn)
+	if !ok {
+		return
+	}
+	defer tx.Rollback()
+
+	qTx := database.New(tx)
+
+	// 2. New owner must already be a member
+	_, err := qTx.GetTradebookMember(ctx, database.GetTradebookMemberParams{
+		TradebookID: tbUUID,
+		UserID:      req.NewOwnerID,
+	})
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "New owner must already be a member of the tradebook"})
+			return
+		}
+		log.Printf("Error fetching member: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	// 3. Swap owner_id (guarded against a concurrent transfer)
+	swapped, err := qTx.TransferTradebookOwnership(ctx, database.TransferTradebookOwnershipParams{
+		NewOwnerID:     req.NewOwnerID,
+		TradebookID:    tbUUID,
+		CurrentOwnerID: workosId,
+	})
+	if err != nil {
+		log.Printf("Error transferring ownership: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to transfer ownership"})
+		return
+	}
+	if swapped == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Tradebook ownership changed concurrently"})
+		return
+	}
+
+	// 4. Update Member Rows
+	_, err = qTx.UpsertTradebookMember(ctx, database.UpsertTradebookMemberParams{
+		TradebookID: tbUUID,
+		UserID:      req.NewOwnerID,
+		Role:        database.TradebookRoleOwner,
+	})
+	if err == nil {
+		_, err = qTx.UpsertTradebookMember(ctx, database.UpsertTradebookMemberParams{
+			TradebookID: tbUUID,
+			UserID:      workosId,
+			Role:        database.TradebookRole(req.PreviousOwnerRole),
+		})
+	}
+	if err != nil {
+		log.Printf("Error updating member roles: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to transfer ownership"})
+		return
+	}
+
+	// 5. Audit Trail
+	transfer, err := qTx.RecordOwnershipTransfer(ctx, database.RecordOwnershipTransferParams{
+		TradebookID: tbUUID,
+		FromUserID:  workosId,
+		ToUserID:    req.NewOwnerID,
+	})
+	if err != nil {
+		log.Printf("Error recording ownership transfer: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to transfer ownership"})
+		return
+	}
+
+	err = recordAudit(ctx, qTx, auditEvent{
+		TradebookID: tbUUID,
+		ActorID:     workosId,
+		Action:      models.AuditTradebookTransfer,
+		EntityID:    tbUUID.String(),
+		Before:      gin.H{"owner_id": workosId},
+		After:       gin.H{"owner_id": req.NewOwnerID, "previous_owner_role": req.PreviousOwnerRole},
+	})
+	if err != nil {
+		log.Printf("Error recording audit event: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to transfer ownership"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Transaction commit failed"})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.OwnershipTransfer{
+		ID:            transfer.ID.String(),
+		TradebookID:   transfer.TradebookID.String(),
+		FromUserID:    transfer.FromUserID,
+		ToUserID:      transfer.ToUserID,
+		TransferredAt: transfer.TransferredAt,
+	})
+}
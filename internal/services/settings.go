@@ -0,0 +1,111 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/sqlc-dev/pqtype"
+
+	"tradebooklm-api/internal/database"
+	"tradebooklm-api/internal/models"
+)
+
+// toUpdateTradebookParams validates a tradebook update and converts it to
+// query params; unset fields stay NULL so the query leaves them unchanged.
+func toUpdateTradebookParams(req models.UpdateTradebookRequest) (database.UpdateTradebookParams, error) {
+	params := database.UpdateTradebookParams{
+		Title: sql.NullString{String: req.Title, Valid: req.Title != ""},
+	}
+
+	settings := req.Settings
+	if settings == nil {
+		return params, nil
+	}
+
+	if settings.Timezone != nil {
+		// LoadLocation takes "" for UTC and "Local" for the server's zone
+		tz := *settings.Timezone
+		if _, err := time.LoadLocation(tz); err != nil || tz == "" || tz == "Local" {
+			return params, fmt.Errorf("unknown timezone %q", tz)
+		}
+		params.Timezone = sql.NullString{String: *settings.Timezone, Valid: true}
+	}
+
+	if settings.BaseCurrency != nil {
+		params.BaseCurrency = sql.NullString{String: strings.ToUpper(*settings.BaseCurrency), Valid: true}
+	}
+
+	if settings.AccountingMethod != nil {
+		params.AccountingMethod = database.NullAccountingMethod{
+			AccountingMethod: database.AccountingMethod(*settings.AccountingMethod),
+			Valid:            true,
+		}
+	}
+
+	if settings.DefaultFees != nil {
+		if settings.DefaultFees.IsNegative() {
+			return params, errors.New("default fees can't be negative")
+		}
+		params.DefaultFees = decimal.NewNullDecimal(*settings.DefaultFees)
+	}
+
+	if settings.FiscalYearStart != nil {
+		params.FiscalYearStart = sql.NullInt32{Int32: *settings.FiscalYearStart, Valid: true}
+	}
+
+	if settings.DefaultAssetClasses != nil {
+		raw, err := json.Marshal(settings.DefaultAssetClasses)
+		if err != nil {
+			return params, err
+		}
+		params.DefaultAssetClasses = pqtype.NullRawMessage{RawMessage: raw, Valid: true}
+	}
+
+	if settings.TagSets != nil {
+		raw, err := json.Marshal(settings.TagSets)
+		if err != nil {
+			return params, err
+		}
+		params.TagSets = pqtype.NullRawMessage{RawMessage: raw, Valid: true}
+	}
+
+	return params, nil
+}
+
+func toSettingsResponse(row database.GetTradebookRow) *models.TradebookSettings {
+	settings := &models.TradebookSettings{
+		Timezone:         row.Timezone,
+		BaseCurrency:     row.BaseCurrency,
+		AccountingMethod: models.AccountingMethod(row.AccountingMethod),
+		DefaultFees:      row.DefaultFees,
+		FiscalYearStart:  row.FiscalYearStart,
+	}
+
+	_ = json.Unmarshal(row.DefaultAssetClasses, &settings.DefaultAssetClasses)
+	_ = json.Unmarshal(row.TagSets, &settings.TagSets)
+
+	return settings
+}
+
+// fiscalYearRange returns the [start, end) instants of a fiscal year in the
+// tradebook's timezone. Fiscal years are named after the calendar year they
+// end in, so with an October start FY2025 runs from October 2024.
+func fiscalYearRange(settings database.GetTradebookSettingsRow, year int) (time.Time, time.Time) {
+	loc, err := time.LoadLocation(settings.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+
+	startYear := year
+	if settings.FiscalYearStart > 1 {
+		startYear--
+	}
+
+	start := time.Date(startYear, time.Month(settings.FiscalYearStart), 1, 0, 0, 0, 0, loc)
+	return start, start.AddDate(1, 0, 0)
+}
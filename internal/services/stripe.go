@@ -1,19 +1,59 @@
 package services
 
 import (
+	"context"
+	"database/sql"
+	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/stripe/stripe-go/v74"
 	"github.com/stripe/stripe-go/v74/checkout/session"
+
+	"tradebooklm-api/internal/database"
+	"tradebooklm-api/internal/helpers"
+	"tradebooklm-api/internal/models"
 )
 
-func StripeCreateCheckoutSessionHandler(c *gin.Context, stripeKey string) {
+// entitledStatuses are the Stripe statuses that keep a subscription's plan.
+// past_due leaves time to fix a failed payment before access drops.
+var entitledStatuses = map[string]bool{
+	"active":   true,
+	"trialing": true,
+	"past_due": true,
+}
+
+// stripePlan returns the tier a configured Stripe price grants.
+func stripePlan(priceID string) (models.SubscriptionTier, bool) {
+	switch {
+	case priceID == "":
+		return "", false
+	case priceID == os.Getenv("STRIPE_PRO_MONTHLY_PRICE_ID"):
+		return models.TierPro, true
+	case priceID == os.Getenv("STRIPE_ULTRA_MONTHLY_PRICE_ID"):
+		return models.TierUltra, true
+	default:
+		return "", false
+	}
+}
+
+// StripeCreateCheckoutSessionHandler starts a subscription checkout. The
+// session and the subscription it creates carry the caller's user ID, so
+// StripeWebHookHandler can match their events to the user.
+func StripeCreateCheckoutSessionHandler(c *gin.Context, conn *sql.DB, stripeKey string) {
+	ctx := c.Request.Context()
 	stripe.Key = stripeKey
 
+	workosId, ok := helpers.GetWorkosID(c)
+	if !ok {
+		return
+	}
+
 	type PlanRequest struct {
 		Plan        string `json:"plan"`
 		RedirectUrl string `json:"redirectUrl"`
@@ -37,6 +77,23 @@ func StripeCreateCheckoutSessionHandler(c *gin.Context, stripeKey string) {
 		return
 	}
 
+	q := database.New(conn)
+
+	// The subscription's rows reference the user
+	user, err := q.UpsertUser(ctx, workosId)
+	if err != nil {
+		log.Printf("Error upserting user: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	customerID, err := q.GetBillingCustomer(ctx, workosId)
+	if err != nil && err != sql.ErrNoRows {
+		log.Printf("Error fetching billing customer: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
 	params := &stripe.CheckoutSessionParams{
 		Mode: stripe.String(string(stripe.CheckoutSessionModeSubscription)),
 		LineItems: []*stripe.CheckoutSessionLineItemParams{
@@ -48,10 +105,19 @@ func StripeCreateCheckoutSessionHandler(c *gin.Context, stripeKey string) {
 		// SuccessURL:        stripe.String(fmt.Sprintf("%s/payment-success?session_id={CHECKOUT_SESSION_ID}", os.Getenv("WEB_URL"))),
 		// CancelURL:         stripe.String(fmt.Sprintf("%s/account?status=cancelled", os.Getenv("WEB_URL"))),
 		// use redirectUrl from request
-		SuccessURL: stripe.String(fmt.Sprintf("%s?session_id={CHECKOUT_SESSION_ID}", req.RedirectUrl)),
-		CancelURL:  stripe.String(fmt.Sprintf("%s?status=cancelled", req.RedirectUrl)),
-		// ClientReferenceID: stripe.String(uid),
-		// CustomerEmail:     stripe.String(user.Email),
+		SuccessURL:        stripe.String(fmt.Sprintf("%s?session_id={CHECKOUT_SESSION_ID}", req.RedirectUrl)),
+		CancelURL:         stripe.String(fmt.Sprintf("%s?status=cancelled", req.RedirectUrl)),
+		ClientReferenceID: stripe.String(workosId),
+		SubscriptionData: &stripe.CheckoutSessionSubscriptionDataParams{
+			Metadata: map[string]string{"user_id": workosId},
+		},
+	}
+
+	// Returning customers keep their Stripe customer and payment methods
+	if customerID != "" {
+		params.Customer = stripe.String(customerID)
+	} else if user.Email.Valid {
+		params.CustomerEmail = stripe.String(user.Email.String)
 	}
 
 	sess, err := session.New(params)
@@ -64,55 +130,223 @@ func StripeCreateCheckoutSessionHandler(c *gin.Context, stripeKey string) {
 	c.JSON(http.StatusOK, gin.H{"sessionId": sess.ID})
 }
 
-func StripeWebHookHandler(c *gin.Context, stripeKey string) {
-	stripe.Key = stripeKey
+// stripeEventHandler applies one event inside the transaction that claimed
+// it, so a failed event is neither applied nor marked as seen.
+type stripeEventHandler func(ctx context.Context, q *database.Queries, event stripe.Event) error
 
-	type PlanRequest struct {
-		Plan        string `json:"plan"`
-		RedirectUrl string `json:"redirectUrl"`
+// stripeEventHandlerFor returns the handler for the events that change state
+// here. Any other event is claimed and acknowledged without effect.
+func stripeEventHandlerFor(eventType string) (stripeEventHandler, bool) {
+	switch {
+	case eventType == "checkout.session.completed":
+		return linkStripeCheckout, true
+	case strings.HasPrefix(eventType, "customer.subscription."):
+		return upsertStripeSubscription, true
+	case strings.HasPrefix(eventType, "invoice."):
+		return recordStripeInvoice, true
+	default:
+		return nil, false
 	}
+}
 
-	var req PlanRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+// StripeWebHookHandler receives every Stripe event; the signature has
+// already been checked by middleware.StripeWebhookMiddleware. As with
+// WorkOSWebhook, events are claimed by ID and any error returns a 5xx so
+// Stripe redelivers later.
+func StripeWebHookHandler(c *gin.Context, conn *sql.DB) {
+	ctx := c.Request.Context()
+
+	var event stripe.Event
+	if err := c.ShouldBindJSON(&event); err != nil || event.ID == "" || event.Data == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid event"})
 		return
 	}
 
-	var priceID string
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Transaction start failed"})
+		return
+	}
+	defer tx.Rollback()
 
-	switch req.Plan {
-	case "pro_monthly":
-		priceID = os.Getenv("STRIPE_PRO_MONTHLY_PRICE_ID")
-	case "ultra_monthly":
-		priceID = os.Getenv("STRIPE_ULTRA_MONTHLY_PRICE_ID")
-	default:
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+	q := database.New(tx)
+
+	// 1. Claim the Event
+	claimed, err := q.ClaimWebhookEvent(ctx, database.ClaimWebhookEventParams{
+		Source:    "stripe",
+		ID:        event.ID,
+		EventType: event.Type,
+	})
+	if err != nil {
+		log.Printf("Error claiming webhook event %s: %v", event.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if claimed == 0 {
+		c.JSON(http.StatusOK, gin.H{"status": "duplicate"})
 		return
 	}
 
-	params := &stripe.CheckoutSessionParams{
-		Mode: stripe.String(string(stripe.CheckoutSessionModeSubscription)),
-		LineItems: []*stripe.CheckoutSessionLineItemParams{
-			{
-				Price:    stripe.String(priceID),
-				Quantity: stripe.Int64(1),
-			},
-		},
-		// SuccessURL:        stripe.String(fmt.Sprintf("%s/payment-success?session_id={CHECKOUT_SESSION_ID}", os.Getenv("WEB_URL"))),
-		// CancelURL:         stripe.String(fmt.Sprintf("%s/account?status=cancelled", os.Getenv("WEB_URL"))),
-		// use redirectUrl from request
-		SuccessURL: stripe.String(fmt.Sprintf("%s?session_id={CHECKOUT_SESSION_ID}", req.RedirectUrl)),
-		CancelURL:  stripe.String(fmt.Sprintf("%s?status=cancelled", req.RedirectUrl)),
-		// ClientReferenceID: stripe.String(uid),
-		// CustomerEmail:     stripe.String(user.Email),
+	// 2. Dispatch
+	status := "ignored"
+	if handler, ok := stripeEventHandlerFor(event.Type); ok {
+		if err := handler(ctx, q, event); err != nil {
+			log.Printf("Error handling Stripe event %s (%s): %v", event.ID, event.Type, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process event"})
+			return
+		}
+		status = "processed"
 	}
 
-	sess, err := session.New(params)
-	if err != nil {
-		log.Printf("Error creating checkout session: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create checkout session"})
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Transaction commit failed"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"sessionId": sess.ID})
+	c.JSON(http.StatusOK, gin.H{"status": status})
+}
+
+// linkStripeCheckout links the checkout's customer to the user who started
+// it, for subscription events that don't carry the user ID.
+func linkStripeCheckout(ctx context.Context, q *database.Queries, event stripe.Event) error {
+	var sess stripe.CheckoutSession
+	if err := decodeStripeObject(event, &sess); err != nil {
+		return err
+	}
+	if sess.Mode != stripe.CheckoutSessionModeSubscription || sess.ClientReferenceID == "" || sess.Customer == nil {
+		return nil
+	}
+
+	_, err := linkStripeCustomer(ctx, q, sess.ClientReferenceID, sess.Customer.ID)
+	return err
+}
+
+func upsertStripeSubscription(ctx context.Context, q *database.Queries, event stripe.Event) error {
+	var sub stripe.Subscription
+	if err := decodeStripeObject(event, &sub); err != nil {
+		return err
+	}
+	if sub.ID == "" || sub.Customer == nil {
+		return fmt.Errorf("%s data has no subscription or customer ID", event.Type)
+	}
+
+	// 1. Find the User, from our checkout's metadata or an earlier checkout
+	userID := sub.Metadata["user_id"]
+	if userID != "" {
+		linked, err := linkStripeCustomer(ctx, q, userID, sub.Customer.ID)
+		if err != nil {
+			return err
+		}
+		if !linked {
+			userID = ""
+		}
+	} else {
+		id, err := q.GetBillingCustomerUser(ctx, sub.Customer.ID)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		userID = id
+	}
+	if userID == "" {
+		log.Printf("WARN: Ignored Stripe subscription %s of unknown customer %s", sub.ID, sub.Customer.ID)
+		return nil
+	}
+
+	// 2. Store. Unknown prices are kept but grant no plan.
+	var priceID string
+	if sub.Items != nil && len(sub.Items.Data) > 0 && sub.Items.Data[0].Price != nil {
+		priceID = sub.Items.Data[0].Price.ID
+	}
+	plan, ok := stripePlan(priceID)
+
+	return q.UpsertSubscription(ctx, database.UpsertSubscriptionParams{
+		ID:                sub.ID,
+		UserID:            userID,
+		StripeCustomerID:  sub.Customer.ID,
+		PriceID:           priceID,
+		Plan:              sql.NullString{String: string(plan), Valid: ok},
+		Status:            string(sub.Status),
+		CurrentPeriodEnd:  time.Unix(sub.CurrentPeriodEnd, 0),
+		CancelAtPeriodEnd: sub.CancelAtPeriodEnd,
+		SyncedAt:          time.Unix(event.Created, 0),
+	})
+}
+
+// recordStripeInvoice stores the latest invoice status of a subscription
+// already known here. Access follows the subscription's own status, which
+// Stripe updates and reports alongside failed or paid invoices.
+func recordStripeInvoice(ctx context.Context, q *database.Queries, event stripe.Event) error {
+	var inv stripe.Invoice
+	if err := decodeStripeObject(event, &inv); err != nil {
+		return err
+	}
+	if inv.Subscription == nil || inv.Subscription.ID == "" {
+		// One-off invoices don't change a plan
+		return nil
+	}
+
+	_, err := q.SetSubscriptionInvoiceStatus(ctx, database.SetSubscriptionInvoiceStatusParams{
+		LatestInvoiceStatus: sql.NullString{String: string(inv.Status), Valid: inv.Status != ""},
+		InvoiceSyncedAt:     sql.NullTime{Time: time.Unix(event.Created, 0), Valid: true},
+		ID:                  inv.Subscription.ID,
+	})
+	return err
+}
+
+// linkStripeCustomer records the user's Stripe customer and reports whether
+// the user exists. Users erased since the checkout aren't recreated.
+func linkStripeCustomer(ctx context.Context, q *database.Queries, userID, customerID string) (bool, error) {
+	if _, err := q.GetUser(ctx, userID); err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, err
+	}
+
+	err := q.UpsertBillingCustomer(ctx, database.UpsertBillingCustomerParams{
+		UserID:           userID,
+		StripeCustomerID: customerID,
+	})
+	return err == nil, err
+}
+
+func decodeStripeObject(event stripe.Event, v any) error {
+	if err := json.Unmarshal(event.Data.Raw, v); err != nil {
+		return fmt.Errorf("decoding %s data: %w", event.Type, err)
+	}
+	return nil
+}
+
+// loadSubscription returns the user's current subscription, or nil if they
+// never subscribed.
+func loadSubscription(ctx context.Context, q *database.Queries, userID string) (*models.Subscription, error) {
+	sub, err := q.GetCurrentSubscription(ctx, userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return toSubscriptionResponse(sub), nil
+}
+
+func toSubscriptionResponse(sub database.Subscription) *models.Subscription {
+	return &models.Subscription{
+		ID:                  sub.ID,
+		Plan:                models.SubscriptionTier(sub.Plan.String),
+		Status:              sub.Status,
+		CurrentPeriodEnd:    sub.CurrentPeriodEnd,
+		CancelAtPeriodEnd:   sub.CancelAtPeriodEnd,
+		LatestInvoiceStatus: sub.LatestInvoiceStatus.String,
+		CreatedAt:           sub.CreatedAt,
+	}
+}
+
+// subscriptionTier is the tier the subscription grants now.
+func subscriptionTier(sub *models.Subscription) models.SubscriptionTier {
+	if sub == nil || sub.Plan == "" || !entitledStatuses[sub.Status] {
+		return models.TierFree
+	}
+	return sub.Plan
 }
@@ -0,0 +1,342 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"tradebooklm-api/internal/database"
+	"tradebooklm-api/internal/helpers"
+	"tradebooklm-api/internal/models"
+)
+
+func CreateTemplate(c *gin.Context, conn *sql.DB) {
+	ctx := c.Request.Context()
+
+	workosId, ok := helpers.GetWorkosID(c)
+	if !ok {
+		return
+	}
+
+	var req models.TradebookTemplateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Body"})
+		return
+	}
+
+	params, err := toTemplateParams(req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	q := database.New(conn)
+
+	// 1. Ensure User Exists
+	if _, err := q.UpsertUser(ctx, workosId); err != nil {
+		log.Printf("Error ensuring user exists: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	tx, ok := helpers.BeginUserTx(c, conn)
+	if !ok {
+		return
+	}
+	defer tx.Rollback()
+
+	// 2. Create Template
+	tmpl, err := q.WithTx(tx).CreateTradebookTemplate(ctx, database.CreateTradebookTemplateParams{
+		OwnerID:             workosId,
+		WorkspaceID:         helpers.GetOrgID(c),
+		Name:                params.Name,
+		IsShared:            params.IsShared,
+		BaseCurrency:        params.BaseCurrency,
+		AccountingMethod:    params.AccountingMethod,
+		DefaultAssetClasses: params.DefaultAssetClasses,
+		TagSets:             params.TagSets,
+		CustomFields:        params.CustomFields,
+	})
+	if err != nil {
+		log.Printf("Error creating template: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create template"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Transaction commit failed"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, toTemplateResponse(tmpl))
+}
+
+// GetTemplates lists the caller's own templates followed by shared ones.
+func GetTemplates(c *gin.Context, conn *sql.DB) {
+	ctx := c.Request.Context()
+
+	workosId, ok := helpers.GetWorkosID(c)
+	if !ok {
+		return
+	}
+
+	tx, ok := helpers.BeginUserTx(c, conn)
+	if !ok {
+		return
+	}
+	defer tx.Rollback()
+
+	rows, err := database.New(tx).ListTradebookTemplates(ctx, database.ListTradebookTemplatesParams{
+		UserID: workosId,
+		OrgID:  helpers.GetOrgID(c),
+	})
+	if err != nil {
+		log.Printf("Error fetching templates: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch data"})
+		return
+	}
+
+	responseList := make([]models.TradebookTemplate, 0, len(rows))
+	for _, row := range rows {
+		responseList = append(responseList, toTemplateResponse(row))
+	}
+
+	c.JSON(http.StatusOK, responseList)
+}
+
+func GetTemplate(c *gin.Context, conn *sql.DB) {
+	ctx := c.Request.Context()
+
+	workosId, ok := helpers.GetWorkosID(c)
+	if !ok {
+		return
+	}
+
+	templateUUID, err := helpers.ParseUUID(c.Param("templateId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid template ID"})
+		return
+	}
+
+	tx, ok := helpers.BeginUserTx(c, conn)
+	if !ok {
+		return
+	}
+	defer tx.Rollback()
+
+	tmpl, err := database.New(tx).GetTradebookTemplate(ctx, database.GetTradebookTemplateParams{
+		TemplateID: templateUUID,
+		UserID:     workosId,
+		OrgID:      helpers.GetOrgID(c),
+	})
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Template not found"})
+			return
+		}
+		log.Printf("Error fetching template: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, toTemplateResponse(tmpl))
+}
+
+// UpdateTemplate replaces a template. Only its owner can change it, and
+// tradebooks already created from it are left as they are.
+func UpdateTemplate(c *gin.Context, conn *sql.DB) {
+	ctx := c.Request.Context()
+
+	workosId, ok := helpers.GetWorkosID(c)
+	if !ok {
+		return
+	}
+
+	templateUUID, err := helpers.ParseUUID(c.Param("templateId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid template ID"})
+		return
+	}
+
+	var req models.TradebookTemplateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Body"})
+		return
+	}
+
+	params, err := toTemplateParams(req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tx, ok := helpers.BeginUserTx(c, conn)
+	if !ok {
+		return
+	}
+	defer tx.Rollback()
+
+	tmpl, err := database.New(tx).UpdateTradebookTemplate(ctx, database.UpdateTradebookTemplateParams{
+		Name:                params.Name,
+		IsShared:            params.IsShared,
+		BaseCurrency:        params.BaseCurrency,
+		AccountingMethod:    params.AccountingMethod,
+		DefaultAssetClasses: params.DefaultAssetClasses,
+		TagSets:             params.TagSets,
+		CustomFields:        params.CustomFields,
+		TemplateID:          templateUUID,
+		OwnerID:             workosId,
+	})
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Template not found"})
+			return
+		}
+		log.Printf("Error updating template: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update template"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Transaction commit failed"})
+		return
+	}
+
+	c.JSON(http.StatusOK, toTemplateResponse(tmpl))
+}
+
+func DeleteTemplate(c *gin.Context, conn *sql.DB) {
+	ctx := c.Request.Context()
+
+	workosId, ok := helpers.GetWorkosID(c)
+	if !ok {
+		return
+	}
+
+	templateUUID, err := helpers.ParseUUID(c.Param("templateId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid template ID"})
+		return
+	}
+
+	tx, ok := helpers.BeginUserTx(c, conn)
+	if !ok {
+		return
+	}
+	defer tx.Rollback()
+
+	deleted, err := database.New(tx).DeleteTradebookTemplate(ctx, database.DeleteTradebookTemplateParams{
+		TemplateID: templateUUID,
+		OwnerID:    workosId,
+	})
+	if err != nil {
+		log.Printf("Error deleting template: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete template"})
+		return
+	}
+
+	if deleted == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Template not found"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Transaction commit failed"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// templateParams is a validated template request with defaults applied and
+// its lists encoded for the JSONB columns.
+type templateParams struct {
+	Name                string
+	IsShared            bool
+	BaseCurrency        string
+	AccountingMethod    database.AccountingMethod
+	DefaultAssetClasses json.RawMessage
+	TagSets             json.RawMessage
+	CustomFields        json.RawMessage
+}
+
+func toTemplateParams(req models.TradebookTemplateRequest) (templateParams, error) {
+	params := templateParams{
+		Name:             strings.TrimSpace(req.Name),
+		IsShared:         req.IsShared,
+		BaseCurrency:     strings.ToUpper(req.BaseCurrency),
+		AccountingMethod: database.AccountingMethod(req.AccountingMethod),
+	}
+
+	if params.Name == "" {
+		return params, fmt.Errorf("Template name is required")
+	}
+	if params.BaseCurrency == "" {
+		params.BaseCurrency = "USD"
+	}
+	if params.AccountingMethod == "" {
+		params.AccountingMethod = database.AccountingMethodFifo
+	}
+
+	// Field names must be unique within a tradebook
+	seen := make(map[string]bool, len(req.CustomFields))
+	for i, field := range req.CustomFields {
+		field.Name = strings.TrimSpace(field.Name)
+		if seen[field.Name] {
+			return params, fmt.Errorf("Duplicate custom field %q", field.Name)
+		}
+		seen[field.Name] = true
+
+		if err := validateCustomField(field.Name, field.Type, field.Options); err != nil {
+			return params, err
+		}
+		req.CustomFields[i] = field
+	}
+
+	var err error
+	if params.DefaultAssetClasses, err = toJSONList(req.DefaultAssetClasses); err != nil {
+		return params, err
+	}
+	if params.TagSets, err = toJSONList(req.TagSets); err != nil {
+		return params, err
+	}
+	if params.CustomFields, err = toJSONList(req.CustomFields); err != nil {
+		return params, err
+	}
+
+	return params, nil
+}
+
+// toJSONList encodes a nil slice as [] so the NOT NULL JSONB columns never hold null.
+func toJSONList[T any](list []T) (json.RawMessage, error) {
+	if list == nil {
+		list = []T{}
+	}
+	return json.Marshal(list)
+}
+
+func toTemplateResponse(t database.TradebookTemplate) models.TradebookTemplate {
+	response := models.TradebookTemplate{
+		ID:               t.ID.String(),
+		OwnerID:          t.OwnerID,
+		WorkspaceID:      t.WorkspaceID.String,
+		Name:             t.Name,
+		IsShared:         t.IsShared,
+		BaseCurrency:     t.BaseCurrency,
+		AccountingMethod: models.AccountingMethod(t.AccountingMethod),
+		CreatedAt:        t.CreatedAt,
+		UpdatedAt:        t.UpdatedAt,
+	}
+
+	// The columns are only ever written by toTemplateParams, so these can't fail
+	_ = json.Unmarshal(t.DefaultAssetClasses, &response.DefaultAssetClasses)
+	_ = json.Unmarshal(t.TagSets, &response.TagSets)
+	_ = json.Unmarshal(t.CustomFields, &response.CustomFields)
+
+	return response
+}
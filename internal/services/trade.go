@@ -2,23 +2,431 @@ package services
 
 import (
 	"database/sql"
+	"encoding/json"
+	"log"
 	"net/http"
+	"strings"
 
 	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+	"github.com/sqlc-dev/pqtype"
+
+	"tradebooklm-api/internal/authz"
+	"tradebooklm-api/internal/database"
+	"tradebooklm-api/internal/helpers"
+	"tradebooklm-api/internal/models"
 )
 
-func CreateTrades(c *gin.Context, db *sql.DB) {
-	c.Status(http.StatusCreated)
+func CreateTrades(c *gin.Context, conn *sql.DB) {
+	ctx := c.Request.Context()
+
+	workosId, ok := helpers.GetWorkosID(c)
+	if !ok {
+		return
+	}
+
+	tbUUID := authz.GetTradebookID(c)
+
+	var req models.AddTradeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Body"})
+		return
+	}
+
+	if !validAmounts(req.EntryQuantity, req.EntryPrice, req.EntryFees) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid quantity, price or fees"})
+		return
+	}
+
+	tx, ok := helpers.BeginUserTx(c, conn)
+	if !ok {
+		return
+	}
+	defer tx.Rollback()
+
+	q := database.New(tx)
+
+	// 1. Apply Tradebook Defaults
+	settings, err := q.GetTradebookSettings(ctx, tbUUID)
+	if err != nil {
+		log.Printf("Error fetching tradebook settings: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	currency := strings.ToUpper(req.Currency)
+	if currency == "" {
+		currency = settings.BaseCurrency
+	}
+
+	// Fees left out are computed from the commission schedule
+	var entryFees decimal.Decimal
+	feesAuto := req.EntryFees == nil
+	if feesAuto {
+		calc, err := loadFeeCalculator(ctx, q, tbUUID)
+		if err != nil {
+			log.Printf("Error fetching commission schedules: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		entryFees = calc.fees(database.AssetClass(req.AssetClass), req.EntryQuantity, req.EntryPrice, false)
+	} else {
+		entryFees = *req.EntryFees
+	}
+
+	// 2. Validate Custom Fields
+	fields, err := loadCustomFields(ctx, q, tbUUID)
+	if err != nil {
+		log.Printf("Error fetching custom fields: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	customValues, err := fields.encodeValues(req.CustomFields, false)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 3. Create Trade
+	trade, err := q.CreateTrade(ctx, database.CreateTradeParams{
+		TradebookID:   tbUUID,
+		AssetClass:    database.AssetClass(req.AssetClass),
+		PurchaseType:  database.TradePurchaseType(req.PurchaseType),
+		OrderType:     database.TradeOrderType(req.OrderType),
+		EntryDate:     req.EntryDate,
+		Symbol:        strings.ToUpper(req.Symbol),
+		Currency:      currency,
+		EntryQuantity: req.EntryQuantity,
+		EntryPrice:    req.EntryPrice,
+		EntryFees:     decimal.NewNullDecimal(entryFees),
+		EntryFeesAuto: feesAuto,
+		CustomFields:  customValues,
+	})
+	if err != nil {
+		log.Printf("Error creating trade: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create trade"})
+		return
+	}
+
+	response := toTradeResponse(trade)
+
+	// 4. Audit
+	err = recordAudit(ctx, q, auditEvent{
+		TradebookID: tbUUID,
+		ActorID:     workosId,
+		Action:      models.AuditTradeCreate,
+		EntityID:    trade.ID.String(),
+		After:       response,
+	})
+	if err != nil {
+		log.Printf("Error recording audit event: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create trade"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Transaction commit failed"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, response)
 }
 
-func GetTrades(c *gin.Context, db *sql.DB) {
-	c.Status(http.StatusOK)
+// GetTrades lists live trades. Filter by custom field with field[<id>]=<value>.
+func GetTrades(c *gin.Context, conn *sql.DB) {
+	ctx := c.Request.Context()
+
+	tbUUID := authz.GetTradebookID(c)
+
+	tx, ok := helpers.BeginUserTx(c, conn)
+	if !ok {
+		return
+	}
+	defer tx.Rollback()
+
+	q := database.New(tx)
+
+	fields, err := loadCustomFields(ctx, q, tbUUID)
+	if err != nil {
+		log.Printf("Error fetching custom fields: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	fieldFilter, ok := fields.filter(c)
+	if !ok {
+		return
+	}
+
+	// Legacy clients get a bare array, paged with ?page=N
+	if !helpers.UseCursorPagination(c) {
+		limit, offset := helpers.GetPaginationParams(c)
+
+		rows, err := q.ListTrades(ctx, database.ListTradesParams{
+			TradebookID:  tbUUID,
+			CustomFields: fieldFilter,
+			LimitVal:     limit,
+			OffsetVal:    offset,
+		})
+		if err != nil {
+			log.Printf("Error fetching trades: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch data"})
+			return
+		}
+
+		responseList := make([]models.Trade, 0, len(rows))
+		for _, row := range rows {
+			responseList = append(responseList, toTradeResponse(row))
+		}
+
+		c.JSON(http.StatusOK, responseList)
+		return
+	}
+
+	// Only valid with the filter it was issued for
+	cursorScope := "trades/" + tbUUID.String() + "?" + string(fieldFilter.RawMessage)
+	limit, cursor, ok := helpers.GetCursorParams(c, cursorScope)
+	if !ok {
+		return
+	}
+
+	// Fetch one extra row to know whether another page exists
+	rows, err := q.ListTradesByCursor(ctx, database.ListTradesByCursorParams{
+		TradebookID:     tbUUID,
+		CustomFields:    fieldFilter,
+		CursorEntryDate: cursor.NullTime(),
+		CursorID:        cursor.NullID(),
+		LimitVal:        limit + 1,
+	})
+	if err != nil {
+		log.Printf("Error fetching trades: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch data"})
+		return
+	}
+
+	hasMore := len(rows) > int(limit)
+	if hasMore {
+		rows = rows[:limit]
+	}
+
+	page := models.Page[models.Trade]{
+		Data:    make([]models.Trade, 0, len(rows)),
+		HasMore: hasMore,
+	}
+
+	for _, row := range rows {
+		page.Data = append(page.Data, toTradeResponse(row))
+	}
+
+	if hasMore {
+		last := rows[len(rows)-1]
+		page.NextCursor = helpers.EncodeCursor(cursorScope, helpers.Cursor{Time: last.EntryDate, ID: last.ID})
+	}
+
+	c.JSON(http.StatusOK, page)
 }
 
-func UpdateTrades(c *gin.Context, db *sql.DB) {
-	c.Status(http.StatusOK)
+func UpdateTrades(c *gin.Context, conn *sql.DB) {
+	ctx := c.Request.Context()
+
+	workosId, ok := helpers.GetWorkosID(c)
+	if !ok {
+		return
+	}
+
+	tbUUID := authz.GetTradebookID(c)
+
+	tradeUUID, err := helpers.ParseUUID(c.Param("tradeId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid trade ID"})
+		return
+	}
+
+	var req models.UpdateTradeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Body"})
+		return
+	}
+
+	tx, ok := helpers.BeginUserTx(c, conn)
+	if !ok {
+		return
+	}
+	defer tx.Rollback()
+
+	q := database.New(tx)
+
+	// 1. Snapshot for the audit log
+	before, err := q.GetTrade(ctx, database.GetTradeParams{
+		TradeID:     tradeUUID,
+		TradebookID: tbUUID,
+	})
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Trade not found"})
+			return
+		}
+		log.Printf("Error fetching trade: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	params := database.UpdateTradeParams{
+		IsOpen:      toNullBool(req.IsOpen),
+		TradeID:     tradeUUID,
+		TradebookID: tbUUID,
+	}
+
+	// 2. Validate Custom Fields
+	if req.CustomFields != nil {
+		fields, err := loadCustomFields(ctx, q, tbUUID)
+		if err != nil {
+			log.Printf("Error fetching custom fields: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+
+		customValues, err := fields.encodeValues(req.CustomFields, true)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		params.CustomFields = pqtype.NullRawMessage{RawMessage: customValues, Valid: true}
+	}
+
+	// 3. Update Trade
+	trade, err := q.UpdateTrade(ctx, params)
+	if err != nil {
+		log.Printf("Error updating trade: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update trade"})
+		return
+	}
+
+	response := toTradeResponse(trade)
+
+	// 4. Audit
+	err = recordAudit(ctx, q, auditEvent{
+		TradebookID: tbUUID,
+		ActorID:     workosId,
+		Action:      models.AuditTradeUpdate,
+		EntityID:    trade.ID.String(),
+		Before:      toTradeResponse(before),
+		After:       response,
+	})
+	if err != nil {
+		log.Printf("Error recording audit event: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update trade"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Transaction commit failed"})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
 }
 
-func DeleteTrades(c *gin.Context, db *sql.DB) {
+// DeleteTrades moves a trade to the trash; see RestoreTrade.
+func DeleteTrades(c *gin.Context, conn *sql.DB) {
+	ctx := c.Request.Context()
+
+	workosId, ok := helpers.GetWorkosID(c)
+	if !ok {
+		return
+	}
+
+	tbUUID := authz.GetTradebookID(c)
+
+	tradeUUID, err := helpers.ParseUUID(c.Param("tradeId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid trade ID"})
+		return
+	}
+
+	tx, ok := helpers.BeginUserTx(c, conn)
+	if !ok {
+		return
+	}
+	defer tx.Rollback()
+
+	q := database.New(tx)
+
+	// 1. Move to Trash
+	trade, err := q.SoftDeleteTrade(ctx, database.SoftDeleteTradeParams{
+		TradeID:     tradeUUID,
+		TradebookID: tbUUID,
+	})
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Trade not found"})
+			return
+		}
+		log.Printf("Error deleting trade: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete"})
+		return
+	}
+
+	// 2. Audit
+	err = recordAudit(ctx, q, auditEvent{
+		TradebookID: tbUUID,
+		ActorID:     workosId,
+		Action:      models.AuditTradeDelete,
+		EntityID:    trade.ID.String(),
+		Before:      toTradeResponse(trade),
+	})
+	if err != nil {
+		log.Printf("Error recording audit event: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Transaction commit failed"})
+		return
+	}
+
 	c.Status(http.StatusNoContent)
 }
+
+// maxAmount bounds quantities, prices and fees to what NUMERIC(19, 8) stores.
+var maxAmount = decimal.New(1, 11)
+
+// validAmounts applies the rules every trade entry and exit leg must meet:
+// a positive quantity and a non-negative price and fees, all within
+// maxAmount. fees is nil when they're left to the commission schedule.
+func validAmounts(quantity, price decimal.Decimal, fees *decimal.Decimal) bool {
+	inRange := func(d decimal.Decimal) bool {
+		return d.Round(8).Abs().LessThan(maxAmount)
+	}
+
+	if !quantity.IsPositive() || price.IsNegative() || !inRange(quantity) || !inRange(price) {
+		return false
+	}
+	return fees == nil || (!fees.IsNegative() && inRange(*fees))
+}
+
+func toTradeResponse(row database.Trade) models.Trade {
+	customValues := map[string]any{}
+	_ = json.Unmarshal(row.CustomFields, &customValues)
+
+	return models.Trade{
+		ID:            row.ID.String(),
+		TradebookID:   row.TradebookID.String(),
+		IsOpen:        row.IsOpen,
+		AssetClass:    models.AssetClass(row.AssetClass),
+		PurchaseType:  models.PurchaseType(row.PurchaseType),
+		OrderType:     models.OrderType(row.OrderType),
+		EntryDate:     row.EntryDate,
+		Symbol:        row.Symbol,
+		Currency:      row.Currency,
+		EntryQuantity: row.EntryQuantity,
+		EntryPrice:    row.EntryPrice,
+		EntryFees:     row.EntryFees.Decimal,
+		ExitLegs:      []*models.ExitLeg{},
+		CustomFields:  customValues,
+		CreatedAt:     row.CreatedAt,
+		UpdatedAt:     row.UpdatedAt,
+	}
+}
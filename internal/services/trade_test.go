@@ -0,0 +1,43 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestValidAmounts(t *testing.T) {
+	d := decimal.RequireFromString
+	fees := func(s string) *decimal.Decimal {
+		f := d(s)
+		return &f
+	}
+
+	tests := []struct {
+		name     string
+		quantity decimal.Decimal
+		price    decimal.Decimal
+		fees     *decimal.Decimal
+		want     bool
+	}{
+		{"valid", d("10"), d("101.25"), fees("1.5"), true},
+		{"fees left to the schedule", d("10"), d("101.25"), nil, true},
+		{"zero price and fees", d("10"), d("0"), fees("0"), true},
+		{"zero quantity", d("0"), d("100"), nil, false},
+		{"negative quantity", d("-1"), d("100"), nil, false},
+		{"negative price", d("10"), d("-0.01"), nil, false},
+		{"negative fees", d("10"), d("100"), fees("-1"), false},
+		{"largest storable quantity", d("99999999999.99999999"), d("1"), nil, true},
+		{"quantity too large", d("100000000000"), d("1"), nil, false},
+		{"price rounding up past the limit", d("1"), d("99999999999.999999999"), nil, false},
+		{"fees too large", d("1"), d("1"), fees("100000000000"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := validAmounts(tt.quantity, tt.price, tt.fees); got != tt.want {
+				t.Errorf("validAmounts(%s, %s, %v) = %v, want %v", tt.quantity, tt.price, tt.fees, got, tt.want)
+			}
+		})
+	}
+}
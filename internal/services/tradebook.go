@@ -2,16 +2,22 @@ package services
 
 import (
 	"database/sql"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
 
 	// Generated package
 	"tradebooklm-api/internal/database"
 
+	"tradebooklm-api/internal/authz"
 	"tradebooklm-api/internal/helpers"
 	"tradebooklm-api/internal/models" // API Response models
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 )
 
 func CreateTradebook(c *gin.Context, conn *sql.DB) {
@@ -22,6 +28,27 @@ func CreateTradebook(c *gin.Context, conn *sql.DB) {
 		return
 	}
 
+	// The body is optional; an empty one creates an untitled book without a template
+	var req models.CreateTradebookRequest
+	if err := c.ShouldBindJSON(&req); err != nil && err != io.EOF {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Body"})
+		return
+	}
+
+	title := strings.TrimSpace(req.Title)
+	if title == "" {
+		title = "Untitled Tradebook"
+	}
+
+	var templateUUID uuid.UUID
+	if req.TemplateID != "" {
+		var err error
+		if templateUUID, err = helpers.ParseUUID(req.TemplateID); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid template ID"})
+			return
+		}
+	}
+
 	q := database.New(conn)
 
 	// 1. Ensure User Exists
@@ -33,32 +60,58 @@ func CreateTradebook(c *gin.Context, conn *sql.DB) {
 	}
 
 	// 2. Start Transaction
-	tx, err := conn.BeginTx(ctx, nil)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Transaction start failed"})
+	tx, ok := helpers.BeginUserTx(c, conn)
+	if !ok {
 		return
 	}
 	defer tx.Rollback()
 
 	qTx := q.WithTx(tx)
 
-	// 3. Create Tradebook (Description ignored as requested)
-	tb, err := qTx.CreateTradebook(ctx, database.CreateTradebookParams{
-		OwnerID: workosId,
-		Title:   "Untitled Tradebook",
-	})
+	// 3. Check the Workspace, if the book is to belong to one
+	if req.WorkspaceID != "" && !requireWorkspaceWriter(c, qTx, req.WorkspaceID, workosId) {
+		return
+	}
+	workspaceID := sql.NullString{String: req.WorkspaceID, Valid: req.WorkspaceID != ""}
+
+	// 4. Create Tradebook, seeding its settings from the template if one was given
+	var tb database.Tradebook
+	if req.TemplateID == "" {
+		tb, err = qTx.CreateTradebook(ctx, database.CreateTradebookParams{
+			OwnerID:     workosId,
+			Title:       title,
+			WorkspaceID: workspaceID,
+		})
+	} else {
+		tb, err = qTx.CreateTradebookFromTemplate(ctx, database.CreateTradebookFromTemplateParams{
+			OwnerID:     workosId,
+			Title:       title,
+			WorkspaceID: workspaceID,
+			TemplateID:  templateUUID,
+			OrgID:       helpers.GetOrgID(c),
+		})
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Template not found"})
+			return
+		}
+		if err == nil {
+			_, err = qTx.CreateCustomFieldsFromTemplate(ctx, database.CreateCustomFieldsFromTemplateParams{
+				TradebookID: tb.ID,
+				TemplateID:  templateUUID,
+			})
+		}
+	}
 	if err != nil {
 		log.Printf("Error creating tradebook: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create tradebook"})
 		return
 	}
 
-	// 4. Add Member (Owner)
+	// 5. Add Member (Owner)
 	_, err = qTx.UpsertTradebookMember(ctx, database.UpsertTradebookMemberParams{
 		TradebookID: tb.ID,
-		NewMemberID: workosId,
+		UserID:      workosId,
 		Role:        database.TradebookRoleOwner,
-		OwnerID:     workosId,
 	})
 	if err != nil {
 		log.Printf("Error adding member: %v", err)
@@ -66,6 +119,27 @@ func CreateTradebook(c *gin.Context, conn *sql.DB) {
 		return
 	}
 
+	// 6. Audit
+	err = recordAudit(ctx, qTx, auditEvent{
+		TradebookID: tb.ID,
+		ActorID:     workosId,
+		Action:      models.AuditTradebookCreate,
+		EntityID:    tb.ID.String(),
+		After: models.Tradebook{
+			ID:          tb.ID.String(),
+			Title:       tb.Title,
+			WorkspaceID: tb.WorkspaceID.String,
+			CreatedAt:   tb.CreatedAt,
+			UpdatedAt:   tb.UpdatedAt,
+			Role:        models.Owner,
+		},
+	})
+	if err != nil {
+		log.Printf("Error recording audit event: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create tradebook"})
+		return
+	}
+
 	if err := tx.Commit(); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Transaction commit failed"})
 		return
@@ -82,18 +156,44 @@ func DeleteTradebook(c *gin.Context, conn *sql.DB) {
 		return
 	}
 
-	tbUUID, err := helpers.ParseUUID(c.Param("tradebookId"))
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid tradebook ID"})
+	tbUUID := authz.GetTradebookID(c)
+
+	tx, ok := helpers.BeginUserTx(c, conn)
+	if !ok {
 		return
 	}
+	defer tx.Rollback()
 
-	q := database.New(conn)
+	q := database.New(tx)
 
-	err = q.DeleteTradebook(ctx, database.DeleteTradebookParams{
+	// 1. Snapshot for the audit log
+	row, err := q.GetTradebook(ctx, database.GetTradebookParams{
 		TradebookID: tbUUID,
 		UserID:      workosId,
+		OrgID:       helpers.GetOrgID(c),
+	})
+	if err != nil {
+		log.Printf("Error fetching tradebook: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete"})
+		return
+	}
+
+	// 2. Audit
+	err = recordAudit(ctx, q, auditEvent{
+		TradebookID: tbUUID,
+		ActorID:     workosId,
+		Action:      models.AuditTradebookDelete,
+		EntityID:    tbUUID.String(),
+		Before:      toTradebookResponse(row),
 	})
+	if err != nil {
+		log.Printf("Error recording audit event: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete"})
+		return
+	}
+
+	// 3. Move to Trash (restorable until the purge)
+	err = q.SoftDeleteTradebook(ctx, tbUUID)
 
 	if err != nil {
 		log.Printf("Error deleting: %v", err)
@@ -101,6 +201,11 @@ func DeleteTradebook(c *gin.Context, conn *sql.DB) {
 		return
 	}
 
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Transaction commit failed"})
+		return
+	}
+
 	c.Status(http.StatusNoContent)
 }
 
@@ -112,9 +217,15 @@ func DeleteTradebooks(c *gin.Context, conn *sql.DB) {
 		return
 	}
 
-	q := database.New(conn)
+	tx, ok := helpers.BeginUserTx(c, conn)
+	if !ok {
+		return
+	}
+	defer tx.Rollback()
 
-	err := q.DeleteAllTradebooks(ctx, workosId)
+	q := database.New(tx)
+
+	err := q.SoftDeleteAllTradebooks(ctx, workosId)
 
 	if err != nil {
 		log.Printf("Error deleting all tradebooks: %v", err)
@@ -122,6 +233,11 @@ func DeleteTradebooks(c *gin.Context, conn *sql.DB) {
 		return
 	}
 
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Transaction commit failed"})
+		return
+	}
+
 	c.Status(http.StatusNoContent)
 }
 
@@ -133,17 +249,18 @@ func GetTradebook(c *gin.Context, conn *sql.DB) {
 		return
 	}
 
-	tbUUID, err := helpers.ParseUUID(c.Param("tradebookId"))
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+	tx, ok := helpers.BeginUserTx(c, conn)
+	if !ok {
 		return
 	}
+	defer tx.Rollback()
 
-	q := database.New(conn)
+	q := database.New(tx)
 
 	row, err := q.GetTradebook(ctx, database.GetTradebookParams{
-		TradebookID: tbUUID,
+		TradebookID: authz.GetTradebookID(c),
 		UserID:      workosId,
+		OrgID:       helpers.GetOrgID(c),
 	})
 
 	if err != nil {
@@ -156,15 +273,7 @@ func GetTradebook(c *gin.Context, conn *sql.DB) {
 		return
 	}
 
-	response := models.Tradebook{
-		ID:        row.ID.String(),
-		Title:     row.Title,
-		Role:      models.Role(row.UserRole),
-		CreatedAt: row.CreatedAt,
-		UpdatedAt: row.UpdatedAt,
-	}
-
-	c.JSON(http.StatusOK, response)
+	c.JSON(http.StatusOK, toTradebookResponse(row))
 }
 
 func GetTradebooks(c *gin.Context, conn *sql.DB) {
@@ -175,14 +284,111 @@ func GetTradebooks(c *gin.Context, conn *sql.DB) {
 		return
 	}
 
+	archived, ok := helpers.GetOptionalBoolQuery(c, "archived")
+	if !ok {
+		return
+	}
+
+	// Archived books stay out of the list unless explicitly requested
+	if !archived.Valid {
+		archived = sql.NullBool{Bool: false, Valid: true}
+	}
+
+	pinned, ok := helpers.GetOptionalBoolQuery(c, "pinned")
+	if !ok {
+		return
+	}
+
+	// Legacy clients get a bare array, paged with ?page=N
+	if !helpers.UseCursorPagination(c) {
+		getTradebooksByOffset(c, conn, workosId, archived, pinned)
+		return
+	}
+
+	// 1. Decode Cursor, which is only valid with the filters it was issued for
+	cursorScope := fmt.Sprintf("tradebooks?archived=%s&pinned=%s", boolParam(archived), boolParam(pinned))
+	limit, cursor, ok := helpers.GetCursorParams(c, cursorScope)
+	if !ok {
+		return
+	}
+
+	tx, ok := helpers.BeginUserTx(c, conn)
+	if !ok {
+		return
+	}
+	defer tx.Rollback()
+
+	q := database.New(tx)
+
+	// 2. Fetch one extra row to know whether another page exists
+	rows, err := q.ListTradebooksByCursor(ctx, database.ListTradebooksByCursorParams{
+		UserID:          workosId,
+		OrgID:           helpers.GetOrgID(c),
+		Archived:        archived,
+		Pinned:          pinned,
+		CursorUpdatedAt: cursor.NullTime(),
+		CursorPinned:    cursor.NullPinned(),
+		CursorID:        cursor.NullID(),
+		LimitVal:        limit + 1,
+	})
+	if err != nil {
+		log.Printf("Error fetching tradebooks: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch data"})
+		return
+	}
+
+	hasMore := len(rows) > int(limit)
+	if hasMore {
+		rows = rows[:limit]
+	}
+
+	// 3. Map to Response
+	page := models.Page[models.Tradebook]{
+		Data:    make([]models.Tradebook, 0, len(rows)),
+		HasMore: hasMore,
+	}
+
+	for _, row := range rows {
+		page.Data = append(page.Data, models.Tradebook{
+			ID:          row.ID.String(),
+			Title:       row.Title,
+			WorkspaceID: row.WorkspaceID.String,
+			Role:        models.Role(row.UserRole),
+			CreatedAt:   row.CreatedAt,
+			UpdatedAt:   row.UpdatedAt,
+			IsPinned:    row.IsPinned,
+			IsArchived:  row.IsArchived,
+		})
+	}
+
+	if hasMore {
+		last := rows[len(rows)-1]
+		page.NextCursor = helpers.EncodeCursor(cursorScope, helpers.Cursor{Time: last.UpdatedAt, ID: last.ID, Pinned: last.IsPinned})
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+func getTradebooksByOffset(c *gin.Context, conn *sql.DB, workosId string, archived, pinned sql.NullBool) {
+	ctx := c.Request.Context()
+
 	// 1. Calculate Pagination
 	limit, offset := helpers.GetPaginationParams(c)
 
-	q := database.New(conn)
+	tx, ok := helpers.BeginUserTx(c, conn)
+	if !ok {
+		return
+	}
+	defer tx.Rollback()
+
+	q := database.New(tx)
 
 	// 2. Fetch with Limit and Offset
 	rows, err := q.ListTradebooks(ctx, database.ListTradebooksParams{
 		UserID:    workosId,
+		OrgID:     helpers.GetOrgID(c),
+		Archived:  archived,
+		Pinned:    pinned,
 		LimitVal:  limit,
 		OffsetVal: offset,
 	})
@@ -197,11 +403,14 @@ func GetTradebooks(c *gin.Context, conn *sql.DB) {
 
 	for _, row := range rows {
 		responseList = append(responseList, models.Tradebook{
-			ID:        row.ID.String(),
-			Title:     row.Title,
-			Role:      models.Role(row.UserRole),
-			CreatedAt: row.CreatedAt,
-			UpdatedAt: row.UpdatedAt,
+			ID:          row.ID.String(),
+			Title:       row.Title,
+			WorkspaceID: row.WorkspaceID.String,
+			Role:        models.Role(row.UserRole),
+			CreatedAt:   row.CreatedAt,
+			UpdatedAt:   row.UpdatedAt,
+			IsPinned:    row.IsPinned,
+			IsArchived:  row.IsArchived,
 		})
 	}
 
@@ -220,11 +429,7 @@ func UpdateTradebook(c *gin.Context, conn *sql.DB) {
 		return
 	}
 
-	tbUUID, err := helpers.ParseUUID(c.Param("tradebookId"))
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
-		return
-	}
+	tbUUID := authz.GetTradebookID(c)
 
 	var req models.UpdateTradebookRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -232,19 +437,42 @@ func UpdateTradebook(c *gin.Context, conn *sql.DB) {
 		return
 	}
 
-	q := database.New(conn)
+	params, err := toUpdateTradebookParams(req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	params.TradebookID = tbUUID
 
-	titleParam := sql.NullString{String: req.Title, Valid: req.Title != ""}
+	tx, ok := helpers.BeginUserTx(c, conn)
+	if !ok {
+		return
+	}
+	defer tx.Rollback()
 
-	_, err = q.UpdateTradebook(ctx, database.UpdateTradebookParams{
-		Title:       titleParam,
+	q := database.New(tx)
+
+	// 1. Snapshot for the audit log
+	beforeRow, err := q.GetTradebook(ctx, database.GetTradebookParams{
 		TradebookID: tbUUID,
 		UserID:      workosId,
+		OrgID:       helpers.GetOrgID(c),
 	})
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Tradebook not found"})
+			return
+		}
+		log.Printf("Error fetching tradebook: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
 
+	// 2. Update
+	_, err = q.UpdateTradebook(ctx, params)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Tradebook not found or permission denied"})
+			c.JSON(http.StatusNotFound, gin.H{"error": "Tradebook not found"})
 			return
 		}
 		log.Printf("Error updating tradebook: %v", err)
@@ -256,6 +484,7 @@ func UpdateTradebook(c *gin.Context, conn *sql.DB) {
 	updatedRow, err := q.GetTradebook(ctx, database.GetTradebookParams{
 		TradebookID: tbUUID,
 		UserID:      workosId,
+		OrgID:       helpers.GetOrgID(c),
 	})
 
 	if err != nil {
@@ -264,13 +493,116 @@ func UpdateTradebook(c *gin.Context, conn *sql.DB) {
 		return
 	}
 
-	c.JSON(http.StatusOK, models.Tradebook{
-		ID:        updatedRow.ID.String(),
-		Title:     updatedRow.Title,
-		CreatedAt: updatedRow.CreatedAt,
-		UpdatedAt: updatedRow.UpdatedAt,
-		Role:      models.Role(updatedRow.UserRole),
+	response := toTradebookResponse(updatedRow)
+
+	// 3. Audit
+	err = recordAudit(ctx, q, auditEvent{
+		TradebookID: tbUUID,
+		ActorID:     workosId,
+		Action:      models.AuditTradebookUpdate,
+		EntityID:    tbUUID.String(),
+		Before:      toTradebookResponse(beforeRow),
+		After:       response,
+	})
+	if err != nil {
+		log.Printf("Error recording audit event: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Transaction commit failed"})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// UpdateTradebookPreferences pins or archives a tradebook in the caller's own
+// list. These are personal view settings, so they are not audited.
+func UpdateTradebookPreferences(c *gin.Context, conn *sql.DB) {
+	ctx := c.Request.Context()
+
+	workosId, ok := helpers.GetWorkosID(c)
+	if !ok {
+		return
+	}
+
+	tbUUID := authz.GetTradebookID(c)
+
+	var req models.UpdateTradebookPreferencesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Body"})
+		return
+	}
+
+	tx, ok := helpers.BeginUserTx(c, conn)
+	if !ok {
+		return
+	}
+	defer tx.Rollback()
+
+	q := database.New(tx)
+
+	// Workspace members may have no member row, so preferences have their own table
+	err := q.UpsertTradebookPreferences(ctx, database.UpsertTradebookPreferencesParams{
+		TradebookID: tbUUID,
+		UserID:      workosId,
+		IsPinned:    toNullBool(req.IsPinned),
+		IsArchived:  toNullBool(req.IsArchived),
 	})
+	if err != nil {
+		log.Printf("Error updating tradebook preferences: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	row, err := q.GetTradebook(ctx, database.GetTradebookParams{
+		TradebookID: tbUUID,
+		UserID:      workosId,
+		OrgID:       helpers.GetOrgID(c),
+	})
+	if err != nil {
+		log.Printf("Error fetching updated tradebook: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Transaction commit failed"})
+		return
+	}
+
+	c.JSON(http.StatusOK, toTradebookResponse(row))
+}
+
+// boolParam formats an optional filter as a query string value; NULL is empty.
+func boolParam(b sql.NullBool) string {
+	if !b.Valid {
+		return ""
+	}
+	return strconv.FormatBool(b.Bool)
+}
+
+func toNullBool(b *bool) sql.NullBool {
+	if b == nil {
+		return sql.NullBool{}
+	}
+	return sql.NullBool{Bool: *b, Valid: true}
+}
+
+func toTradebookResponse(row database.GetTradebookRow) models.Tradebook {
+	return models.Tradebook{
+		ID:          row.ID.String(),
+		Title:       row.Title,
+		WorkspaceID: row.WorkspaceID.String,
+		Role:        models.Role(row.UserRole),
+		CreatedAt:   row.CreatedAt,
+		UpdatedAt:   row.UpdatedAt,
+		IsPinned:    row.IsPinned,
+		IsArchived:  row.IsArchived,
+		Settings:    toSettingsResponse(row),
+	}
 }
 
 func CreateTradebookFirestore(c *gin.Context, conn *sql.DB) {
@@ -292,9 +624,8 @@ func CreateTradebookFirestore(c *gin.Context, conn *sql.DB) {
 	}
 
 	// 2. Start Transaction
-	tx, err := conn.BeginTx(ctx, nil)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Transaction start failed"})
+	tx, ok := helpers.BeginUserTx(c, conn)
+	if !ok {
 		return
 	}
 	defer tx.Rollback()
@@ -315,9 +646,8 @@ func CreateTradebookFirestore(c *gin.Context, conn *sql.DB) {
 	// 4. Add Member (Owner)
 	_, err = qTx.UpsertTradebookMember(ctx, database.UpsertTradebookMemberParams{
 		TradebookID: tb.ID,
-		NewMemberID: workosId,
+		UserID:      workosId,
 		Role:        database.TradebookRoleOwner,
-		OwnerID:     workosId,
 	})
 	if err != nil {
 		log.Printf("Error adding member: %v", err)
@@ -347,19 +677,30 @@ func DeleteTradebookFirestore(c *gin.Context, conn *sql.DB) {
 		return
 	}
 
-	q := database.New(conn)
+	tx, ok := helpers.BeginUserTx(c, conn)
+	if !ok {
+		return
+	}
+	defer tx.Rollback()
 
-	err = q.DeleteTradebook(ctx, database.DeleteTradebookParams{
-		TradebookID: tbUUID,
-		UserID:      workosId,
-	})
+	q := database.New(tx)
 
+	if !requireFirestoreOwner(c, q, workosId, tbUUID) {
+		return
+	}
+
+	err = q.SoftDeleteTradebook(ctx, tbUUID)
 	if err != nil {
 		log.Printf("Error deleting: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete"})
 		return
 	}
 
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Transaction commit failed"})
+		return
+	}
+
 	c.Status(http.StatusNoContent)
 }
 
@@ -371,16 +712,26 @@ func DeleteTradebooksFirestore(c *gin.Context, conn *sql.DB) {
 		return
 	}
 
-	q := database.New(conn)
+	tx, ok := helpers.BeginUserTx(c, conn)
+	if !ok {
+		return
+	}
+	defer tx.Rollback()
 
-	err := q.DeleteAllTradebooks(ctx, workosId)
+	q := database.New(tx)
 
+	err := q.SoftDeleteAllTradebooks(ctx, workosId)
 	if err != nil {
 		log.Printf("Error deleting all tradebooks: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete tradebooks"})
 		return
 	}
 
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Transaction commit failed"})
+		return
+	}
+
 	c.Status(http.StatusNoContent)
 }
 
@@ -398,11 +749,18 @@ func GetTradebookFirestore(c *gin.Context, conn *sql.DB) {
 		return
 	}
 
-	q := database.New(conn)
+	tx, ok := helpers.BeginUserTx(c, conn)
+	if !ok {
+		return
+	}
+	defer tx.Rollback()
+
+	q := database.New(tx)
 
 	row, err := q.GetTradebook(ctx, database.GetTradebookParams{
 		TradebookID: tbUUID,
 		UserID:      workosId,
+		OrgID:       helpers.GetOrgID(c),
 	})
 
 	if err != nil {
@@ -437,11 +795,18 @@ func GetTradebooksFirestore(c *gin.Context, conn *sql.DB) {
 	// 1. Calculate Pagination
 	limit, offset := helpers.GetPaginationParams(c)
 
-	q := database.New(conn)
+	tx, ok := helpers.BeginUserTx(c, conn)
+	if !ok {
+		return
+	}
+	defer tx.Rollback()
+
+	q := database.New(tx)
 
 	// 2. Fetch with Limit and Offset
 	rows, err := q.ListTradebooks(ctx, database.ListTradebooksParams{
 		UserID:    workosId,
+		OrgID:     helpers.GetOrgID(c),
 		LimitVal:  limit,
 		OffsetVal: offset,
 	})
@@ -491,21 +856,25 @@ func UpdateTradebookFirestore(c *gin.Context, conn *sql.DB) {
 		return
 	}
 
-	q := database.New(conn)
+	tx, ok := helpers.BeginUserTx(c, conn)
+	if !ok {
+		return
+	}
+	defer tx.Rollback()
+
+	q := database.New(tx)
+
+	if !requireFirestoreOwner(c, q, workosId, tbUUID) {
+		return
+	}
 
 	titleParam := sql.NullString{String: req.Title, Valid: req.Title != ""}
 
 	_, err = q.UpdateTradebook(ctx, database.UpdateTradebookParams{
 		Title:       titleParam,
 		TradebookID: tbUUID,
-		UserID:      workosId,
 	})
-
 	if err != nil {
-		if err == sql.ErrNoRows {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Tradebook not found or permission denied"})
-			return
-		}
 		log.Printf("Error updating tradebook: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
 		return
@@ -515,14 +884,19 @@ func UpdateTradebookFirestore(c *gin.Context, conn *sql.DB) {
 	updatedRow, err := q.GetTradebook(ctx, database.GetTradebookParams{
 		TradebookID: tbUUID,
 		UserID:      workosId,
+		OrgID:       helpers.GetOrgID(c),
 	})
-
 	if err != nil {
 		log.Printf("Error fetching updated tradebook: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
 		return
 	}
 
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Transaction commit failed"})
+		return
+	}
+
 	c.JSON(http.StatusOK, models.Tradebook{
 		ID:        updatedRow.ID.String(),
 		Title:     updatedRow.Title,
@@ -531,3 +905,23 @@ func UpdateTradebookFirestore(c *gin.Context, conn *sql.DB) {
 		Role:      models.Role(updatedRow.UserRole),
 	})
 }
+
+// requireFirestoreOwner keeps the owner-only rule the Firestore handlers
+// had, since they sit outside the authz middleware.
+func requireFirestoreOwner(c *gin.Context, q *database.Queries, workosId string, tbUUID uuid.UUID) bool {
+	row, err := q.GetTradebookRole(c.Request.Context(), database.GetTradebookRoleParams{
+		UserID:      workosId,
+		OrgID:       helpers.GetOrgID(c),
+		TradebookID: tbUUID,
+	})
+	if err != nil && err != sql.ErrNoRows {
+		log.Printf("Error resolving tradebook role: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return false
+	}
+	if err == sql.ErrNoRows || row.IsDeleted || row.Role != database.TradebookRoleOwner {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Tradebook not found or permission denied"})
+		return false
+	}
+	return true
+}
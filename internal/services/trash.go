@@ -0,0 +1,263 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"tradebooklm-api/internal/authz"
+	"tradebooklm-api/internal/database"
+	"tradebooklm-api/internal/helpers"
+	"tradebooklm-api/internal/models"
+)
+
+const (
+	// trashRetention is how long deleted tradebooks and trades stay restorable.
+	trashRetention     = 30 * 24 * time.Hour
+	trashPurgeInterval = time.Hour
+)
+
+// trashCutoff is the oldest deleted_at that is still restorable.
+func trashCutoff() time.Time {
+	return time.Now().Add(-trashRetention)
+}
+
+func GetTrashedTradebooks(c *gin.Context, conn *sql.DB) {
+	ctx := c.Request.Context()
+
+	workosId, ok := helpers.GetWorkosID(c)
+	if !ok {
+		return
+	}
+
+	tx, ok := helpers.BeginUserTx(c, conn)
+	if !ok {
+		return
+	}
+	defer tx.Rollback()
+
+	q := database.New(tx)
+
+	rows, err := q.ListTrashedTradebooks(ctx, database.ListTrashedTradebooksParams{
+		UserID: workosId,
+		Cutoff: trashCutoff(),
+	})
+	if err != nil {
+		log.Printf("Error fetching trashed tradebooks: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch data"})
+		return
+	}
+
+	responseList := make([]models.TrashedTradebook, 0, len(rows))
+	for _, row := range rows {
+		responseList = append(responseList, models.TrashedTradebook{
+			Tradebook: models.Tradebook{
+				ID:        row.ID.String(),
+				Title:     row.Title,
+				CreatedAt: row.CreatedAt,
+				UpdatedAt: row.UpdatedAt,
+				Role:      models.Owner,
+			},
+			DeletedAt: row.DeletedAt.Time,
+			PurgeAt:   row.DeletedAt.Time.Add(trashRetention),
+		})
+	}
+
+	c.JSON(http.StatusOK, responseList)
+}
+
+func RestoreTradebook(c *gin.Context, conn *sql.DB) {
+	ctx := c.Request.Context()
+
+	workosId, ok := helpers.GetWorkosID(c)
+	if !ok {
+		return
+	}
+
+	tbUUID := authz.GetTradebookID(c)
+
+	tx, ok := helpers.BeginUserTx(c, conn)
+	if !ok {
+		return
+	}
+	defer tx.Rollback()
+
+	q := database.New(tx)
+
+	// 1. Restore (only within the retention window)
+	tb, err := q.RestoreTradebook(ctx, database.RestoreTradebookParams{
+		TradebookID: tbUUID,
+		Cutoff:      trashCutoff(),
+	})
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusGone, gin.H{"error": "Tradebook is past its retention window"})
+			return
+		}
+		log.Printf("Error restoring tradebook: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore tradebook"})
+		return
+	}
+
+	response := models.Tradebook{
+		ID:        tb.ID.String(),
+		Title:     tb.Title,
+		CreatedAt: tb.CreatedAt,
+		UpdatedAt: tb.UpdatedAt,
+		Role:      authz.GetRole(c),
+	}
+
+	// 2. Audit
+	err = recordAudit(ctx, q, auditEvent{
+		TradebookID: tbUUID,
+		ActorID:     workosId,
+		Action:      models.AuditTradebookRestore,
+		EntityID:    tbUUID.String(),
+		After:       response,
+	})
+	if err != nil {
+		log.Printf("Error recording audit event: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore tradebook"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Transaction commit failed"})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+func GetTrashedTrades(c *gin.Context, conn *sql.DB) {
+	ctx := c.Request.Context()
+
+	tx, ok := helpers.BeginUserTx(c, conn)
+	if !ok {
+		return
+	}
+	defer tx.Rollback()
+
+	q := database.New(tx)
+
+	rows, err := q.ListTrashedTrades(ctx, database.ListTrashedTradesParams{
+		TradebookID: authz.GetTradebookID(c),
+		Cutoff:      trashCutoff(),
+	})
+	if err != nil {
+		log.Printf("Error fetching trashed trades: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch data"})
+		return
+	}
+
+	responseList := make([]models.TrashedTrade, 0, len(rows))
+	for _, row := range rows {
+		responseList = append(responseList, models.TrashedTrade{
+			Trade:     toTradeResponse(row),
+			DeletedAt: row.DeletedAt.Time,
+			PurgeAt:   row.DeletedAt.Time.Add(trashRetention),
+		})
+	}
+
+	c.JSON(http.StatusOK, responseList)
+}
+
+func RestoreTrade(c *gin.Context, conn *sql.DB) {
+	ctx := c.Request.Context()
+
+	workosId, ok := helpers.GetWorkosID(c)
+	if !ok {
+		return
+	}
+
+	tbUUID := authz.GetTradebookID(c)
+
+	tradeUUID, err := helpers.ParseUUID(c.Param("tradeId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid trade ID"})
+		return
+	}
+
+	tx, ok := helpers.BeginUserTx(c, conn)
+	if !ok {
+		return
+	}
+	defer tx.Rollback()
+
+	q := database.New(tx)
+
+	// 1. Restore (only within the retention window)
+	trade, err := q.RestoreTrade(ctx, database.RestoreTradeParams{
+		TradeID:     tradeUUID,
+		TradebookID: tbUUID,
+		Cutoff:      trashCutoff(),
+	})
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Trade not found in trash"})
+			return
+		}
+		log.Printf("Error restoring trade: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore trade"})
+		return
+	}
+
+	response := toTradeResponse(trade)
+
+	// 2. Audit
+	err = recordAudit(ctx, q, auditEvent{
+		TradebookID: tbUUID,
+		ActorID:     workosId,
+		Action:      models.AuditTradeRestore,
+		EntityID:    trade.ID.String(),
+		After:       response,
+	})
+	if err != nil {
+		log.Printf("Error recording audit event: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore trade"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Transaction commit failed"})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// RunTrashPurge hard-deletes trash older than the retention window, once at
+// startup and then every trashPurgeInterval, until ctx is cancelled.
+func RunTrashPurge(ctx context.Context, conn *sql.DB) {
+	ticker := time.NewTicker(trashPurgeInterval)
+	defer ticker.Stop()
+
+	for {
+		purgeExpiredTrash(ctx, conn)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func purgeExpiredTrash(ctx context.Context, conn *sql.DB) {
+	q := database.New(conn)
+
+	// purge_expired_trash runs as the table owner, so no user scope is needed
+	purged, err := q.PurgeExpiredTrash(ctx, trashCutoff())
+	if err != nil {
+		log.Printf("Error purging trash: %v", err)
+		return
+	}
+
+	if purged > 0 {
+		log.Printf("Purged %d expired trash items", purged)
+	}
+}
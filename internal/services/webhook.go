@@ -0,0 +1,134 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"tradebooklm-api/internal/database"
+	"tradebooklm-api/internal/models"
+)
+
+// workosEventHandler applies one event inside the transaction that claimed
+// it, so a failed event is neither applied nor marked as seen.
+type workosEventHandler func(ctx context.Context, q *database.Queries, event models.WorkOSEvent) error
+
+// workosEventHandlers lists the events that change state here. Any other
+// event is claimed and acknowledged without effect, so WorkOS doesn't keep
+// retrying it.
+var workosEventHandlers = map[string]workosEventHandler{
+	"user.created": upsertWorkOSUser,
+	"user.updated": upsertWorkOSUser,
+	"user.deleted": deleteWorkOSUser,
+
+	"organization.created": upsertWorkOSOrganization,
+	"organization.updated": upsertWorkOSOrganization,
+	"organization.deleted": deleteWorkOSOrganization,
+
+	"organization_membership.created": upsertWorkOSMembership,
+	"organization_membership.updated": upsertWorkOSMembership,
+	"organization_membership.deleted": deleteWorkOSMembership,
+}
+
+// WorkOSWebhook receives every WorkOS event; the signature has already been
+// checked by middleware.WorkOSWebhookMiddleware. Events are claimed by ID, so
+// a redelivered event is acknowledged without being applied again. Any error
+// returns a 5xx and WorkOS redelivers later.
+func WorkOSWebhook(c *gin.Context, conn *sql.DB) {
+	ctx := c.Request.Context()
+
+	var event models.WorkOSEvent
+	if err := c.ShouldBindJSON(&event); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid event"})
+		return
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Transaction start failed"})
+		return
+	}
+	defer tx.Rollback()
+
+	q := database.New(tx)
+
+	// 1. Claim the Event
+	claimed, err := q.ClaimWebhookEvent(ctx, database.ClaimWebhookEventParams{
+		Source:    "workos",
+		ID:        event.ID,
+		EventType: event.Event,
+	})
+	if err != nil {
+		log.Printf("Error claiming webhook event %s: %v", event.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if claimed == 0 {
+		c.JSON(http.StatusOK, gin.H{"status": "duplicate"})
+		return
+	}
+
+	// 2. Dispatch
+	status := "ignored"
+	if handler, ok := workosEventHandlers[event.Event]; ok {
+		if err := handler(ctx, q, event); err != nil {
+			log.Printf("Error handling WorkOS event %s (%s): %v", event.ID, event.Event, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process event"})
+			return
+		}
+		status = "processed"
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Transaction commit failed"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": status})
+}
+
+func upsertWorkOSUser(ctx context.Context, q *database.Queries, event models.WorkOSEvent) error {
+	user, err := decodeWorkOSUser(event)
+	if err != nil {
+		return err
+	}
+
+	return q.UpsertUserProfile(ctx, toUserProfileParams(user))
+}
+
+// deleteWorkOSUser erases a user deleted from WorkOS. Nobody is left to
+// choose a policy, so shared tradebooks go to their members.
+func deleteWorkOSUser(ctx context.Context, q *database.Queries, event models.WorkOSEvent) error {
+	user, err := decodeWorkOSUser(event)
+	if err != nil {
+		return err
+	}
+	syncedProfiles.Delete(user.ID)
+
+	report, err := eraseUser(ctx, q, user.ID, models.ErasureTransfer)
+	if err == errUserNotFound {
+		// Never signed in here, or already erased through DELETE /account
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Erased user deleted from WorkOS (erasure %s)", report.ID)
+	return nil
+}
+
+func decodeWorkOSUser(event models.WorkOSEvent) (models.WorkOSUser, error) {
+	var user models.WorkOSUser
+	if err := decodeWorkOSData(event, &user); err != nil {
+		return user, err
+	}
+	if user.ID == "" {
+		return user, fmt.Errorf("%s data has no user ID", event.Event)
+	}
+	return user, nil
+}
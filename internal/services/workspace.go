@@ -0,0 +1,403 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/workos/workos-go/v6/pkg/usermanagement"
+
+	"tradebooklm-api/internal/authz"
+	"tradebooklm-api/internal/database"
+	"tradebooklm-api/internal/helpers"
+	"tradebooklm-api/internal/models"
+)
+
+// workspaceRoles maps WorkOS organization role slugs to the role a member
+// gets on every tradebook the workspace owns. Other slugs map to reader.
+var workspaceRoles = map[string]database.TradebookRole{
+	"admin":  database.TradebookRoleOwner,
+	"member": database.TradebookRoleEditor,
+}
+
+func workspaceRole(slug string) database.TradebookRole {
+	if role, ok := workspaceRoles[slug]; ok {
+		return role
+	}
+	return database.TradebookRoleReader
+}
+
+// syncedMemberships holds the "<org>/<user>" pairs SyncWorkspaceMembership
+// has already checked on this instance; webhooks keep them current after.
+var syncedMemberships sync.Map
+
+// SyncWorkspaceMembership runs after authentication for sessions in a WorkOS
+// organization and stores the caller's membership if it hasn't arrived by
+// webhook yet. As with SyncUserProfile, failures are logged and retried on
+// the next request.
+func SyncWorkspaceMembership(c *gin.Context, conn *sql.DB) {
+	orgID := helpers.GetOrgID(c)
+	if !orgID.Valid {
+		return
+	}
+
+	workosId, ok := helpers.GetWorkosID(c)
+	if !ok {
+		c.Abort()
+		return
+	}
+
+	key := orgID.String + "/" + workosId
+	if _, ok := syncedMemberships.Load(key); ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	q := database.New(conn)
+
+	// 1. Check the Stored Membership
+	_, err := q.GetWorkspace(ctx, database.GetWorkspaceParams{
+		WorkspaceID: orgID.String,
+		UserID:      workosId,
+	})
+	if err == nil {
+		syncedMemberships.Store(key, struct{}{})
+		return
+	}
+	if err != sql.ErrNoRows {
+		log.Printf("Error fetching workspace membership: %v", err)
+		return
+	}
+
+	// 2. Fetch from WorkOS
+	fetchCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	res, err := usermanagement.ListOrganizationMemberships(fetchCtx, usermanagement.ListOrganizationMembershipsOpts{
+		OrganizationID: orgID.String,
+		UserID:         workosId,
+		Statuses:       []usermanagement.OrganizationMembershipStatus{usermanagement.Active},
+		Limit:          1,
+	})
+	if err != nil {
+		log.Printf("Error fetching WorkOS membership of %s in %s: %v", workosId, orgID.String, err)
+		return
+	}
+
+	// 3. Store. No active membership means no workspace access, which is
+	// also remembered so WorkOS isn't asked on every request.
+	if len(res.Data) > 0 {
+		m := res.Data[0]
+
+		updatedAt, err := time.Parse(time.RFC3339, m.UpdatedAt)
+		if err != nil {
+			updatedAt = time.Now()
+		}
+
+		membership := models.WorkOSOrganizationMembership{
+			ID:             m.ID,
+			UserID:         m.UserID,
+			OrganizationID: m.OrganizationID,
+			Status:         string(m.Status),
+			UpdatedAt:      updatedAt,
+		}
+		membership.Role.Slug = m.Role.Slug
+
+		err = storeWorkspaceMembership(ctx, q, m.OrganizationName, membership)
+		if err != nil {
+			log.Printf("Error storing membership of %s in %s: %v", workosId, orgID.String, err)
+			return
+		}
+	}
+
+	syncedMemberships.Store(key, struct{}{})
+}
+
+// storeWorkspaceMembership creates the user and workspace rows the
+// membership needs, naming the workspace if name is set.
+func storeWorkspaceMembership(ctx context.Context, q *database.Queries, name string, m models.WorkOSOrganizationMembership) error {
+	if _, err := q.UpsertUser(ctx, m.UserID); err != nil {
+		return err
+	}
+
+	var err error
+	if name != "" {
+		err = q.UpsertWorkspace(ctx, database.UpsertWorkspaceParams{ID: m.OrganizationID, Name: name})
+	} else {
+		err = q.EnsureWorkspace(ctx, m.OrganizationID)
+	}
+	if err != nil {
+		return err
+	}
+
+	return q.UpsertWorkspaceMember(ctx, database.UpsertWorkspaceMemberParams{
+		WorkspaceID: m.OrganizationID,
+		UserID:      m.UserID,
+		Role:        workspaceRole(m.Role.Slug),
+		SyncedAt:    m.UpdatedAt,
+	})
+}
+
+func upsertWorkOSOrganization(ctx context.Context, q *database.Queries, event models.WorkOSEvent) error {
+	var org models.WorkOSOrganization
+	if err := decodeWorkOSData(event, &org); err != nil {
+		return err
+	}
+	if org.ID == "" {
+		return fmt.Errorf("%s data has no organization ID", event.Event)
+	}
+
+	return q.UpsertWorkspace(ctx, database.UpsertWorkspaceParams{ID: org.ID, Name: org.Name})
+}
+
+// deleteWorkOSOrganization removes the workspace and its memberships. Its
+// tradebooks stay with their owners as personal books.
+func deleteWorkOSOrganization(ctx context.Context, q *database.Queries, event models.WorkOSEvent) error {
+	var org models.WorkOSOrganization
+	if err := decodeWorkOSData(event, &org); err != nil {
+		return err
+	}
+
+	_, err := q.DeleteWorkspace(ctx, org.ID)
+	return err
+}
+
+// upsertWorkOSMembership stores active memberships and removes any other,
+// since inactive and pending members get no access.
+func upsertWorkOSMembership(ctx context.Context, q *database.Queries, event models.WorkOSEvent) error {
+	var m models.WorkOSOrganizationMembership
+	if err := decodeWorkOSData(event, &m); err != nil {
+		return err
+	}
+	if m.UserID == "" || m.OrganizationID == "" {
+		return fmt.Errorf("%s data has no user or organization ID", event.Event)
+	}
+
+	if m.Status != string(usermanagement.Active) {
+		return deleteWorkOSMembership(ctx, q, event)
+	}
+
+	return storeWorkspaceMembership(ctx, q, "", m)
+}
+
+func deleteWorkOSMembership(ctx context.Context, q *database.Queries, event models.WorkOSEvent) error {
+	var m models.WorkOSOrganizationMembership
+	if err := decodeWorkOSData(event, &m); err != nil {
+		return err
+	}
+	syncedMemberships.Delete(m.OrganizationID + "/" + m.UserID)
+
+	_, err := q.DeleteWorkspaceMember(ctx, database.DeleteWorkspaceMemberParams{
+		WorkspaceID: m.OrganizationID,
+		UserID:      m.UserID,
+	})
+	return err
+}
+
+func decodeWorkOSData(event models.WorkOSEvent, v any) error {
+	if err := json.Unmarshal(event.Data, v); err != nil {
+		return fmt.Errorf("decoding %s data: %w", event.Event, err)
+	}
+	return nil
+}
+
+// ListWorkspaces returns the workspaces the caller belongs to. Only the
+// current one's tradebooks are reachable in this session.
+func ListWorkspaces(c *gin.Context, conn *sql.DB) {
+	ctx := c.Request.Context()
+
+	workosId, ok := helpers.GetWorkosID(c)
+	if !ok {
+		return
+	}
+
+	q := database.New(conn)
+
+	rows, err := q.ListUserWorkspaces(ctx, workosId)
+	if err != nil {
+		log.Printf("Error fetching workspaces: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch data"})
+		return
+	}
+
+	orgID := helpers.GetOrgID(c)
+	responseList := make([]models.Workspace, 0, len(rows))
+	for _, row := range rows {
+		responseList = append(responseList, models.Workspace{
+			ID:        row.ID,
+			Name:      row.Name,
+			Role:      models.Role(row.Role),
+			IsCurrent: orgID.Valid && row.ID == orgID.String,
+			CreatedAt: row.CreatedAt,
+			JoinedAt:  row.JoinedAt,
+		})
+	}
+
+	c.JSON(http.StatusOK, responseList)
+}
+
+// ListWorkspaceMembers lists the members of the session's workspace.
+func ListWorkspaceMembers(c *gin.Context, conn *sql.DB) {
+	ctx := c.Request.Context()
+
+	orgID := helpers.GetOrgID(c)
+	if !orgID.Valid {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Sign in to a workspace to list its members"})
+		return
+	}
+
+	q := database.New(conn)
+
+	rows, err := q.ListWorkspaceMembers(ctx, orgID.String)
+	if err != nil {
+		log.Printf("Error fetching workspace members: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch data"})
+		return
+	}
+
+	responseList := make([]models.TradebookMember, 0, len(rows))
+	for _, row := range rows {
+		responseList = append(responseList, models.TradebookMember{
+			UserID:      row.UserID,
+			Role:        models.Role(row.Role),
+			JoinedAt:    row.JoinedAt,
+			Email:       row.Email.String,
+			DisplayName: row.DisplayName.String,
+			AvatarURL:   row.AvatarUrl.String,
+		})
+	}
+
+	c.JSON(http.StatusOK, responseList)
+}
+
+// requireWorkspaceWriter checks that workspaceID is the session's workspace
+// and that the caller may add tradebooks to it. On failure a response has
+// already been written.
+func requireWorkspaceWriter(c *gin.Context, q *database.Queries, workspaceID, workosId string) bool {
+	if orgID := helpers.GetOrgID(c); !orgID.Valid || orgID.String != workspaceID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Sign in to the workspace to add tradebooks to it"})
+		return false
+	}
+
+	ws, err := q.GetWorkspace(c.Request.Context(), database.GetWorkspaceParams{
+		WorkspaceID: workspaceID,
+		UserID:      workosId,
+	})
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusForbidden, gin.H{"error": "You are not a member of this workspace"})
+			return false
+		}
+		log.Printf("Error fetching workspace membership: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return false
+	}
+
+	if !authz.Can(models.Role(ws.Role), authz.AddWorkspaceTradebook) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Your workspace role does not permit adding tradebooks"})
+		return false
+	}
+
+	return true
+}
+
+// SetTradebookWorkspace moves a tradebook into the session's workspace, or
+// back out to its owner. Only the recorded owner may do either, so a book
+// never leaves a workspace on the word of another workspace admin.
+func SetTradebookWorkspace(c *gin.Context, conn *sql.DB) {
+	ctx := c.Request.Context()
+
+	workosId, ok := helpers.GetWorkosID(c)
+	if !ok {
+		return
+	}
+
+	tbUUID := authz.GetTradebookID(c)
+
+	var req models.SetTradebookWorkspaceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Body"})
+		return
+	}
+
+	tx, ok := helpers.BeginUserTx(c, conn)
+	if !ok {
+		return
+	}
+	defer tx.Rollback()
+
+	q := database.New(tx)
+
+	// 1. Check the Target Workspace
+	if req.WorkspaceID != "" && !requireWorkspaceWriter(c, q, req.WorkspaceID, workosId) {
+		return
+	}
+
+	beforeRow, err := q.GetTradebook(ctx, database.GetTradebookParams{
+		TradebookID: tbUUID,
+		UserID:      workosId,
+		OrgID:       helpers.GetOrgID(c),
+	})
+	if err != nil {
+		log.Printf("Error fetching tradebook: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	// 2. Move
+	_, err = q.SetTradebookWorkspace(ctx, database.SetTradebookWorkspaceParams{
+		WorkspaceID: sql.NullString{String: req.WorkspaceID, Valid: req.WorkspaceID != ""},
+		TradebookID: tbUUID,
+		OwnerID:     workosId,
+	})
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Only the tradebook's owner can move it"})
+			return
+		}
+		log.Printf("Error moving tradebook: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to move tradebook"})
+		return
+	}
+
+	updatedRow, err := q.GetTradebook(ctx, database.GetTradebookParams{
+		TradebookID: tbUUID,
+		UserID:      workosId,
+		OrgID:       helpers.GetOrgID(c),
+	})
+	if err != nil {
+		log.Printf("Error fetching moved tradebook: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	response := toTradebookResponse(updatedRow)
+
+	// 3. Audit
+	err = recordAudit(ctx, q, auditEvent{
+		TradebookID: tbUUID,
+		ActorID:     workosId,
+		Action:      models.AuditTradebookMove,
+		EntityID:    tbUUID.String(),
+		Before:      toTradebookResponse(beforeRow),
+		After:       response,
+	})
+	if err != nil {
+		log.Printf("Error recording audit event: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Transaction commit failed"})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
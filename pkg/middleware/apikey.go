@@ -0,0 +1,67 @@
+package middleware
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"tradebooklm-api/internal/database"
+	"tradebooklm-api/internal/helpers"
+)
+
+// authenticateAPIKey resolves an API key to its user, the same identity a
+// session of theirs would have, and records what the key may reach for
+// authz to check.
+func authenticateAPIKey(c *gin.Context, db *sql.DB, key string) {
+	ctx := c.Request.Context()
+	q := database.New(db)
+
+	row, err := q.GetActiveAPIKeyByHash(ctx, helpers.HashToken(key))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid, expired or revoked API key"})
+			return
+		}
+		log.Printf("Error looking up API key: %v", err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	access := helpers.APIKeyAccess{ID: row.ID}
+	var tradebookIDs []string
+	if err := json.Unmarshal(row.Scopes, &access.Scopes); err != nil {
+		log.Printf("Error decoding scopes of API key %s: %v", row.ID, err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Invalid API key record"})
+		return
+	}
+	if err := json.Unmarshal(row.TradebookIds, &tradebookIDs); err != nil {
+		log.Printf("Error decoding tradebooks of API key %s: %v", row.ID, err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Invalid API key record"})
+		return
+	}
+	for _, id := range tradebookIDs {
+		parsed, err := uuid.Parse(id)
+		if err != nil {
+			log.Printf("Error decoding tradebooks of API key %s: %v", row.ID, err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Invalid API key record"})
+			return
+		}
+		access.TradebookIDs = append(access.TradebookIDs, parsed)
+	}
+
+	// Usage tracking must not fail the request
+	if err := q.TouchAPIKey(ctx, row.ID); err != nil {
+		log.Printf("Error recording use of API key %s: %v", row.ID, err)
+	}
+
+	helpers.SetPrincipal(c, helpers.Principal{
+		UserID: row.UserID,
+		OrgID:  row.OrgID.String,
+		APIKey: &access,
+	})
+	c.Next()
+}
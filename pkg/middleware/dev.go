@@ -0,0 +1,35 @@
+package middleware
+
+import (
+	"context"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"tradebooklm-api/internal/helpers"
+)
+
+// devProvider accepts HS256 tokens signed with DEV_AUTH_KEY, so the API can
+// run locally without WorkOS. Any holder of the key can sign in as anyone;
+// InitAuth refuses it unless ALLOW_DEV_AUTH=1, and always in release mode.
+type devProvider struct {
+	key []byte
+}
+
+func newDevProvider() *devProvider {
+	return &devProvider{key: []byte(helpers.MustGetenv("DEV_AUTH_KEY"))}
+}
+
+func (p *devProvider) Name() string {
+	return ProviderDev
+}
+
+func (p *devProvider) Authenticate(_ context.Context, token string) (helpers.Principal, error) {
+	claims, err := parseJWT(token, func(*jwt.Token) (any, error) {
+		return p.key, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil {
+		return helpers.Principal{}, err
+	}
+
+	return principalFromClaims(claims)
+}
@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/MicahParks/keyfunc/v2"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// jwksRefreshInterval is how often signing keys are fetched in the
+	// background, so rotated keys are picked up before tokens use them
+	jwksRefreshInterval = time.Hour
+	// jwksRefreshRateLimit bounds refreshes triggered by tokens with an
+	// unknown key ID, which anyone can send
+	jwksRefreshRateLimit = time.Minute
+	jwksRefreshTimeout   = 10 * time.Second
+)
+
+// jwksCache fetches a JWKS on first use rather than at boot, so the API
+// starts while the identity provider is unreachable. Once fetched, keys are
+// refreshed in the background and on unknown key IDs; a failed refresh
+// keeps the keys already cached.
+type jwksCache struct {
+	url string
+
+	refreshInterval  time.Duration
+	refreshRateLimit time.Duration
+
+	once sync.Once
+	jwks *keyfunc.JWKS
+	err  error
+}
+
+func newJWKSCache(url string) *jwksCache {
+	return &jwksCache{
+		url:              url,
+		refreshInterval:  jwksRefreshInterval,
+		refreshRateLimit: jwksRefreshRateLimit,
+	}
+}
+
+// Keyfunc is a jwt.Keyfunc. Until a fetch succeeds every token fails with
+// keyfunc.ErrKIDNotFound and asks for a rate-limited retry. If the first
+// fetch fails, the first token retries at once; should that fail too, the
+// next retry waits out the rate limit, so tokens are rejected for up to
+// jwksRefreshRateLimit after the provider recovers.
+func (k *jwksCache) Keyfunc(token *jwt.Token) (any, error) {
+	k.once.Do(func() {
+		k.jwks, k.err = keyfunc.Get(k.url, keyfunc.Options{
+			RefreshInterval:   k.refreshInterval,
+			RefreshRateLimit:  k.refreshRateLimit,
+			RefreshTimeout:    jwksRefreshTimeout,
+			RefreshUnknownKID: true,
+			RefreshErrorHandler: func(err error) {
+				log.Printf("WARN: Failed to refresh JWKS from %s: %v", k.url, err)
+			},
+			// Start empty rather than fail; the first unknown key ID retries
+			TolerateInitialJWKHTTPError: true,
+		})
+	})
+	if k.err != nil {
+		return nil, k.err
+	}
+
+	return k.jwks.Keyfunc(token)
+}
@@ -0,0 +1,280 @@
+package middleware
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// testRateLimit stands in for jwksRefreshRateLimit, which is too long to wait out.
+const testRateLimit = 300 * time.Millisecond
+
+// jwksServer serves a JWKS whose keys can be rotated and whose responses can
+// be made to fail.
+type jwksServer struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	keys    map[string]*ecdsa.PrivateKey
+	failing bool
+
+	fetches atomic.Int32
+}
+
+func newJWKSServer(t *testing.T) *jwksServer {
+	t.Helper()
+
+	s := &jwksServer{keys: make(map[string]*ecdsa.PrivateKey)}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveJWKS))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *jwksServer) serveJWKS(w http.ResponseWriter, _ *http.Request) {
+	s.fetches.Add(1)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.failing {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	keys := make([]map[string]string, 0, len(s.keys))
+	for kid, key := range s.keys {
+		keys = append(keys, map[string]string{
+			"kty": "EC",
+			"crv": "P-256",
+			"kid": kid,
+			"x":   base64.RawURLEncoding.EncodeToString(key.PublicKey.X.FillBytes(make([]byte, 32))),
+			"y":   base64.RawURLEncoding.EncodeToString(key.PublicKey.Y.FillBytes(make([]byte, 32))),
+		})
+	}
+	_ = json.NewEncoder(w).Encode(map[string]any{"keys": keys})
+}
+
+// addKey publishes a new signing key under kid.
+func (s *jwksServer) addKey(t *testing.T, kid string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[kid] = key
+}
+
+func (s *jwksServer) setFailing(failing bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failing = failing
+}
+
+// sign returns a token signed with the key published under kid, or with a
+// key the server has never published if there is none.
+func (s *jwksServer) sign(t *testing.T, kid string) string {
+	t.Helper()
+
+	s.mu.Lock()
+	key, ok := s.keys[kid]
+	s.mu.Unlock()
+	if !ok {
+		var err error
+		if key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
+		"sub": "user_123",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	})
+	token.Header["kid"] = kid
+
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func newTestJWKSCache(t *testing.T, url string) *jwksCache {
+	t.Helper()
+
+	k := newJWKSCache(url)
+	k.refreshRateLimit = testRateLimit
+	t.Cleanup(func() {
+		if k.jwks != nil {
+			k.jwks.EndBackground()
+		}
+	})
+	return k
+}
+
+func verify(k *jwksCache, token string) error {
+	_, err := jwt.Parse(token, k.Keyfunc)
+	return err
+}
+
+// eventually polls check until it succeeds or timeout passes.
+func eventually(t *testing.T, timeout time.Duration, check func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(timeout)
+	for !check() {
+		if time.Now().After(deadline) {
+			t.Fatalf("condition not met within %v", timeout)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestJWKSCacheFetchesLazily(t *testing.T) {
+	server := newJWKSServer(t)
+	server.addKey(t, "a")
+
+	k := newTestJWKSCache(t, server.URL)
+	if n := server.fetches.Load(); n != 0 {
+		t.Fatalf("fetched %d times before the first token", n)
+	}
+
+	if err := verify(k, server.sign(t, "a")); err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	if err := verify(k, server.sign(t, "a")); err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	if n := server.fetches.Load(); n != 1 {
+		t.Fatalf("fetched %d times, want 1", n)
+	}
+}
+
+func TestJWKSCacheRefreshesOnUnknownKID(t *testing.T) {
+	server := newJWKSServer(t)
+	server.addKey(t, "a")
+
+	k := newTestJWKSCache(t, server.URL)
+	if err := verify(k, server.sign(t, "a")); err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+
+	// Rotate in a new key; the first token using it triggers a refresh
+	server.addKey(t, "b")
+	if err := verify(k, server.sign(t, "b")); err != nil {
+		t.Fatalf("verify with rotated key: %v", err)
+	}
+	if n := server.fetches.Load(); n != 2 {
+		t.Fatalf("fetched %d times, want 2", n)
+	}
+}
+
+func TestJWKSCacheRateLimitsRefreshes(t *testing.T) {
+	server := newJWKSServer(t)
+	server.addKey(t, "a")
+
+	k := newTestJWKSCache(t, server.URL)
+	if err := verify(k, server.sign(t, "a")); err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+
+	// The first unknown key ID refreshes straight away
+	if err := verify(k, server.sign(t, "unknown")); err == nil {
+		t.Fatal("verified a token with an unknown key")
+	}
+	if n := server.fetches.Load(); n != 2 {
+		t.Fatalf("fetched %d times, want 2", n)
+	}
+
+	// Within the rate limit, tokens are rejected without fetching, even
+	// when their key has since been published
+	server.addKey(t, "b")
+	for range 10 {
+		if err := verify(k, server.sign(t, "b")); err == nil {
+			t.Fatal("verified a token before the rate limit allowed a refresh")
+		}
+	}
+	if n := server.fetches.Load(); n != 2 {
+		t.Fatalf("fetched %d times within the rate limit, want 2", n)
+	}
+
+	// Once it passes, the queued refresh picks up the new key
+	eventually(t, 5*testRateLimit, func() bool {
+		return verify(k, server.sign(t, "b")) == nil
+	})
+	if n := server.fetches.Load(); n != 3 {
+		t.Fatalf("fetched %d times, want 3", n)
+	}
+}
+
+func TestJWKSCacheKeepsKeysWhenRefreshFails(t *testing.T) {
+	server := newJWKSServer(t)
+	server.addKey(t, "a")
+
+	k := newTestJWKSCache(t, server.URL)
+	if err := verify(k, server.sign(t, "a")); err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+
+	server.setFailing(true)
+	if err := verify(k, server.sign(t, "unknown")); err == nil {
+		t.Fatal("verified a token with an unknown key")
+	}
+	if n := server.fetches.Load(); n != 2 {
+		t.Fatalf("fetched %d times, want 2", n)
+	}
+
+	if err := verify(k, server.sign(t, "a")); err != nil {
+		t.Fatalf("cached key lost after a failed refresh: %v", err)
+	}
+}
+
+func TestJWKSCacheToleratesFailedFirstFetch(t *testing.T) {
+	server := newJWKSServer(t)
+	server.addKey(t, "a")
+	server.setFailing(true)
+
+	k := newTestJWKSCache(t, server.URL)
+
+	// The first fetch fails and the token's unknown key ID retries at once
+	if err := verify(k, server.sign(t, "a")); err == nil {
+		t.Fatal("verified a token without any keys")
+	}
+	if k.err != nil {
+		t.Fatalf("failed first fetch was not tolerated: %v", k.err)
+	}
+	if n := server.fetches.Load(); n != 2 {
+		t.Fatalf("fetched %d times, want 2", n)
+	}
+
+	// Tokens stay rejected after the provider recovers, until the rate
+	// limit lets the queued retry run
+	start := time.Now()
+	server.setFailing(false)
+	if err := verify(k, server.sign(t, "a")); err == nil {
+		t.Fatal("verified a token before the rate limit allowed a retry")
+	}
+
+	eventually(t, 5*testRateLimit, func() bool {
+		return verify(k, server.sign(t, "a")) == nil
+	})
+	if elapsed := time.Since(start); elapsed < testRateLimit/2 {
+		t.Fatalf("recovered after %v, before the rate limit", elapsed)
+	}
+	if n := server.fetches.Load(); n != 3 {
+		t.Fatalf("fetched %d times, want 3", n)
+	}
+}
@@ -1,18 +1,24 @@
 package middleware
 
 import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"io"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 	"tradebooklm-api/internal/helpers"
 
-	"github.com/MicahParks/keyfunc/v2"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v5"
-	"github.com/workos/workos-go/v6/pkg/usermanagement"
+	"github.com/stripe/stripe-go/v74/webhook"
 )
 
 func CORS() gin.HandlerFunc {
@@ -20,103 +26,137 @@ func CORS() gin.HandlerFunc {
 		AllowOrigins: []string{
 			os.Getenv("TRADEBOOKLM_WEB_URL"),
 		},
-		AllowMethods:     []string{"DELETE", "GET", "PATCH", "POST"},
+		AllowMethods:     []string{"DELETE", "GET", "PATCH", "POST", "PUT"},
 		AllowHeaders:     []string{"Authorization", "Content-Type", "X-Workos-Token"},
 		AllowCredentials: true,
 		MaxAge:           12 * time.Hour,
 	})
 }
 
-var (
-	jwks           *keyfunc.JWKS
-	workosClientID string
-)
-
-func InitAuth() {
-
-	var (
-		workosAPIKey   = helpers.MustGetenv("WORKOS_API_KEY")
-		workosClientID = helpers.MustGetenv("WORKOS_CLIENT_ID")
-	)
-
-	usermanagement.SetAPIKey(workosAPIKey)
-
-	jwksURL, err := usermanagement.GetJWKSURL(workosClientID)
-	if err != nil {
-		log.Fatalf("Failed to get JWKS URL from WorkOS: %v", err)
-	}
-
-	jwks, err = keyfunc.Get(jwksURL.String(), keyfunc.Options{})
-	if err != nil {
-		log.Fatalf("Failed to create JWKS from URL (%s): %v", jwksURL, err)
-	}
-}
-
-func AuthMiddleware() gin.HandlerFunc {
+// AuthMiddleware accepts an API key as "Authorization: Bearer tlm_...", or
+// a session token from the identity provider, either as a bearer token or
+// in X-Workos-Token. Both put a helpers.Principal on the context.
+func AuthMiddleware(db *sql.DB, provider IdentityProvider) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var (
-			workosClientID = helpers.MustGetenv("WORKOS_CLIENT_ID")
-		)
+		bearer, _ := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if strings.HasPrefix(bearer, helpers.APIKeyPrefix) {
+			authenticateAPIKey(c, db, bearer)
+			return
+		}
 
 		tokenString := c.GetHeader("X-Workos-Token")
 		if tokenString == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "X-Workos-Token header is required"})
+			tokenString = bearer
+		}
+		if tokenString == "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "A session token or an API key is required"})
 			c.Abort()
 			return
 		}
 
-		token, err := jwt.Parse(tokenString, jwks.Keyfunc)
+		principal, err := provider.Authenticate(c.Request.Context(), tokenString)
 		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token: " + err.Error()})
 			c.Abort()
 			return
 		}
 
-		if !token.Valid {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
-			c.Abort()
-			return
-		}
+		helpers.SetPrincipal(c, principal)
+		c.Next()
+	}
+}
 
-		claims, ok := token.Claims.(jwt.MapClaims)
-		if !ok {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token claims"})
-			c.Abort()
-			return
-		}
+const (
+	// workosSignatureTolerance bounds how old a signed webhook may be, which
+	// limits replays of a captured delivery
+	workosSignatureTolerance = 3 * time.Minute
+	maxWebhookSize           = 1 << 20
+)
 
-		issuer, _ := claims.GetIssuer()
-		if !strings.EqualFold(issuer, "https://api.workos.com/user_management/"+workosClientID) {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token issuer"})
-			c.Abort()
+// WorkOSWebhookMiddleware rejects requests without a valid WorkOS-Signature.
+// The body is read in full to check it, then restored for the handler.
+func WorkOSWebhookMiddleware() gin.HandlerFunc {
+	var (
+		webhookSecret = helpers.MustGetenv("WORKOS_WEBHOOK_SECRET")
+	)
+
+	return func(c *gin.Context) {
+		body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxWebhookSize))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Failed to read body"})
 			return
 		}
 
-		workosId, err := claims.GetSubject()
-		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token subject (user ID)"})
-			c.Abort()
+		if err := verifyWorkOSSignature(c.GetHeader("WorkOS-Signature"), body, webhookSecret, time.Now()); err != nil {
+			log.Printf("WARN: Rejected WorkOS webhook from %s: %v", c.ClientIP(), err)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid signature"})
 			return
 		}
 
-		c.Set("workos_id", workosId)
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
 		c.Next()
 	}
 }
 
-func WebhookMiddleware() gin.HandlerFunc {
+// verifyWorkOSSignature checks a "t=<unix millis>, v1=<hex>" header, where
+// v1 is the HMAC-SHA256 of "<t>.<body>" keyed with the webhook secret.
+func verifyWorkOSSignature(header string, body []byte, secret string, now time.Time) error {
+	var timestamp, signature string
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signature = value
+		}
+	}
+	if timestamp == "" || signature == "" {
+		return errors.New("missing or malformed signature header")
+	}
+
+	millis, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.New("invalid signature timestamp")
+	}
+	if age := now.Sub(time.UnixMilli(millis)); age > workosSignatureTolerance || age < -workosSignatureTolerance {
+		return errors.New("signature timestamp outside tolerance")
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	expected := hex.EncodeToString(mac.Sum(nil))
+
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return errors.New("signature mismatch")
+	}
+
+	return nil
+}
+
+// StripeWebhookMiddleware rejects requests without a valid Stripe-Signature,
+// checked against STRIPE_WEBHOOK_SECRET with Stripe's default tolerance. The
+// body is restored for the handler as in WorkOSWebhookMiddleware.
+func StripeWebhookMiddleware() gin.HandlerFunc {
 	var (
-		internalSecret = helpers.MustGetenv("INTERNAL_API_SECRET")
+		webhookSecret = helpers.MustGetenv("STRIPE_WEBHOOK_SECRET")
 	)
 
 	return func(c *gin.Context) {
-		providedKey := c.GetHeader("X-Internal-Api-Key")
-		if providedKey != internalSecret {
-			log.Printf("WARN: Unauthorized internal API attempt from %s", c.ClientIP())
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxWebhookSize))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Failed to read body"})
+			return
+		}
+
+		if err := webhook.ValidatePayload(body, c.GetHeader("Stripe-Signature"), webhookSecret); err != nil {
+			log.Printf("WARN: Rejected Stripe webhook from %s: %v", c.ClientIP(), err)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid signature"})
 			return
 		}
 
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
 		c.Next()
 	}
 }
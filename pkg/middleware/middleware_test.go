@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestVerifyWorkOSSignature(t *testing.T) {
+	const secret = "whsec_test"
+	body := []byte(`{"event":"user.updated"}`)
+	now := time.Now()
+
+	sign := func(at time.Time, secret string, body []byte) string {
+		timestamp := strconv.FormatInt(at.UnixMilli(), 10)
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(timestamp + "."))
+		mac.Write(body)
+		return "t=" + timestamp + ", v1=" + hex.EncodeToString(mac.Sum(nil))
+	}
+
+	tests := []struct {
+		name    string
+		header  string
+		wantErr bool
+	}{
+		{"valid", sign(now, secret, body), false},
+		{"within tolerance", sign(now.Add(-workosSignatureTolerance+time.Second), secret, body), false},
+		{"missing header", "", true},
+		{"missing signature", "t=" + strconv.FormatInt(now.UnixMilli(), 10), true},
+		{"invalid timestamp", "t=yesterday, v1=00", true},
+		{"too old", sign(now.Add(-workosSignatureTolerance-time.Second), secret, body), true},
+		{"too far ahead", sign(now.Add(workosSignatureTolerance+time.Second), secret, body), true},
+		{"wrong secret", sign(now, "whsec_other", body), true},
+		{"different body", sign(now, secret, []byte(`{"event":"user.deleted"}`)), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifyWorkOSSignature(tt.header, body, secret, now)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("verifyWorkOSSignature(%q) error = %v, wantErr %v", tt.header, err, tt.wantErr)
+			}
+		})
+	}
+}
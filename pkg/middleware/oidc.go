@@ -0,0 +1,137 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"tradebooklm-api/internal/helpers"
+)
+
+// oidcProvider verifies tokens from any OpenID Connect issuer. Its JWKS is
+// found through issuer discovery on the first authenticated request.
+type oidcProvider struct {
+	issuer   string // As configured, e.g. with the trailing slash Auth0 uses
+	audience string
+
+	mu          sync.Mutex
+	jwks        *jwksCache
+	lastAttempt time.Time
+}
+
+func newOIDCProvider() *oidcProvider {
+	var (
+		issuer   = helpers.MustGetenv("OIDC_ISSUER")
+		audience = helpers.MustGetenv("OIDC_AUDIENCE")
+	)
+
+	return &oidcProvider{
+		issuer:   issuer,
+		audience: audience,
+	}
+}
+
+func (p *oidcProvider) Name() string {
+	return ProviderOIDC
+}
+
+func (p *oidcProvider) Authenticate(ctx context.Context, token string) (helpers.Principal, error) {
+	jwks, err := p.keys(ctx)
+	if err != nil {
+		return helpers.Principal{}, err
+	}
+
+	claims, err := parseJWT(token, jwks.Keyfunc, jwt.WithAudience(p.audience))
+	if err != nil {
+		return helpers.Principal{}, err
+	}
+
+	// Issuers are compared without a trailing slash, which providers don't
+	// apply consistently between their configuration and their tokens
+	if issuer, _ := claims.GetIssuer(); !sameIssuer(issuer, p.issuer) {
+		return helpers.Principal{}, errors.New("invalid token issuer")
+	}
+
+	// The parser only checks exp when present; OIDC ID and access tokens
+	// always expire
+	if exp, _ := claims.GetExpirationTime(); exp == nil {
+		return helpers.Principal{}, errors.New("token has no expiry")
+	}
+
+	return principalFromClaims(claims)
+}
+
+// keys runs issuer discovery once it succeeds, retrying failures at most
+// once per jwksRefreshRateLimit.
+func (p *oidcProvider) keys(ctx context.Context) (*jwksCache, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.jwks != nil {
+		return p.jwks, nil
+	}
+	if time.Since(p.lastAttempt) < jwksRefreshRateLimit {
+		return nil, errors.New("identity provider discovery unavailable")
+	}
+	p.lastAttempt = time.Now()
+
+	jwksURL, err := discoverJWKSURL(ctx, p.issuer)
+	if err != nil {
+		return nil, fmt.Errorf("identity provider discovery failed: %w", err)
+	}
+
+	p.jwks = newJWKSCache(jwksURL)
+	return p.jwks, nil
+}
+
+// discoverJWKSURL reads the issuer's OpenID configuration, which must name
+// the same issuer.
+func discoverJWKSURL(ctx context.Context, issuer string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, jwksRefreshTimeout)
+	defer cancel()
+
+	discoveryURL := strings.TrimSuffix(issuer, "/") + "/.well-known/openid-configuration"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, discoveryURL, nil)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	var config struct {
+		Issuer  string `json:"issuer"`
+		JWKSURI string `json:"jwks_uri"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&config); err != nil {
+		return "", err
+	}
+
+	if !sameIssuer(config.Issuer, issuer) {
+		return "", fmt.Errorf("configuration names issuer %q", config.Issuer)
+	}
+	if config.JWKSURI == "" {
+		return "", errors.New("configuration has no jwks_uri")
+	}
+
+	return config.JWKSURI, nil
+}
+
+// sameIssuer compares issuer identifiers, ignoring a trailing slash.
+func sameIssuer(a, b string) bool {
+	return a != "" && strings.TrimSuffix(a, "/") == strings.TrimSuffix(b, "/")
+}
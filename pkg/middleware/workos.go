@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+
+	"github.com/workos/workos-go/v6/pkg/usermanagement"
+
+	"tradebooklm-api/internal/helpers"
+)
+
+// workosProvider verifies WorkOS AuthKit access tokens. It also configures
+// the WorkOS SDK, which profile and membership syncing rely on.
+type workosProvider struct {
+	issuer string
+	jwks   *jwksCache
+}
+
+func newWorkOSProvider() *workosProvider {
+	var (
+		workosAPIKey   = helpers.MustGetenv("WORKOS_API_KEY")
+		workosClientID = helpers.MustGetenv("WORKOS_CLIENT_ID")
+	)
+
+	usermanagement.SetAPIKey(workosAPIKey)
+
+	jwksURL, err := usermanagement.GetJWKSURL(workosClientID)
+	if err != nil {
+		log.Fatalf("Failed to get JWKS URL from WorkOS: %v", err)
+	}
+
+	return &workosProvider{
+		issuer: "https://api.workos.com/user_management/" + workosClientID,
+		jwks:   newJWKSCache(jwksURL.String()),
+	}
+}
+
+func (p *workosProvider) Name() string {
+	return ProviderWorkOS
+}
+
+func (p *workosProvider) Authenticate(_ context.Context, token string) (helpers.Principal, error) {
+	claims, err := parseJWT(token, p.jwks.Keyfunc)
+	if err != nil {
+		return helpers.Principal{}, err
+	}
+
+	issuer, _ := claims.GetIssuer()
+	if !strings.EqualFold(issuer, p.issuer) {
+		return helpers.Principal{}, errors.New("invalid token issuer")
+	}
+
+	return principalFromClaims(claims)
+}
@@ -13,81 +13,255 @@ RETURNING *;
 SELECT * FROM users
 WHERE id = @id;
 
+-- name: UpsertUserProfile :exec
+-- Skips profiles older than the stored one, since webhooks can arrive out of order
+INSERT INTO users (id, email, display_name, avatar_url, profile_updated_at)
+VALUES (@id, @email, @display_name, @avatar_url, @profile_updated_at)
+ON CONFLICT (id) DO UPDATE
+SET
+    email = EXCLUDED.email,
+    display_name = EXCLUDED.display_name,
+    avatar_url = EXCLUDED.avatar_url,
+    profile_updated_at = EXCLUDED.profile_updated_at,
+    updated_at = NOW()
+WHERE users.profile_updated_at IS NULL
+    OR users.profile_updated_at <= EXCLUDED.profile_updated_at;
+
+-- name: UpdateUserPreferences :one
+-- Merges into the stored preferences; a null value removes the key
+UPDATE users
+SET
+    preferences = jsonb_strip_nulls(preferences || @preferences::jsonb),
+    updated_at = NOW()
+WHERE id = @id
+RETURNING *;
+
 -- ============================================================================
 -- 2. TRADEBOOKS
 -- ============================================================================
 
 -- name: CreateTradebook :one
-INSERT INTO tradebooks (owner_id, title)
-VALUES (@owner_id, @title)
+INSERT INTO tradebooks (owner_id, title, workspace_id)
+VALUES (@owner_id, @title, sqlc.narg('workspace_id'))
+RETURNING *;
+
+-- name: CreateTradebookFromTemplate :one
+-- Seeds the settings from a template the owner can see (their own or one
+-- shared in the session's workspace)
+INSERT INTO tradebooks (
+    owner_id, title, workspace_id, template_id,
+    base_currency, accounting_method, default_asset_classes, tag_sets
+)
+SELECT
+    @owner_id::text, @title::text, sqlc.narg('workspace_id')::text, t.id,
+    t.base_currency, t.accounting_method, t.default_asset_classes, t.tag_sets
+FROM tradebook_templates t
+WHERE t.id = @template_id
+    AND (t.owner_id = @owner_id OR (t.is_shared AND t.workspace_id = sqlc.narg('org_id')))
+RETURNING *;
+
+-- name: CreateTradebookCopy :one
+-- Copies the settings of an existing tradebook into a new one
+INSERT INTO tradebooks (
+    owner_id, title, template_id,
+    base_currency, accounting_method, default_asset_classes, tag_sets,
+    timezone, default_fees, fiscal_year_start
+)
+SELECT
+    @owner_id::text, @title::text, tb.template_id,
+    tb.base_currency, tb.accounting_method, tb.default_asset_classes, tb.tag_sets,
+    tb.timezone, tb.default_fees, tb.fiscal_year_start
+FROM tradebooks tb
+WHERE tb.id = @source_tradebook_id
 RETURNING *;
 
 -- name: ListTradebooks :many
 SELECT
-    tb.id, tb.owner_id, tb.title, tb.created_at, tb.updated_at,
-    COALESCE(tm.role, 'owner')::tradebook_role AS user_role
+    tb.id, tb.owner_id, tb.workspace_id, tb.title, tb.created_at, tb.updated_at,
+    (CASE WHEN tb.owner_id = @user_id THEN 'owner' ELSE LEAST(tm.role, wm.role) END)::tradebook_role AS user_role,
+    COALESCE(tp.is_pinned, FALSE)::boolean AS is_pinned,
+    COALESCE(tp.is_archived, FALSE)::boolean AS is_archived
 FROM tradebooks tb
 LEFT JOIN tradebook_members tm
     ON tb.id = tm.tradebook_id AND tm.user_id = @user_id
-WHERE tb.owner_id = @user_id
-    OR tm.user_id = @user_id
-ORDER BY tb.updated_at DESC
+LEFT JOIN tradebook_preferences tp
+    ON tb.id = tp.tradebook_id AND tp.user_id = @user_id
+LEFT JOIN workspace_members wm
+    ON wm.workspace_id = tb.workspace_id AND wm.workspace_id = sqlc.narg('org_id') AND wm.user_id = @user_id
+WHERE (tb.owner_id = @user_id OR tm.user_id IS NOT NULL OR wm.user_id IS NOT NULL)
+    AND tb.deleted_at IS NULL
+    AND (sqlc.narg('archived')::boolean IS NULL OR COALESCE(tp.is_archived, FALSE) = sqlc.narg('archived')::boolean)
+    AND (sqlc.narg('pinned')::boolean IS NULL OR COALESCE(tp.is_pinned, FALSE) = sqlc.narg('pinned')::boolean)
+ORDER BY is_pinned DESC, tb.updated_at DESC
 LIMIT @limit_val OFFSET @offset_val;
 
+-- name: ListTradebooksByCursor :many
+-- Keyset pagination on (is_pinned, updated_at, id) so pinned books sort first;
+-- a NULL cursor starts from the top and NULL flag filters match everything
+SELECT
+    tb.id, tb.owner_id, tb.workspace_id, tb.title, tb.created_at, tb.updated_at,
+    (CASE WHEN tb.owner_id = @user_id THEN 'owner' ELSE LEAST(tm.role, wm.role) END)::tradebook_role AS user_role,
+    COALESCE(tp.is_pinned, FALSE)::boolean AS is_pinned,
+    COALESCE(tp.is_archived, FALSE)::boolean AS is_archived
+FROM tradebooks tb
+LEFT JOIN tradebook_members tm
+    ON tb.id = tm.tradebook_id AND tm.user_id = @user_id
+LEFT JOIN tradebook_preferences tp
+    ON tb.id = tp.tradebook_id AND tp.user_id = @user_id
+LEFT JOIN workspace_members wm
+    ON wm.workspace_id = tb.workspace_id AND wm.workspace_id = sqlc.narg('org_id') AND wm.user_id = @user_id
+WHERE (tb.owner_id = @user_id OR tm.user_id IS NOT NULL OR wm.user_id IS NOT NULL)
+    AND tb.deleted_at IS NULL
+    AND (sqlc.narg('archived')::boolean IS NULL OR COALESCE(tp.is_archived, FALSE) = sqlc.narg('archived')::boolean)
+    AND (sqlc.narg('pinned')::boolean IS NULL OR COALESCE(tp.is_pinned, FALSE) = sqlc.narg('pinned')::boolean)
+    AND (
+        sqlc.narg('cursor_updated_at')::timestamptz IS NULL
+        OR (COALESCE(tp.is_pinned, FALSE), tb.updated_at, tb.id)
+            < (sqlc.narg('cursor_pinned')::boolean, sqlc.narg('cursor_updated_at')::timestamptz, sqlc.narg('cursor_id')::uuid)
+    )
+ORDER BY is_pinned DESC, tb.updated_at DESC, tb.id DESC
+LIMIT @limit_val;
+
 -- name: GetTradebook :one
 SELECT
     tb.*,
-    COALESCE(tm.role, 'owner')::tradebook_role AS user_role
+    (CASE WHEN tb.owner_id = @user_id THEN 'owner' ELSE LEAST(tm.role, wm.role) END)::tradebook_role AS user_role,
+    COALESCE(tp.is_pinned, FALSE)::boolean AS is_pinned,
+    COALESCE(tp.is_archived, FALSE)::boolean AS is_archived
 FROM tradebooks tb
 LEFT JOIN tradebook_members tm
     ON tb.id = tm.tradebook_id AND tm.user_id = @user_id
+LEFT JOIN tradebook_preferences tp
+    ON tb.id = tp.tradebook_id AND tp.user_id = @user_id
+LEFT JOIN workspace_members wm
+    ON wm.workspace_id = tb.workspace_id AND wm.workspace_id = sqlc.narg('org_id') AND wm.user_id = @user_id
 WHERE tb.id = @tradebook_id
-    AND (tb.owner_id = @user_id OR tm.user_id IS NOT NULL);
+    AND (tb.owner_id = @user_id OR tm.user_id IS NOT NULL OR wm.user_id IS NOT NULL)
+    AND tb.deleted_at IS NULL;
 
 -- name: UpdateTradebook :one
 UPDATE tradebooks
 SET
     title = COALESCE(sqlc.narg('title'), title),
+    timezone = COALESCE(sqlc.narg('timezone'), timezone),
+    base_currency = COALESCE(sqlc.narg('base_currency'), base_currency),
+    accounting_method = COALESCE(sqlc.narg('accounting_method'), accounting_method),
+    default_fees = COALESCE(sqlc.narg('default_fees'), default_fees),
+    fiscal_year_start = COALESCE(sqlc.narg('fiscal_year_start'), fiscal_year_start),
+    default_asset_classes = COALESCE(sqlc.narg('default_asset_classes')::jsonb, default_asset_classes),
+    tag_sets = COALESCE(sqlc.narg('tag_sets')::jsonb, tag_sets),
     updated_at = NOW()
 WHERE id = @tradebook_id
-    AND owner_id = @user_id
+    AND deleted_at IS NULL
 RETURNING *;
 
--- name: DeleteTradebook :exec
-DELETE FROM tradebooks
-WHERE id = @tradebook_id AND owner_id = @user_id;
+-- name: GetTradebookSettings :one
+-- Everything trade and report calculations need; access is checked by the caller
+SELECT timezone, base_currency, accounting_method, default_fees, fiscal_year_start
+FROM tradebooks
+WHERE id = @tradebook_id;
+
+-- name: SoftDeleteTradebook :exec
+-- Moves the tradebook to the trash; its trades stay untouched until the purge
+UPDATE tradebooks
+SET deleted_at = NOW()
+WHERE id = @tradebook_id
+    AND deleted_at IS NULL;
+
+-- name: SoftDeleteAllTradebooks :exec
+UPDATE tradebooks
+SET deleted_at = NOW()
+WHERE owner_id = @user_id
+    AND deleted_at IS NULL;
 
--- name: DeleteAllTradebooks :exec
-DELETE FROM tradebooks
-WHERE owner_id = @user_id;
+-- name: ListTrashedTradebooks :many
+SELECT * FROM tradebooks
+WHERE owner_id = @user_id
+    AND deleted_at > @cutoff::timestamptz
+ORDER BY deleted_at DESC;
+
+-- name: RestoreTradebook :one
+UPDATE tradebooks
+SET deleted_at = NULL
+WHERE id = @tradebook_id
+    AND deleted_at > @cutoff::timestamptz
+RETURNING *;
+
+-- name: TransferTradebookOwnership :execrows
+UPDATE tradebooks
+SET
+    owner_id = @new_owner_id,
+    updated_at = NOW()
+WHERE id = @tradebook_id
+    AND owner_id = @current_owner_id;
+
+-- name: RecordOwnershipTransfer :one
+INSERT INTO tradebook_ownership_transfers (tradebook_id, from_user_id, to_user_id)
+VALUES (@tradebook_id, @from_user_id, @to_user_id)
+RETURNING *;
 
 -- ============================================================================
 -- 3. MEMBERS (Refactored to use UPSERT)
 -- ============================================================================
 
 -- name: UpsertTradebookMember :one
-WITH authorized_check AS (
-    -- Only proceed if the @owner_id is the actual owner of the tradebook
-    SELECT 1
-    FROM tradebooks
-    WHERE id = @tradebook_id AND owner_id = @owner_id
-)
+-- Permission checks live in internal/authz; queries below trust @tradebook_id
 INSERT INTO tradebook_members (tradebook_id, user_id, role)
-SELECT @tradebook_id, @new_member_id, @role
-FROM authorized_check
+VALUES (@tradebook_id, @user_id, @role)
 ON CONFLICT (tradebook_id, user_id) DO UPDATE
 SET
     role = EXCLUDED.role
 RETURNING *;
 
--- name: RemoveTradebookMember :exec
+-- name: GetTradebookRole :one
+-- Resolves the caller's effective role, the stronger of their role on the
+-- book and, in a session of the book's workspace, their workspace role; no
+-- row means no access
+SELECT
+    (CASE WHEN tb.owner_id = @user_id THEN 'owner' ELSE LEAST(tm.role, wm.role) END)::tradebook_role AS role,
+    (tb.deleted_at IS NOT NULL)::boolean AS is_deleted
+FROM tradebooks tb
+LEFT JOIN tradebook_members tm
+    ON tb.id = tm.tradebook_id AND tm.user_id = @user_id
+LEFT JOIN workspace_members wm
+    ON wm.workspace_id = tb.workspace_id AND wm.workspace_id = sqlc.narg('org_id') AND wm.user_id = @user_id
+WHERE tb.id = @tradebook_id
+    AND (tb.owner_id = @user_id OR tm.user_id IS NOT NULL OR wm.user_id IS NOT NULL);
+
+-- name: RemoveTradebookMember :execrows
 DELETE FROM tradebook_members
 WHERE tradebook_id = @tradebook_id
-    AND user_id = @target_user_id
-    AND EXISTS (
-        SELECT 1 FROM tradebooks
-        WHERE id = @tradebook_id AND owner_id = @owner_id
-    );
+    AND user_id = @user_id;
+
+-- name: ListTradebookMembers :many
+SELECT
+    m.user_id, m.role, m.joined_at,
+    u.email, u.display_name, u.avatar_url
+FROM tradebook_members m
+JOIN users u ON u.id = m.user_id
+WHERE m.tradebook_id = @tradebook_id
+ORDER BY m.joined_at ASC;
+
+-- name: GetTradebookMember :one
+SELECT * FROM tradebook_members
+WHERE tradebook_id = @tradebook_id AND user_id = @user_id;
+
+-- name: UpsertTradebookPreferences :exec
+-- Only touches the caller's own row; NULL leaves a flag unchanged
+INSERT INTO tradebook_preferences (tradebook_id, user_id, is_pinned, is_archived)
+VALUES (
+    @tradebook_id, @user_id,
+    COALESCE(sqlc.narg('is_pinned')::boolean, FALSE),
+    COALESCE(sqlc.narg('is_archived')::boolean, FALSE)
+)
+ON CONFLICT (tradebook_id, user_id) DO UPDATE
+SET
+    is_pinned = COALESCE(sqlc.narg('is_pinned')::boolean, tradebook_preferences.is_pinned),
+    is_archived = COALESCE(sqlc.narg('is_archived')::boolean, tradebook_preferences.is_archived);
+
+-- name: CountTradebookOwners :one
+SELECT COUNT(*) FROM tradebook_members
+WHERE tradebook_id = @tradebook_id AND role = 'owner';
 
 -- ============================================================================
 -- 4. TRADES
@@ -96,57 +270,95 @@ WHERE tradebook_id = @tradebook_id
 -- name: CreateTrade :one
 INSERT INTO trades (
     tradebook_id, asset_class, purchase_type, order_type,
-    entry_date, symbol, currency, entry_quantity, entry_price, entry_fees
-)
-SELECT
+    entry_date, symbol, currency, entry_quantity, entry_price, entry_fees, entry_fees_auto, custom_fields
+) VALUES (
     @tradebook_id, @asset_class, @purchase_type, @order_type,
-    @entry_date, @symbol, @currency, @entry_quantity, @entry_price, @entry_fees
-WHERE EXISTS (
-    SELECT 1 FROM tradebooks tb
-    LEFT JOIN tradebook_members tm ON tb.id = tm.tradebook_id
-    WHERE tb.id = @tradebook_id
-    AND (
-        tb.owner_id = @user_id
-        OR (tm.user_id = @user_id AND tm.role IN ('owner', 'editor'))
-    )
+    @entry_date, @symbol, @currency, @entry_quantity, @entry_price, @entry_fees, @entry_fees_auto, @custom_fields
 )
 RETURNING *;
 
 -- name: ListTrades :many
-SELECT t.* FROM trades t
-JOIN tradebooks tb ON t.tradebook_id = tb.id
-LEFT JOIN tradebook_members tm ON tb.id = tm.tradebook_id
-WHERE t.tradebook_id = @tradebook_id
-    AND (tb.owner_id = @user_id OR tm.user_id = @user_id)
-ORDER BY t.entry_date DESC
+SELECT * FROM trades
+WHERE tradebook_id = @tradebook_id
+    AND deleted_at IS NULL
+    AND (sqlc.narg('custom_fields')::jsonb IS NULL OR custom_fields @> sqlc.narg('custom_fields')::jsonb)
+ORDER BY entry_date DESC
 LIMIT @limit_val OFFSET @offset_val;
 
+-- name: ListTradesByCursor :many
+-- Keyset pagination on (entry_date, id); a NULL cursor starts from the newest.
+-- custom_fields filters by containment, e.g. {"<field id>": "breakout"}
+SELECT * FROM trades
+WHERE tradebook_id = @tradebook_id
+    AND deleted_at IS NULL
+    AND (sqlc.narg('custom_fields')::jsonb IS NULL OR custom_fields @> sqlc.narg('custom_fields')::jsonb)
+    AND (
+        sqlc.narg('cursor_entry_date')::timestamptz IS NULL
+        OR (entry_date, id) < (sqlc.narg('cursor_entry_date')::timestamptz, sqlc.narg('cursor_id')::uuid)
+    )
+ORDER BY entry_date DESC, id DESC
+LIMIT @limit_val;
+
 -- name: GetTrade :one
-SELECT t.* FROM trades t
-JOIN tradebooks tb ON t.tradebook_id = tb.id
-LEFT JOIN tradebook_members tm ON tb.id = tm.tradebook_id
-WHERE t.id = @trade_id
-    AND (tb.owner_id = @user_id OR tm.user_id = @user_id);
+SELECT * FROM trades
+WHERE id = @trade_id AND tradebook_id = @tradebook_id
+    AND deleted_at IS NULL;
 
 -- name: UpdateTrade :one
+-- custom_fields is merged into the existing values; a null value removes that field
 UPDATE trades
 SET
     is_open = COALESCE(sqlc.narg('is_open'), is_open),
+    custom_fields = jsonb_strip_nulls(custom_fields || COALESCE(sqlc.narg('custom_fields')::jsonb, '{}'::jsonb)),
     updated_at = NOW()
-FROM tradebooks tb
-LEFT JOIN tradebook_members tm ON tb.id = tm.tradebook_id
-WHERE trades.id = @trade_id
-    AND trades.tradebook_id = tb.id
-    AND (tb.owner_id = @user_id OR (tm.user_id = @user_id AND tm.role IN ('owner', 'editor')))
-RETURNING trades.*;
+WHERE id = @trade_id
+    AND tradebook_id = @tradebook_id
+    AND deleted_at IS NULL
+RETURNING *;
+
+-- name: SoftDeleteTrade :one
+UPDATE trades
+SET deleted_at = NOW()
+WHERE id = @trade_id
+    AND tradebook_id = @tradebook_id
+    AND deleted_at IS NULL
+RETURNING *;
+
+-- name: ListTrashedTrades :many
+SELECT * FROM trades
+WHERE tradebook_id = @tradebook_id
+    AND deleted_at > @cutoff::timestamptz
+ORDER BY deleted_at DESC;
 
--- name: DeleteTrade :exec
-DELETE FROM trades
-USING tradebooks tb, tradebook_members tm
-WHERE trades.id = @trade_id
-    AND trades.tradebook_id = tb.id
-    AND tb.id = tm.tradebook_id
-    AND (tb.owner_id = @user_id OR (tm.user_id = @user_id AND tm.role IN ('owner', 'editor')));
+-- name: RestoreTrade :one
+UPDATE trades
+SET deleted_at = NULL
+WHERE id = @trade_id
+    AND tradebook_id = @tradebook_id
+    AND deleted_at > @cutoff::timestamptz
+RETURNING *;
+
+-- name: CloneTrades :execrows
+-- Copies the live trades of one tradebook into another. Run after CloneCustomFields:
+-- custom field values are re-keyed to the target's definitions by field name
+INSERT INTO trades (
+    tradebook_id, cloned_from_id, is_open, asset_class, purchase_type, order_type,
+    entry_date, symbol, currency, entry_quantity, entry_price, entry_fees, entry_fees_auto, custom_fields
+)
+SELECT
+    @target_tradebook_id::uuid, t.id, t.is_open, t.asset_class, t.purchase_type, t.order_type,
+    t.entry_date, t.symbol, t.currency, t.entry_quantity, t.entry_price, t.entry_fees, t.entry_fees_auto,
+    (
+        SELECT COALESCE(jsonb_object_agg(target_field.id::text, kv.value), '{}'::jsonb)
+        FROM jsonb_each(t.custom_fields) kv
+        JOIN custom_field_definitions source_field ON source_field.id::text = kv.key
+        JOIN custom_field_definitions target_field
+            ON target_field.tradebook_id = @target_tradebook_id::uuid
+            AND target_field.name = source_field.name
+    )
+FROM trades t
+WHERE t.tradebook_id = @source_tradebook_id
+    AND t.deleted_at IS NULL;
 
 -- ============================================================================
 -- 5. EXIT LEGS
@@ -157,17 +369,16 @@ WHERE trades.id = @trade_id
 SELECT COUNT(*) FROM exit_legs WHERE trade_id = @trade_id;
 
 -- name: AddExitLeg :one
--- Security: Verifies ownership AND enforces max 100 exit legs per trade (DB Level Safety Net)
+-- Security: Scopes the trade to its tradebook AND enforces max 100 exit legs per trade (DB Level Safety Net)
 INSERT INTO exit_legs (
-    trade_id, exit_date, exit_quantity, exit_price, exit_fees
+    trade_id, exit_date, exit_quantity, exit_price, exit_fees, exit_fees_auto
 )
 SELECT
-    @trade_id, @exit_date, @exit_quantity, @exit_price, @exit_fees
+    @trade_id, @exit_date, @exit_quantity, @exit_price, @exit_fees, @exit_fees_auto
 FROM trades t
-JOIN tradebooks tb ON t.tradebook_id = tb.id
-LEFT JOIN tradebook_members tm ON tb.id = tm.tradebook_id
 WHERE t.id = @trade_id
-    AND (tb.owner_id = @user_id OR (tm.user_id = @user_id AND tm.role IN ('owner', 'editor')))
+    AND t.tradebook_id = @tradebook_id
+    AND t.deleted_at IS NULL
     -- LIMIT CHECK: Ensure we haven't hit 100 legs yet
     AND (SELECT COUNT(*) FROM exit_legs WHERE trade_id = @trade_id) < 100
 RETURNING *;
@@ -175,25 +386,69 @@ RETURNING *;
 -- name: ListExitLegs :many
 SELECT el.* FROM exit_legs el
 JOIN trades t ON el.trade_id = t.id
-JOIN tradebooks tb ON t.tradebook_id = tb.id
-LEFT JOIN tradebook_members tm ON tb.id = tm.tradebook_id
 WHERE t.id = @trade_id
-    AND (tb.owner_id = @user_id OR tm.user_id = @user_id)
+    AND t.tradebook_id = @tradebook_id
+    AND t.deleted_at IS NULL
 ORDER BY el.exit_date ASC;
 
+-- name: CloneExitLegs :execrows
+-- Copies exit legs onto trades created by CloneTrades, matched via cloned_from_id
+INSERT INTO exit_legs (trade_id, exit_date, exit_quantity, exit_price, exit_fees, exit_fees_auto)
+SELECT t.id, el.exit_date, el.exit_quantity, el.exit_price, el.exit_fees, el.exit_fees_auto
+FROM trades t
+JOIN exit_legs el ON el.trade_id = t.cloned_from_id
+WHERE t.tradebook_id = @tradebook_id;
+
 -- ============================================================================
 -- 6. DASHBOARD
 -- ============================================================================
 
 -- name: GetOpenPositions :many
-SELECT t.*
+SELECT * FROM trades
+WHERE tradebook_id = @tradebook_id
+    AND is_open = TRUE
+    AND deleted_at IS NULL
+ORDER BY entry_date DESC;
+
+-- name: ListEntryLots :many
+-- Each live trade's entry is one lot for exits to be matched against
+SELECT id, symbol, entry_date, entry_quantity, entry_price FROM trades
+WHERE tradebook_id = @tradebook_id
+    AND deleted_at IS NULL
+ORDER BY entry_date ASC, id ASC;
+
+-- name: ListExitsForLotMatching :many
+SELECT el.trade_id, t.symbol, t.entry_price, el.exit_date, el.exit_quantity, el.exit_price, el.exit_fees
+FROM exit_legs el
+JOIN trades t ON el.trade_id = t.id
+WHERE t.tradebook_id = @tradebook_id
+    AND t.deleted_at IS NULL
+ORDER BY el.exit_date ASC, el.id ASC;
+
+-- name: GetTradeBreakdown :many
+-- Groups live trades by one custom field's value; trades without it fall in the NULL group.
+-- Realized P&L is matched to lots by the caller, per the tradebook's accounting
+-- method, and passed in as {"<trade_id>": "<pnl>"}
+SELECT
+    (t.custom_fields -> @field_id::text) AS value,
+    COUNT(*)::bigint AS trade_count,
+    COUNT(*) FILTER (WHERE t.is_open)::bigint AS open_count,
+    COALESCE(SUM(t.entry_quantity * t.entry_price), 0)::numeric AS entry_notional,
+    COALESCE(SUM(COALESCE(t.entry_fees, 0) + exits.fees), 0)::numeric AS total_fees,
+    COALESCE(SUM((@realized_pnl::jsonb ->> t.id::text)::numeric), 0)::numeric AS realized_pnl
 FROM trades t
-JOIN tradebooks tb ON t.tradebook_id = tb.id
-LEFT JOIN tradebook_members tm ON tb.id = tm.tradebook_id
+CROSS JOIN LATERAL (
+    SELECT COALESCE(SUM(COALESCE(el.exit_fees, 0)), 0) AS fees
+    FROM exit_legs el
+    WHERE el.trade_id = t.id
+) exits
 WHERE t.tradebook_id = @tradebook_id
-    AND t.is_open = TRUE
-    AND (tb.owner_id = @user_id OR tm.user_id = @user_id)
-ORDER BY t.entry_date DESC;
+    AND t.deleted_at IS NULL
+    AND (sqlc.narg('custom_fields')::jsonb IS NULL OR t.custom_fields @> sqlc.narg('custom_fields')::jsonb)
+    AND (sqlc.narg('entry_from')::timestamptz IS NULL OR t.entry_date >= sqlc.narg('entry_from')::timestamptz)
+    AND (sqlc.narg('entry_to')::timestamptz IS NULL OR t.entry_date < sqlc.narg('entry_to')::timestamptz)
+GROUP BY 1
+ORDER BY trade_count DESC;
 
 -- ============================================================================
 -- 7. METERING
@@ -205,3 +460,629 @@ INSERT INTO token_usage_log (
 ) VALUES (
     @user_id, @model_name, @prompt_tokens, @completion_tokens, @total_tokens, @cost
 );
+
+-- ============================================================================
+-- 8. INVITATIONS
+-- ============================================================================
+
+-- name: CreateTradebookInvitation :one
+INSERT INTO tradebook_invitations (
+    tradebook_id, email, role, token_hash, invited_by, expires_at
+)
+VALUES (
+    @tradebook_id, @email, @role, @token_hash, @invited_by, @expires_at
+)
+RETURNING *;
+
+-- name: ListTradebookInvitations :many
+SELECT * FROM tradebook_invitations
+WHERE tradebook_id = @tradebook_id
+    AND accepted_at IS NULL
+    AND revoked_at IS NULL
+ORDER BY created_at DESC;
+
+-- name: RevokeTradebookInvitation :one
+UPDATE tradebook_invitations
+SET revoked_at = NOW()
+WHERE id = @invitation_id
+    AND tradebook_id = @tradebook_id
+    AND accepted_at IS NULL
+    AND revoked_at IS NULL
+RETURNING *;
+
+-- name: GetPendingInvitationByTokenHash :one
+-- Locks the invitation row so a token can only be accepted once
+SELECT * FROM tradebook_invitations
+WHERE token_hash = @token_hash
+    AND accepted_at IS NULL
+    AND revoked_at IS NULL
+    AND expires_at > NOW()
+FOR UPDATE;
+
+-- name: AcceptTradebookInvitation :exec
+UPDATE tradebook_invitations
+SET
+    accepted_at = NOW(),
+    accepted_by = @user_id::text
+WHERE id = @invitation_id;
+
+-- ============================================================================
+-- 9. ROW-LEVEL SECURITY
+-- ============================================================================
+
+-- name: SetCurrentUser :exec
+-- Transaction-local (is_local = true) so pooled connections never leak it
+SELECT set_config('app.current_user_id', @user_id::text, true);
+
+-- name: SetCurrentOrg :exec
+SELECT set_config('app.current_org_id', @org_id::text, true);
+
+-- name: GetRowSecurityBypass :one
+-- Ways the connected role could skip the policies; the API refuses to start
+-- with any of them
+SELECT
+    r.rolsuper::boolean AS is_superuser,
+    r.rolbypassrls::boolean AS bypasses_rls,
+    EXISTS (
+        SELECT 1 FROM pg_class c
+        WHERE c.relrowsecurity AND pg_has_role(r.oid, c.relowner, 'MEMBER')
+    )::boolean AS owns_tables
+FROM pg_roles r
+WHERE r.rolname = current_user;
+
+-- ============================================================================
+-- 10. AUDIT LOG
+-- ============================================================================
+
+-- name: RecordAuditEvent :exec
+INSERT INTO audit_log (
+    tradebook_id, actor_id, action, entity_type, entity_id, before, after
+) VALUES (
+    @tradebook_id, @actor_id, @action, @entity_type, @entity_id, @before, @after
+);
+
+-- name: ListAuditEvents :many
+-- Keyset pagination on (created_at, id); NULL filters match everything
+SELECT * FROM audit_log
+WHERE tradebook_id = @tradebook_id
+    AND (sqlc.narg('actor_id')::text IS NULL OR actor_id = sqlc.narg('actor_id')::text)
+    AND (sqlc.narg('action')::text IS NULL OR action = sqlc.narg('action')::text)
+    AND (sqlc.narg('entity_type')::text IS NULL OR entity_type = sqlc.narg('entity_type')::text)
+    AND (
+        sqlc.narg('cursor_created_at')::timestamptz IS NULL
+        OR (created_at, id) < (sqlc.narg('cursor_created_at')::timestamptz, sqlc.narg('cursor_id')::uuid)
+    )
+ORDER BY created_at DESC, id DESC
+LIMIT @limit_val;
+
+-- ============================================================================
+-- 11. TRASH
+-- ============================================================================
+
+-- name: PurgeExpiredTrash :one
+-- Hard-deletes tradebooks and trades trashed before the cutoff
+SELECT purge_expired_trash(@cutoff::timestamptz)::bigint AS purged;
+
+-- ============================================================================
+-- 12. TEMPLATES
+-- ============================================================================
+
+-- name: CreateTradebookTemplate :one
+INSERT INTO tradebook_templates (
+    owner_id, workspace_id, name, is_shared, base_currency, accounting_method,
+    default_asset_classes, tag_sets, custom_fields
+) VALUES (
+    @owner_id, sqlc.narg('workspace_id'), @name, @is_shared, @base_currency, @accounting_method,
+    @default_asset_classes, @tag_sets, @custom_fields
+)
+RETURNING *;
+
+-- name: ListTradebookTemplates :many
+-- The caller's own templates first, then ones shared in the session's workspace
+SELECT * FROM tradebook_templates
+WHERE owner_id = @user_id OR (is_shared AND workspace_id = sqlc.narg('org_id'))
+ORDER BY (owner_id = @user_id) DESC, name ASC;
+
+-- name: GetTradebookTemplate :one
+SELECT * FROM tradebook_templates
+WHERE id = @template_id
+    AND (owner_id = @user_id OR (is_shared AND workspace_id = sqlc.narg('org_id')));
+
+-- name: UpdateTradebookTemplate :one
+UPDATE tradebook_templates
+SET
+    name = @name,
+    is_shared = @is_shared,
+    base_currency = @base_currency,
+    accounting_method = @accounting_method,
+    default_asset_classes = @default_asset_classes,
+    tag_sets = @tag_sets,
+    custom_fields = @custom_fields,
+    updated_at = NOW()
+WHERE id = @template_id
+    AND owner_id = @owner_id
+RETURNING *;
+
+-- name: DeleteTradebookTemplate :execrows
+-- Tradebooks created from the template keep their settings
+DELETE FROM tradebook_templates
+WHERE id = @template_id
+    AND owner_id = @owner_id;
+
+-- name: CreateCustomFieldsFromTemplate :execrows
+-- Expands the template's custom_fields JSON into definitions, keeping their order
+INSERT INTO custom_field_definitions (tradebook_id, name, field_type, options, position)
+SELECT
+    @tradebook_id::uuid,
+    f.value->>'name',
+    (f.value->>'type')::custom_field_type,
+    COALESCE(f.value->'options', '[]'::jsonb),
+    f.ordinality::integer - 1
+FROM tradebook_templates t
+CROSS JOIN LATERAL jsonb_array_elements(t.custom_fields) WITH ORDINALITY AS f(value, ordinality)
+WHERE t.id = @template_id;
+
+-- name: CloneCustomFields :execrows
+INSERT INTO custom_field_definitions (tradebook_id, name, field_type, options, position)
+SELECT @target_tradebook_id::uuid, name, field_type, options, position
+FROM custom_field_definitions
+WHERE tradebook_id = @source_tradebook_id;
+
+-- name: CloneCommissionSchedules :execrows
+INSERT INTO commission_schedules (
+    tradebook_id, asset_class, rate_type, rate, min_fee, max_fee,
+    regulatory_rate, regulatory_per_unit
+)
+SELECT
+    @target_tradebook_id::uuid, asset_class, rate_type, rate, min_fee, max_fee,
+    regulatory_rate, regulatory_per_unit
+FROM commission_schedules
+WHERE tradebook_id = @source_tradebook_id;
+
+-- ============================================================================
+-- 13. CUSTOM FIELDS
+-- ============================================================================
+
+-- name: ListCustomFields :many
+SELECT * FROM custom_field_definitions
+WHERE tradebook_id = @tradebook_id
+ORDER BY position ASC, created_at ASC;
+
+-- name: CreateCustomField :one
+-- New fields go to the end of the list
+INSERT INTO custom_field_definitions (tradebook_id, name, field_type, options, position)
+VALUES (
+    @tradebook_id, @name, @field_type, @options,
+    (SELECT COALESCE(MAX(position) + 1, 0) FROM custom_field_definitions WHERE tradebook_id = @tradebook_id)
+)
+RETURNING *;
+
+-- name: GetCustomField :one
+SELECT * FROM custom_field_definitions
+WHERE id = @field_id AND tradebook_id = @tradebook_id;
+
+-- name: UpdateCustomField :one
+-- The type is fixed once created so stored values stay valid
+UPDATE custom_field_definitions
+SET
+    name = COALESCE(sqlc.narg('name'), name),
+    options = COALESCE(sqlc.narg('options')::jsonb, options),
+    position = COALESCE(sqlc.narg('position'), position),
+    updated_at = NOW()
+WHERE id = @field_id
+    AND tradebook_id = @tradebook_id
+RETURNING *;
+
+-- name: DeleteCustomField :one
+DELETE FROM custom_field_definitions
+WHERE id = @field_id
+    AND tradebook_id = @tradebook_id
+RETURNING *;
+
+-- name: ClearCustomFieldValues :exec
+-- Includes trashed trades so a restore doesn't bring back an orphaned value
+UPDATE trades
+SET custom_fields = custom_fields - @field_id::text
+WHERE tradebook_id = @tradebook_id
+    AND custom_fields ? @field_id::text;
+
+-- name: ClearRemovedOptionValues :exec
+-- Removes a select field's value from trades where it is no longer one of
+-- @options (a JSON array, which contains each of its strings); trashed
+-- trades included, as above
+UPDATE trades
+SET custom_fields = custom_fields - @field_id::text
+WHERE tradebook_id = @tradebook_id
+    AND custom_fields ? @field_id::text
+    AND NOT @options::jsonb @> (custom_fields -> @field_id::text);
+
+-- ============================================================================
+-- 14. COMMISSIONS
+-- ============================================================================
+
+-- name: ListCommissionSchedules :many
+SELECT * FROM commission_schedules
+WHERE tradebook_id = @tradebook_id
+ORDER BY asset_class;
+
+-- name: GetCommissionSchedule :one
+SELECT * FROM commission_schedules
+WHERE tradebook_id = @tradebook_id AND asset_class = @asset_class;
+
+-- name: UpsertCommissionSchedule :one
+INSERT INTO commission_schedules (
+    tradebook_id, asset_class, rate_type, rate, min_fee, max_fee,
+    regulatory_rate, regulatory_per_unit
+) VALUES (
+    @tradebook_id, @asset_class, @rate_type, @rate, @min_fee, @max_fee,
+    @regulatory_rate, @regulatory_per_unit
+)
+ON CONFLICT (tradebook_id, asset_class) DO UPDATE SET
+    rate_type = EXCLUDED.rate_type,
+    rate = EXCLUDED.rate,
+    min_fee = EXCLUDED.min_fee,
+    max_fee = EXCLUDED.max_fee,
+    regulatory_rate = EXCLUDED.regulatory_rate,
+    regulatory_per_unit = EXCLUDED.regulatory_per_unit,
+    updated_at = NOW()
+RETURNING *;
+
+-- name: DeleteCommissionSchedule :one
+DELETE FROM commission_schedules
+WHERE tradebook_id = @tradebook_id AND asset_class = @asset_class
+RETURNING *;
+
+-- name: ListTradesForFeeRecompute :many
+-- Live trades whose entry fees were computed, or all of them with include_manual
+SELECT * FROM trades
+WHERE tradebook_id = @tradebook_id
+    AND deleted_at IS NULL
+    AND (entry_fees_auto OR @include_manual::boolean)
+    AND (sqlc.narg('asset_class')::asset_class IS NULL OR asset_class = sqlc.narg('asset_class')::asset_class);
+
+-- name: ListExitLegsForFeeRecompute :many
+SELECT el.*, t.asset_class
+FROM exit_legs el
+JOIN trades t ON el.trade_id = t.id
+WHERE t.tradebook_id = @tradebook_id
+    AND t.deleted_at IS NULL
+    AND (el.exit_fees_auto OR @include_manual::boolean)
+    AND (sqlc.narg('asset_class')::asset_class IS NULL OR t.asset_class = sqlc.narg('asset_class')::asset_class);
+
+-- name: SetTradeEntryFees :exec
+UPDATE trades
+SET
+    entry_fees = @entry_fees,
+    entry_fees_auto = TRUE,
+    updated_at = NOW()
+WHERE id = @trade_id
+    AND tradebook_id = @tradebook_id;
+
+-- name: SetExitLegFees :exec
+UPDATE exit_legs
+SET
+    exit_fees = @exit_fees,
+    exit_fees_auto = TRUE,
+    updated_at = NOW()
+WHERE id = @exit_leg_id;
+
+-- ============================================================================
+-- 15. EXPORT
+-- ============================================================================
+
+-- name: ListTradesForExport :many
+-- Keyset pagination on id; the order only has to be stable
+SELECT * FROM trades
+WHERE tradebook_id = @tradebook_id
+    AND deleted_at IS NULL
+    AND (sqlc.narg('after_id')::uuid IS NULL OR id > sqlc.narg('after_id')::uuid)
+ORDER BY id
+LIMIT @limit_val;
+
+-- name: ListExitLegsForExport :many
+SELECT el.* FROM exit_legs el
+JOIN trades t ON el.trade_id = t.id
+WHERE t.tradebook_id = @tradebook_id
+    AND t.deleted_at IS NULL
+    AND (sqlc.narg('after_id')::uuid IS NULL OR el.id > sqlc.narg('after_id')::uuid)
+ORDER BY el.id
+LIMIT @limit_val;
+
+-- ============================================================================
+-- 16. IMPORT
+-- ============================================================================
+
+-- name: ImportTrade :one
+-- Like CreateTrade, but keeps the archived status, fee origin and creation time
+INSERT INTO trades (
+    tradebook_id, is_open, asset_class, purchase_type, order_type,
+    entry_date, symbol, currency, entry_quantity, entry_price, entry_fees, entry_fees_auto,
+    custom_fields, created_at
+) VALUES (
+    @tradebook_id, @is_open, @asset_class, @purchase_type, @order_type,
+    @entry_date, @symbol, @currency, @entry_quantity, @entry_price, @entry_fees, @entry_fees_auto,
+    @custom_fields, @created_at
+)
+RETURNING id;
+
+-- name: ImportExitLeg :exec
+INSERT INTO exit_legs (
+    trade_id, exit_date, exit_quantity, exit_price, exit_fees, exit_fees_auto, created_at
+) VALUES (
+    @trade_id, @exit_date, @exit_quantity, @exit_price, @exit_fees, @exit_fees_auto, @created_at
+);
+
+-- ============================================================================
+-- 17. ACCOUNT (Personal Data Export & Erasure)
+-- ============================================================================
+
+-- name: ListUserMemberships :many
+SELECT
+    m.tradebook_id, t.title, m.role,
+    COALESCE(p.is_pinned, FALSE)::boolean AS is_pinned,
+    COALESCE(p.is_archived, FALSE)::boolean AS is_archived,
+    m.joined_at
+FROM tradebook_members m
+JOIN tradebooks t ON t.id = m.tradebook_id
+LEFT JOIN tradebook_preferences p ON p.tradebook_id = m.tradebook_id AND p.user_id = m.user_id
+WHERE m.user_id = @user_id
+ORDER BY m.joined_at, m.tradebook_id;
+
+-- name: ListOwnedTradebooks :many
+-- Trashed books included. The successor, who takes the book over when the
+-- owner's account is erased, is the longest-standing editor or else reader;
+-- workspace books go to the strongest, longest-standing workspace member first.
+SELECT
+    t.id,
+    t.workspace_id,
+    t.deleted_at,
+    COALESCE(
+        (
+            SELECT wm.user_id FROM workspace_members wm
+            WHERE wm.workspace_id = t.workspace_id AND wm.user_id <> t.owner_id
+            ORDER BY wm.role, wm.joined_at, wm.user_id
+            LIMIT 1
+        ),
+        (
+            SELECT m.user_id FROM tradebook_members m
+            WHERE m.tradebook_id = t.id AND m.user_id <> t.owner_id
+            ORDER BY (m.role = 'editor') DESC, m.joined_at, m.user_id
+            LIMIT 1
+        )
+    )::text AS successor_id
+FROM tradebooks t
+WHERE t.owner_id = @owner_id
+ORDER BY t.created_at, t.id;
+
+-- name: ListInvitationsSentBy :many
+SELECT * FROM tradebook_invitations
+WHERE invited_by = @user_id
+ORDER BY created_at, id;
+
+-- name: ListOwnershipTransfersForUser :many
+SELECT * FROM tradebook_ownership_transfers
+WHERE from_user_id = @user_id OR to_user_id = @user_id
+ORDER BY transferred_at, id;
+
+-- name: ListAuditEventsByActor :many
+-- Keyset pagination on id, for exports
+SELECT * FROM audit_log
+WHERE actor_id = @actor_id
+    AND (sqlc.narg('after_id')::uuid IS NULL OR id > sqlc.narg('after_id')::uuid)
+ORDER BY id
+LIMIT @limit_val;
+
+-- name: ListTokenUsage :many
+SELECT * FROM token_usage_log
+WHERE user_id = @user_id
+    AND (sqlc.narg('after_id')::uuid IS NULL OR event_id > sqlc.narg('after_id')::uuid)
+ORDER BY event_id
+LIMIT @limit_val;
+
+-- name: DeleteOwnedTradebooks :execrows
+-- Hard delete, trash included; trades and exit legs cascade
+DELETE FROM tradebooks WHERE owner_id = @owner_id;
+
+-- name: DeleteUserMemberships :execrows
+DELETE FROM tradebook_members WHERE user_id = @user_id;
+
+-- name: DeleteTemplatesOwnedBy :execrows
+DELETE FROM tradebook_templates WHERE owner_id = @owner_id;
+
+-- name: DeleteInvitationsSentBy :execrows
+DELETE FROM tradebook_invitations WHERE invited_by = @user_id;
+
+-- name: DeleteUser :execrows
+DELETE FROM users WHERE id = @id;
+
+-- name: EraseUserRecords :one
+-- Anonymizes audit entries and ownership transfers, and purges usage logs
+-- and invitations addressed to the user's email
+SELECT
+    audit_entries::bigint AS audit_entries,
+    ownership_transfers::bigint AS ownership_transfers,
+    usage_logs::bigint AS usage_logs,
+    invitations_received::bigint AS invitations_received
+FROM erase_user_records(@user_id::text, @pseudonym::text, sqlc.narg('email')::text);
+
+-- name: CountUserRecords :one
+SELECT count_user_records(@user_id::text, sqlc.narg('email')::text)::bigint AS remaining;
+
+-- name: RecordAccountErasure :one
+INSERT INTO account_erasures (subject_hash, pseudonym, report)
+VALUES (@subject_hash, @pseudonym, @report)
+RETURNING id, completed_at;
+
+-- ============================================================================
+-- 18. WEBHOOKS
+-- ============================================================================
+
+-- name: ClaimWebhookEvent :execrows
+-- Affects no rows if the event was already claimed (i.e. this is a redelivery)
+INSERT INTO webhook_events (source, id, event_type)
+VALUES (@source, @id, @event_type)
+ON CONFLICT (source, id) DO NOTHING;
+
+-- ============================================================================
+-- 19. WORKSPACES
+-- ============================================================================
+
+-- name: UpsertWorkspace :exec
+INSERT INTO workspaces (id, name)
+VALUES (@id, @name)
+ON CONFLICT (id) DO UPDATE
+SET name = EXCLUDED.name;
+
+-- name: EnsureWorkspace :exec
+-- For memberships that arrive before their organization
+INSERT INTO workspaces (id)
+VALUES (@id)
+ON CONFLICT (id) DO NOTHING;
+
+-- name: DeleteWorkspace :execrows
+-- Its tradebooks fall back to being personal books of their owners
+DELETE FROM workspaces WHERE id = @id;
+
+-- name: GetWorkspace :one
+SELECT
+    w.id, w.name, w.created_at,
+    wm.role, wm.joined_at
+FROM workspaces w
+JOIN workspace_members wm ON wm.workspace_id = w.id
+WHERE w.id = @workspace_id
+    AND wm.user_id = @user_id;
+
+-- name: UpsertWorkspaceMember :exec
+-- Skips memberships older than the stored one, as with UpsertUserProfile
+INSERT INTO workspace_members (workspace_id, user_id, role, synced_at)
+VALUES (@workspace_id, @user_id, @role, @synced_at)
+ON CONFLICT (workspace_id, user_id) DO UPDATE
+SET
+    role = EXCLUDED.role,
+    synced_at = EXCLUDED.synced_at
+WHERE workspace_members.synced_at <= EXCLUDED.synced_at;
+
+-- name: DeleteWorkspaceMember :execrows
+DELETE FROM workspace_members
+WHERE workspace_id = @workspace_id
+    AND user_id = @user_id;
+
+-- name: ListUserWorkspaces :many
+SELECT
+    w.id, w.name, w.created_at,
+    wm.role, wm.joined_at
+FROM workspace_members wm
+JOIN workspaces w ON w.id = wm.workspace_id
+WHERE wm.user_id = @user_id
+ORDER BY wm.joined_at, w.id;
+
+-- name: ListWorkspaceMembers :many
+SELECT
+    wm.user_id, wm.role, wm.joined_at,
+    u.email, u.display_name, u.avatar_url
+FROM workspace_members wm
+JOIN users u ON u.id = wm.user_id
+WHERE wm.workspace_id = @workspace_id
+ORDER BY wm.joined_at ASC, wm.user_id ASC;
+
+-- name: SetTradebookWorkspace :one
+-- Only the recorded owner may move a book in or out of a workspace
+UPDATE tradebooks
+SET
+    workspace_id = sqlc.narg('workspace_id'),
+    updated_at = NOW()
+WHERE id = @tradebook_id
+    AND owner_id = @owner_id
+RETURNING *;
+
+-- ============================================================================
+-- 20. API KEYS
+-- ============================================================================
+
+-- name: CreateAPIKey :one
+INSERT INTO api_keys (user_id, org_id, name, prefix, key_hash, scopes, tradebook_ids, expires_at)
+VALUES (@user_id, sqlc.narg('org_id'), @name, @prefix, @key_hash, @scopes, @tradebook_ids, sqlc.narg('expires_at'))
+RETURNING *;
+
+-- name: ListAPIKeys :many
+SELECT * FROM api_keys
+WHERE user_id = @user_id
+ORDER BY created_at DESC, id DESC;
+
+-- name: RevokeAPIKey :one
+UPDATE api_keys
+SET revoked_at = NOW()
+WHERE id = @id
+    AND user_id = @user_id
+    AND revoked_at IS NULL
+RETURNING *;
+
+-- name: GetActiveAPIKeyByHash :one
+SELECT * FROM api_keys
+WHERE key_hash = @key_hash
+    AND revoked_at IS NULL
+    AND (expires_at IS NULL OR expires_at > NOW());
+
+-- name: TouchAPIKey :exec
+-- At most one write a minute per key, however busy the key is
+UPDATE api_keys
+SET last_used_at = NOW()
+WHERE id = @id
+    AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute');
+
+-- ============================================================================
+-- 21. BILLING
+-- ============================================================================
+
+-- name: UpsertBillingCustomer :exec
+INSERT INTO billing_customers (user_id, stripe_customer_id)
+VALUES (@user_id, @stripe_customer_id)
+ON CONFLICT (user_id) DO UPDATE
+SET stripe_customer_id = EXCLUDED.stripe_customer_id;
+
+-- name: GetBillingCustomer :one
+SELECT stripe_customer_id FROM billing_customers
+WHERE user_id = @user_id;
+
+-- name: GetBillingCustomerUser :one
+SELECT user_id FROM billing_customers
+WHERE stripe_customer_id = @stripe_customer_id;
+
+-- name: UpsertSubscription :exec
+-- Stripe doesn't deliver events in order, so an older event never overwrites
+-- a newer one
+INSERT INTO subscriptions (id, user_id, stripe_customer_id, price_id, plan, status, current_period_end, cancel_at_period_end, synced_at)
+VALUES (@id, @user_id, @stripe_customer_id, @price_id, sqlc.narg('plan'), @status, @current_period_end, @cancel_at_period_end, @synced_at)
+ON CONFLICT (id) DO UPDATE
+SET
+    price_id = EXCLUDED.price_id,
+    plan = EXCLUDED.plan,
+    status = EXCLUDED.status,
+    current_period_end = EXCLUDED.current_period_end,
+    cancel_at_period_end = EXCLUDED.cancel_at_period_end,
+    synced_at = EXCLUDED.synced_at
+WHERE subscriptions.synced_at <= EXCLUDED.synced_at;
+
+-- name: SetSubscriptionInvoiceStatus :execrows
+-- Affects no rows for unknown subscriptions or older invoice events
+UPDATE subscriptions
+SET
+    latest_invoice_status = @latest_invoice_status,
+    invoice_synced_at = @invoice_synced_at
+WHERE id = @id
+    AND (invoice_synced_at IS NULL OR invoice_synced_at <= @invoice_synced_at);
+
+-- name: GetCurrentSubscription :one
+-- Prefers a subscription that still grants its plan, then the latest period
+SELECT * FROM subscriptions
+WHERE user_id = @user_id
+ORDER BY
+    (status IN ('active', 'trialing', 'past_due')) DESC,
+    current_period_end DESC,
+    id
+LIMIT 1;
+
+-- name: ListUserSubscriptions :many
+SELECT * FROM subscriptions
+WHERE user_id = @user_id
+ORDER BY created_at DESC, id;
@@ -15,10 +15,36 @@ $$ language 'plpgsql';
 -- ============================================================================
 -- Section 2: Enums
 -- ============================================================================
-CREATE TYPE asset_class AS ENUM ('equities', 'fixed_income', 'commodities', 'etfs', 'forex', 'derivatives', 'crypto');
-CREATE TYPE trade_order_type AS ENUM ('market', 'limit', 'stop', 'stop_limit');
-CREATE TYPE trade_purchase_type AS ENUM ('cash', 'margin');
-CREATE TYPE tradebook_role AS ENUM ('owner', 'editor', 'reader');
+-- Guarded so this file can be re-run, as CREATE TYPE has no IF NOT EXISTS.
+-- sqlc reads the enums from sql/types.sql instead.
+DO $$ BEGIN
+    CREATE TYPE asset_class AS ENUM ('equities', 'fixed_income', 'commodities', 'etfs', 'forex', 'derivatives', 'crypto');
+EXCEPTION WHEN duplicate_object THEN NULL;
+END $$;
+DO $$ BEGIN
+    CREATE TYPE trade_order_type AS ENUM ('market', 'limit', 'stop', 'stop_limit');
+EXCEPTION WHEN duplicate_object THEN NULL;
+END $$;
+DO $$ BEGIN
+    CREATE TYPE trade_purchase_type AS ENUM ('cash', 'margin');
+EXCEPTION WHEN duplicate_object THEN NULL;
+END $$;
+DO $$ BEGIN
+    CREATE TYPE tradebook_role AS ENUM ('owner', 'editor', 'reader'); -- Most to least privileged, so LEAST() picks the stronger role
+EXCEPTION WHEN duplicate_object THEN NULL;
+END $$;
+DO $$ BEGIN
+    CREATE TYPE accounting_method AS ENUM ('fifo', 'lifo', 'average_cost');
+EXCEPTION WHEN duplicate_object THEN NULL;
+END $$;
+DO $$ BEGIN
+    CREATE TYPE custom_field_type AS ENUM ('text', 'number', 'select', 'boolean', 'date');
+EXCEPTION WHEN duplicate_object THEN NULL;
+END $$;
+DO $$ BEGIN
+    CREATE TYPE commission_rate_type AS ENUM ('per_share', 'per_contract', 'percent_of_notional');
+EXCEPTION WHEN duplicate_object THEN NULL;
+END $$;
 
 -- ============================================================================
 -- Section 3: Core Application Tables
@@ -27,6 +53,80 @@ CREATE TYPE tradebook_role AS ENUM ('owner', 'editor', 'reader');
 -- 1. Users
 CREATE TABLE IF NOT EXISTS users (
     id TEXT PRIMARY KEY, -- Matches Auth Provider ID
+
+    -- Profile, synced from WorkOS
+    email TEXT,
+    display_name TEXT,
+    avatar_url TEXT,
+    profile_updated_at TIMESTAMPTZ, -- WorkOS updated_at of the stored profile; NULL until first synced
+
+    preferences JSONB NOT NULL DEFAULT '{}', -- Client settings, opaque to the API
+
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- 1b. Tradebook Templates (Reusable Setups)
+CREATE TABLE IF NOT EXISTS tradebook_templates (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    owner_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    workspace_id TEXT, -- Session workspace it was created in; NULL if personal
+    name TEXT NOT NULL,
+    is_shared BOOLEAN NOT NULL DEFAULT FALSE, -- Usable by the workspace's members, not just the owner
+
+    -- Applied to tradebooks created from the template
+    base_currency CHAR(3) NOT NULL DEFAULT 'USD',
+    accounting_method accounting_method NOT NULL DEFAULT 'fifo',
+    default_asset_classes JSONB NOT NULL DEFAULT '[]', -- ["equities", "etfs"]
+    tag_sets JSONB NOT NULL DEFAULT '[]', -- [{"name": "Setup", "tags": ["breakout", "pullback"]}]
+    custom_fields JSONB NOT NULL DEFAULT '[]', -- [{"name": "Catalyst", "type": "text"}]; copied into custom_field_definitions
+
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- 1c. Workspaces (WorkOS Organizations)
+CREATE TABLE IF NOT EXISTS workspaces (
+    id TEXT PRIMARY KEY, -- Matches the WorkOS organization ID
+    name TEXT NOT NULL DEFAULT '', -- Empty until the organization itself is synced
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- 1d. Workspace Members (WorkOS Organization Memberships)
+CREATE TABLE IF NOT EXISTS workspace_members (
+    workspace_id TEXT NOT NULL REFERENCES workspaces(id) ON DELETE CASCADE,
+    user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    role tradebook_role NOT NULL, -- Mapped from the WorkOS role; applies to every workspace tradebook
+    synced_at TIMESTAMPTZ NOT NULL, -- WorkOS updated_at of the stored membership
+    joined_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (workspace_id, user_id)
+);
+
+-- 1e. Billing Customers (Stripe Customers)
+-- Linked when a checkout completes, so later events can be matched to a user
+CREATE TABLE IF NOT EXISTS billing_customers (
+    user_id TEXT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    stripe_customer_id TEXT NOT NULL UNIQUE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- 1f. Subscriptions (Stripe Subscriptions)
+-- Written only by Stripe webhooks; each event carries the whole subscription
+CREATE TABLE IF NOT EXISTS subscriptions (
+    id TEXT PRIMARY KEY, -- Matches the Stripe subscription ID
+    user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    stripe_customer_id TEXT NOT NULL,
+    price_id TEXT NOT NULL,
+    plan TEXT, -- 'pro' or 'ultra'; NULL for prices not configured here
+    status TEXT NOT NULL, -- Stripe's status, e.g. 'active', 'past_due', 'canceled'
+    current_period_end TIMESTAMPTZ NOT NULL,
+    cancel_at_period_end BOOLEAN NOT NULL DEFAULT FALSE,
+    synced_at TIMESTAMPTZ NOT NULL, -- Created time of the last applied subscription event
+
+    latest_invoice_status TEXT, -- e.g. 'paid', 'open', 'uncollectible'
+    invoice_synced_at TIMESTAMPTZ, -- Created time of the last applied invoice event
+
     created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
     updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
 );
@@ -35,9 +135,54 @@ CREATE TABLE IF NOT EXISTS users (
 CREATE TABLE IF NOT EXISTS tradebooks (
     id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
     owner_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    workspace_id TEXT REFERENCES workspaces(id) ON DELETE SET NULL, -- Workspace books stay with owner_id if the workspace goes
     title TEXT NOT NULL,
+    template_id UUID REFERENCES tradebook_templates(id) ON DELETE SET NULL,
+
+    -- Settings (seeded from the template, if any)
+    base_currency CHAR(3) NOT NULL DEFAULT 'USD',
+    accounting_method accounting_method NOT NULL DEFAULT 'fifo',
+    default_asset_classes JSONB NOT NULL DEFAULT '[]',
+    tag_sets JSONB NOT NULL DEFAULT '[]',
+    timezone TEXT NOT NULL DEFAULT 'UTC', -- IANA name; defines day and year boundaries in reports
+    default_fees NUMERIC(19, 8) NOT NULL DEFAULT 0, -- Used when a trade is entered without fees
+    fiscal_year_start INTEGER NOT NULL DEFAULT 1 CHECK (fiscal_year_start BETWEEN 1 AND 12), -- Month
+
     created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
-    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    deleted_at TIMESTAMPTZ -- Set while in the trash; purged after the retention window
+);
+
+-- 2b. Custom Field Definitions (Per-Tradebook Trade Attributes)
+CREATE TABLE IF NOT EXISTS custom_field_definitions (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tradebook_id UUID NOT NULL REFERENCES tradebooks(id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    field_type custom_field_type NOT NULL,
+    options JSONB NOT NULL DEFAULT '[]', -- Allowed values for select fields
+    position INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (tradebook_id, name)
+);
+
+-- 2c. Commission Schedules (Fee Auto-Calculation, one per asset class)
+CREATE TABLE IF NOT EXISTS commission_schedules (
+    tradebook_id UUID NOT NULL REFERENCES tradebooks(id) ON DELETE CASCADE,
+    asset_class asset_class NOT NULL,
+
+    rate_type commission_rate_type NOT NULL,
+    rate NUMERIC(19, 8) NOT NULL, -- Per unit, or a fraction of notional (0.001 = 0.1%)
+    min_fee NUMERIC(19, 8) NOT NULL DEFAULT 0,
+    max_fee NUMERIC(19, 8), -- NULL means uncapped
+
+    -- Regulatory fees (e.g. SEC Section 31, FINRA TAF) apply to exits only
+    regulatory_rate NUMERIC(19, 8) NOT NULL DEFAULT 0, -- Fraction of notional
+    regulatory_per_unit NUMERIC(19, 8) NOT NULL DEFAULT 0,
+
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (tradebook_id, asset_class)
 );
 
 -- 3. Members (Access Control)
@@ -49,10 +194,101 @@ CREATE TABLE IF NOT EXISTS tradebook_members (
     PRIMARY KEY (tradebook_id, user_id)
 );
 
+-- 3a. Preferences (Per-User View Settings)
+-- Kept apart from members so workspace members without a member row of their
+-- own can pin and archive books too; a missing row means neither
+CREATE TABLE IF NOT EXISTS tradebook_preferences (
+    tradebook_id UUID NOT NULL REFERENCES tradebooks(id) ON DELETE CASCADE,
+    user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    is_pinned BOOLEAN NOT NULL DEFAULT FALSE,
+    is_archived BOOLEAN NOT NULL DEFAULT FALSE,
+    PRIMARY KEY (tradebook_id, user_id)
+);
+
+-- 3b. Invitations (Pending Access)
+CREATE TABLE IF NOT EXISTS tradebook_invitations (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tradebook_id UUID NOT NULL REFERENCES tradebooks(id) ON DELETE CASCADE,
+    email TEXT NOT NULL,
+    role tradebook_role NOT NULL DEFAULT 'reader',
+    token_hash TEXT NOT NULL UNIQUE, -- SHA-256 of the emailed token; the token itself is never stored
+    invited_by TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    expires_at TIMESTAMPTZ NOT NULL,
+    accepted_at TIMESTAMPTZ,
+    accepted_by TEXT REFERENCES users(id) ON DELETE SET NULL,
+    revoked_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- 3c. Ownership Transfers (Audit Trail)
+-- No foreign keys on the user columns so history survives account deletion
+CREATE TABLE IF NOT EXISTS tradebook_ownership_transfers (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tradebook_id UUID NOT NULL REFERENCES tradebooks(id) ON DELETE CASCADE,
+    from_user_id TEXT NOT NULL,
+    to_user_id TEXT NOT NULL,
+    transferred_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- 3d. Audit Log (Append-Only)
+-- No foreign keys so entries outlive the tradebook, entity and actor they describe
+CREATE TABLE IF NOT EXISTS audit_log (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tradebook_id UUID NOT NULL,
+    actor_id TEXT NOT NULL,
+    action TEXT NOT NULL, -- e.g. 'member.update'
+    entity_type TEXT NOT NULL, -- Prefix of action, e.g. 'member'
+    entity_id TEXT NOT NULL,
+    before JSONB, -- NULL for creations
+    after JSONB, -- NULL for deletions
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- 3e. Account Erasures (Completion Reports)
+-- Keeps only a hash of the erased user ID, so a report can be matched to an
+-- account by someone who already knows the ID but doesn't reveal it
+CREATE TABLE IF NOT EXISTS account_erasures (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    subject_hash TEXT NOT NULL, -- SHA-256 of the user ID
+    pseudonym TEXT NOT NULL, -- Replaced the user ID in retained records
+    report JSONB NOT NULL,
+    completed_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- 3f. Webhook Events (Idempotency)
+-- One row per delivered event ID, claimed in the transaction that applies the
+-- event, so retried deliveries are acknowledged without being applied twice
+CREATE TABLE IF NOT EXISTS webhook_events (
+    source TEXT NOT NULL, -- 'workos' or 'stripe'
+    id TEXT NOT NULL, -- The provider's event ID
+    event_type TEXT NOT NULL,
+    received_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (source, id)
+);
+
+-- 3g. API Keys (Programmatic Access)
+-- Act as their user, limited to their scopes and, if any are listed, to
+-- those tradebooks. Like invitation tokens, only the hash is stored.
+CREATE TABLE IF NOT EXISTS api_keys (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    org_id TEXT REFERENCES workspaces(id) ON DELETE CASCADE, -- Workspace of the session that created it
+    name TEXT NOT NULL,
+    prefix TEXT NOT NULL, -- Leading characters of the key, shown to tell keys apart
+    key_hash TEXT NOT NULL UNIQUE, -- SHA-256 of the key
+    scopes JSONB NOT NULL DEFAULT '["read"]', -- ["read", "write"]
+    tradebook_ids JSONB NOT NULL DEFAULT '[]', -- Empty means every tradebook the user can access
+    expires_at TIMESTAMPTZ, -- NULL never expires
+    last_used_at TIMESTAMPTZ,
+    revoked_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
 -- 4. Trades
 CREATE TABLE IF NOT EXISTS trades (
     id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
     tradebook_id UUID NOT NULL REFERENCES tradebooks(id) ON DELETE CASCADE,
+    cloned_from_id UUID, -- Source trade when copied by a tradebook clone; no FK so purges don't cascade
 
     -- Status Flag
     is_open BOOLEAN NOT NULL DEFAULT TRUE,
@@ -71,9 +307,14 @@ CREATE TABLE IF NOT EXISTS trades (
     entry_quantity NUMERIC(19, 8) NOT NULL,
     entry_price NUMERIC(19, 8) NOT NULL,
     entry_fees NUMERIC(19, 8) DEFAULT 0,
+    entry_fees_auto BOOLEAN NOT NULL DEFAULT FALSE, -- Computed from the commission schedule, so recomputable
+
+    -- Custom Field Values, keyed by custom_field_definitions.id
+    custom_fields JSONB NOT NULL DEFAULT '{}',
 
     created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
-    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    deleted_at TIMESTAMPTZ -- Set while in the trash; purged after the retention window
 );
 
 -- 5. Exit Legs
@@ -86,6 +327,7 @@ CREATE TABLE IF NOT EXISTS exit_legs (
     exit_quantity NUMERIC(19, 8) NOT NULL,
     exit_price NUMERIC(19, 8) NOT NULL,
     exit_fees NUMERIC(19, 8) DEFAULT 0,
+    exit_fees_auto BOOLEAN NOT NULL DEFAULT FALSE, -- Computed from the commission schedule, so recomputable
 
     created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
     updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
@@ -106,22 +348,391 @@ CREATE TABLE IF NOT EXISTS token_usage_log (
 );
 
 -- ============================================================================
--- Section 5: Indexes & Triggers
+-- Section 5: Column Upgrades
+-- ============================================================================
+-- CREATE TABLE IF NOT EXISTS leaves existing tables alone, so columns added
+-- to them after release are also added here for databases created earlier.
+-- Each must match its definition above.
+
+-- 1. Users
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email TEXT;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS display_name TEXT;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS avatar_url TEXT;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS profile_updated_at TIMESTAMPTZ;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS preferences JSONB NOT NULL DEFAULT '{}';
+
+-- 1b. Templates: ones shared before sharing was limited to a workspace become
+-- the owner's only
+ALTER TABLE tradebook_templates ADD COLUMN IF NOT EXISTS workspace_id TEXT;
+
+-- 2. Tradebooks
+ALTER TABLE tradebooks ADD COLUMN IF NOT EXISTS workspace_id TEXT REFERENCES workspaces(id) ON DELETE SET NULL;
+ALTER TABLE tradebooks ADD COLUMN IF NOT EXISTS template_id UUID REFERENCES tradebook_templates(id) ON DELETE SET NULL;
+ALTER TABLE tradebooks ADD COLUMN IF NOT EXISTS base_currency CHAR(3) NOT NULL DEFAULT 'USD';
+ALTER TABLE tradebooks ADD COLUMN IF NOT EXISTS accounting_method accounting_method NOT NULL DEFAULT 'fifo';
+ALTER TABLE tradebooks ADD COLUMN IF NOT EXISTS default_asset_classes JSONB NOT NULL DEFAULT '[]';
+ALTER TABLE tradebooks ADD COLUMN IF NOT EXISTS tag_sets JSONB NOT NULL DEFAULT '[]';
+ALTER TABLE tradebooks ADD COLUMN IF NOT EXISTS timezone TEXT NOT NULL DEFAULT 'UTC';
+ALTER TABLE tradebooks ADD COLUMN IF NOT EXISTS default_fees NUMERIC(19, 8) NOT NULL DEFAULT 0;
+ALTER TABLE tradebooks ADD COLUMN IF NOT EXISTS fiscal_year_start INTEGER NOT NULL DEFAULT 1 CHECK (fiscal_year_start BETWEEN 1 AND 12);
+ALTER TABLE tradebooks ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
+
+-- 3. Members: preferences moved to tradebook_preferences
+DO $$
+BEGIN
+    IF EXISTS (
+        SELECT 1 FROM information_schema.columns
+        WHERE table_schema = 'public' AND table_name = 'tradebook_members' AND column_name = 'is_pinned'
+    ) THEN
+        INSERT INTO tradebook_preferences (tradebook_id, user_id, is_pinned, is_archived)
+        SELECT tradebook_id, user_id, is_pinned, is_archived FROM tradebook_members
+        WHERE is_pinned OR is_archived
+        ON CONFLICT DO NOTHING;
+
+        ALTER TABLE tradebook_members DROP COLUMN is_pinned, DROP COLUMN is_archived;
+    END IF;
+END $$;
+
+-- 4. Trades
+ALTER TABLE trades ADD COLUMN IF NOT EXISTS cloned_from_id UUID;
+ALTER TABLE trades ADD COLUMN IF NOT EXISTS entry_fees_auto BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE trades ADD COLUMN IF NOT EXISTS custom_fields JSONB NOT NULL DEFAULT '{}';
+ALTER TABLE trades ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
+
+-- 5. Exit Legs
+ALTER TABLE exit_legs ADD COLUMN IF NOT EXISTS exit_fees_auto BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- ============================================================================
+-- Section 6: Indexes & Triggers
 -- ============================================================================
 
 -- 1. Standard Performance Indexes
 CREATE INDEX IF NOT EXISTS idx_tradebooks_owner ON tradebooks(owner_id);
+CREATE INDEX IF NOT EXISTS idx_templates_owner ON tradebook_templates(owner_id);
+CREATE INDEX IF NOT EXISTS idx_templates_shared ON tradebook_templates(name) WHERE is_shared = TRUE;
+CREATE INDEX IF NOT EXISTS idx_tradebooks_cursor ON tradebooks(updated_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_tradebooks_trash ON tradebooks(owner_id, deleted_at DESC) WHERE deleted_at IS NOT NULL;
 CREATE INDEX IF NOT EXISTS idx_members_user ON tradebook_members(user_id);
 CREATE INDEX IF NOT EXISTS idx_exit_legs_trade ON exit_legs(trade_id);
+CREATE INDEX IF NOT EXISTS idx_invitations_tradebook ON tradebook_invitations(tradebook_id);
+CREATE INDEX IF NOT EXISTS idx_audit_log_tradebook ON audit_log(tradebook_id, created_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_ownership_transfers_tradebook ON tradebook_ownership_transfers(tradebook_id, transferred_at DESC);
+CREATE INDEX IF NOT EXISTS idx_audit_log_actor ON audit_log(actor_id);
+CREATE INDEX IF NOT EXISTS idx_invitations_invited_by ON tradebook_invitations(invited_by);
+CREATE INDEX IF NOT EXISTS idx_account_erasures_subject ON account_erasures(subject_hash);
+CREATE INDEX IF NOT EXISTS idx_token_usage_user ON token_usage_log(user_id);
+CREATE INDEX IF NOT EXISTS idx_tradebooks_workspace ON tradebooks(workspace_id) WHERE workspace_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_workspace_members_user ON workspace_members(user_id);
+CREATE INDEX IF NOT EXISTS idx_api_keys_user ON api_keys(user_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_subscriptions_user ON subscriptions(user_id);
 
 -- 2. AI & Search Indexes
 CREATE INDEX IF NOT EXISTS idx_trades_symbol ON trades(symbol);
 CREATE INDEX IF NOT EXISTS idx_trades_is_open ON trades(tradebook_id) WHERE is_open = TRUE;
 CREATE INDEX IF NOT EXISTS idx_trades_asset_analysis ON trades(tradebook_id, asset_class, entry_date);
-CREATE INDEX IF NOT EXISTS idx_trades_date_lookup ON trades(tradebook_id, entry_date DESC);
+CREATE INDEX IF NOT EXISTS idx_trades_date_lookup ON trades(tradebook_id, entry_date DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_trades_trash ON trades(tradebook_id, deleted_at DESC) WHERE deleted_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_trades_custom_fields ON trades USING GIN (custom_fields jsonb_path_ops);
 
 -- 3. Triggers (Auto-update updated_at)
-CREATE TRIGGER update_users_modtime BEFORE UPDATE ON users FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
-CREATE TRIGGER update_tradebooks_modtime BEFORE UPDATE ON tradebooks FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
-CREATE TRIGGER update_trades_modtime BEFORE UPDATE ON trades FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
-CREATE TRIGGER update_exits_modtime BEFORE UPDATE ON exit_legs FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+CREATE OR REPLACE TRIGGER update_users_modtime BEFORE UPDATE ON users FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+CREATE OR REPLACE TRIGGER update_tradebooks_modtime BEFORE UPDATE ON tradebooks FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+CREATE OR REPLACE TRIGGER update_workspaces_modtime BEFORE UPDATE ON workspaces FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+CREATE OR REPLACE TRIGGER update_subscriptions_modtime BEFORE UPDATE ON subscriptions FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+CREATE OR REPLACE TRIGGER update_templates_modtime BEFORE UPDATE ON tradebook_templates FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+CREATE OR REPLACE TRIGGER update_commissions_modtime BEFORE UPDATE ON commission_schedules FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+CREATE OR REPLACE TRIGGER update_custom_fields_modtime BEFORE UPDATE ON custom_field_definitions FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+CREATE OR REPLACE TRIGGER update_trades_modtime BEFORE UPDATE ON trades FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+CREATE OR REPLACE TRIGGER update_exits_modtime BEFORE UPDATE ON exit_legs FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+
+-- ============================================================================
+-- Section 7: Row-Level Security
+-- ============================================================================
+-- Defense in depth for tenant isolation. Each request sets app.current_user_id
+-- (and app.current_org_id for sessions in a workspace) inside its transaction
+-- (see helpers.BeginUserTx), so a query that forgets its tenant predicate
+-- still only sees the caller's tradebooks.
+-- Policies are skipped for superusers and roles with BYPASSRLS, and the table
+-- owner could turn them off, so the API connects as a separate role with none
+-- of these (config.InitializeConfig refuses to start otherwise). FORCE also
+-- applies them to the owner, which means the SECURITY DEFINER functions below
+-- must be owned by a superuser or a role with BYPASSRLS: apply this file as one.
+
+CREATE OR REPLACE FUNCTION app_current_user_id()
+RETURNS TEXT AS $$
+    SELECT NULLIF(current_setting('app.current_user_id', true), '');
+$$ LANGUAGE sql STABLE;
+
+CREATE OR REPLACE FUNCTION app_current_org_id()
+RETURNS TEXT AS $$
+    SELECT NULLIF(current_setting('app.current_org_id', true), '');
+$$ LANGUAGE sql STABLE;
+
+-- SECURITY DEFINER so these lookups skip RLS and the policies can't recurse.
+-- Workspace tradebooks are reachable only from a session in that workspace.
+CREATE OR REPLACE FUNCTION app_can_access_tradebook(tb_id UUID)
+RETURNS BOOLEAN AS $$
+    SELECT EXISTS (
+        SELECT 1 FROM tradebooks WHERE id = tb_id AND owner_id = app_current_user_id()
+    ) OR EXISTS (
+        SELECT 1 FROM tradebook_members WHERE tradebook_id = tb_id AND user_id = app_current_user_id()
+    ) OR EXISTS (
+        SELECT 1 FROM tradebooks tb
+        JOIN workspace_members wm ON wm.workspace_id = tb.workspace_id
+        WHERE tb.id = tb_id
+            AND tb.workspace_id = app_current_org_id()
+            AND wm.user_id = app_current_user_id()
+    );
+$$ LANGUAGE sql STABLE SECURITY DEFINER SET search_path = public;
+
+CREATE OR REPLACE FUNCTION app_can_access_trade(t_id UUID)
+RETURNS BOOLEAN AS $$
+    SELECT EXISTS (
+        SELECT 1 FROM trades WHERE id = t_id AND app_can_access_tradebook(tradebook_id)
+    );
+$$ LANGUAGE sql STABLE SECURITY DEFINER SET search_path = public;
+
+-- Owners manage the member list: the book's owner, a member with the owner
+-- role, or, in a session of the book's workspace, a workspace owner
+CREATE OR REPLACE FUNCTION app_owns_tradebook(tb_id UUID)
+RETURNS BOOLEAN AS $$
+    SELECT EXISTS (
+        SELECT 1 FROM tradebooks WHERE id = tb_id AND owner_id = app_current_user_id()
+    ) OR EXISTS (
+        SELECT 1 FROM tradebook_members
+        WHERE tradebook_id = tb_id AND user_id = app_current_user_id() AND role = 'owner'
+    ) OR EXISTS (
+        SELECT 1 FROM tradebooks tb
+        JOIN workspace_members wm ON wm.workspace_id = tb.workspace_id
+        WHERE tb.id = tb_id
+            AND tb.workspace_id = app_current_org_id()
+            AND wm.user_id = app_current_user_id()
+            AND wm.role = 'owner'
+    );
+$$ LANGUAGE sql STABLE SECURITY DEFINER SET search_path = public;
+
+-- The role a member row holds before the statement updating it, so a member
+-- changing their own preferences can't change their role with them
+CREATE OR REPLACE FUNCTION app_member_role(tb_id UUID, member_id TEXT)
+RETURNS tradebook_role AS $$
+    SELECT role FROM tradebook_members WHERE tradebook_id = tb_id AND user_id = member_id;
+$$ LANGUAGE sql STABLE SECURITY DEFINER SET search_path = public;
+
+-- True only inside the transaction that accepted the invitation (NOW() is the
+-- transaction start time), which lets an invitee insert their own member row
+CREATE OR REPLACE FUNCTION app_accepted_invitation(tb_id UUID, invited_role tradebook_role)
+RETURNS BOOLEAN AS $$
+    SELECT EXISTS (
+        SELECT 1 FROM tradebook_invitations
+        WHERE tradebook_id = tb_id
+            AND role = invited_role
+            AND accepted_by = app_current_user_id()
+            AND accepted_at = NOW()
+    );
+$$ LANGUAGE sql STABLE SECURITY DEFINER SET search_path = public;
+
+-- Runs as the table owner so the background purge, which has no current
+-- user, isn't filtered down to nothing by the policies below
+CREATE OR REPLACE FUNCTION purge_expired_trash(cutoff TIMESTAMPTZ)
+RETURNS BIGINT AS $$
+DECLARE
+    purged_trades BIGINT;
+    purged_tradebooks BIGINT;
+BEGIN
+    DELETE FROM trades WHERE deleted_at < cutoff;
+    GET DIAGNOSTICS purged_trades = ROW_COUNT;
+
+    DELETE FROM tradebooks WHERE deleted_at < cutoff;
+    GET DIAGNOSTICS purged_tradebooks = ROW_COUNT;
+
+    RETURN purged_trades + purged_tradebooks;
+END;
+$$ LANGUAGE plpgsql SECURITY DEFINER SET search_path = public;
+
+-- Account erasure rewrites rows the user can't see through the policies
+-- below (audit entries in tradebooks they left, and the append-only log
+-- itself), so it runs as the table owner. The user ID is replaced wherever it
+-- appears as a whole JSON string, e.g. {"user_id": "..."} in member events,
+-- and so is their email, in any case, e.g. in invitation events. Invitations
+-- addressed to the email are deleted. target_email is NULL if none is stored.
+DROP FUNCTION IF EXISTS erase_user_records(TEXT, TEXT);
+CREATE OR REPLACE FUNCTION erase_user_records(target TEXT, pseudonym TEXT, target_email TEXT)
+RETURNS TABLE (audit_entries BIGINT, ownership_transfers BIGINT, usage_logs BIGINT, invitations_received BIGINT) AS $$
+DECLARE
+    quoted_target TEXT := to_jsonb(target)::text;
+    quoted_pseudonym TEXT := to_jsonb(pseudonym)::text;
+    quoted_email TEXT := lower(to_jsonb(target_email)::text);
+    -- Every character but letters and digits escaped, so it matches literally
+    email_pattern TEXT := regexp_replace(quoted_email, '([^[:alnum:]])', '\\\1', 'g');
+    email_entries BIGINT;
+BEGIN
+    UPDATE audit_log SET
+        actor_id = CASE WHEN actor_id = target THEN pseudonym ELSE actor_id END,
+        entity_id = CASE WHEN entity_id = target THEN pseudonym ELSE entity_id END,
+        before = replace(before::text, quoted_target, quoted_pseudonym)::jsonb,
+        after = replace(after::text, quoted_target, quoted_pseudonym)::jsonb
+    WHERE actor_id = target
+        OR entity_id = target
+        OR strpos(before::text, quoted_target) > 0
+        OR strpos(after::text, quoted_target) > 0;
+    GET DIAGNOSTICS audit_entries = ROW_COUNT;
+
+    invitations_received := 0;
+    IF target_email IS NOT NULL THEN
+        UPDATE audit_log SET
+            before = regexp_replace(before::text, email_pattern, quoted_pseudonym, 'gi')::jsonb,
+            after = regexp_replace(after::text, email_pattern, quoted_pseudonym, 'gi')::jsonb
+        WHERE strpos(lower(before::text), quoted_email) > 0
+            OR strpos(lower(after::text), quoted_email) > 0;
+        GET DIAGNOSTICS email_entries = ROW_COUNT;
+        audit_entries := audit_entries + email_entries;
+
+        DELETE FROM tradebook_invitations WHERE lower(email) = lower(target_email);
+        GET DIAGNOSTICS invitations_received = ROW_COUNT;
+    END IF;
+
+    UPDATE tradebook_ownership_transfers SET
+        from_user_id = CASE WHEN from_user_id = target THEN pseudonym ELSE from_user_id END,
+        to_user_id = CASE WHEN to_user_id = target THEN pseudonym ELSE to_user_id END
+    WHERE from_user_id = target OR to_user_id = target;
+    GET DIAGNOSTICS ownership_transfers = ROW_COUNT;
+
+    DELETE FROM token_usage_log WHERE user_id = target;
+    GET DIAGNOSTICS usage_logs = ROW_COUNT;
+
+    RETURN NEXT;
+END;
+$$ LANGUAGE plpgsql SECURITY DEFINER SET search_path = public;
+
+-- Counts every row that still references the user or their email, across
+-- all tables and regardless of the policies; an erasure only commits when
+-- this is zero
+DROP FUNCTION IF EXISTS count_user_records(TEXT);
+CREATE OR REPLACE FUNCTION count_user_records(target TEXT, target_email TEXT)
+RETURNS BIGINT AS $$
+    SELECT
+        (SELECT COUNT(*) FROM users WHERE id = target)
+        + (SELECT COUNT(*) FROM tradebooks WHERE owner_id = target)
+        + (SELECT COUNT(*) FROM tradebook_members WHERE user_id = target)
+        + (SELECT COUNT(*) FROM workspace_members WHERE user_id = target)
+        + (SELECT COUNT(*) FROM tradebook_preferences WHERE user_id = target)
+        + (SELECT COUNT(*) FROM api_keys WHERE user_id = target)
+        + (SELECT COUNT(*) FROM billing_customers WHERE user_id = target)
+        + (SELECT COUNT(*) FROM subscriptions WHERE user_id = target)
+        + (SELECT COUNT(*) FROM tradebook_templates WHERE owner_id = target)
+        + (SELECT COUNT(*) FROM tradebook_invitations
+            WHERE invited_by = target
+                OR accepted_by = target
+                OR lower(email) = lower(target_email))
+        + (SELECT COUNT(*) FROM tradebook_ownership_transfers WHERE from_user_id = target OR to_user_id = target)
+        + (SELECT COUNT(*) FROM audit_log
+            WHERE actor_id = target
+                OR entity_id = target
+                OR strpos(before::text, to_jsonb(target)::text) > 0
+                OR strpos(after::text, to_jsonb(target)::text) > 0
+                OR strpos(lower(before::text), lower(to_jsonb(target_email)::text)) > 0
+                OR strpos(lower(after::text), lower(to_jsonb(target_email)::text)) > 0)
+        + (SELECT COUNT(*) FROM token_usage_log WHERE user_id = target);
+$$ LANGUAGE sql STABLE SECURITY DEFINER SET search_path = public;
+
+ALTER TABLE tradebooks ENABLE ROW LEVEL SECURITY;
+ALTER TABLE tradebook_members ENABLE ROW LEVEL SECURITY;
+ALTER TABLE tradebook_preferences ENABLE ROW LEVEL SECURITY;
+ALTER TABLE tradebook_templates ENABLE ROW LEVEL SECURITY;
+ALTER TABLE custom_field_definitions ENABLE ROW LEVEL SECURITY;
+ALTER TABLE commission_schedules ENABLE ROW LEVEL SECURITY;
+ALTER TABLE trades ENABLE ROW LEVEL SECURITY;
+ALTER TABLE exit_legs ENABLE ROW LEVEL SECURITY;
+ALTER TABLE audit_log ENABLE ROW LEVEL SECURITY;
+
+ALTER TABLE tradebooks FORCE ROW LEVEL SECURITY;
+ALTER TABLE tradebook_members FORCE ROW LEVEL SECURITY;
+ALTER TABLE tradebook_preferences FORCE ROW LEVEL SECURITY;
+ALTER TABLE tradebook_templates FORCE ROW LEVEL SECURITY;
+ALTER TABLE custom_field_definitions FORCE ROW LEVEL SECURITY;
+ALTER TABLE commission_schedules FORCE ROW LEVEL SECURITY;
+ALTER TABLE trades FORCE ROW LEVEL SECURITY;
+ALTER TABLE exit_legs FORCE ROW LEVEL SECURITY;
+ALTER TABLE audit_log FORCE ROW LEVEL SECURITY;
+
+-- owner_id is checked directly so INSERT ... RETURNING sees the new row
+DROP POLICY IF EXISTS tradebooks_tenant ON tradebooks;
+CREATE POLICY tradebooks_tenant ON tradebooks
+    USING (owner_id = app_current_user_id() OR app_can_access_tradebook(id))
+    WITH CHECK (owner_id = app_current_user_id() OR app_can_access_tradebook(id));
+
+-- Anyone with access sees the member list, but only owners change it. Members
+-- may also update their own row without changing its role, delete it to leave,
+-- and join (or change role) through an invitation they just accepted.
+DROP POLICY IF EXISTS tradebook_members_tenant ON tradebook_members;
+CREATE POLICY tradebook_members_tenant ON tradebook_members FOR SELECT
+    USING (user_id = app_current_user_id() OR app_can_access_tradebook(tradebook_id));
+
+DROP POLICY IF EXISTS tradebook_members_owner ON tradebook_members;
+CREATE POLICY tradebook_members_owner ON tradebook_members
+    USING (app_owns_tradebook(tradebook_id))
+    WITH CHECK (app_owns_tradebook(tradebook_id));
+
+DROP POLICY IF EXISTS tradebook_members_self_update ON tradebook_members;
+CREATE POLICY tradebook_members_self_update ON tradebook_members FOR UPDATE
+    USING (user_id = app_current_user_id())
+    WITH CHECK (
+        user_id = app_current_user_id()
+        AND (role = app_member_role(tradebook_id, user_id) OR app_accepted_invitation(tradebook_id, role))
+    );
+
+DROP POLICY IF EXISTS tradebook_members_self_delete ON tradebook_members;
+CREATE POLICY tradebook_members_self_delete ON tradebook_members FOR DELETE
+    USING (user_id = app_current_user_id());
+
+DROP POLICY IF EXISTS tradebook_members_accept ON tradebook_members;
+CREATE POLICY tradebook_members_accept ON tradebook_members FOR INSERT
+    WITH CHECK (user_id = app_current_user_id() AND app_accepted_invitation(tradebook_id, role));
+
+-- Preferences are private to the user they belong to
+DROP POLICY IF EXISTS tradebook_preferences_self ON tradebook_preferences;
+CREATE POLICY tradebook_preferences_self ON tradebook_preferences
+    USING (user_id = app_current_user_id() AND app_can_access_tradebook(tradebook_id))
+    WITH CHECK (user_id = app_current_user_id() AND app_can_access_tradebook(tradebook_id));
+
+-- Shared templates are readable in their workspace but only writable by their
+-- owner; a shared personal template is still only the owner's
+DROP POLICY IF EXISTS tradebook_templates_read ON tradebook_templates;
+CREATE POLICY tradebook_templates_read ON tradebook_templates FOR SELECT
+    USING (owner_id = app_current_user_id() OR (is_shared AND workspace_id = app_current_org_id()));
+
+DROP POLICY IF EXISTS tradebook_templates_owner ON tradebook_templates;
+CREATE POLICY tradebook_templates_owner ON tradebook_templates
+    USING (owner_id = app_current_user_id())
+    WITH CHECK (owner_id = app_current_user_id());
+
+DROP POLICY IF EXISTS custom_field_definitions_tenant ON custom_field_definitions;
+CREATE POLICY custom_field_definitions_tenant ON custom_field_definitions
+    USING (app_can_access_tradebook(tradebook_id));
+
+DROP POLICY IF EXISTS commission_schedules_tenant ON commission_schedules;
+CREATE POLICY commission_schedules_tenant ON commission_schedules
+    USING (app_can_access_tradebook(tradebook_id));
+
+DROP POLICY IF EXISTS trades_tenant ON trades;
+CREATE POLICY trades_tenant ON trades
+    USING (app_can_access_tradebook(tradebook_id));
+
+DROP POLICY IF EXISTS exit_legs_tenant ON exit_legs;
+CREATE POLICY exit_legs_tenant ON exit_legs
+    USING (app_can_access_trade(trade_id));
+
+-- Append-only: there is deliberately no UPDATE or DELETE policy
+DROP POLICY IF EXISTS audit_log_read ON audit_log;
+CREATE POLICY audit_log_read ON audit_log FOR SELECT
+    USING (app_can_access_tradebook(tradebook_id));
+
+-- Actors can always read their own entries, e.g. for a personal data export
+-- that includes tradebooks they have since left
+DROP POLICY IF EXISTS audit_log_actor_read ON audit_log;
+CREATE POLICY audit_log_actor_read ON audit_log FOR SELECT
+    USING (actor_id = app_current_user_id());
+
+DROP POLICY IF EXISTS audit_log_append ON audit_log;
+CREATE POLICY audit_log_append ON audit_log FOR INSERT
+    WITH CHECK (actor_id = app_current_user_id() AND app_can_access_tradebook(tradebook_id));
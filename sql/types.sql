@@ -0,0 +1,10 @@
+-- Enums as sqlc sees them. schema.sql creates them inside DO blocks so it
+-- can be re-run, and sqlc doesn't look inside those; keep the two in step.
+
+CREATE TYPE asset_class AS ENUM ('equities', 'fixed_income', 'commodities', 'etfs', 'forex', 'derivatives', 'crypto');
+CREATE TYPE trade_order_type AS ENUM ('market', 'limit', 'stop', 'stop_limit');
+CREATE TYPE trade_purchase_type AS ENUM ('cash', 'margin');
+CREATE TYPE tradebook_role AS ENUM ('owner', 'editor', 'reader'); -- Most to least privileged, so LEAST() picks the stronger role
+CREATE TYPE accounting_method AS ENUM ('fifo', 'lifo', 'average_cost');
+CREATE TYPE custom_field_type AS ENUM ('text', 'number', 'select', 'boolean', 'date');
+CREATE TYPE commission_rate_type AS ENUM ('per_share', 'per_contract', 'percent_of_notional');